@@ -0,0 +1,328 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/E-Timileyin/school-management-system/internal/models"
+)
+
+// skipKey disables auditing for a statement, see Skip
+const skipKey = "audit:skip"
+
+// beforeKey holds the rows captured before an update or delete
+const beforeKey = "audit:before"
+
+// maxRows caps how many rows of a bulk update or delete are recorded
+const maxRows = 500
+
+// ignoredTables are never audited: the audit log itself and tables that
+// are already logs or only hold counters
+var ignoredTables = map[string]bool{
+	"audit_logs":      true,
+	"job_runs":        true,
+	"login_attempts":  true,
+	"security_events": true,
+}
+
+// redactedColumns are recorded as changed without their values
+var redactedColumns = map[string]bool{
+	"password":          true,
+	"two_factor_secret": true,
+	"private_key":       true,
+	"key_hash":          true,
+	"token_hash":        true,
+	"code_hash":         true,
+}
+
+// Skip marks a statement as not worth auditing, e.g. a last-seen timestamp
+func Skip(db *gorm.DB) *gorm.DB {
+	return db.Set(skipKey, true)
+}
+
+// Register installs the audit callbacks on db
+func Register(db *gorm.DB) error {
+	callback := db.Callback()
+	if err := callback.Create().After("gorm:create").Register("audit:after_create", afterCreate); err != nil {
+		return err
+	}
+	if err := callback.Update().Before("gorm:update").Register("audit:before_update", captureBefore); err != nil {
+		return err
+	}
+	if err := callback.Update().After("gorm:update").Register("audit:after_update", afterChange(models.AuditActionUpdate)); err != nil {
+		return err
+	}
+	if err := callback.Delete().Before("gorm:delete").Register("audit:before_delete", captureBefore); err != nil {
+		return err
+	}
+	return callback.Delete().After("gorm:delete").Register("audit:after_delete", afterChange(models.AuditActionDelete))
+}
+
+// audited reports whether the statement should be recorded
+func audited(db *gorm.DB) bool {
+	if db.Error != nil || db.Statement.Schema == nil || db.Statement.Schema.PrioritizedPrimaryField == nil {
+		return false
+	}
+	if skip, ok := db.Get(skipKey); ok && skip.(bool) {
+		return false
+	}
+	return !ignoredTables[db.Statement.Table]
+}
+
+func afterCreate(db *gorm.DB) {
+	if !audited(db) || db.Statement.RowsAffected == 0 {
+		return
+	}
+
+	// The created rows are in the statement, no need to query them
+	for _, row := range modelRows(db) {
+		record(db, models.AuditActionCreate, row, nil, row)
+	}
+}
+
+// captureBefore loads the rows an update or delete is about to change
+func captureBefore(db *gorm.DB) {
+	if !audited(db) {
+		return
+	}
+	rows, err := loadRows(db)
+	if err != nil {
+		log.Printf("Audit: failed to load rows before change of %s: %v", db.Statement.Table, err)
+		return
+	}
+	db.Statement.Settings.Store(beforeKey, rows)
+}
+
+func afterChange(action string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		if !audited(db) || db.Statement.RowsAffected == 0 {
+			return
+		}
+		value, ok := db.Statement.Settings.Load(beforeKey)
+		if !ok {
+			return
+		}
+		before := value.([]map[string]interface{})
+		if len(before) == 0 {
+			return
+		}
+
+		// Reload by primary key, including soft-deleted rows
+		pk := db.Statement.Schema.PrioritizedPrimaryField.DBName
+		ids := make([]interface{}, 0, len(before))
+		for _, row := range before {
+			ids = append(ids, row[pk])
+		}
+		var after []map[string]interface{}
+		if err := rowQuery(db).
+			Where(clause.IN{Column: clause.Column{Name: pk}, Values: ids}).
+			Find(&after).Error; err != nil {
+			log.Printf("Audit: failed to load rows after change of %s: %v", db.Statement.Table, err)
+			return
+		}
+		afterByID := map[string]map[string]interface{}{}
+		for _, row := range after {
+			afterByID[fmt.Sprint(row[pk])] = row
+		}
+
+		for _, old := range before {
+			current := afterByID[fmt.Sprint(old[pk])]
+			changed := diff(old, current)
+			if current != nil && len(changed) == 0 {
+				continue
+			}
+			if action == models.AuditActionDelete {
+				// Keep the whole deleted row; After shows what a soft delete
+				// changed and is empty for hard deletes
+				record(db, action, old, old, changed)
+				continue
+			}
+			record(db, action, old, pick(old, changed), changed)
+		}
+	}
+}
+
+// loadRows fetches the rows matched by the statement: the primary key of the
+// model if it has one, otherwise the statement's WHERE clause. Soft-deleted
+// rows are included so that restoring a row is recorded too; rows the
+// statement does not actually change are dropped by diff later.
+func loadRows(db *gorm.DB) ([]map[string]interface{}, error) {
+	stmt := db.Statement
+	query := rowQuery(db)
+
+	pk := stmt.Schema.PrioritizedPrimaryField
+	ids := primaryKeys(db)
+	switch {
+	case len(ids) > 0:
+		query = query.Where(clause.IN{Column: clause.Column{Name: pk.DBName}, Values: ids})
+	case stmt.Clauses["WHERE"].Expression != nil:
+		query = query.Clauses(stmt.Clauses["WHERE"].Expression)
+	default:
+		return nil, nil
+	}
+
+	var rows []map[string]interface{}
+	err := query.Limit(maxRows).Find(&rows).Error
+	return rows, err
+}
+
+// rowQuery starts an unscoped query on the statement's table. A zero model
+// resolves primary key conditions such as Delete(&User{}, id), and column
+// types, without adding conditions of its own.
+func rowQuery(db *gorm.DB) *gorm.DB {
+	stmt := db.Statement
+	return newSession(db).Unscoped().Model(reflect.New(stmt.Schema.ModelType).Interface()).Table(stmt.Table)
+}
+
+// primaryKeys returns the non-zero primary keys of the statement's model
+func primaryKeys(db *gorm.DB) []interface{} {
+	stmt := db.Statement
+	pk := stmt.Schema.PrioritizedPrimaryField
+	var ids []interface{}
+	collect := func(value reflect.Value) {
+		if id, zero := pk.ValueOf(stmt.Context, value); !zero {
+			ids = append(ids, id)
+		}
+	}
+
+	value := reflect.Indirect(stmt.ReflectValue)
+	switch value.Kind() {
+	case reflect.Struct:
+		if value.Type() == stmt.Schema.ModelType {
+			collect(value)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if item := reflect.Indirect(value.Index(i)); item.Kind() == reflect.Struct {
+				collect(item)
+			}
+		}
+	}
+	return ids
+}
+
+// modelRows converts the statement's model values into column maps
+func modelRows(db *gorm.DB) []map[string]interface{} {
+	stmt := db.Statement
+	toRow := func(value reflect.Value) map[string]interface{} {
+		row := map[string]interface{}{}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" {
+				continue
+			}
+			row[field.DBName], _ = field.ValueOf(stmt.Context, value)
+		}
+		return row
+	}
+
+	var rows []map[string]interface{}
+	value := reflect.Indirect(stmt.ReflectValue)
+	switch value.Kind() {
+	case reflect.Struct:
+		rows = append(rows, toRow(value))
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if item := reflect.Indirect(value.Index(i)); item.Kind() == reflect.Struct {
+				rows = append(rows, toRow(item))
+			}
+		}
+	}
+	return rows
+}
+
+// diff returns the columns whose value in after differs from before. It is
+// nil if the row no longer exists.
+func diff(before, after map[string]interface{}) map[string]interface{} {
+	if after == nil {
+		return nil
+	}
+	changed := map[string]interface{}{}
+	for column, value := range after {
+		if column == "updated_at" {
+			continue
+		}
+		if !sameValue(before[column], value) {
+			changed[column] = value
+		}
+	}
+	return changed
+}
+
+// pick returns the values of row for the given columns
+func pick(row, columns map[string]interface{}) map[string]interface{} {
+	values := make(map[string]interface{}, len(columns))
+	for column := range columns {
+		values[column] = row[column]
+	}
+	return values
+}
+
+// sameValue compares column values by their JSON form, so a time read back
+// from the database equals the one that was written
+func sameValue(a, b interface{}) bool {
+	aJSON, errA := json.Marshal(a)
+	bJSON, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(aJSON) == string(bJSON)
+}
+
+// record writes one audit log entry in the statement's transaction
+func record(db *gorm.DB, action string, row, before, after map[string]interface{}) {
+	entityID := fmt.Sprint(row[db.Statement.Schema.PrioritizedPrimaryField.DBName])
+	if action == models.AuditActionCreate {
+		before = nil
+	}
+	if err := Log(db, action, db.Statement.Table, entityID, before, after); err != nil {
+		log.Printf("Audit: failed to record %s of %s %s: %v", action, db.Statement.Table, entityID, err)
+	}
+}
+
+// Log writes an audit log entry for a change the callbacks cannot describe,
+// e.g. one made with Skip. The actor is taken from db's context.
+func Log(db *gorm.DB, action, entityType, entityID string, before, after map[string]interface{}) error {
+	entry := &models.AuditLog{
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Before:     encode(before),
+		After:      encode(after),
+	}
+	if actor, ok := ActorFromContext(db.Statement.Context); ok {
+		entry.ActorID = actor.UserID
+		entry.APIKeyID = actor.APIKeyID
+		entry.KioskID = actor.KioskID
+		entry.IP = actor.IP
+		entry.UserAgent = actor.UserAgent
+		entry.RequestID = actor.RequestID
+	}
+	return newSession(db).Create(entry).Error
+}
+
+// encode marshals a column map with secrets redacted
+func encode(values map[string]interface{}) json.RawMessage {
+	if values == nil {
+		return nil
+	}
+	redacted := make(map[string]interface{}, len(values))
+	for column, value := range values {
+		if redactedColumns[column] {
+			value = "[redacted]"
+		}
+		redacted[column] = value
+	}
+	data, err := json.Marshal(redacted)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// newSession returns a fresh statement on the same connection, so audit
+// queries run inside the transaction of the change being recorded
+func newSession(db *gorm.DB) *gorm.DB {
+	return db.Session(&gorm.Session{NewDB: true, SkipHooks: true})
+}
@@ -0,0 +1,33 @@
+// Package audit records every create, update and delete made through GORM,
+// together with who made it, by hooking into GORM's callbacks
+package audit
+
+import "context"
+
+// Actor describes who caused a change. Changes made outside a request,
+// e.g. by scheduled jobs, have no actor and are recorded as made by the system.
+type Actor struct {
+	UserID    *uint
+	APIKeyID  *uint
+	KioskID   *uint
+	IP        string
+	UserAgent string
+	RequestID string
+}
+
+type actorKey struct{}
+
+// WithActor returns a context carrying actor. Pass it to GORM with
+// db.WithContext so the audit callbacks can attribute the change.
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor stored in ctx
+func ActorFromContext(ctx context.Context) (Actor, bool) {
+	if ctx == nil {
+		return Actor{}, false
+	}
+	actor, ok := ctx.Value(actorKey{}).(Actor)
+	return actor, ok
+}
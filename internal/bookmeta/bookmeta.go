@@ -0,0 +1,174 @@
+// Package bookmeta looks up bibliographic details of books by ISBN
+package bookmeta
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/E-Timileyin/school-management-system/internal/config"
+)
+
+var ErrNotFound = errors.New("no metadata found for ISBN")
+
+// Metadata is what a provider knows about an edition of a book
+type Metadata struct {
+	Title           string   `json:"title"`
+	Authors         []string `json:"authors"`
+	Publisher       string   `json:"publisher"`
+	PublicationYear int      `json:"publication_year"`
+	Pages           int      `json:"pages"`
+	CoverURL        string   `json:"cover_url"`
+}
+
+// Provider looks up book metadata by ISBN-13
+type Provider interface {
+	Lookup(ctx context.Context, isbn string) (*Metadata, error)
+}
+
+// New returns the provider selected by the configuration. client is used
+// for HTTP requests; nil means a client with the configured timeout.
+func New(cfg config.LibraryConfig, client *http.Client) (Provider, error) {
+	switch cfg.MetadataProvider {
+	case "openlibrary":
+		if client == nil {
+			client = &http.Client{Timeout: cfg.MetadataTimeout}
+		}
+		return NewOpenLibraryProvider(cfg.MetadataURL, client), nil
+	case "fixture":
+		if cfg.MetadataFixtures == "" {
+			return NewFixtureProvider(nil), nil
+		}
+		return LoadFixtureProvider(cfg.MetadataFixtures)
+	case "", "none":
+		return NewFixtureProvider(nil), nil
+	default:
+		return nil, fmt.Errorf("unknown book metadata provider %q", cfg.MetadataProvider)
+	}
+}
+
+// OpenLibraryProvider queries the Open Library books API, or a service
+// speaking the same protocol
+type OpenLibraryProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewOpenLibraryProvider creates a provider for the API at baseURL
+func NewOpenLibraryProvider(baseURL string, client *http.Client) *OpenLibraryProvider {
+	return &OpenLibraryProvider{baseURL: strings.TrimRight(baseURL, "/"), client: client}
+}
+
+type openLibraryName struct {
+	Name string `json:"name"`
+}
+
+type openLibraryBook struct {
+	Title         string            `json:"title"`
+	Subtitle      string            `json:"subtitle"`
+	Authors       []openLibraryName `json:"authors"`
+	Publishers    []openLibraryName `json:"publishers"`
+	PublishDate   string            `json:"publish_date"`
+	NumberOfPages int               `json:"number_of_pages"`
+	Cover         struct {
+		Medium string `json:"medium"`
+		Large  string `json:"large"`
+	} `json:"cover"`
+}
+
+var yearPattern = regexp.MustCompile(`\b\d{4}\b`)
+
+// Lookup fetches the details of an edition
+func (p *OpenLibraryProvider) Lookup(ctx context.Context, isbn string) (*Metadata, error) {
+	key := "ISBN:" + isbn
+	query := url.Values{"bibkeys": {key}, "format": {"json"}, "jscmd": {"data"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/api/books?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("book metadata request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("book metadata request failed: %s", resp.Status)
+	}
+
+	var books map[string]openLibraryBook
+	if err := json.NewDecoder(resp.Body).Decode(&books); err != nil {
+		return nil, fmt.Errorf("invalid book metadata response: %w", err)
+	}
+	book, ok := books[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	meta := &Metadata{
+		Title:    book.Title,
+		Pages:    book.NumberOfPages,
+		CoverURL: book.Cover.Large,
+	}
+	if book.Subtitle != "" {
+		meta.Title += ": " + book.Subtitle
+	}
+	if meta.CoverURL == "" {
+		meta.CoverURL = book.Cover.Medium
+	}
+	for _, author := range book.Authors {
+		meta.Authors = append(meta.Authors, author.Name)
+	}
+	if len(book.Publishers) > 0 {
+		meta.Publisher = book.Publishers[0].Name
+	}
+	if year := yearPattern.FindString(book.PublishDate); year != "" {
+		meta.PublicationYear, _ = strconv.Atoi(year)
+	}
+	return meta, nil
+}
+
+// FixtureProvider serves metadata from a fixed set of books. It is meant
+// for development and tests without network access.
+type FixtureProvider struct {
+	books map[string]Metadata
+}
+
+// NewFixtureProvider creates a provider for books keyed by ISBN-13
+func NewFixtureProvider(books map[string]Metadata) *FixtureProvider {
+	if books == nil {
+		books = map[string]Metadata{}
+	}
+	return &FixtureProvider{books: books}
+}
+
+// LoadFixtureProvider reads the books of a fixture provider from a JSON
+// file mapping ISBN-13 to metadata
+func LoadFixtureProvider(path string) (*FixtureProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read book metadata fixtures: %w", err)
+	}
+	var books map[string]Metadata
+	if err := json.Unmarshal(data, &books); err != nil {
+		return nil, fmt.Errorf("invalid book metadata fixtures: %w", err)
+	}
+	return NewFixtureProvider(books), nil
+}
+
+// Lookup returns the fixture for isbn
+func (p *FixtureProvider) Lookup(_ context.Context, isbn string) (*Metadata, error) {
+	meta, ok := p.books[isbn]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &meta, nil
+}
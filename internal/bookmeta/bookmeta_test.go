@@ -0,0 +1,104 @@
+package bookmeta
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/E-Timileyin/school-management-system/internal/config"
+)
+
+func TestOpenLibraryLookup(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/books" || r.URL.Query().Get("jscmd") != "data" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("bibkeys") {
+		case "ISBN:9780441172719":
+			w.Write([]byte(`{"ISBN:9780441172719": {
+				"title": "Dune",
+				"subtitle": "Deluxe Edition",
+				"authors": [{"name": "Frank Herbert"}, {"name": "Brian Herbert"}],
+				"publishers": [{"name": "Ace Books"}, {"name": "Chilton"}],
+				"publish_date": "August 1, 1990",
+				"number_of_pages": 535,
+				"cover": {"medium": "https://covers.test/m.jpg"}
+			}}`))
+		case "ISBN:9780000000002":
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+	provider := NewOpenLibraryProvider(server.URL+"/", server.Client())
+
+	meta, err := provider.Lookup(context.Background(), "9780441172719")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Title != "Dune: Deluxe Edition" || !slices.Equal(meta.Authors, []string{"Frank Herbert", "Brian Herbert"}) ||
+		meta.Publisher != "Ace Books" || meta.PublicationYear != 1990 || meta.Pages != 535 ||
+		meta.CoverURL != "https://covers.test/m.jpg" {
+		t.Fatalf("got %+v", meta)
+	}
+
+	if _, err := provider.Lookup(context.Background(), "9780306406157"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("unknown ISBN: got %v, want ErrNotFound", err)
+	}
+	if _, err := provider.Lookup(context.Background(), "9780000000002"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("failing service: got %v, want a request error", err)
+	}
+}
+
+func TestFixtureProvider(t *testing.T) {
+	provider, err := LoadFixtureProvider("fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := provider.Lookup(context.Background(), "9780441172719")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Title != "Dune" || !slices.Equal(meta.Authors, []string{"Frank Herbert"}) {
+		t.Fatalf("got %+v", meta)
+	}
+	if _, err := provider.Lookup(context.Background(), "9780306406157"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+
+	if _, err := LoadFixtureProvider("missing.json"); err == nil {
+		t.Fatal("loaded a missing fixture file")
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		provider string
+		want     string
+	}{
+		{provider: "openlibrary", want: "*bookmeta.OpenLibraryProvider"},
+		{provider: "fixture", want: "*bookmeta.FixtureProvider"},
+		{provider: "none", want: "*bookmeta.FixtureProvider"},
+		{provider: "", want: "*bookmeta.FixtureProvider"},
+	}
+	for _, tt := range tests {
+		provider, err := New(config.LibraryConfig{MetadataProvider: tt.provider}, nil)
+		if err != nil {
+			t.Fatalf("New(%q) failed: %v", tt.provider, err)
+		}
+		if got := fmt.Sprintf("%T", provider); got != tt.want {
+			t.Errorf("New(%q) = %s, want %s", tt.provider, got, tt.want)
+		}
+	}
+
+	if _, err := New(config.LibraryConfig{MetadataProvider: "isbndb"}, nil); err == nil {
+		t.Fatal("New accepted an unknown provider")
+	}
+}
@@ -0,0 +1,84 @@
+package config
+
+import "time"
+
+// AuthConfig holds account and authentication settings
+type AuthConfig struct {
+	// BaseURL is used to build links sent to users by email
+	BaseURL string
+	// RequireEmailVerification blocks login until the email address is verified
+	RequireEmailVerification bool
+	EmailVerificationTTL     time.Duration
+	InvitationTTL            time.Duration
+
+	// SelfRegistrationEnabled allows anyone to create an account via /register
+	SelfRegistrationEnabled bool
+	// SelfRegistrationRoles lists the roles available to self-registration;
+	// the first one is used when no role is requested
+	SelfRegistrationRoles []string
+
+	// TokenTTL is the lifetime of access tokens
+	TokenTTL time.Duration
+	// JWTSigningAlgorithm is RS256 or EdDSA for rotating keys published at
+	// /.well-known/jwks.json, or HS256 to sign with JWT_SECRET
+	JWTSigningAlgorithm string
+	// JWTKeyRotationInterval is how long a signing key is used before a new one
+	// takes over; JWTKeyPrepublish is how early the new key appears in the key set
+	JWTKeyRotationInterval time.Duration
+	JWTKeyPrepublish       time.Duration
+	// TwoFactorChallengeTTL is how long a user has to enter their TOTP code
+	// after a successful password check
+	TwoFactorChallengeTTL time.Duration
+	// TwoFactorIssuer is the account issuer shown in authenticator apps
+	TwoFactorIssuer string
+	// TwoFactorRequiredRoles must enroll in 2FA before using admin routes
+	TwoFactorRequiredRoles []string
+
+	// LoginAttemptStore selects where failed logins are tracked: "memory" for
+	// a single instance or "database" to share state between replicas
+	LoginAttemptStore string
+	// LoginMaxFailures failed logins lock an account for LoginLockoutDuration
+	LoginMaxFailures     int
+	LoginLockoutDuration time.Duration
+	// LoginIPMaxFailures failed logins from one IP block it for LoginLockoutDuration
+	LoginIPMaxFailures int
+	// Failures before the limit delay the next attempt exponentially,
+	// starting at LoginBackoffBase and capped at LoginBackoffMax
+	LoginBackoffBase time.Duration
+	LoginBackoffMax  time.Duration
+	// LoginFailureWindow resets the failure counter after a quiet period
+	LoginFailureWindow time.Duration
+
+	// APIKeyDefaultTTL applies to API keys created without an expiry;
+	// APIKeyMaxTTL caps the expiry that can be requested
+	APIKeyDefaultTTL time.Duration
+	APIKeyMaxTTL     time.Duration
+}
+
+// LoadAuthConfig reads authentication settings from environment variables
+func LoadAuthConfig() AuthConfig {
+	return AuthConfig{
+		BaseURL:                  getEnv("APP_BASE_URL", "http://localhost:8080"),
+		RequireEmailVerification: getEnvBool("REQUIRE_EMAIL_VERIFICATION", true),
+		EmailVerificationTTL:     getEnvDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour),
+		InvitationTTL:            getEnvDuration("INVITATION_TTL", 72*time.Hour),
+		SelfRegistrationEnabled:  getEnvBool("SELF_REGISTRATION_ENABLED", true),
+		SelfRegistrationRoles:    getEnvList("SELF_REGISTRATION_ROLES", []string{"student"}),
+		TokenTTL:                 getEnvDuration("JWT_EXPIRATION", 24*time.Hour),
+		JWTSigningAlgorithm:      getEnv("JWT_SIGNING_ALG", "RS256"),
+		JWTKeyRotationInterval:   getEnvDuration("JWT_KEY_ROTATION_INTERVAL", 30*24*time.Hour),
+		JWTKeyPrepublish:         getEnvDuration("JWT_KEY_PREPUBLISH", time.Hour),
+		TwoFactorChallengeTTL:    getEnvDuration("TWO_FACTOR_CHALLENGE_TTL", 5*time.Minute),
+		TwoFactorIssuer:          getEnv("TWO_FACTOR_ISSUER", "School Management System"),
+		TwoFactorRequiredRoles:   getEnvList("TWO_FACTOR_REQUIRED_ROLES", []string{"admin"}),
+		LoginAttemptStore:        getEnv("LOGIN_ATTEMPT_STORE", "memory"),
+		LoginMaxFailures:         getEnvInt("LOGIN_MAX_FAILURES", 5),
+		LoginLockoutDuration:     getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		LoginIPMaxFailures:       getEnvInt("LOGIN_IP_MAX_FAILURES", 20),
+		LoginBackoffBase:         getEnvDuration("LOGIN_BACKOFF_BASE", time.Second),
+		LoginBackoffMax:          getEnvDuration("LOGIN_BACKOFF_MAX", time.Minute),
+		LoginFailureWindow:       getEnvDuration("LOGIN_FAILURE_WINDOW", time.Hour),
+		APIKeyDefaultTTL:         getEnvDuration("API_KEY_DEFAULT_TTL", 90*24*time.Hour),
+		APIKeyMaxTTL:             getEnvDuration("API_KEY_MAX_TTL", 365*24*time.Hour),
+	}
+}
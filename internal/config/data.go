@@ -0,0 +1,17 @@
+package config
+
+import "time"
+
+// DataConfig holds data retention settings
+type DataConfig struct {
+	// TrashRetention is how long soft-deleted records are kept before an
+	// admin purge removes them for good
+	TrashRetention time.Duration
+}
+
+// LoadDataConfig reads data retention settings from environment variables
+func LoadDataConfig() DataConfig {
+	return DataConfig{
+		TrashRetention: getEnvDuration("TRASH_RETENTION", 30*24*time.Hour),
+	}
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// getEnv returns the value of an environment variable or a fallback
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getEnvBool parses a boolean environment variable, falling back on parse errors
+func getEnvBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// getEnvInt parses an integer environment variable, falling back on parse errors
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// getEnvDuration parses a duration (e.g. "24h") environment variable
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// getEnvList splits a comma separated environment variable into trimmed values
+func getEnvList(key string, fallback []string) []string {
+	raw, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	var values []string
+	for _, part := range strings.Split(raw, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
+
+// getEnvMap parses a comma separated list of key=value pairs
+func getEnvMap(key string) map[string]string {
+	values := map[string]string{}
+	for _, pair := range getEnvList(key, nil) {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		values[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return values
+}
+
+// getEnvDecimal parses an exact decimal environment variable, such as an
+// amount of money, falling back on parse errors
+func getEnvDecimal(key string, fallback string) decimal.Decimal {
+	if value, err := decimal.NewFromString(strings.TrimSpace(os.Getenv(key))); err == nil {
+		return value
+	}
+	return decimal.RequireFromString(fallback)
+}
+
+// getEnvDecimalMap parses a comma separated list of key=decimal pairs.
+// Pairs with invalid numbers are skipped.
+func getEnvDecimalMap(key string) map[string]decimal.Decimal {
+	values := map[string]decimal.Decimal{}
+	for k, v := range getEnvMap(key) {
+		if n, err := decimal.NewFromString(v); err == nil {
+			values[k] = n
+		}
+	}
+	return values
+}
+
+// getEnvIntMap parses a comma separated list of key=integer pairs. Pairs
+// with invalid numbers are skipped; an unset variable yields fallback.
+func getEnvIntMap(key string, fallback map[string]int) map[string]int {
+	if _, ok := os.LookupEnv(key); !ok {
+		return fallback
+	}
+	values := map[string]int{}
+	for k, v := range getEnvMap(key) {
+		if n, err := strconv.Atoi(v); err == nil {
+			values[k] = n
+		}
+	}
+	return values
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// LibraryConfig holds library settings
+type LibraryConfig struct {
+	// MetadataProvider looks up book details by ISBN: openlibrary, fixture
+	// or none
+	MetadataProvider string
+	// MetadataURL is the base URL of the Open Library compatible API
+	MetadataURL string
+	// MetadataFixtures is a JSON file of book details keyed by ISBN-13,
+	// used by the fixture provider
+	MetadataFixtures string
+	MetadataTimeout  time.Duration
+
+	// LoanLimits is how many books each role may have on loan at once
+	// (LIBRARY_LOAN_LIMITS="student=3,teacher=10"). Roles that are not
+	// listed cannot borrow.
+	LoanLimits map[string]int
+	// LoanDays is the loan period of each role in days
+	LoanDays map[string]int
+	// DefaultLoanDays applies to roles with a loan limit but no period
+	DefaultLoanDays int
+	// MaxUnpaidFines blocks borrowing once a user's unpaid fines exceed it;
+	// zero blocks on any unpaid fine
+	MaxUnpaidFines decimal.Decimal
+	// BlockOnOverdue stops users with overdue loans from borrowing more
+	BlockOnOverdue bool
+	// HoldPickupWindow is how long a returned copy waits for the next
+	// reader in the queue before it passes to the one after
+	HoldPickupWindow time.Duration
+
+	// MaxRenewals is how many times a loan may be renewed
+	MaxRenewals int
+	// RenewalGracePeriod is how long after its due date a loan can still
+	// be renewed
+	RenewalGracePeriod time.Duration
+
+	// DueSoonWindow is how long before its due date a borrower is reminded
+	// of a loan
+	DueSoonWindow time.Duration
+	// OverdueReminderInterval is how often borrowers are reminded of an
+	// overdue loan
+	OverdueReminderInterval time.Duration
+
+	// FineDailyRate is charged per overdue day unless a category or role
+	// rate applies
+	FineDailyRate decimal.Decimal
+	// FineCategoryRates are daily rates by category name, e.g. for short
+	// loan collections. They take precedence over role rates.
+	FineCategoryRates map[string]decimal.Decimal
+	// FineRoleRates are daily rates by the borrower's role
+	FineRoleRates map[string]decimal.Decimal
+	// FineGraceDays are overdue days not charged for
+	FineGraceDays int
+	// FineMaxPerItem caps the overdue fine of a loan; zero means no cap
+	FineMaxPerItem decimal.Decimal
+	// FineSkipWeekends leaves Saturdays and Sundays out of overdue days,
+	// like the holidays in the library calendar
+	FineSkipWeekends bool
+	// LostBookFee is charged on top of the price of a lost book
+	LostBookFee decimal.Decimal
+	// LostBookDefaultPrice is charged for lost books without a price
+	LostBookDefaultPrice decimal.Decimal
+}
+
+// LoadLibraryConfig reads library settings from environment variables
+func LoadLibraryConfig() LibraryConfig {
+	return LibraryConfig{
+		MetadataProvider: getEnv("BOOK_METADATA_PROVIDER", "openlibrary"),
+		MetadataURL:      getEnv("BOOK_METADATA_URL", "https://openlibrary.org"),
+		MetadataFixtures: getEnv("BOOK_METADATA_FIXTURES", ""),
+		MetadataTimeout:  getEnvDuration("BOOK_METADATA_TIMEOUT", 10*time.Second),
+
+		LoanLimits: getEnvIntMap("LIBRARY_LOAN_LIMITS", map[string]int{
+			"student": 3, "teacher": 10, "librarian": 10, "admin": 10,
+		}),
+		LoanDays: getEnvIntMap("LIBRARY_LOAN_DAYS", map[string]int{
+			"student": 14, "teacher": 28, "librarian": 28, "admin": 28,
+		}),
+		DefaultLoanDays: getEnvInt("LIBRARY_DEFAULT_LOAN_DAYS", 14),
+		MaxUnpaidFines:  getEnvDecimal("LIBRARY_MAX_UNPAID_FINES", "10"),
+		BlockOnOverdue:  getEnvBool("LIBRARY_BLOCK_ON_OVERDUE", true),
+
+		HoldPickupWindow: getEnvDuration("LIBRARY_HOLD_PICKUP_WINDOW", 72*time.Hour),
+
+		MaxRenewals:        getEnvInt("LIBRARY_MAX_RENEWALS", 2),
+		RenewalGracePeriod: getEnvDuration("LIBRARY_RENEWAL_GRACE_PERIOD", 72*time.Hour),
+
+		DueSoonWindow:           getEnvDuration("LIBRARY_DUE_SOON_WINDOW", 48*time.Hour),
+		OverdueReminderInterval: getEnvDuration("LIBRARY_OVERDUE_REMINDER_INTERVAL", 72*time.Hour),
+
+		FineDailyRate:        getEnvDecimal("LIBRARY_FINE_DAILY_RATE", "5"),
+		FineCategoryRates:    lowerKeys(getEnvDecimalMap("LIBRARY_FINE_CATEGORY_RATES")),
+		FineRoleRates:        getEnvDecimalMap("LIBRARY_FINE_ROLE_RATES"),
+		FineGraceDays:        getEnvInt("LIBRARY_FINE_GRACE_DAYS", 0),
+		FineMaxPerItem:       getEnvDecimal("LIBRARY_FINE_MAX_PER_ITEM", "0"),
+		FineSkipWeekends:     getEnvBool("LIBRARY_FINE_SKIP_WEEKENDS", false),
+		LostBookFee:          getEnvDecimal("LIBRARY_LOST_BOOK_FEE", "0"),
+		LostBookDefaultPrice: getEnvDecimal("LIBRARY_LOST_BOOK_DEFAULT_PRICE", "0"),
+	}
+}
+
+// lowerKeys lowercases the keys of rates looked up case-insensitively
+func lowerKeys(rates map[string]decimal.Decimal) map[string]decimal.Decimal {
+	lowered := make(map[string]decimal.Decimal, len(rates))
+	for k, v := range rates {
+		lowered[strings.ToLower(k)] = v
+	}
+	return lowered
+}
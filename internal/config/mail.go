@@ -0,0 +1,23 @@
+package config
+
+// MailConfig holds outgoing email settings
+type MailConfig struct {
+	Driver   string // log or smtp
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// LoadMailConfig reads mailer settings from environment variables
+func LoadMailConfig() MailConfig {
+	return MailConfig{
+		Driver:   getEnv("MAIL_DRIVER", "log"),
+		Host:     getEnv("SMTP_HOST", ""),
+		Port:     getEnv("SMTP_PORT", "587"),
+		Username: getEnv("SMTP_USERNAME", ""),
+		Password: getEnv("SMTP_PASSWORD", ""),
+		From:     getEnv("MAIL_FROM", "no-reply@school.local"),
+	}
+}
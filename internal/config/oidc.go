@@ -0,0 +1,49 @@
+package config
+
+import "time"
+
+// OIDCConfig holds single sign-on settings for an OpenID Connect provider
+type OIDCConfig struct {
+	Enabled bool
+	// IssuerURL is used for discovery via /.well-known/openid-configuration
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL must point at /auth/oidc/callback and be registered with the IdP
+	RedirectURL string
+	Scopes      []string
+
+	// GroupsClaim names the ID token claim holding the user's groups
+	GroupsClaim string
+	// RoleMapping maps IdP groups to roles (OIDC_ROLE_MAPPING="staff=teacher,it=admin").
+	// When several groups match, the most privileged role wins.
+	RoleMapping map[string]string
+	// DefaultRole is given to users none of whose groups are mapped.
+	// Empty means such users are rejected.
+	DefaultRole string
+	// AutoCreateUsers creates accounts for unknown emails on first login
+	AutoCreateUsers bool
+	// SyncRoles updates the role of existing users from their groups on every login
+	SyncRoles bool
+
+	// FlowTTL is how long a user has to complete the login at the IdP
+	FlowTTL time.Duration
+}
+
+// LoadOIDCConfig reads single sign-on settings from environment variables
+func LoadOIDCConfig() OIDCConfig {
+	return OIDCConfig{
+		Enabled:         getEnvBool("OIDC_ENABLED", false),
+		IssuerURL:       getEnv("OIDC_ISSUER_URL", ""),
+		ClientID:        getEnv("OIDC_CLIENT_ID", ""),
+		ClientSecret:    getEnv("OIDC_CLIENT_SECRET", ""),
+		RedirectURL:     getEnv("OIDC_REDIRECT_URL", "http://localhost:8080/auth/oidc/callback"),
+		Scopes:          getEnvList("OIDC_SCOPES", []string{"openid", "email", "profile"}),
+		GroupsClaim:     getEnv("OIDC_GROUPS_CLAIM", "groups"),
+		RoleMapping:     getEnvMap("OIDC_ROLE_MAPPING"),
+		DefaultRole:     getEnv("OIDC_DEFAULT_ROLE", ""),
+		AutoCreateUsers: getEnvBool("OIDC_AUTO_CREATE_USERS", true),
+		SyncRoles:       getEnvBool("OIDC_SYNC_ROLES", true),
+		FlowTTL:         getEnvDuration("OIDC_FLOW_TTL", 10*time.Minute),
+	}
+}
@@ -0,0 +1,27 @@
+package config
+
+import "time"
+
+// SchedulerConfig holds settings for the background job scheduler
+type SchedulerConfig struct {
+	// Enabled runs scheduled jobs in this process. Replicas elect one of
+	// them to run each job, so it can stay on everywhere.
+	Enabled bool
+	// PollInterval is how often the scheduler checks for jobs that are due
+	PollInterval time.Duration
+	// HistoryRetention is how long job runs are kept
+	HistoryRetention time.Duration
+	// Schedules override the schedules of jobs by name, e.g.
+	// loan_reminders=@daily 07:30 or overdue_loans=off
+	Schedules map[string]string
+}
+
+// LoadSchedulerConfig reads scheduler settings from environment variables
+func LoadSchedulerConfig() SchedulerConfig {
+	return SchedulerConfig{
+		Enabled:          getEnvBool("SCHEDULER_ENABLED", true),
+		PollInterval:     getEnvDuration("SCHEDULER_POLL_INTERVAL", 30*time.Second),
+		HistoryRetention: getEnvDuration("SCHEDULER_HISTORY_RETENTION", 30*24*time.Hour),
+		Schedules:        getEnvMap("SCHEDULER_SCHEDULES"),
+	}
+}
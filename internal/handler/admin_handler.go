@@ -1,7 +1,10 @@
 package handler
 
 import (
+	"errors"
+	"log"
 	"strconv"
+	"time"
 
 	"github.com/E-Timileyin/school-management-system/internal/models"
 	"github.com/E-Timileyin/school-management-system/internal/service"
@@ -9,14 +12,23 @@ import (
 )
 
 type AdminHandler struct {
-	userService   *service.UserService
-	courseService *service.CourseService
+	userService         *service.UserService
+	courseService       *service.CourseService
+	verificationService *service.VerificationService
+	sessionService      *service.SessionService
 }
 
-func NewAdminHandler(userService *service.UserService, courseService *service.CourseService) *AdminHandler {
+func NewAdminHandler(
+	userService *service.UserService,
+	courseService *service.CourseService,
+	verificationService *service.VerificationService,
+	sessionService *service.SessionService,
+) *AdminHandler {
 	return &AdminHandler{
-		userService:   userService,
-		courseService: courseService,
+		userService:         userService,
+		courseService:       courseService,
+		verificationService: verificationService,
+		sessionService:      sessionService,
 	}
 }
 
@@ -30,21 +42,93 @@ func (h *AdminHandler) GetAllUsers(c *gin.Context) {
 	c.JSON(200, users)
 }
 
+// CreateUser creates an account either with a password chosen by the admin
+// or, when send_invite is set, by emailing the user an invitation link to
+// choose their own password
 func (h *AdminHandler) CreateUser(c *gin.Context) {
-	var user models.User
-	if err := c.ShouldBindJSON(&user); err != nil {
+	var input struct {
+		Email      string `json:"email" binding:"required,email"`
+		Password   string `json:"password"`
+		FirstName  string `json:"first_name" binding:"required"`
+		LastName   string `json:"last_name" binding:"required"`
+		Role       string `json:"role" binding:"required,oneof=admin teacher student parent librarian"`
+		SendInvite bool   `json:"send_invite"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(400, gin.H{"error": "invalid user data"})
 		return
 	}
 
-	if err := h.userService.CreateUser(&user); err != nil {
-		c.JSON(500, gin.H{"error": "failed to create user"})
+	actor, _ := c.Get("user")
+	if err := h.userService.CheckRoleAssignment(actor.(*models.User), input.Role); err != nil {
+		c.JSON(403, gin.H{"error": err.Error()})
 		return
 	}
 
+	user := &models.User{
+		Email:     input.Email,
+		FirstName: input.FirstName,
+		LastName:  input.LastName,
+		Role:      input.Role,
+	}
+
+	if input.SendInvite {
+		if err := h.verificationService.WithContext(c.Request.Context()).InviteUser(user); err != nil {
+			if !errors.Is(err, service.ErrEmailDelivery) {
+				c.JSON(500, gin.H{"error": "failed to create user"})
+				return
+			}
+			// The account exists; the invitation can be resent later
+			log.Printf("Failed to send invitation to user %d: %v", user.ID, err)
+		}
+	} else {
+		if err := user.SetPassword(input.Password); err != nil {
+			c.JSON(400, gin.H{"error": "invalid password: " + err.Error()})
+			return
+		}
+		// The admin vouches for the address when choosing the password
+		now := time.Now()
+		user.EmailVerified = true
+		user.EmailVerifiedAt = &now
+
+		if err := h.userService.WithContext(c.Request.Context()).CreateUser(user); err != nil {
+			c.JSON(500, gin.H{"error": "failed to create user"})
+			return
+		}
+	}
+
+	// Don't return the password hash in the response
+	user.Password = ""
+
 	c.JSON(201, user)
 }
 
+// ResendInvitation sends a fresh invitation link to a user who has not accepted yet
+func (h *AdminHandler) ResendInvitation(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	user, err := h.userService.GetUserByID(uint(id))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "user not found"})
+		return
+	}
+	if user.EmailVerified {
+		c.JSON(409, gin.H{"error": "user has already activated their account"})
+		return
+	}
+
+	if err := h.verificationService.WithContext(c.Request.Context()).SendInvitation(user); err != nil {
+		c.JSON(500, gin.H{"error": "failed to send invitation"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "invitation sent"})
+}
+
 func (h *AdminHandler) GetUserByID(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
@@ -61,6 +145,9 @@ func (h *AdminHandler) GetUserByID(c *gin.Context) {
 	c.JSON(200, user)
 }
 
+// UpdateUser updates a user's profile fields. Only the fields present in the
+// request are changed; role changes are checked against the role policy and
+// recorded in the audit trail.
 func (h *AdminHandler) UpdateUser(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
@@ -68,7 +155,13 @@ func (h *AdminHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
-	var updateData models.User
+	var updateData struct {
+		FirstName *string `json:"first_name"`
+		LastName  *string `json:"last_name"`
+		Email     *string `json:"email" binding:"omitempty,email"`
+		Role      *string `json:"role"`
+		Reason    string  `json:"reason"` // Why the role was changed
+	}
 	if err := c.ShouldBindJSON(&updateData); err != nil {
 		c.JSON(400, gin.H{"error": "invalid user data"})
 		return
@@ -81,19 +174,70 @@ func (h *AdminHandler) UpdateUser(c *gin.Context) {
 	}
 
 	// Update user fields
-	user.FirstName = updateData.FirstName
-	user.LastName = updateData.LastName
-	user.Email = updateData.Email
-	user.Role = updateData.Role
+	if updateData.FirstName != nil && *updateData.FirstName != "" {
+		user.FirstName = *updateData.FirstName
+	}
+	if updateData.LastName != nil && *updateData.LastName != "" {
+		user.LastName = *updateData.LastName
+	}
+
+	emailChanged := updateData.Email != nil && *updateData.Email != "" && *updateData.Email != user.Email
+	if emailChanged {
+		// A new address is unverified until the link sent to it is opened
+		err = h.verificationService.WithContext(c.Request.Context()).ChangeEmail(user, *updateData.Email)
+		switch {
+		case errors.Is(err, service.ErrEmailTaken):
+			c.JSON(409, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, service.ErrEmailDelivery):
+			log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+		case err != nil:
+			c.JSON(500, gin.H{"error": "failed to update user"})
+			return
+		}
+	}
 
-	if err := h.userService.UpdateUser(user); err != nil {
-		c.JSON(500, gin.H{"error": "failed to update user"})
+	switch {
+	case updateData.Role != nil:
+		actor, _ := c.Get("user")
+		err = h.userService.WithContext(c.Request.Context()).ChangeRole(actor.(*models.User), user, *updateData.Role, updateData.Reason)
+	case !emailChanged:
+		err = h.userService.WithContext(c.Request.Context()).UpdateUser(user)
+	default:
+		err = nil // Saved with the email
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidRole):
+			c.JSON(400, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrRoleNotAllowed), errors.Is(err, service.ErrOwnRoleChange):
+			c.JSON(403, gin.H{"error": err.Error()})
+		default:
+			c.JSON(500, gin.H{"error": "failed to update user"})
+		}
 		return
 	}
 
 	c.JSON(200, user)
 }
 
+// GetUserRoleHistory returns the audit trail of role changes for a user
+func (h *AdminHandler) GetUserRoleHistory(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	changes, err := h.userService.GetRoleHistory(uint(id))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to fetch role history"})
+		return
+	}
+
+	c.JSON(200, changes)
+}
+
 func (h *AdminHandler) DeleteUser(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
@@ -101,11 +245,16 @@ func (h *AdminHandler) DeleteUser(c *gin.Context) {
 		return
 	}
 
-	if err := h.userService.DeleteUser(uint(id)); err != nil {
+	if err := h.userService.WithContext(c.Request.Context()).DeleteUser(uint(id)); err != nil {
 		c.JSON(500, gin.H{"error": "failed to delete user"})
 		return
 	}
 
+	// Deleted users must not keep working tokens
+	if _, err := h.sessionService.WithContext(c.Request.Context()).RevokeAll(uint(id), ""); err != nil {
+		log.Printf("Failed to revoke sessions of deleted user %d: %v", id, err)
+	}
+
 	c.Status(204)
 }
 
@@ -117,7 +266,7 @@ func (h *AdminHandler) CreateCourse(c *gin.Context) {
 		return
 	}
 
-	if err := h.courseService.CreateCourse(&course); err != nil {
+	if err := h.courseService.WithContext(c.Request.Context()).CreateCourse(&course); err != nil {
 		c.JSON(500, gin.H{"error": "failed to create course"})
 		return
 	}
@@ -149,7 +298,7 @@ func (h *AdminHandler) UpdateCourse(c *gin.Context) {
 	course.Description = updateData.Description
 	course.TeacherID = updateData.TeacherID
 
-	if err := h.courseService.UpdateCourse(course); err != nil {
+	if err := h.courseService.WithContext(c.Request.Context()).UpdateCourse(course); err != nil {
 		c.JSON(500, gin.H{"error": "failed to update course"})
 		return
 	}
@@ -164,7 +313,7 @@ func (h *AdminHandler) DeleteCourse(c *gin.Context) {
 		return
 	}
 
-	if err := h.courseService.DeleteCourse(uint(id)); err != nil {
+	if err := h.courseService.WithContext(c.Request.Context()).DeleteCourse(uint(id)); err != nil {
 		c.JSON(500, gin.H{"error": "failed to delete course"})
 		return
 	}
@@ -188,7 +337,7 @@ func (h *AdminHandler) EnrollStudent(c *gin.Context) {
 		return
 	}
 
-	if err := h.courseService.EnrollStudent(uint(courseID), enrollment.StudentID); err != nil {
+	if err := h.courseService.WithContext(c.Request.Context()).EnrollStudent(uint(courseID), enrollment.StudentID); err != nil {
 		c.JSON(500, gin.H{"error": "failed to enroll student"})
 		return
 	}
@@ -209,7 +358,7 @@ func (h *AdminHandler) RemoveEnrollment(c *gin.Context) {
 		return
 	}
 
-	if err := h.courseService.RemoveEnrollment(uint(courseID), uint(studentID)); err != nil {
+	if err := h.courseService.WithContext(c.Request.Context()).RemoveEnrollment(uint(courseID), uint(studentID)); err != nil {
 		c.JSON(500, gin.H{"error": "failed to remove enrollment"})
 		return
 	}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHandler lets admins manage service accounts and their API keys
+type APIKeyHandler struct {
+	apiKeyService *service.APIKeyService
+}
+
+func NewAPIKeyHandler(apiKeyService *service.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{apiKeyService: apiKeyService}
+}
+
+// rejectAPIKeyAuth keeps API keys from minting further keys; only a person
+// may manage credentials
+func rejectAPIKeyAuth(c *gin.Context) bool {
+	if c.GetString("authMethod") == "api_key" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API keys cannot manage service accounts or API keys"})
+		return true
+	}
+	return false
+}
+
+// ListServiceAccounts lists all service accounts
+func (h *APIKeyHandler) ListServiceAccounts(c *gin.Context) {
+	accounts, err := h.apiKeyService.ListServiceAccounts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch service accounts"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"service_accounts": accounts})
+}
+
+// CreateServiceAccount creates a machine user
+func (h *APIKeyHandler) CreateServiceAccount(c *gin.Context) {
+	if rejectAPIKeyAuth(c) {
+		return
+	}
+	actor := c.MustGet("user").(*models.User)
+
+	var request struct {
+		Name string `json:"name" binding:"required,max=100"`
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	account, err := h.apiKeyService.WithContext(c.Request.Context()).CreateServiceAccount(actor, request.Name, request.Role)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, account)
+}
+
+// DeleteServiceAccount revokes a service account's keys and deletes it
+func (h *APIKeyHandler) DeleteServiceAccount(c *gin.Context) {
+	if rejectAPIKeyAuth(c) {
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid service account ID"})
+		return
+	}
+
+	if err := h.apiKeyService.WithContext(c.Request.Context()).DeleteServiceAccount(uint(id)); err != nil {
+		h.handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "service account deleted"})
+}
+
+// ListKeys lists API keys, optionally filtered by ?service_account_id=
+func (h *APIKeyHandler) ListKeys(c *gin.Context) {
+	var accountID uint64
+	if raw := c.Query("service_account_id"); raw != "" {
+		var err error
+		if accountID, err = strconv.ParseUint(raw, 10, 32); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid service account ID"})
+			return
+		}
+	}
+
+	keys, err := h.apiKeyService.ListKeys(uint(accountID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch API keys"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// CreateKey issues an API key. The key itself is only ever shown in this response.
+func (h *APIKeyHandler) CreateKey(c *gin.Context) {
+	if rejectAPIKeyAuth(c) {
+		return
+	}
+	actor := c.MustGet("user").(*models.User)
+
+	var request struct {
+		ServiceAccountID uint       `json:"service_account_id" binding:"required"`
+		Name             string     `json:"name" binding:"required,max=100"`
+		Scopes           []string   `json:"scopes" binding:"required"`
+		ExpiresAt        *time.Time `json:"expires_at"` // Optional, defaults to API_KEY_DEFAULT_TTL
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	created, err := h.apiKeyService.WithContext(c.Request.Context()).CreateKey(actor, request.ServiceAccountID, request.Name, request.Scopes, request.ExpiresAt)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "store this key now, it cannot be shown again",
+		"key":     created.Key,
+		"api_key": created.APIKey,
+	})
+}
+
+// RevokeKey revokes an API key
+func (h *APIKeyHandler) RevokeKey(c *gin.Context) {
+	if rejectAPIKeyAuth(c) {
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid API key ID"})
+		return
+	}
+
+	if err := h.apiKeyService.WithContext(c.Request.Context()).RevokeKey(uint(id)); err != nil {
+		h.handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
+
+func (h *APIKeyHandler) handleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrServiceAccountNotFound), errors.Is(err, service.ErrAPIKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidScope), errors.Is(err, service.ErrInvalidExpiry),
+		errors.Is(err, service.ErrInvalidRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrRoleNotAllowed):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+	}
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+// AuditHandler exposes the audit log to admins
+type AuditHandler struct {
+	auditRepo *repository.AuditLogRepository
+}
+
+func NewAuditHandler(auditRepo *repository.AuditLogRepository) *AuditHandler {
+	return &AuditHandler{auditRepo: auditRepo}
+}
+
+// ListAuditLogs queries the audit log. Supported filters: entity_type,
+// entity_id, actor_id, action, request_id, from and to (RFC 3339), limit
+// and offset.
+func (h *AuditHandler) ListAuditLogs(c *gin.Context) {
+	filter := repository.AuditLogFilter{
+		EntityType: c.Query("entity_type"),
+		EntityID:   c.Query("entity_id"),
+		Action:     c.Query("action"),
+		RequestID:  c.Query("request_id"),
+	}
+
+	if raw := c.Query("actor_id"); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid actor_id"})
+			return
+		}
+		actorID := uint(id)
+		filter.ActorID = &actorID
+	}
+
+	var err error
+	if filter.From, err = parseTimeQuery(c, "from"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filter.To, err = parseTimeQuery(c, "to"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if limit, err := strconv.Atoi(c.DefaultQuery("limit", "100")); err == nil {
+		filter.Limit = limit
+	}
+	if offset, err := strconv.Atoi(c.DefaultQuery("offset", "0")); err == nil {
+		filter.Offset = offset
+	}
+
+	logs, err := h.auditRepo.List(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, logs)
+}
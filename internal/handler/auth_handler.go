@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -27,7 +28,7 @@ func (h *AuthHandler) Signup(c *gin.Context) {
 		Password  string `json:"password" binding:"required,min=8"`
 		FirstName string `json:"first_name" binding:"required"`
 		LastName  string `json:"last_name" binding:"required"`
-		Role      string `json:"role" binding:"required,oneof=admin teacher student parent"`
+		Role      string `json:"role"` // Optional, must be allowed for self-registration
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -39,8 +40,11 @@ func (h *AuthHandler) Signup(c *gin.Context) {
 	user, err := h.authService.Signup(input.Email, input.Password, input.FirstName, input.LastName, input.Role)
 	if err != nil {
 		statusCode := http.StatusInternalServerError
-		if err.Error() == "email already registered" {
+		switch err.Error() {
+		case "email already registered", "invalid role":
 			statusCode = http.StatusBadRequest
+		case "self-registration is disabled", "you are not allowed to assign this role":
+			statusCode = http.StatusForbidden
 		}
 		c.JSON(statusCode, gin.H{"error": err.Error()})
 		return
@@ -65,19 +69,67 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	}
 
 	// Use AuthService to handle user login
-	user, token, err := h.authService.Login(input.Email, input.Password)
+	result, err := h.authService.Login(input.Email, input.Password, clientInfo(c))
+	if errors.Is(err, service.ErrLoginThrottled) {
+		respondThrottled(c, err)
+		return
+	}
 	if err != nil {
 		statusCode := http.StatusInternalServerError
 		switch err.Error() {
 		case "invalid credentials":
 			statusCode = http.StatusUnauthorized
-		case "account is deactivated":
+		case "account is deactivated", "email address not verified", "this account signs in with single sign-on",
+			"service accounts cannot log in":
 			statusCode = http.StatusForbidden
 		}
 		c.JSON(statusCode, gin.H{"error": err.Error()})
 		return
 	}
 
+	if result.TwoFactorRequired {
+		c.JSON(http.StatusOK, gin.H{
+			"message":             "Two-factor authentication required",
+			"two_factor_required": true,
+			"challenge_token":     result.ChallengeToken,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Login successful",
+		"token":   result.Token,
+		"user":    result.User,
+	})
+}
+
+// VerifyTwoFactor completes a two-phase login
+func (h *AuthHandler) VerifyTwoFactor(c *gin.Context) {
+	var input struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+		Code           string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, token, err := h.authService.VerifyTwoFactor(input.ChallengeToken, input.Code, clientInfo(c))
+	if errors.Is(err, service.ErrLoginThrottled) {
+		respondThrottled(c, err)
+		return
+	}
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		switch err.Error() {
+		case "invalid or expired login challenge", "invalid two-factor code", "invalid credentials":
+			statusCode = http.StatusUnauthorized
+		}
+		c.JSON(statusCode, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Login successful",
 		"token":   token,
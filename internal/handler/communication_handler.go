@@ -0,0 +1,283 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/E-Timileyin/school-management-system/internal/model"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/service"
+)
+
+// CommunicationHandler serves the notice board
+type CommunicationHandler struct {
+	service *service.CommunicationService
+}
+
+func NewCommunicationHandler(communicationService *service.CommunicationService) *CommunicationHandler {
+	return &CommunicationHandler{service: communicationService}
+}
+
+// communicationRequest is the body of a new or changed communication
+type communicationRequest struct {
+	Title         string                  `json:"title" binding:"required,max=255"`
+	Content       string                  `json:"content" binding:"required"`
+	CommType      model.CommunicationType `json:"comm_type" binding:"required"`
+	Audience      model.AudienceType      `json:"audience" binding:"required"`
+	StartDate     *time.Time              `json:"start_date"`
+	EndDate       *time.Time              `json:"end_date"`
+	IsImportant   bool                    `json:"is_important"`
+	TargetClassID *uint                   `json:"target_class_id"`
+	TargetUserID  *uint                   `json:"target_user_id"`
+	Location      string                  `json:"location" binding:"max=255"`
+	IsAllDay      bool                    `json:"is_all_day"`
+	Attachments   []attachmentRequest     `json:"attachments"`
+}
+
+type attachmentRequest struct {
+	FileName string `json:"file_name" binding:"max=255"`
+	FileURL  string `json:"file_url"`
+	FileType string `json:"file_type" binding:"max=100"`
+	FileSize int64  `json:"file_size"`
+}
+
+func (r *communicationRequest) communication() *model.Communication {
+	communication := &model.Communication{
+		Title:         r.Title,
+		Content:       r.Content,
+		CommType:      r.CommType,
+		Audience:      r.Audience,
+		StartDate:     r.StartDate,
+		EndDate:       r.EndDate,
+		IsImportant:   r.IsImportant,
+		TargetClassID: r.TargetClassID,
+		TargetUserID:  r.TargetUserID,
+		Location:      r.Location,
+		IsAllDay:      r.IsAllDay,
+	}
+	if r.Attachments != nil {
+		communication.Attachments = make([]model.CommunicationAttachment, len(r.Attachments))
+		for i, attachment := range r.Attachments {
+			communication.Attachments[i] = model.CommunicationAttachment{
+				FileName: attachment.FileName,
+				FileURL:  attachment.FileURL,
+				FileType: attachment.FileType,
+				FileSize: attachment.FileSize,
+			}
+		}
+	}
+	return communication
+}
+
+// Feed Handlers
+
+// GetFeed lists the communications for the current user, important ones
+// first. Takes ?limit= (20 by default, at most 100) and ?offset=.
+func (h *CommunicationHandler) GetFeed(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+	feed, err := h.service.WithContext(c.Request.Context()).Feed(user, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch communications"})
+		return
+	}
+	c.JSON(http.StatusOK, feed)
+}
+
+// ReadCommunication opens a communication from the feed, which records a
+// read receipt
+func (h *CommunicationHandler) ReadCommunication(c *gin.Context) {
+	id, ok := communicationID(c)
+	if !ok {
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+	item, err := h.service.WithContext(c.Request.Context()).ReadCommunication(id, user)
+	if err != nil {
+		respondCommunicationError(c, err, "failed to fetch communication")
+		return
+	}
+	c.JSON(http.StatusOK, item)
+}
+
+// Authoring Handlers
+
+// ListCommunications lists what the current user wrote, or everything for
+// admins. Takes ?status=draft|scheduled|published|expired.
+func (h *CommunicationHandler) ListCommunications(c *gin.Context) {
+	status := c.Query("status")
+	switch status {
+	case "", "draft", "scheduled", "published", "expired":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be draft, scheduled, published or expired"})
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+	communications, err := h.service.WithContext(c.Request.Context()).ListCommunications(user, status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch communications"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"communications": communications})
+}
+
+// CreateCommunication saves a draft
+func (h *CommunicationHandler) CreateCommunication(c *gin.Context) {
+	var request communicationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+	communication := request.communication()
+	if err := h.service.WithContext(c.Request.Context()).CreateCommunication(user, communication); err != nil {
+		respondCommunicationError(c, err, "failed to create communication")
+		return
+	}
+	c.JSON(http.StatusCreated, communication)
+}
+
+// GetCommunication returns a communication to its author or an admin
+func (h *CommunicationHandler) GetCommunication(c *gin.Context) {
+	id, ok := communicationID(c)
+	if !ok {
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+	communication, err := h.service.WithContext(c.Request.Context()).GetCommunication(id, user)
+	if err != nil {
+		respondCommunicationError(c, err, "failed to fetch communication")
+		return
+	}
+	c.JSON(http.StatusOK, communication)
+}
+
+// UpdateCommunication replaces the content and settings of a
+// communication. Attachments are kept unless the body has attachments.
+func (h *CommunicationHandler) UpdateCommunication(c *gin.Context) {
+	id, ok := communicationID(c)
+	if !ok {
+		return
+	}
+	var request communicationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+	communication, err := h.service.WithContext(c.Request.Context()).UpdateCommunication(id, user, request.communication())
+	if err != nil {
+		respondCommunicationError(c, err, "failed to update communication")
+		return
+	}
+	c.JSON(http.StatusOK, communication)
+}
+
+// DeleteCommunication moves a communication to the trash
+func (h *CommunicationHandler) DeleteCommunication(c *gin.Context) {
+	id, ok := communicationID(c)
+	if !ok {
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+	if err := h.service.WithContext(c.Request.Context()).DeleteCommunication(id, user); err != nil {
+		respondCommunicationError(c, err, "failed to delete communication")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "communication deleted"})
+}
+
+// PublishCommunication publishes a communication now
+func (h *CommunicationHandler) PublishCommunication(c *gin.Context) {
+	h.changeState(c, (*service.CommunicationService).PublishCommunication)
+}
+
+// ScheduleCommunication publishes a communication at its start date
+func (h *CommunicationHandler) ScheduleCommunication(c *gin.Context) {
+	h.changeState(c, (*service.CommunicationService).ScheduleCommunication)
+}
+
+// UnpublishCommunication makes a communication a draft again
+func (h *CommunicationHandler) UnpublishCommunication(c *gin.Context) {
+	h.changeState(c, (*service.CommunicationService).UnpublishCommunication)
+}
+
+// GetReadReceipts shows who has and has not read a communication
+func (h *CommunicationHandler) GetReadReceipts(c *gin.Context) {
+	id, ok := communicationID(c)
+	if !ok {
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+	receipts, err := h.service.WithContext(c.Request.Context()).GetReadReceipts(id, user)
+	if err != nil {
+		respondCommunicationError(c, err, "failed to fetch read receipts")
+		return
+	}
+	c.JSON(http.StatusOK, receipts)
+}
+
+// changeState publishes, schedules or unpublishes the communication in the
+// path
+func (h *CommunicationHandler) changeState(c *gin.Context, change func(*service.CommunicationService, uint, *models.User) (*model.Communication, error)) {
+	id, ok := communicationID(c)
+	if !ok {
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+	communication, err := change(h.service.WithContext(c.Request.Context()), id, user)
+	if err != nil {
+		respondCommunicationError(c, err, "failed to update communication")
+		return
+	}
+	c.JSON(http.StatusOK, communication)
+}
+
+func communicationID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid communication ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func respondCommunicationError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, service.ErrCommunicationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrNotCommunicationAuthor):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidCommunication), errors.Is(err, service.ErrInvalidPublishWindow),
+		errors.Is(err, service.ErrInvalidTargetClass), errors.Is(err, service.ErrInvalidTargetUser),
+		errors.Is(err, service.ErrInvalidAttachment),
+		errors.Is(err, service.ErrCommunicationNotStarted):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrCommunicationPublished), errors.Is(err, service.ErrCommunicationEnded):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -96,7 +96,7 @@ func (h *CourseHandler) EnrollInCourse(c *gin.Context) {
 	}
 
 	studentID := user.(*models.User).ID
-	if err := h.courseService.EnrollStudent(uint(courseID), studentID); err != nil {
+	if err := h.courseService.WithContext(c.Request.Context()).EnrollStudent(uint(courseID), studentID); err != nil {
 		c.JSON(500, gin.H{"error": "failed to enroll in course"})
 		return
 	}
@@ -113,7 +113,7 @@ func (h *CourseHandler) WithdrawFromCourse(c *gin.Context) {
 	}
 
 	// Use the enrollmentID to find and delete the enrollment
-	if err := h.enrollmentService.DeleteEnrollment(uint(enrollmentID)); err != nil {
+	if err := h.enrollmentService.WithContext(c.Request.Context()).DeleteEnrollment(uint(enrollmentID)); err != nil {
 		c.JSON(500, gin.H{"error": "failed to withdraw from course"})
 		return
 	}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+	"github.com/E-Timileyin/school-management-system/internal/scheduler"
+	"github.com/gin-gonic/gin"
+)
+
+// JobHandler lets admins see and run scheduled background jobs
+type JobHandler struct {
+	scheduler *scheduler.Scheduler
+	runRepo   *repository.JobRunRepository
+}
+
+func NewJobHandler(scheduler *scheduler.Scheduler, runRepo *repository.JobRunRepository) *JobHandler {
+	return &JobHandler{scheduler: scheduler, runRepo: runRepo}
+}
+
+// ListJobs lists the registered jobs with their schedules, last and next
+// runs
+func (h *JobHandler) ListJobs(c *gin.Context) {
+	jobs, err := h.scheduler.Jobs(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch jobs"})
+		return
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+// ListRuns returns the job run history, most recent first. Supported
+// filters: job, status, limit and offset.
+func (h *JobHandler) ListRuns(c *gin.Context) {
+	filter := repository.JobRunFilter{
+		Job:    c.Query("job"),
+		Status: c.Query("status"),
+	}
+	if limit, err := strconv.Atoi(c.DefaultQuery("limit", "100")); err == nil {
+		filter.Limit = limit
+	}
+	if offset, err := strconv.Atoi(c.DefaultQuery("offset", "0")); err == nil {
+		filter.Offset = offset
+	}
+
+	runs, err := h.runRepo.WithContext(c.Request.Context()).List(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch job runs"})
+		return
+	}
+	c.JSON(http.StatusOK, runs)
+}
+
+// RunJob runs a job now and returns the run, which records whether it
+// failed
+func (h *JobHandler) RunJob(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	run, err := h.scheduler.RunNow(c.Request.Context(), c.Param("name"), userID.(uint))
+	switch {
+	case errors.Is(err, scheduler.ErrUnknownJob):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, scheduler.ErrJobRunning):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to run job"})
+	default:
+		c.JSON(http.StatusOK, run)
+	}
+}
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// KeyHandler publishes the token signing keys and lets admins rotate them
+type KeyHandler struct {
+	keyManager *service.KeyManager
+}
+
+func NewKeyHandler(keyManager *service.KeyManager) *KeyHandler {
+	return &KeyHandler{keyManager: keyManager}
+}
+
+// JWKS serves the public signing keys so other services can verify tokens
+func (h *KeyHandler) JWKS(c *gin.Context) {
+	// Keys are published ahead of use, so verifiers may cache the set briefly
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": h.keyManager.PublicKeys()})
+}
+
+// ListSigningKeys shows the key set without key material
+func (h *KeyHandler) ListSigningKeys(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"keys": h.keyManager.ListKeys()})
+}
+
+// RotateSigningKey replaces the signing key immediately
+func (h *KeyHandler) RotateSigningKey(c *gin.Context) {
+	actor := c.MustGet("user").(*models.User)
+
+	key, err := h.keyManager.Rotate(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, service.ErrRotationUnsupported) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to rotate signing key"})
+		return
+	}
+
+	log.Printf("Signing key rotated by user %d, new key %s", actor.ID, key.KID)
+	c.JSON(http.StatusOK, gin.H{"message": "signing key rotated", "key": key})
+}
@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/E-Timileyin/school-management-system/internal/service"
+)
+
+// KioskHandler serves the self-service kiosks and lets admins register them
+type KioskHandler struct {
+	service *service.KioskService
+}
+
+func NewKioskHandler(kioskService *service.KioskService) *KioskHandler {
+	return &KioskHandler{service: kioskService}
+}
+
+// Kiosk Management Handlers
+
+// ListKiosks lists registered kiosks, including revoked ones
+func (h *KioskHandler) ListKiosks(c *gin.Context) {
+	kiosks, err := h.service.ListKiosks()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch kiosks"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"kiosks": kiosks})
+}
+
+// RegisterKiosk registers a kiosk. Its credential is only ever shown in this
+// response.
+func (h *KioskHandler) RegisterKiosk(c *gin.Context) {
+	if rejectAPIKeyAuth(c) {
+		return
+	}
+	var request struct {
+		Name     string `json:"name" binding:"required,max=100"`
+		Location string `json:"location" binding:"max=200"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	registered, err := h.service.WithContext(c.Request.Context()).RegisterKiosk(request.Name, request.Location, c.GetUint("userID"))
+	if err != nil {
+		respondKioskError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "store this credential on the kiosk now, it cannot be shown again",
+		"credential": registered.Credential,
+		"kiosk":      registered.Kiosk,
+	})
+}
+
+// RevokeKiosk revokes the credential of a kiosk
+func (h *KioskHandler) RevokeKiosk(c *gin.Context) {
+	if rejectAPIKeyAuth(c) {
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid kiosk ID"})
+		return
+	}
+
+	if err := h.service.WithContext(c.Request.Context()).RevokeKiosk(uint(id)); err != nil {
+		respondKioskError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "kiosk revoked"})
+}
+
+// Kiosk Handlers
+
+// Checkout lends a scanned copy to the holder of a scanned library card
+func (h *KioskHandler) Checkout(c *gin.Context) {
+	var request struct {
+		CardNumber string `json:"card_number" binding:"required"`
+		Barcode    string `json:"barcode" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scan your library card and the book"})
+		return
+	}
+
+	loan, err := h.service.WithContext(c.Request.Context()).Checkout(c.GetUint("kioskID"), request.CardNumber, request.Barcode)
+	if err != nil {
+		respondKioskError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, loan)
+}
+
+// Return takes back a scanned copy. No card is needed.
+func (h *KioskHandler) Return(c *gin.Context) {
+	var request struct {
+		Barcode string `json:"barcode" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scan the book"})
+		return
+	}
+
+	loan, err := h.service.WithContext(c.Request.Context()).Return(c.GetUint("kioskID"), request.Barcode)
+	if err != nil {
+		respondKioskError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, loan)
+}
+
+// Loans shows the holder of a scanned library card what they have on loan.
+// The card number is sent in the body so it stays out of access logs.
+func (h *KioskHandler) Loans(c *gin.Context) {
+	var request struct {
+		CardNumber string `json:"card_number" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scan your library card"})
+		return
+	}
+
+	loans, err := h.service.WithContext(c.Request.Context()).Loans(request.CardNumber)
+	if err != nil {
+		respondKioskError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, loans)
+}
+
+// respondKioskError reports kiosk errors; checkouts and returns refused by
+// the library are reported as at the circulation desk
+func respondKioskError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrKioskNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidKiosk), errors.Is(err, service.ErrBarcodeRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		var refusal *service.PolicyError
+		if _, ok := libraryErrorStatus(err); ok || errors.As(err, &refusal) || errors.Is(err, service.ErrUserNotFound) {
+			respondCirculationError(c, err)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+	}
+}
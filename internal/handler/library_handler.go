@@ -1,11 +1,22 @@
 package handler
 
 import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
 	"net/http"
+	"reflect"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
 	"github.com/E-Timileyin/school-management-system/internal/model"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/pdf"
+	"github.com/E-Timileyin/school-management-system/internal/repository"
 	"github.com/E-Timileyin/school-management-system/internal/service"
 )
 
@@ -19,20 +30,61 @@ func NewLibraryHandler(service *service.LibraryService) *LibraryHandler {
 
 // Book Handlers
 func (h *LibraryHandler) CreateBook(c *gin.Context) {
-	var book model.Book
-	if err := c.ShouldBindJSON(&book); err != nil {
+	var request bookRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if err := h.service.AddNewBook(&book); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	var book model.Book
+	request.apply(&book)
+
+	if err := h.service.WithContext(c.Request.Context()).AddNewBook(&book); err != nil {
+		respondLibraryError(c, err, "failed to create book")
 		return
 	}
 
 	c.JSON(http.StatusCreated, book)
 }
 
+// IntakeBook catalogues a scanned ISBN. Details of new books are looked up
+// by ISBN; copies of a book already in the catalogue are added to it.
+func (h *LibraryHandler) IntakeBook(c *gin.Context) {
+	var request struct {
+		ISBN       string          `json:"isbn" binding:"required"`
+		CategoryID uint            `json:"category_id"`
+		Copies     int             `json:"copies"`
+		RackNumber string          `json:"rack_number"`
+		Price      decimal.Decimal `json:"price"`
+		Title      string          `json:"title"`
+		Author     string          `json:"author"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	book, created, err := h.service.WithContext(c.Request.Context()).IntakeBook(c.Request.Context(), service.BookIntake{
+		ISBN:       request.ISBN,
+		CategoryID: request.CategoryID,
+		Copies:     request.Copies,
+		RackNumber: request.RackNumber,
+		Price:      request.Price,
+		Title:      request.Title,
+		Author:     request.Author,
+	})
+	if err != nil {
+		respondLibraryError(c, err, "failed to catalogue book")
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, gin.H{"book": book, "created": created})
+}
+
 func (h *LibraryHandler) GetBook(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
@@ -41,7 +93,7 @@ func (h *LibraryHandler) GetBook(c *gin.Context) {
 	}
 
 	book, err := h.service.GetBookByID(uint(id))
-	if err != nil {
+	if err != nil || (!book.IsActive && !isLibraryStaff(c)) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
 		return
 	}
@@ -50,32 +102,212 @@ func (h *LibraryHandler) GetBook(c *gin.Context) {
 }
 
 // Library Card Handlers
+
+// IssueLibraryCard gives a user their library card, valid for
+// validForYears (1 by default)
 func (h *LibraryHandler) IssueLibraryCard(c *gin.Context) {
-	userID, err := strconv.ParseUint(c.Param("userId"), 10, 32)
+	var request struct {
+		UserID        uint `json:"userId" binding:"required"`
+		ValidForYears int  `json:"validForYears"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.ValidForYears == 0 {
+		request.ValidForYears = 1
+	}
+
+	card, err := h.service.WithContext(c.Request.Context()).IssueLibraryCard(request.UserID, request.ValidForYears, c.GetUint("userID"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		if errors.Is(err, service.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		respondLibraryError(c, err, "Failed to issue library card")
 		return
 	}
 
-	validForYears := 1 // Default validity
-	if years, err := strconv.Atoi(c.DefaultQuery("validForYears", "1")); err == nil && years > 0 {
-		validForYears = years
+	c.JSON(http.StatusCreated, card)
+}
+
+// GetLibraryCard returns a card and its history. Readers can see their own.
+func (h *LibraryHandler) GetLibraryCard(c *gin.Context) {
+	cardID, err := strconv.ParseUint(c.Param("cardId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid card ID"})
+		return
 	}
 
-	card, err := h.service.IssueLibraryCard(uint(userID), validForYears)
+	card, err := h.service.WithContext(c.Request.Context()).GetLibraryCard(uint(cardID), c.GetUint("userID"), isLibraryStaff(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondLibraryError(c, err, "Failed to fetch library card")
 		return
 	}
+	c.JSON(http.StatusOK, card)
+}
 
-	c.JSON(http.StatusCreated, card)
+// RenewLibraryCard extends a card by years (1 by default)
+func (h *LibraryHandler) RenewLibraryCard(c *gin.Context) {
+	var request struct {
+		Years int `json:"years"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if request.Years == 0 {
+		request.Years = 1
+	}
+	h.changeCard(c, func(s *service.LibraryService, cardID, staffID uint) (*model.LibraryCard, error) {
+		return s.RenewLibraryCard(cardID, request.Years, staffID)
+	})
+}
+
+// BlockLibraryCard blocks a card, with a reason
+func (h *LibraryHandler) BlockLibraryCard(c *gin.Context) {
+	var request struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": service.ErrBlockReason.Error()})
+		return
+	}
+	h.changeCard(c, func(s *service.LibraryService, cardID, staffID uint) (*model.LibraryCard, error) {
+		return s.BlockLibraryCard(cardID, request.Reason, staffID)
+	})
+}
+
+// UnblockLibraryCard lifts the block of a card, optionally with a reason
+func (h *LibraryHandler) UnblockLibraryCard(c *gin.Context) {
+	var request struct {
+		Reason string `json:"reason"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	h.changeCard(c, func(s *service.LibraryService, cardID, staffID uint) (*model.LibraryCard, error) {
+		return s.UnblockLibraryCard(cardID, request.Reason, staffID)
+	})
+}
+
+// ReplaceLibraryCard gives a card a new number; the old one stops working
+func (h *LibraryHandler) ReplaceLibraryCard(c *gin.Context) {
+	var request struct {
+		Reason string `json:"reason"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	h.changeCard(c, func(s *service.LibraryService, cardID, staffID uint) (*model.LibraryCard, error) {
+		return s.ReplaceLibraryCard(cardID, request.Reason, staffID)
+	})
+}
+
+// SetCardLimit sets how many books the card may have on loan. A null
+// max_books goes back to the limit of the holder's role.
+func (h *LibraryHandler) SetCardLimit(c *gin.Context) {
+	var request struct {
+		MaxBooks *int `json:"max_books"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.changeCard(c, func(s *service.LibraryService, cardID, staffID uint) (*model.LibraryCard, error) {
+		return s.SetCardLimit(cardID, request.MaxBooks, staffID)
+	})
+}
+
+// changeCard runs a change to the card in the path as the signed in
+// member of staff and responds with the card
+func (h *LibraryHandler) changeCard(c *gin.Context, change func(*service.LibraryService, uint, uint) (*model.LibraryCard, error)) {
+	cardID, err := strconv.ParseUint(c.Param("cardId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid card ID"})
+		return
+	}
+
+	card, err := change(h.service.WithContext(c.Request.Context()), uint(cardID), c.GetUint("userID"))
+	if err != nil {
+		respondLibraryError(c, err, "Failed to update library card")
+		return
+	}
+	c.JSON(http.StatusOK, card)
+}
+
+// PrintLibraryCard renders a card as a PDF to print, with its number as a
+// barcode. Readers can print their own.
+func (h *LibraryHandler) PrintLibraryCard(c *gin.Context) {
+	cardID, err := strconv.ParseUint(c.Param("cardId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid card ID"})
+		return
+	}
+
+	card, holder, err := h.service.WithContext(c.Request.Context()).GetCardHolder(uint(cardID), c.GetUint("userID"), isLibraryStaff(c))
+	if err != nil {
+		respondLibraryError(c, err, "Failed to fetch library card")
+		return
+	}
+
+	document, err := pdf.LibraryCard(pdf.Card{
+		Title:      "Library card",
+		Holder:     holder.FirstName + " " + holder.LastName,
+		CardNumber: card.CardNumber,
+		Lines: [][2]string{
+			{"Role", string(holder.Role)},
+			{"Valid until", card.ExpiryDate.Format("2 Jan 2006")},
+		},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to render library card"})
+		return
+	}
+
+	c.Header("Content-Disposition", `inline; filename="library-card-`+card.CardNumber+`.pdf"`)
+	c.Data(http.StatusOK, "application/pdf", document)
+}
+
+// GetBorrowerStatus tells the desk whether a user may borrow, on what terms,
+// and if not, why
+func (h *LibraryHandler) GetBorrowerStatus(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("userId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	status, err := h.service.WithContext(c.Request.Context()).GetBorrowerStatus(uint(userID))
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check borrower"})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
 }
 
 // Book Circulation Handlers
+
+// CheckoutBook lends a copy, identified by its scanned barcode, to a user.
+// Sending bookId instead lends any copy of the book that is on the shelf.
 func (h *LibraryHandler) CheckoutBook(c *gin.Context) {
 	var request struct {
-		BookID uint `json:"bookId" binding:"required"`
-		UserID uint `json:"userId" binding:"required"`
+		Barcode string `json:"barcode"`
+		BookID  uint   `json:"bookId"`
+		UserID  uint   `json:"userId" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -90,18 +322,24 @@ func (h *LibraryHandler) CheckoutBook(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.CheckoutBook(request.BookID, request.UserID, staffID.(uint)); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	issue, err := h.service.WithContext(c.Request.Context()).CheckoutBook(request.Barcode, request.BookID, request.UserID, model.StaffAttendant(staffID.(uint)))
+	if err != nil {
+		respondCirculationError(c, err)
 		return
 	}
 
-	c.Status(http.StatusNoContent)
+	c.JSON(http.StatusCreated, issue)
 }
 
+// ReturnBook takes back a copy, identified by its scanned barcode or by the
+// issueId of the loan
 func (h *LibraryHandler) ReturnBook(c *gin.Context) {
-	issueID, err := strconv.ParseUint(c.Param("issueId"), 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid issue ID"})
+	var request struct {
+		Barcode string `json:"barcode"`
+		IssueID uint   `json:"issueId"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || (request.Barcode == "") == (request.IssueID == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "send either barcode or issueId"})
 		return
 	}
 
@@ -112,34 +350,1229 @@ func (h *LibraryHandler) ReturnBook(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.ReturnBook(uint(issueID), receivedBy.(uint)); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	libraryService := h.service.WithContext(c.Request.Context())
+	if request.Barcode != "" {
+		issue, err := libraryService.ReturnCopy(request.Barcode, model.StaffAttendant(receivedBy.(uint)))
+		if err != nil {
+			respondCirculationError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, issue)
+		return
+	}
+
+	if err := libraryService.ReturnBook(request.IssueID, model.StaffAttendant(receivedBy.(uint))); err != nil {
+		respondCirculationError(c, err)
 		return
 	}
 
 	c.Status(http.StatusNoContent)
 }
 
-// Fine Handlers
-func (h *LibraryHandler) PayFine(c *gin.Context) {
+// GetLoan returns a loan with its renewal history
+func (h *LibraryHandler) GetLoan(c *gin.Context) {
+	issueID, err := strconv.ParseUint(c.Param("issueId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid issue ID"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	issue, err := h.service.WithContext(c.Request.Context()).GetLoan(uint(issueID), userID.(uint), isLibraryStaff(c))
+	if err != nil {
+		respondLibraryError(c, err, "failed to fetch loan")
+		return
+	}
+	c.JSON(http.StatusOK, issue)
+}
+
+// RenewLoan extends the due date of a loan. Borrowers renew their own
+// loans; staff can renew anyone's.
+func (h *LibraryHandler) RenewLoan(c *gin.Context) {
+	issueID, err := strconv.ParseUint(c.Param("issueId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid issue ID"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	issue, err := h.service.WithContext(c.Request.Context()).RenewLoan(uint(issueID), userID.(uint), isLibraryStaff(c))
+	if err != nil {
+		respondCirculationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, issue)
+}
+
+// DeclareLost closes a loan whose book the borrower lost and charges them
+// for it
+func (h *LibraryHandler) DeclareLost(c *gin.Context) {
+	h.closeLoan(c, (*service.LibraryService).DeclareLost)
+}
+
+// ReturnDamaged takes back a book too damaged to lend again and charges the
+// borrower for it
+func (h *LibraryHandler) ReturnDamaged(c *gin.Context) {
+	h.closeLoan(c, (*service.LibraryService).ReturnDamaged)
+}
+
+// RecoverLost takes back a book that was declared lost and reverses the
+// charge for it
+func (h *LibraryHandler) RecoverLost(c *gin.Context) {
+	h.closeLoan(c, (*service.LibraryService).RecoverLost)
+}
+
+// closeLoan runs one of the ways of closing the loan in the issueId path
+// parameter on behalf of the current staff member
+func (h *LibraryHandler) closeLoan(c *gin.Context, action func(*service.LibraryService, uint, uint) (*model.BookIssue, error)) {
 	issueID, err := strconv.ParseUint(c.Param("issueId"), 10, 32)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid issue ID"})
 		return
 	}
 
-	var payment model.FinePayment
-	if err := c.ShouldBindJSON(&payment); err != nil {
+	staffID, _ := c.Get("userID")
+	issue, err := action(h.service.WithContext(c.Request.Context()), uint(issueID), staffID.(uint))
+	if err != nil {
+		respondLibraryError(c, err, "failed to update loan")
+		return
+	}
+	c.JSON(http.StatusOK, issue)
+}
+
+// Reservation Handlers
+
+// PlaceReservation queues the current user for a book. Staff may reserve
+// for another reader by sending userId.
+func (h *LibraryHandler) PlaceReservation(c *gin.Context) {
+	bookID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+	var request struct {
+		UserID uint `json:"userId"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	userID, _ := c.Get("userID")
+	readerID := userID.(uint)
+	if request.UserID != 0 && request.UserID != readerID {
+		if !isLibraryStaff(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only library staff can reserve for other readers"})
+			return
+		}
+		readerID = request.UserID
+	}
+
+	reservation, err := h.service.WithContext(c.Request.Context()).PlaceReservation(uint(bookID), readerID)
+	if err != nil {
+		respondCirculationError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, reservation)
+}
+
+// ListReservations lists the current user's reservations. Staff may list
+// another reader's with ?user_id=.
+func (h *LibraryHandler) ListReservations(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	readerID := userID.(uint)
+	if raw := c.Query("user_id"); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		if uint(id) != readerID && !isLibraryStaff(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only library staff can see other readers' reservations"})
+			return
+		}
+		readerID = uint(id)
+	}
+
+	reservations, err := h.service.WithContext(c.Request.Context()).ListReservations(readerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch reservations"})
+		return
+	}
+	c.JSON(http.StatusOK, reservations)
+}
+
+// ListBookReservations shows staff the queue for a book
+func (h *LibraryHandler) ListBookReservations(c *gin.Context) {
+	bookID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+
+	reservations, err := h.service.WithContext(c.Request.Context()).ListBookReservations(uint(bookID))
+	if err != nil {
+		respondLibraryError(c, err, "failed to fetch reservations")
+		return
+	}
+	c.JSON(http.StatusOK, reservations)
+}
+
+// CancelReservation takes a reservation out of the queue
+func (h *LibraryHandler) CancelReservation(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reservation ID"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	reservation, err := h.service.WithContext(c.Request.Context()).CancelReservation(uint(id), userID.(uint), isLibraryStaff(c))
+	if err != nil {
+		respondLibraryError(c, err, "failed to cancel reservation")
+		return
+	}
+	c.JSON(http.StatusOK, reservation)
+}
+
+// Copy Handlers
+
+// ListCopies lists the physical copies of a book
+func (h *LibraryHandler) ListCopies(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+
+	copies, err := h.service.ListCopies(uint(id))
+	if err != nil {
+		respondLibraryError(c, err, "failed to fetch copies")
+		return
+	}
+
+	c.JSON(http.StatusOK, copies)
+}
+
+// AddCopies puts new copies of a book on the shelf
+func (h *LibraryHandler) AddCopies(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+
+	var request struct {
+		Count      int    `json:"count" binding:"required"`
+		RackNumber string `json:"rack_number"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	payment.IssueID = uint(issueID)
+	copies, err := h.service.WithContext(c.Request.Context()).AddCopies(uint(id), request.Count, request.RackNumber)
+	if err != nil {
+		respondLibraryError(c, err, "failed to add copies")
+		return
+	}
+
+	c.JSON(http.StatusCreated, copies)
+}
 
-	if err := h.service.RecordFinePayment(&payment); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+// FindCopy looks up a copy by its scanned barcode
+func (h *LibraryHandler) FindCopy(c *gin.Context) {
+	barcode := c.Query("barcode")
+	if barcode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "barcode is required"})
 		return
 	}
 
-	c.Status(http.StatusNoContent)
+	bookCopy, err := h.service.GetCopyByBarcode(barcode)
+	if err != nil {
+		respondLibraryError(c, err, "failed to fetch copy")
+		return
+	}
+
+	c.JSON(http.StatusOK, bookCopy)
+}
+
+// UpdateCopy changes the barcode, condition, rack or status of a copy.
+// Only the fields sent are updated.
+func (h *LibraryHandler) UpdateCopy(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid copy ID"})
+		return
+	}
+
+	var request struct {
+		Barcode    *string `json:"barcode"`
+		Condition  *string `json:"condition"`
+		RackNumber *string `json:"rack_number"`
+		Status     *string `json:"status"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	libraryService := h.service.WithContext(c.Request.Context())
+	bookCopy, err := libraryService.GetCopyByID(uint(id))
+	if err != nil {
+		respondLibraryError(c, err, "failed to fetch copy")
+		return
+	}
+	previousStatus := bookCopy.Status
+	setIfPresent(&bookCopy.Barcode, request.Barcode)
+	setIfPresent(&bookCopy.Condition, request.Condition)
+	setIfPresent(&bookCopy.RackNumber, request.RackNumber)
+	setIfPresent(&bookCopy.Status, request.Status)
+
+	if err := libraryService.UpdateCopy(bookCopy, previousStatus); err != nil {
+		respondLibraryError(c, err, "failed to update copy")
+		return
+	}
+
+	c.JSON(http.StatusOK, bookCopy)
+}
+
+// PrintLabels renders Code128 barcode labels as a PDF for printing on A4
+// label sheets: for all copies of book_id, or for the copies listed in
+// copy_ids (comma separated)
+func (h *LibraryHandler) PrintLabels(c *gin.Context) {
+	var bookID uint64
+	var copyIDs []uint
+	if raw := c.Query("copy_ids"); raw != "" {
+		for _, part := range strings.Split(raw, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 32)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid copy_ids"})
+				return
+			}
+			copyIDs = append(copyIDs, uint(id))
+		}
+	} else {
+		var err error
+		if bookID, err = strconv.ParseUint(c.Query("book_id"), 10, 32); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "book_id or copy_ids is required"})
+			return
+		}
+	}
+
+	copies, err := h.service.LabelCopies(uint(bookID), copyIDs)
+	if err != nil {
+		respondLibraryError(c, err, "failed to fetch copies")
+		return
+	}
+
+	labels := make([]pdf.Label, len(copies))
+	for i, bookCopy := range copies {
+		labels[i] = pdf.Label{Barcode: bookCopy.Barcode, Caption: bookCopy.AccessionNumber}
+		if bookCopy.Book != nil {
+			labels[i].Title = bookCopy.Book.Title
+		}
+		if bookCopy.RackNumber != "" {
+			labels[i].Caption += " / " + bookCopy.RackNumber
+		}
+	}
+	document, err := pdf.BarcodeLabels(labels)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to render labels"})
+		return
+	}
+
+	c.Header("Content-Disposition", `inline; filename="labels.pdf"`)
+	c.Data(http.StatusOK, "application/pdf", document)
+}
+
+// Fine Handlers
+
+// GetFine explains the overdue fine of a loan, accrued so far if it is still
+// open, and what losing the book would cost
+func (h *LibraryHandler) GetFine(c *gin.Context) {
+	issueID, err := strconv.ParseUint(c.Param("issueId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid issue ID"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	quote, err := h.service.WithContext(c.Request.Context()).GetFine(uint(issueID), userID.(uint), isLibraryStaff(c))
+	if err != nil {
+		respondLibraryError(c, err, "failed to calculate fine")
+		return
+	}
+	c.JSON(http.StatusOK, quote)
+}
+
+// finePaymentRequest is the body of a fine payment
+type finePaymentRequest struct {
+	Amount      decimal.Decimal `json:"amount" binding:"required"`
+	PaymentMode string          `json:"payment_mode" binding:"required"`
+	ReferenceNo string          `json:"reference_no"`
+}
+
+// PayFine records a payment of part or all of the fine outstanding on a
+// loan and returns its ledger entry
+func (h *LibraryHandler) PayFine(c *gin.Context) {
+	issueID, err := strconv.ParseUint(c.Param("issueId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid issue ID"})
+		return
+	}
+
+	var request finePaymentRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.recordFinePayment(c, uint(issueID), request)
+}
+
+// PayFineByIssue is the older form of PayFine that takes the loan as
+// issue_id in the body. It records the payment in the ledger the same way.
+func (h *LibraryHandler) PayFineByIssue(c *gin.Context) {
+	var request struct {
+		IssueID uint `json:"issue_id" binding:"required"`
+		finePaymentRequest
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.recordFinePayment(c, request.IssueID, request.finePaymentRequest)
+}
+
+func (h *LibraryHandler) recordFinePayment(c *gin.Context, issueID uint, request finePaymentRequest) {
+	staffID, _ := c.Get("userID")
+	payment := model.FinePayment{
+		IssueID:     issueID,
+		Amount:      request.Amount,
+		PaymentMode: request.PaymentMode,
+		ReferenceNo: request.ReferenceNo,
+		ReceivedBy:  staffID.(uint),
+	}
+	entry, err := h.service.WithContext(c.Request.Context()).RecordFinePayment(&payment)
+	if err != nil {
+		respondFineError(c, err, "failed to record payment")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"payment": payment, "entry": entry})
+}
+
+// WaiveFine writes off part or all of the fine outstanding on a loan, with
+// the signed in librarian as the approver
+func (h *LibraryHandler) WaiveFine(c *gin.Context) {
+	issueID, err := strconv.ParseUint(c.Param("issueId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid issue ID"})
+		return
+	}
+
+	var request struct {
+		Amount decimal.Decimal `json:"amount" binding:"required"`
+		Reason string          `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	staffID, _ := c.Get("userID")
+	entry, err := h.service.WithContext(c.Request.Context()).WaiveFine(uint(issueID), request.Amount, request.Reason, staffID.(uint))
+	if err != nil {
+		respondFineError(c, err, "failed to waive fine")
+		return
+	}
+	c.JSON(http.StatusCreated, entry)
+}
+
+// RefundPayment gives back part or all of a fine payment
+func (h *LibraryHandler) RefundPayment(c *gin.Context) {
+	paymentID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return
+	}
+
+	var request struct {
+		Amount decimal.Decimal `json:"amount" binding:"required"`
+		Reason string          `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	staffID, _ := c.Get("userID")
+	entry, err := h.service.WithContext(c.Request.Context()).RefundPayment(uint(paymentID), request.Amount, request.Reason, staffID.(uint))
+	if err != nil {
+		respondFineError(c, err, "failed to refund payment")
+		return
+	}
+	c.JSON(http.StatusCreated, entry)
+}
+
+// GetFineLedger returns the signed in reader's fine ledger; staff may pass
+// ?user_id= to see another reader's
+func (h *LibraryHandler) GetFineLedger(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	readerID := userID.(uint)
+	if raw := c.Query("user_id"); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		if uint(id) != readerID && !isLibraryStaff(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only library staff can see other readers' fines"})
+			return
+		}
+		readerID = uint(id)
+	}
+
+	ledger, err := h.service.WithContext(c.Request.Context()).GetFineLedger(readerID)
+	if err != nil {
+		respondFineError(c, err, "failed to fetch fine ledger")
+		return
+	}
+	c.JSON(http.StatusOK, ledger)
+}
+
+// PrintReceipt renders the receipt of a fine payment as a PDF
+func (h *LibraryHandler) PrintReceipt(c *gin.Context) {
+	paymentID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	receipt, err := h.service.WithContext(c.Request.Context()).GetFineReceipt(uint(paymentID), userID.(uint), isLibraryStaff(c))
+	if err != nil {
+		respondFineError(c, err, "failed to fetch payment")
+		return
+	}
+
+	payment := receipt.Payment
+	lines := [][2]string{{"Date", payment.PaymentDate.Format("2 Jan 2006 15:04")}}
+	if issue := payment.BookIssue; issue != nil {
+		if issue.User != nil {
+			lines = append(lines, [2]string{"Received from", issue.User.FirstName + " " + issue.User.LastName})
+		}
+		if issue.LibraryCard != nil {
+			lines = append(lines, [2]string{"Library card", issue.LibraryCard.CardNumber})
+		}
+		if issue.Book != nil {
+			lines = append(lines, [2]string{"Book", issue.Book.Title})
+		}
+		lines = append(lines, [2]string{"Loan", "#" + strconv.FormatUint(uint64(issue.ID), 10)})
+	}
+	lines = append(lines, [2]string{"Payment mode", payment.PaymentMode})
+	if payment.Receiver != nil {
+		lines = append(lines, [2]string{"Received by", payment.Receiver.FirstName + " " + payment.Receiver.LastName})
+	}
+	if payment.Refunded.IsPositive() {
+		lines = append(lines, [2]string{"Refunded", payment.Refunded.StringFixed(2)})
+	}
+	lines = append(lines, [2]string{"Balance after payment", receipt.Balance.StringFixed(2)})
+
+	document, err := pdf.PaymentReceipt(pdf.Receipt{
+		Title:       "Library fine receipt",
+		ReferenceNo: payment.ReferenceNo,
+		Lines:       lines,
+		Amount:      payment.Amount.StringFixed(2),
+		Footer:      "Keep this receipt as proof of payment.",
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to render receipt"})
+		return
+	}
+
+	c.Header("Content-Disposition", `inline; filename="receipt-`+payment.ReferenceNo+`.pdf"`)
+	c.Data(http.StatusOK, "application/pdf", document)
+}
+
+// respondFineError reports a failed fine ledger request. A user without a
+// library card has no ledger.
+func respondFineError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, service.ErrNoLibraryCard), errors.Is(err, service.ErrPaymentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidPayment), errors.Is(err, service.ErrInvalidAdjustment):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrNoFineOwed), errors.Is(err, service.ErrFineExceeded),
+		errors.Is(err, service.ErrRefundExceeded):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		respondLibraryError(c, err, message)
+	}
+}
+
+// Holiday Handlers
+
+// ListHolidays lists the library closures, optionally those overlapping
+// ?from= and ?to= (YYYY-MM-DD)
+func (h *LibraryHandler) ListHolidays(c *gin.Context) {
+	var from, to time.Time
+	for name, date := range map[string]*time.Time{"from": &from, "to": &to} {
+		if raw := c.Query(name); raw != "" {
+			parsed, err := time.Parse(service.DateLayout, raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name + " date, expected YYYY-MM-DD"})
+				return
+			}
+			*date = parsed
+		}
+	}
+
+	holidays, err := h.service.WithContext(c.Request.Context()).ListHolidays(from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch holidays"})
+		return
+	}
+	c.JSON(http.StatusOK, holidays)
+}
+
+// CreateHoliday adds a closure to the library calendar
+func (h *LibraryHandler) CreateHoliday(c *gin.Context) {
+	var request struct {
+		Name      string `json:"name"`
+		StartDate string `json:"start_date"`
+		EndDate   string `json:"end_date"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.EndDate == "" {
+		request.EndDate = request.StartDate
+	}
+	start, startErr := time.Parse(service.DateLayout, request.StartDate)
+	end, endErr := time.Parse(service.DateLayout, request.EndDate)
+	if startErr != nil || endErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": service.ErrInvalidHoliday.Error()})
+		return
+	}
+
+	holiday := model.LibraryHoliday{Name: request.Name, StartDate: start, EndDate: end}
+	if err := h.service.WithContext(c.Request.Context()).AddHoliday(&holiday); err != nil {
+		respondLibraryError(c, err, "failed to add holiday")
+		return
+	}
+	c.JSON(http.StatusCreated, holiday)
+}
+
+// DeleteHoliday removes a closure from the library calendar
+func (h *LibraryHandler) DeleteHoliday(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid holiday ID"})
+		return
+	}
+
+	if err := h.service.WithContext(c.Request.Context()).DeleteHoliday(uint(id)); err != nil {
+		respondLibraryError(c, err, "failed to delete holiday")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// bookRequest is the body of a book create or update. Fields left out of an
+// update keep their current value.
+type bookRequest struct {
+	ISBN            *string          `json:"isbn"`
+	Title           *string          `json:"title"`
+	Author          *string          `json:"author"`
+	Publisher       *string          `json:"publisher"`
+	PublicationYear *int             `json:"publication_year"`
+	Edition         *string          `json:"edition"`
+	CategoryID      *uint            `json:"category_id"`
+	Price           *decimal.Decimal `json:"price"`
+	Pages           *int             `json:"pages"`
+	Description     *string          `json:"description"`
+	CoverImage      *string          `json:"cover_image"`
+	TotalCopies     *int             `json:"total_copies"`
+	RackNumber      *string          `json:"rack_number"`
+	IsActive        *bool            `json:"is_active"`
+}
+
+// apply copies the fields present in the request onto book
+func (r *bookRequest) apply(book *model.Book) {
+	setIfPresent(&book.ISBN, r.ISBN)
+	setIfPresent(&book.Title, r.Title)
+	setIfPresent(&book.Author, r.Author)
+	setIfPresent(&book.Publisher, r.Publisher)
+	setIfPresent(&book.PublicationYear, r.PublicationYear)
+	setIfPresent(&book.Edition, r.Edition)
+	setIfPresent(&book.CategoryID, r.CategoryID)
+	setIfPresent(&book.Price, r.Price)
+	setIfPresent(&book.Pages, r.Pages)
+	setIfPresent(&book.Description, r.Description)
+	setIfPresent(&book.CoverImage, r.CoverImage)
+	setIfPresent(&book.TotalCopies, r.TotalCopies)
+	setIfPresent(&book.RackNumber, r.RackNumber)
+	setIfPresent(&book.IsActive, r.IsActive)
+}
+
+func setIfPresent[T any](field *T, value *T) {
+	if value != nil {
+		*field = *value
+	}
+}
+
+// ListBooks searches the catalogue. Supports q (title, author or ISBN),
+// category_id, author, available, year_from, year_to, limit and offset.
+// Librarians and admins also see withdrawn books with include_inactive=true.
+func (h *LibraryHandler) ListBooks(c *gin.Context) {
+	filter := repository.BookFilter{
+		Query:  strings.TrimSpace(c.Query("q")),
+		Author: strings.TrimSpace(c.Query("author")),
+	}
+
+	var err error
+	if raw := c.Query("category_id"); raw != "" {
+		id, parseErr := strconv.ParseUint(raw, 10, 32)
+		if parseErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid category_id"})
+			return
+		}
+		categoryID := uint(id)
+		filter.CategoryID = &categoryID
+	}
+	if raw := c.Query("available"); raw != "" {
+		available, parseErr := strconv.ParseBool(raw)
+		if parseErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid available, expected true or false"})
+			return
+		}
+		filter.Available = &available
+	}
+	if filter.YearFrom, err = atoiQuery(c, "year_from"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid year_from"})
+		return
+	}
+	if filter.YearTo, err = atoiQuery(c, "year_to"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid year_to"})
+		return
+	}
+	filter.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "50"))
+	filter.Offset, _ = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if includeInactive, _ := strconv.ParseBool(c.Query("include_inactive")); includeInactive && isLibraryStaff(c) {
+		filter.IncludeInactive = true
+	}
+
+	books, total, err := h.service.SearchBooks(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search books"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"books":  books,
+		"total":  total,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+	})
+}
+
+// UpdateBook changes the details of a book. Only the fields sent are
+// updated.
+func (h *LibraryHandler) UpdateBook(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+
+	var request bookRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	libraryService := h.service.WithContext(c.Request.Context())
+	book, err := libraryService.GetBookByID(uint(id))
+	if err != nil {
+		respondLibraryError(c, err, "failed to fetch book")
+		return
+	}
+	request.apply(book)
+
+	if err := libraryService.UpdateBookDetails(book); err != nil {
+		respondLibraryError(c, err, "failed to update book")
+		return
+	}
+
+	c.JSON(http.StatusOK, book)
+}
+
+// DeactivateBook withdraws a book from circulation
+func (h *LibraryHandler) DeactivateBook(c *gin.Context) {
+	h.setBookActive(c, false)
+}
+
+// ActivateBook returns a withdrawn book to circulation
+func (h *LibraryHandler) ActivateBook(c *gin.Context) {
+	h.setBookActive(c, true)
+}
+
+func (h *LibraryHandler) setBookActive(c *gin.Context, active bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+
+	book, err := h.service.WithContext(c.Request.Context()).SetBookActive(uint(id), active)
+	if err != nil {
+		respondLibraryError(c, err, "failed to update book")
+		return
+	}
+
+	c.JSON(http.StatusOK, book)
+}
+
+// DeleteBook moves a book to the trash
+func (h *LibraryHandler) DeleteBook(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+
+	if err := h.service.WithContext(c.Request.Context()).DeleteBook(uint(id)); err != nil {
+		respondLibraryError(c, err, "failed to delete book")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetAvailability returns copy counts of active books per category
+func (h *LibraryHandler) GetAvailability(c *gin.Context) {
+	availability, err := h.service.Availability()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch availability"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"categories": availability})
+}
+
+// Report Handlers
+//
+// Reports take ?from= and ?to= (YYYY-MM-DD, both days included) and are
+// sent as JSON, or with ?format=csv as a CSV file.
+
+// MostBorrowedReport lists the titles lent most often, optionally in one
+// ?category_id=, up to ?limit= titles
+func (h *LibraryHandler) MostBorrowedReport(c *gin.Context) {
+	rng, ok := reportRange(c)
+	if !ok {
+		return
+	}
+	categoryID, ok := reportCategory(c)
+	if !ok {
+		return
+	}
+	limit, err := atoiQuery(c, "limit")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+
+	titles, err := h.service.WithContext(c.Request.Context()).MostBorrowed(rng, categoryID, limit)
+	if err != nil {
+		respondLibraryError(c, err, "failed to build the report")
+		return
+	}
+	respondReport(c, "most-borrowed", gin.H{"titles": titles}, titles)
+}
+
+// CirculationReport counts the loans issued and returned per category and
+// month
+func (h *LibraryHandler) CirculationReport(c *gin.Context) {
+	rng, ok := reportRange(c)
+	if !ok {
+		return
+	}
+	months, err := h.service.WithContext(c.Request.Context()).CirculationByMonth(rng)
+	if err != nil {
+		respondLibraryError(c, err, "failed to build the report")
+		return
+	}
+	respondReport(c, "circulation", gin.H{"months": months}, months)
+}
+
+// OverdueReport gives the share of loans that ran late per category. The
+// CSV ends with the total.
+func (h *LibraryHandler) OverdueReport(c *gin.Context) {
+	rng, ok := reportRange(c)
+	if !ok {
+		return
+	}
+	report, err := h.service.WithContext(c.Request.Context()).OverdueRates(rng)
+	if err != nil {
+		respondLibraryError(c, err, "failed to build the report")
+		return
+	}
+	respondReport(c, "overdue", report, append(report.Categories, report.Total))
+}
+
+// FinesReport sums up the fines collected per payment mode. The CSV ends
+// with the total.
+func (h *LibraryHandler) FinesReport(c *gin.Context) {
+	rng, ok := reportRange(c)
+	if !ok {
+		return
+	}
+	report, err := h.service.WithContext(c.Request.Context()).FinesCollected(rng)
+	if err != nil {
+		respondLibraryError(c, err, "failed to build the report")
+		return
+	}
+	respondReport(c, "fines", report, append(report.PaymentModes, report.Total))
+}
+
+// InactiveCardholdersReport lists the holders of active cards who borrowed
+// nothing in the period, the last 180 days without ?from=
+func (h *LibraryHandler) InactiveCardholdersReport(c *gin.Context) {
+	rng, ok := reportRange(c)
+	if !ok {
+		return
+	}
+	cardholders, err := h.service.WithContext(c.Request.Context()).InactiveCardholders(rng)
+	if err != nil {
+		respondLibraryError(c, err, "failed to build the report")
+		return
+	}
+	respondReport(c, "inactive-cardholders", gin.H{"cardholders": cardholders}, cardholders)
+}
+
+// UnborrowedBooksReport lists the active books nobody borrowed in the
+// period, or ever without dates, optionally in one ?category_id=
+func (h *LibraryHandler) UnborrowedBooksReport(c *gin.Context) {
+	rng, ok := reportRange(c)
+	if !ok {
+		return
+	}
+	categoryID, ok := reportCategory(c)
+	if !ok {
+		return
+	}
+	books, err := h.service.WithContext(c.Request.Context()).UnborrowedBooks(rng, categoryID)
+	if err != nil {
+		respondLibraryError(c, err, "failed to build the report")
+		return
+	}
+	respondReport(c, "never-borrowed", gin.H{"books": books}, books)
+}
+
+// reportRange reads the ?from= and ?to= dates of a report. The range ends
+// at the start of the day after ?to=.
+func reportRange(c *gin.Context) (repository.ReportRange, bool) {
+	var rng repository.ReportRange
+	for name, date := range map[string]*time.Time{"from": &rng.From, "to": &rng.To} {
+		if raw := c.Query(name); raw != "" {
+			parsed, err := time.ParseInLocation(service.DateLayout, raw, time.Local)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name + " date, expected YYYY-MM-DD"})
+				return rng, false
+			}
+			*date = parsed
+		}
+	}
+	if !rng.To.IsZero() {
+		rng.To = rng.To.AddDate(0, 0, 1)
+	}
+	return rng, true
+}
+
+func reportCategory(c *gin.Context) (uint, bool) {
+	raw := c.Query("category_id")
+	if raw == "" {
+		return 0, true
+	}
+	id, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid category_id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// respondReport sends body as JSON, or rows as a CSV file named after the
+// report and the day it was made
+func respondReport(c *gin.Context, name string, body interface{}, rows interface{}) {
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		c.JSON(http.StatusOK, body)
+	case "csv":
+		data, err := reportCSV(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to write the report"})
+			return
+		}
+		filename := name + "-" + time.Now().Format(service.DateLayout) + ".csv"
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", data)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+	}
+}
+
+// reportCSV writes a slice of report rows as CSV, one column per field,
+// headed by the field's JSON name
+func reportCSV(rows interface{}) ([]byte, error) {
+	value := reflect.ValueOf(rows)
+	rowType := value.Type().Elem()
+	header := make([]string, rowType.NumField())
+	for i := range header {
+		header[i] = strings.Split(rowType.Field(i).Tag.Get("json"), ",")[0]
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write(header); err != nil {
+		return nil, err
+	}
+	for i := 0; i < value.Len(); i++ {
+		row := value.Index(i)
+		record := make([]string, len(header))
+		for j := range record {
+			record[j] = csvValue(row.Field(j))
+		}
+		if err := writer.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+// csvValue formats a report field for a spreadsheet: dates without the
+// time of day, money with two decimals and nil as an empty cell. Text that
+// a spreadsheet would take for a formula, such as a book titled
+// =HYPERLINK(...), is quoted with a leading apostrophe.
+func csvValue(field reflect.Value) string {
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			return ""
+		}
+		field = field.Elem()
+	}
+	switch value := field.Interface().(type) {
+	case time.Time:
+		return value.In(time.Local).Format(service.DateLayout)
+	case decimal.Decimal:
+		return value.StringFixed(2)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case string:
+		if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+			return "'" + value
+		}
+		return value
+	}
+	return fmt.Sprint(field.Interface())
+}
+
+// Category Handlers
+type categoryRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	IsActive    *bool   `json:"is_active"`
+}
+
+func (r *categoryRequest) apply(category *model.BookCategory) {
+	setIfPresent(&category.Name, r.Name)
+	setIfPresent(&category.Description, r.Description)
+	setIfPresent(&category.IsActive, r.IsActive)
+}
+
+// ListCategories lists the book categories. Librarians and admins also see
+// inactive ones with include_inactive=true.
+func (h *LibraryHandler) ListCategories(c *gin.Context) {
+	includeInactive, _ := strconv.ParseBool(c.Query("include_inactive"))
+
+	categories, err := h.service.ListCategories(includeInactive && isLibraryStaff(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch categories"})
+		return
+	}
+
+	c.JSON(http.StatusOK, categories)
+}
+
+func (h *LibraryHandler) GetCategory(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+		return
+	}
+
+	category, err := h.service.GetCategoryByID(uint(id))
+	if err != nil {
+		respondLibraryError(c, err, "failed to fetch category")
+		return
+	}
+
+	c.JSON(http.StatusOK, category)
+}
+
+func (h *LibraryHandler) CreateCategory(c *gin.Context) {
+	var request categoryRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var category model.BookCategory
+	request.apply(&category)
+
+	if err := h.service.WithContext(c.Request.Context()).CreateCategory(&category); err != nil {
+		respondLibraryError(c, err, "failed to create category")
+		return
+	}
+
+	c.JSON(http.StatusCreated, category)
+}
+
+func (h *LibraryHandler) UpdateCategory(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+		return
+	}
+
+	var request categoryRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	libraryService := h.service.WithContext(c.Request.Context())
+	category, err := libraryService.GetCategoryByID(uint(id))
+	if err != nil {
+		respondLibraryError(c, err, "failed to fetch category")
+		return
+	}
+	request.apply(category)
+
+	if err := libraryService.UpdateCategory(category); err != nil {
+		respondLibraryError(c, err, "failed to update category")
+		return
+	}
+
+	c.JSON(http.StatusOK, category)
+}
+
+// DeleteCategory moves an empty category to the trash
+func (h *LibraryHandler) DeleteCategory(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+		return
+	}
+
+	if err := h.service.WithContext(c.Request.Context()).DeleteCategory(uint(id)); err != nil {
+		respondLibraryError(c, err, "failed to delete category")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// isLibraryStaff reports whether the current user manages the catalogue
+func isLibraryStaff(c *gin.Context) bool {
+	user, exists := c.Get("user")
+	if !exists {
+		return false
+	}
+	role := user.(*models.User).Role
+	return role == models.RoleLibrarian || role == models.RoleAdmin
+}
+
+// atoiQuery parses an optional integer query parameter, returning 0 when it
+// is absent
+func atoiQuery(c *gin.Context, name string) (int, error) {
+	raw := c.Query(name)
+	if raw == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(raw)
+}
+
+// libraryErrorStatus maps library service errors to HTTP statuses
+func libraryErrorStatus(err error) (int, bool) {
+	switch {
+	case errors.Is(err, service.ErrBookNotFound), errors.Is(err, service.ErrCategoryNotFound),
+		errors.Is(err, service.ErrCopyNotFound), errors.Is(err, service.ErrReservationNotFound),
+		errors.Is(err, service.ErrIssueNotFound), errors.Is(err, service.ErrHolidayNotFound),
+		errors.Is(err, service.ErrCardNotFound):
+		return http.StatusNotFound, true
+	case errors.Is(err, service.ErrCardReplaced):
+		return http.StatusGone, true
+	case errors.Is(err, service.ErrInvalidBook), errors.Is(err, service.ErrInvalidCategory),
+		errors.Is(err, service.ErrInvalidISBN), errors.Is(err, service.ErrInvalidCopies),
+		errors.Is(err, service.ErrInvalidCopy), errors.Is(err, service.ErrCheckoutTarget),
+		errors.Is(err, service.ErrInvalidHoliday), errors.Is(err, service.ErrInvalidReportRange),
+		errors.Is(err, service.ErrInvalidCardTerm), errors.Is(err, service.ErrInvalidCardLimit),
+		errors.Is(err, service.ErrBlockReason):
+		return http.StatusBadRequest, true
+	case errors.Is(err, service.ErrDuplicateISBN), errors.Is(err, service.ErrDuplicateCategory),
+		errors.Is(err, service.ErrCategoryInUse), errors.Is(err, service.ErrBookOnLoan),
+		errors.Is(err, service.ErrCopiesOnLoan), errors.Is(err, service.ErrBookInactive),
+		errors.Is(err, service.ErrCopyNotAvailable), errors.Is(err, service.ErrNoCopyAvailable),
+		errors.Is(err, service.ErrCopyNotOnLoan), errors.Is(err, service.ErrCopyOnLoan),
+		errors.Is(err, service.ErrAlreadyReturned), errors.Is(err, service.ErrDuplicateBarcode),
+		errors.Is(err, service.ErrCopyOnHold), errors.Is(err, service.ErrReservationClosed),
+		errors.Is(err, service.ErrAlreadyReserved), errors.Is(err, service.ErrAlreadyBorrowed),
+		errors.Is(err, service.ErrCopiesOnShelf), errors.Is(err, service.ErrLoanChanged),
+		errors.Is(err, service.ErrLoanNotLost), errors.Is(err, service.ErrCardExists),
+		errors.Is(err, service.ErrCardBlocked), errors.Is(err, service.ErrCardNotBlocked):
+		return http.StatusConflict, true
+	case errors.Is(err, service.ErrMetadataNotFound):
+		return http.StatusUnprocessableEntity, true
+	case errors.Is(err, service.ErrMetadataUnavailable):
+		return http.StatusBadGateway, true
+	}
+	return 0, false
+}
+
+func respondLibraryError(c *gin.Context, err error, message string) {
+	if status, ok := libraryErrorStatus(err); ok {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
+
+// respondCirculationError reports a failed checkout, return or reservation.
+// Loans refused by the circulation policy carry a code and the numbers
+// behind it; errors the service does not classify are problems with the
+// request.
+func respondCirculationError(c *gin.Context, err error) {
+	var refusal *service.PolicyError
+	if errors.As(err, &refusal) {
+		c.JSON(http.StatusConflict, gin.H{"error": refusal.Message, "code": refusal.Code, "details": refusal.Details})
+		return
+	}
+	if errors.Is(err, service.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	status, ok := libraryErrorStatus(err)
+	if !ok {
+		status = http.StatusBadRequest
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
 }
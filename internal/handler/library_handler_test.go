@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+)
+
+func TestReportCSVQuotesFormulas(t *testing.T) {
+	titles := []repository.BorrowedTitle{
+		{BookID: 1, Title: `=HYPERLINK("http://evil.test","Dune")`, Author: "+Frank", Category: "@fiction", Loans: 3},
+		{BookID: 2, Title: "-1", Author: "\tTab", Category: "\rReturn"},
+		{BookID: 3, Title: "Dune", Author: "Frank Herbert", Category: "Fiction"},
+	}
+	data, err := reportCSV(titles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "book_id,isbn,title,author,category,loans,borrowers\n" +
+		`1,,"'=HYPERLINK(""http://evil.test"",""Dune"")",'+Frank,'@fiction,3,0` + "\n" +
+		"2,,'-1,'\tTab,\"'\rReturn\",0,0\n" +
+		"3,,Dune,Frank Herbert,Fiction,0,0\n"
+	if string(data) != want {
+		t.Fatalf("got\n%q\nwant\n%q", data, want)
+	}
+}
+
+func TestReportCSVLeavesNumbersAlone(t *testing.T) {
+	totals := []repository.PaymentModeTotal{{PaymentMode: "cash", Payments: 1, Amount: decimal.RequireFromString("-2.5")}}
+	data, err := reportCSV(totals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "cash,1,-2.50,") {
+		t.Fatalf("got %q", data)
+	}
+}
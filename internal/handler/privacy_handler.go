@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// PrivacyHandler serves personal data exports and erasure requests
+type PrivacyHandler struct {
+	privacyService *service.PrivacyService
+}
+
+func NewPrivacyHandler(privacyService *service.PrivacyService) *PrivacyHandler {
+	return &PrivacyHandler{privacyService: privacyService}
+}
+
+// ExportMyData downloads the current user's personal data. Pass
+// ?format=zip for an archive with one file per section.
+func (h *PrivacyHandler) ExportMyData(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	h.export(c, user.ID)
+}
+
+// ExportUserData downloads the personal data of any user, e.g. to answer a
+// parent's request
+func (h *PrivacyHandler) ExportUserData(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+	h.export(c, uint(id))
+}
+
+func (h *PrivacyHandler) export(c *gin.Context, userID uint) {
+	export, err := h.privacyService.Export(userID)
+	if errors.Is(err, service.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export personal data"})
+		return
+	}
+
+	filename := fmt.Sprintf("personal-data-%d-%s", userID, export.GeneratedAt.Format("20060102"))
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		data, err := json.MarshalIndent(export, "", "  ")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export personal data"})
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		c.Data(http.StatusOK, "application/json", data)
+	case "zip":
+		data, err := zipExport(export)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export personal data"})
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`.zip"`)
+		c.Data(http.StatusOK, "application/zip", data)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or zip"})
+	}
+}
+
+// zipExport writes each section of an export to its own JSON file
+func zipExport(export *service.PersonalDataExport) ([]byte, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+
+	write := func(name string, value interface{}) error {
+		data, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return err
+		}
+		file, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(data)
+		return err
+	}
+
+	names := make([]string, 0, len(export.Sections))
+	for name := range export.Sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	manifest := gin.H{"generated_at": export.GeneratedAt, "user_id": export.UserID, "sections": names}
+	if err := write("manifest.json", manifest); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if err := write(name+".json", export.Sections[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// EraseUser anonymizes a user's personal data. The request must repeat the
+// user's email in confirm_email.
+func (h *PrivacyHandler) EraseUser(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	var request struct {
+		ConfirmEmail string `json:"confirm_email" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "confirm_email is required"})
+		return
+	}
+
+	actor := c.MustGet("user").(*models.User)
+	if err := h.privacyService.WithContext(c.Request.Context()).Erase(actor, uint(id), request.ConfirmEmail); err != nil {
+		switch {
+		case errors.Is(err, service.ErrUserNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrErasureConfirmation):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrAlreadyErased):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrEraseSelf), errors.Is(err, service.ErrEraseServiceAccount):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to erase personal data"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "personal data erased"})
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+	"github.com/E-Timileyin/school-management-system/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// SecurityHandler exposes account lockout management and the security event log to admins
+type SecurityHandler struct {
+	loginGuard  *service.LoginGuard
+	userService *service.UserService
+}
+
+func NewSecurityHandler(loginGuard *service.LoginGuard, userService *service.UserService) *SecurityHandler {
+	return &SecurityHandler{
+		loginGuard:  loginGuard,
+		userService: userService,
+	}
+}
+
+// GetLockStatus shows the failed login counter of a user
+func (h *SecurityHandler) GetLockStatus(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	user, err := h.userService.GetUserByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	attempt, err := h.loginGuard.LockStatus(user.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch lock status"})
+		return
+	}
+
+	status := gin.H{"locked": false, "failures": 0}
+	if attempt != nil {
+		status["failures"] = attempt.Failures
+		status["last_failure_at"] = attempt.LastFailureAt
+		if attempt.LockedUntil != nil && attempt.LockedUntil.After(time.Now()) {
+			status["locked"] = true
+			status["locked_until"] = attempt.LockedUntil
+		}
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// UnlockUser clears a user's failed logins and lockout
+func (h *SecurityHandler) UnlockUser(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	user, err := h.userService.GetUserByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	admin := c.MustGet("user").(*models.User)
+	if err := h.loginGuard.Unlock(user, admin.ID, c.ClientIP()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unlock user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "user unlocked"})
+}
+
+// ListSecurityEvents queries the security event log. Supported filters:
+// user_id, email, ip, event, from and to (RFC 3339) and limit.
+func (h *SecurityHandler) ListSecurityEvents(c *gin.Context) {
+	filter := repository.SecurityEventFilter{
+		Email: c.Query("email"),
+		IP:    c.Query("ip"),
+		Event: c.Query("event"),
+	}
+
+	if raw := c.Query("user_id"); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+			return
+		}
+		userID := uint(id)
+		filter.UserID = &userID
+	}
+
+	var err error
+	if filter.From, err = parseTimeQuery(c, "from"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filter.To, err = parseTimeQuery(c, "to"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if limit, err := strconv.Atoi(c.DefaultQuery("limit", "100")); err == nil {
+		filter.Limit = limit
+	}
+
+	events, err := h.loginGuard.ListEvents(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch security events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
+
+// parseTimeQuery parses an optional RFC 3339 or YYYY-MM-DD query parameter
+func parseTimeQuery(c *gin.Context, name string) (*time.Time, error) {
+	raw := c.Query(name)
+	if raw == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid %s, expected RFC 3339 or YYYY-MM-DD", name)
+}
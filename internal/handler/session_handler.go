@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// SessionHandler lets users manage their own sessions and admins manage
+// the sessions of any user
+type SessionHandler struct {
+	sessionService *service.SessionService
+}
+
+func NewSessionHandler(sessionService *service.SessionService) *SessionHandler {
+	return &SessionHandler{sessionService: sessionService}
+}
+
+// ListMySessions lists the current user's active sessions
+func (h *SessionHandler) ListMySessions(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	sessions, err := h.sessionService.ListForUser(user.ID, c.GetString("tokenID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeMySession signs the current user out of one session
+func (h *SessionHandler) RevokeMySession(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+	h.revoke(c, user.ID)
+}
+
+// RevokeMyOtherSessions signs the current user out everywhere except this session
+func (h *SessionHandler) RevokeMyOtherSessions(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	count, err := h.sessionService.WithContext(c.Request.Context()).RevokeAll(user.ID, c.GetString("tokenID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"revoked": count})
+}
+
+// Logout ends the session making the request
+func (h *SessionHandler) Logout(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	if err := h.sessionService.WithContext(c.Request.Context()).Revoke(user.ID, c.GetUint("sessionID")); err != nil && !errors.Is(err, service.ErrSessionNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log out"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListUserSessions lists the active sessions of any user
+func (h *SessionHandler) ListUserSessions(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	sessions, err := h.sessionService.ListForUser(uint(userID), c.GetString("tokenID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeUserSession ends one session of any user
+func (h *SessionHandler) RevokeUserSession(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+	h.revoke(c, uint(userID))
+}
+
+// RevokeUserSessions ends every session of any user
+func (h *SessionHandler) RevokeUserSessions(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	count, err := h.sessionService.WithContext(c.Request.Context()).RevokeAll(uint(userID), "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"revoked": count})
+}
+
+func (h *SessionHandler) revoke(c *gin.Context, userID uint) {
+	sessionID, err := strconv.ParseUint(c.Param("sessionId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session ID"})
+		return
+	}
+
+	if err := h.sessionService.WithContext(c.Request.Context()).Revoke(userID, uint(sessionID)); err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke session"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
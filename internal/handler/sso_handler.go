@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/E-Timileyin/school-management-system/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// ssoFlowCookie carries the state of a login between the redirect to the
+// identity provider and its callback
+const (
+	ssoFlowCookie     = "sso_flow"
+	ssoFlowCookiePath = "/auth/oidc"
+)
+
+type SSOHandler struct {
+	ssoService  *service.SSOService
+	userHandler *UserHandler
+}
+
+// NewSSOHandler creates the handler. Logins are completed by userHandler so
+// that federated users get the same 2FA and session handling as everyone else.
+func NewSSOHandler(ssoService *service.SSOService, userHandler *UserHandler) *SSOHandler {
+	return &SSOHandler{ssoService: ssoService, userHandler: userHandler}
+}
+
+// Login redirects the browser to the identity provider
+func (h *SSOHandler) Login(c *gin.Context) {
+	flow, err := h.ssoService.Begin(c.Request.Context())
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoFlowCookie, flow.FlowToken, int(h.ssoService.FlowTTL().Seconds()),
+		ssoFlowCookiePath, "", h.ssoService.SecureCookies(), true)
+	c.Redirect(http.StatusFound, flow.RedirectURL)
+}
+
+// Callback receives the authorization code from the identity provider and
+// logs the user in
+func (h *SSOHandler) Callback(c *gin.Context) {
+	// The flow cookie is single use
+	flowToken, _ := c.Cookie(ssoFlowCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoFlowCookie, "", -1, ssoFlowCookiePath, "", h.ssoService.SecureCookies(), true)
+
+	if idpError := c.Query("error"); idpError != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":             service.ErrSSOFailed.Error(),
+			"provider_error":    idpError,
+			"error_description": c.Query("error_description"),
+		})
+		return
+	}
+
+	user, err := h.ssoService.WithContext(c.Request.Context()).Complete(c.Request.Context(), flowToken, c.Query("state"), c.Query("code"))
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	h.userHandler.completeLogin(c, user)
+}
+
+func (h *SSOHandler) handleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrSSODisabled):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidSSOFlow):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrSSOFailed):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrSSOEmailUnverified),
+		errors.Is(err, service.ErrSSONoAccount),
+		errors.Is(err, service.ErrSSONoRole),
+		errors.Is(err, service.ErrSSOIdentityMismatch):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Single sign-on error: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider unavailable"})
+	}
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/E-Timileyin/school-management-system/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// TrashHandler lets admins browse, restore and purge soft-deleted records
+type TrashHandler struct {
+	trashService *service.TrashService
+}
+
+func NewTrashHandler(trashService *service.TrashService) *TrashHandler {
+	return &TrashHandler{trashService: trashService}
+}
+
+// ListEntities lists the entity types in the trash with their record counts
+func (h *TrashHandler) ListEntities(c *gin.Context) {
+	counts, err := h.trashService.Entities()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch trash"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entities":  counts,
+		"retention": h.trashService.Retention().String(),
+	})
+}
+
+// ListDeleted lists the deleted records of one entity type. Supports limit
+// and offset.
+func (h *TrashHandler) ListDeleted(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	records, err := h.trashService.List(c.Param("entity"), limit, offset)
+	if err != nil {
+		respondTrashError(c, err, "failed to fetch deleted records")
+		return
+	}
+
+	c.JSON(http.StatusOK, records)
+}
+
+// Restore brings a record back from the trash. With ?dependents=true the
+// records deleted along with it are restored too.
+func (h *TrashHandler) Restore(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ID"})
+		return
+	}
+	withDependents, _ := strconv.ParseBool(c.DefaultQuery("dependents", "false"))
+
+	restored, err := h.trashService.WithContext(c.Request.Context()).Restore(c.Param("entity"), uint(id), withDependents)
+	if err != nil {
+		respondTrashError(c, err, "failed to restore record")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "record restored", "dependents_restored": restored})
+}
+
+// Purge permanently deletes one record in the trash
+func (h *TrashHandler) Purge(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ID"})
+		return
+	}
+
+	if err := h.trashService.WithContext(c.Request.Context()).Purge(c.Param("entity"), uint(id)); err != nil {
+		respondTrashError(c, err, "failed to purge record, it may still be referenced by other records")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "record purged"})
+}
+
+// PurgeExpired permanently deletes records that have been in the trash for
+// longer than the retention period. Optional query parameters: entity to
+// limit the purge to one entity type, and older_than (e.g. 720h) to
+// override the configured retention.
+func (h *TrashHandler) PurgeExpired(c *gin.Context) {
+	retention := h.trashService.Retention()
+	if raw := c.Query("older_than"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid older_than, expected a duration such as 720h"})
+			return
+		}
+		retention = parsed
+	}
+
+	result, err := h.trashService.WithContext(c.Request.Context()).PurgeExpired(c.Query("entity"), retention)
+	if err != nil {
+		respondTrashError(c, err, "failed to purge trash")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func respondTrashError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, service.ErrUnknownTrashEntity), errors.Is(err, service.ErrNotInTrash):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrRestoreConflict), errors.Is(err, service.ErrRestoreParentDeleted):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidRetention):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// TwoFactorHandler manages the current user's two-factor authentication
+type TwoFactorHandler struct {
+	twoFactorService *service.TwoFactorService
+}
+
+func NewTwoFactorHandler(twoFactorService *service.TwoFactorService) *TwoFactorHandler {
+	return &TwoFactorHandler{twoFactorService: twoFactorService}
+}
+
+// GetStatus reports whether 2FA is enabled or required for the current user
+func (h *TwoFactorHandler) GetStatus(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	remaining, err := h.twoFactorService.RemainingRecoveryCodes(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch two-factor status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                  user.TwoFactorEnabled,
+		"required":                 h.twoFactorService.IsRequired(user),
+		"enabled_at":               user.TwoFactorEnabledAt,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// Setup starts enrollment and returns the secret and QR code to scan
+func (h *TwoFactorHandler) Setup(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	enrollment, err := h.twoFactorService.WithContext(c.Request.Context()).BeginEnrollment(user)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// Confirm enables 2FA with a code from the authenticator app and returns
+// the recovery codes, which are only shown this once
+func (h *TwoFactorHandler) Confirm(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	var request struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	codes, err := h.twoFactorService.WithContext(c.Request.Context()).ConfirmEnrollment(user, request.Code)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// Disable turns 2FA off. Roles that require 2FA cannot disable it.
+func (h *TwoFactorHandler) Disable(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	var request struct {
+		Password string `json:"password"` // Not needed for federated accounts
+		Code     string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || (request.Password == "" && !user.IsFederated()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	if h.twoFactorService.IsRequired(user) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "two-factor authentication is required for your role"})
+		return
+	}
+
+	if err := h.twoFactorService.WithContext(c.Request.Context()).Disable(user, request.Password, request.Code); err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	var request struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	codes, err := h.twoFactorService.WithContext(c.Request.Context()).RegenerateRecoveryCodes(user, request.Code)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (h *TwoFactorHandler) handleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidTwoFactorCode), errors.Is(err, service.ErrInvalidPassword):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrTwoFactorAlreadyEnabled),
+		errors.Is(err, service.ErrTwoFactorNotEnabled),
+		errors.Is(err, service.ErrTwoFactorNotStarted):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "two-factor operation failed"})
+	}
+}
@@ -2,7 +2,11 @@
 package handler
 
 import (
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/E-Timileyin/school-management-system/internal/models"
 	"github.com/E-Timileyin/school-management-system/internal/service"
@@ -11,12 +15,31 @@ import (
 )
 
 type UserHandler struct {
-	userService *service.UserService
+	userService         *service.UserService
+	verificationService *service.VerificationService
+	twoFactorService    *service.TwoFactorService
+	tokenService        *service.TokenService
+	loginGuard          *service.LoginGuard
+	sessionService      *service.SessionService
 }
 
 // internal/handler/user_handler.go
-func NewUserHandler(userService *service.UserService) *UserHandler {
-	return &UserHandler{userService: userService}
+func NewUserHandler(
+	userService *service.UserService,
+	verificationService *service.VerificationService,
+	twoFactorService *service.TwoFactorService,
+	tokenService *service.TokenService,
+	loginGuard *service.LoginGuard,
+	sessionService *service.SessionService,
+) *UserHandler {
+	return &UserHandler{
+		userService:         userService,
+		verificationService: verificationService,
+		twoFactorService:    twoFactorService,
+		tokenService:        tokenService,
+		loginGuard:          loginGuard,
+		sessionService:      sessionService,
+	}
 }
 
 // Login handles user login
@@ -31,9 +54,16 @@ func (h *UserHandler) Login(c *gin.Context) {
 		return
 	}
 
+	ip := c.ClientIP()
+	if err := h.loginGuard.Check(loginData.Email, ip); err != nil {
+		respondThrottled(c, err)
+		return
+	}
+
 	user, err := h.userService.GetUserByEmail(loginData.Email)
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
+			h.loginGuard.RecordFailure(loginData.Email, ip, nil, "unknown email")
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid email or password"})
 			return
 		}
@@ -41,15 +71,113 @@ func (h *UserHandler) Login(c *gin.Context) {
 		return
 	}
 
+	if user.IsServiceAccount() {
+		c.JSON(http.StatusForbidden, gin.H{"error": service.ErrServiceAccountLogin.Error()})
+		return
+	}
+	if user.IsFederated() {
+		c.JSON(http.StatusForbidden, gin.H{"error": service.ErrFederatedAccount.Error()})
+		return
+	}
+
 	if err := user.CheckPassword(loginData.Password); err != nil {
+		h.loginGuard.RecordFailure(loginData.Email, ip, &user.ID, "wrong password")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid email or password"})
+		return
+	}
+
+	if err := h.verificationService.CheckVerified(user); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.completeLogin(c, user)
+}
+
+// completeLogin finishes a login once the first factor has been checked.
+// With 2FA enabled only a challenge token is issued, which has to be
+// exchanged for an access token at /login/2fa.
+func (h *UserHandler) completeLogin(c *gin.Context, user *models.User) {
+	if user.TwoFactorEnabled {
+		challenge, err := h.tokenService.IssueChallengeToken(user.ID, user.Email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":             "two-factor authentication required",
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+		})
+		return
+	}
+
+	h.respondWithToken(c, user)
+}
+
+// VerifyTwoFactorLogin completes a login by exchanging a challenge token and
+// a TOTP or recovery code for an access token
+func (h *UserHandler) VerifyTwoFactorLogin(c *gin.Context) {
+	var request struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+		Code           string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	userID, err := h.tokenService.ParseChallengeToken(request.ChallengeToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.userService.GetUserByID(userID)
+	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid email or password"})
 		return
 	}
 
-	// TODO: Generate JWT token and return it
+	// Second factor guesses count towards the same lockout as passwords
+	ip := c.ClientIP()
+	if err := h.loginGuard.Check(user.Email, ip); err != nil {
+		respondThrottled(c, err)
+		return
+	}
+
+	if err := h.twoFactorService.WithContext(c.Request.Context()).Verify(userID, request.Code); err != nil {
+		if errors.Is(err, service.ErrInvalidTwoFactorCode) || errors.Is(err, service.ErrTwoFactorNotEnabled) {
+			h.loginGuard.RecordFailure(user.Email, ip, &user.ID, "wrong two-factor code")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": service.ErrInvalidTwoFactorCode.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	h.respondWithToken(c, user)
+}
+
+// respondWithToken issues an access token for a fully authenticated user
+func (h *UserHandler) respondWithToken(c *gin.Context, user *models.User) {
+	token, _, err := h.sessionService.WithContext(c.Request.Context()).Create(user.ID, user.Email, user.Role, clientInfo(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+	h.loginGuard.RecordSuccess(user.Email, c.ClientIP(), user.ID)
+
+	// Don't return the password hash in the response
+	user.Password = ""
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "login successful",
+		"token":   token,
 		"user":    user,
+		// Roles that require 2FA must enroll before they can use admin routes
+		"two_factor_setup_required": h.twoFactorService.IsRequired(user) && !user.TwoFactorEnabled,
 	})
 }
 
@@ -60,7 +188,7 @@ func (h *UserHandler) Register(c *gin.Context) {
 		Password  string `json:"password" binding:"required,min=8"`
 		FirstName string `json:"first_name" binding:"required"`
 		LastName  string `json:"last_name" binding:"required"`
-		Role      string `json:"role" binding:"required,oneof=admin teacher student parent"`
+		Role      string `json:"role"` // Optional, must be allowed for self-registration
 	}
 
 	if err := c.ShouldBindJSON(&registerData); err != nil {
@@ -68,11 +196,22 @@ func (h *UserHandler) Register(c *gin.Context) {
 		return
 	}
 
+	role, err := h.userService.SelfRegistrationRole(registerData.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidRole):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
 	user := &models.User{
 		Email:     registerData.Email,
 		FirstName: registerData.FirstName,
 		LastName:  registerData.LastName,
-		Role:      registerData.Role,
+		Role:      role,
 	}
 
 	if err := user.SetPassword(registerData.Password); err != nil {
@@ -80,20 +219,129 @@ func (h *UserHandler) Register(c *gin.Context) {
 		return
 	}
 
-	if err := h.userService.CreateUser(user); err != nil {
+	if err := h.userService.WithContext(c.Request.Context()).CreateUser(user); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create user"})
 		return
 	}
 
+	// The account stays unverified until the emailed link is opened
+	message := "user registered successfully, check your email to verify your account"
+	if err := h.verificationService.WithContext(c.Request.Context()).SendVerificationEmail(user); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+		message = "user registered successfully, but the verification email could not be sent; request a new one"
+	}
+
 	// Don't return the password hash in the response
 	user.Password = ""
 
 	c.JSON(http.StatusCreated, gin.H{
-		"message": "user registered successfully",
+		"message": message,
 		"user":    user,
 	})
 }
 
+// clientInfo describes the client making the request
+func clientInfo(c *gin.Context) service.ClientInfo {
+	return service.ClientInfo{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
+
+// respondThrottled reports a login blocked by the brute-force protection
+func respondThrottled(c *gin.Context, err error) {
+	var throttled *service.ThrottledError
+	if !errors.As(err, &throttled) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	retryAfter := int(math.Ceil(throttled.RetryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       throttled.Error(),
+		"retry_after": retryAfter,
+	})
+}
+
+// VerifyEmail activates an account using the token from the verification email
+func (h *UserHandler) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing token"})
+		return
+	}
+
+	if _, err := h.verificationService.WithContext(c.Request.Context()).VerifyEmail(token); err != nil {
+		if errors.Is(err, service.ErrInvalidToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "email verified successfully"})
+}
+
+// ResendVerification sends a new verification link to an unverified account
+func (h *UserHandler) ResendVerification(c *gin.Context) {
+	var request struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	if err := h.verificationService.WithContext(c.Request.Context()).ResendVerification(request.Email); err != nil {
+		log.Printf("Failed to resend verification email: %v", err)
+	}
+
+	// Always respond the same way so registered emails cannot be enumerated
+	c.JSON(http.StatusOK, gin.H{"message": "if the account exists and is unverified, a new verification email has been sent"})
+}
+
+// GetInvitation checks an invitation token before the user chooses a password
+func (h *UserHandler) GetInvitation(c *gin.Context) {
+	user, err := h.verificationService.LookupInvitation(c.Query("token"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"email":      user.Email,
+		"first_name": user.FirstName,
+		"last_name":  user.LastName,
+	})
+}
+
+// AcceptInvitation lets an invited user set their own password
+func (h *UserHandler) AcceptInvitation(c *gin.Context) {
+	var request struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required,min=8"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	if _, err := h.verificationService.WithContext(c.Request.Context()).AcceptInvitation(request.Token, request.Password); err != nil {
+		if errors.Is(err, service.ErrInvalidToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to accept invitation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "password set successfully, you can now log in"})
+}
+
 func (h *UserHandler) GetProfile(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
@@ -116,7 +364,7 @@ func (h *UserHandler) UpdateProfile(c *gin.Context) {
 	var updateData struct {
 		FirstName string `json:"first_name"`
 		LastName  string `json:"last_name"`
-		Email     string `json:"email"`
+		Email     string `json:"email" binding:"omitempty,email"` // Kept when empty
 	}
 
 	if err := c.ShouldBindJSON(&updateData); err != nil {
@@ -126,9 +374,21 @@ func (h *UserHandler) UpdateProfile(c *gin.Context) {
 
 	currentUser.FirstName = updateData.FirstName
 	currentUser.LastName = updateData.LastName
-	currentUser.Email = updateData.Email
 
-	if err := h.userService.UpdateUser(currentUser); err != nil {
+	var err error
+	if updateData.Email != "" && updateData.Email != currentUser.Email {
+		// A new address is unverified until the link sent to it is opened
+		err = h.verificationService.WithContext(c.Request.Context()).ChangeEmail(currentUser, updateData.Email)
+	} else {
+		err = h.userService.WithContext(c.Request.Context()).UpdateUser(currentUser)
+	}
+	switch {
+	case errors.Is(err, service.ErrEmailTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, service.ErrEmailDelivery):
+		log.Printf("Failed to send verification email to user %d: %v", currentUser.ID, err)
+	case err != nil:
 		c.JSON(500, gin.H{"error": "failed to update profile"})
 		return
 	}
@@ -144,6 +404,10 @@ func (h *UserHandler) ChangePassword(c *gin.Context) {
 	}
 
 	currentUser := user.(*models.User)
+	if currentUser.IsFederated() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": service.ErrFederatedAccount.Error()})
+		return
+	}
 
 	var passwordData struct {
 		CurrentPassword string `json:"current_password"`
@@ -165,10 +429,15 @@ func (h *UserHandler) ChangePassword(c *gin.Context) {
 		return
 	}
 
-	if err := h.userService.UpdateUser(currentUser); err != nil {
+	if err := h.userService.WithContext(c.Request.Context()).UpdateUser(currentUser); err != nil {
 		c.JSON(500, gin.H{"error": "failed to update password"})
 		return
 	}
 
+	// Sign out every other device that may know the old password
+	if _, err := h.sessionService.RevokeAll(currentUser.ID, c.GetString("tokenID")); err != nil {
+		log.Printf("Failed to revoke sessions of user %d after password change: %v", currentUser.ID, err)
+	}
+
 	c.JSON(200, gin.H{"message": "password updated successfully"})
 }
@@ -0,0 +1,55 @@
+package middlewares
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/E-Timileyin/school-management-system/internal/audit"
+	"github.com/E-Timileyin/school-management-system/internal/utils"
+)
+
+// maxRequestIDLength bounds request IDs supplied by clients
+const maxRequestIDLength = 64
+
+// RequestID tags every request with an ID, taken from the X-Request-ID
+// header when the client or a proxy sent one. The ID is echoed in the
+// response and attached to the request context for the audit log.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader("X-Request-ID")
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID, _ = utils.GenerateRandomToken(12)
+		}
+		c.Set("requestID", requestID)
+		c.Header("X-Request-ID", requestID)
+
+		actor := audit.Actor{
+			IP:        c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			RequestID: requestID,
+		}
+		c.Request = c.Request.WithContext(audit.WithActor(c.Request.Context(), actor))
+		c.Next()
+	}
+}
+
+// AuditActor adds the authenticated user, API key or kiosk to the audit
+// actor of the request. It must run after AuthMiddleware or KioskAuth.
+func AuditActor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor, _ := audit.ActorFromContext(c.Request.Context())
+		if userID, ok := c.Get("userID"); ok {
+			id := userID.(uint)
+			actor.UserID = &id
+		}
+		if apiKeyID, ok := c.Get("apiKeyID"); ok {
+			id := apiKeyID.(uint)
+			actor.APIKeyID = &id
+		}
+		if kioskID, ok := c.Get("kioskID"); ok {
+			id := kioskID.(uint)
+			actor.KioskID = &id
+		}
+		c.Request = c.Request.WithContext(audit.WithActor(c.Request.Context(), actor))
+		c.Next()
+	}
+}
@@ -1,18 +1,32 @@
 package middlewares
 
 import (
+	"log"
 	"net/http"
-	"github.com/E-Timileyin/school-management-system/internal/utils"
+	"github.com/E-Timileyin/school-management-system/internal/service"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
+func AuthMiddleware(
+	tokenService *service.TokenService,
+	sessionService *service.SessionService,
+	apiKeyService *service.APIKeyService,
+) gin.HandlerFunc {
 	// This file will hold the function that checks if a user has a valid token before allowing access to protected routes.
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 
+		// Service accounts send an API key instead of a Bearer token
+		if apiKey := c.GetHeader("X-API-Key"); apiKey != "" || strings.HasPrefix(authHeader, "ApiKey ") {
+			if apiKey == "" {
+				apiKey = strings.TrimPrefix(authHeader, "ApiKey ")
+			}
+			authenticateAPIKey(c, apiKeyService, apiKey)
+			return
+		}
+
 		// Check if header exists and starts with Bearer
 		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing or invalid Authorization header"})
@@ -22,18 +36,54 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
-		// Validate token with the provided JWT secret
-		claims, err := utils.ValidateToken(tokenString, jwtSecret)
+		// Validate the token against the signing key set. Restricted tokens
+		// (e.g. 2FA challenges) cannot be used for API access.
+		claims, err := tokenService.ParseAccessToken(tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
 			return
 		}
 
+		// The token's session must not have been revoked
+		session, err := sessionService.Touch(claims.ID, c.ClientIP())
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session revoked or expired"})
+			c.Abort()
+			return
+		}
+
 		// Store user info in Gin context
 		c.Set("userID", claims.UserID)
 		c.Set("email", claims.Email)
+		c.Set("sessionID", session.ID)
+		c.Set("tokenID", claims.ID)
+		c.Set("authMethod", "token")
 
 		c.Next()
 	}
 }
+
+// authenticateAPIKey authenticates a service account by API key. The key's
+// scopes are stored as "apiKeyScopes" for RequireScope, and every request is
+// logged with the key so it can be attributed.
+func authenticateAPIKey(c *gin.Context, apiKeyService *service.APIKeyService, rawKey string) {
+	key, account, err := apiKeyService.Authenticate(rawKey, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid, expired or revoked API key"})
+		c.Abort()
+		return
+	}
+
+	c.Set("userID", account.ID)
+	c.Set("email", account.Email)
+	c.Set("apiKeyID", key.ID)
+	c.Set("apiKeyPrefix", key.Prefix)
+	c.Set("apiKeyScopes", strings.Split(key.Scopes, ","))
+	c.Set("authMethod", "api_key")
+
+	c.Next()
+
+	log.Printf("[api-key] key=%s account=%d %s %s status=%d ip=%s",
+		key.Prefix, account.ID, c.Request.Method, c.Request.URL.Path, c.Writer.Status(), c.ClientIP())
+}
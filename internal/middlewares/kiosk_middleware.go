@@ -0,0 +1,33 @@
+package middlewares
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/E-Timileyin/school-management-system/internal/service"
+)
+
+// KioskAuth authenticates a self-service kiosk by the device credential in
+// the X-Kiosk-Key header. The kiosk is stored as "kioskID"; no user is set,
+// so kiosks cannot reach any of the /api routes. Every request is logged
+// with the kiosk so it can be attributed.
+func KioskAuth(kioskService *service.KioskService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		kiosk, err := kioskService.Authenticate(c.GetHeader("X-Kiosk-Key"), c.ClientIP())
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or revoked kiosk credential"})
+			c.Abort()
+			return
+		}
+
+		c.Set("kioskID", kiosk.ID)
+		c.Set("authMethod", "kiosk")
+
+		c.Next()
+
+		log.Printf("[kiosk] kiosk=%s id=%d %s %s status=%d ip=%s",
+			kiosk.Prefix, kiosk.ID, c.Request.Method, c.Request.URL.Path, c.Writer.Status(), c.ClientIP())
+	}
+}
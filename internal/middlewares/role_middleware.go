@@ -0,0 +1,34 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole only lets users holding one of roles through. It must run
+// after CurrentUser.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	allowed := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		allowed[role] = true
+	}
+
+	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			c.Abort()
+			return
+		}
+
+		if !allowed[user.(*models.User).Role] {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden - insufficient role"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
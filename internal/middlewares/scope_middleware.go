@@ -0,0 +1,39 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/E-Timileyin/school-management-system/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// RequireScope limits API key requests to keys holding a scope for area:
+// "<area>:read" for safe methods and "<area>:write" for everything else.
+// Requests authenticated with a user token are not affected.
+func RequireScope(area string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes, ok := c.Get("apiKeyScopes")
+		if !ok {
+			c.Next()
+			return
+		}
+
+		write := true
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			write = false
+		}
+
+		if !service.ScopeAllows(scopes.([]string), area, write) {
+			access := "read"
+			if write {
+				access = "write"
+			}
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key lacks the " + area + ":" + access + " scope"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
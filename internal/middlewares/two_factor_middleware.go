@@ -0,0 +1,25 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// RequireTwoFactor blocks users whose role requires 2FA until they have
+// enrolled. It must run after CurrentUser.
+func RequireTwoFactor(twoFactorService *service.TwoFactorService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(*models.User)
+
+		if twoFactorService.IsRequired(user) && !user.TwoFactorEnabled {
+			c.JSON(http.StatusForbidden, gin.H{"error": "two-factor authentication must be enabled for your account"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/E-Timileyin/school-management-system/internal/model"
 	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/repository"
 	"gorm.io/gorm"
 )
 
@@ -25,6 +27,15 @@ func MigrateDB(db *gorm.DB) error {
 		// as it might already exist or not be needed
 	}
 
+	// Accounts created before email verification existed must stay usable,
+	// so remember whether the column is being added by this migration
+	backfillVerified := db.Migrator().HasTable(&models.User{}) &&
+		!db.Migrator().HasColumn(&models.User{}, "EmailVerified")
+	// Before drafts were told apart from scheduled communications, every
+	// unpublished communication with a start date was published at it
+	backfillScheduled := db.Migrator().HasTable(&model.Communication{}) &&
+		!db.Migrator().HasColumn(&model.Communication{}, "IsScheduled")
+
 	// AutoMigrate creates tables and adds missing columns, but won't change column types
 	// or delete unused columns to protect your data
 	err := db.AutoMigrate(
@@ -34,12 +45,89 @@ func MigrateDB(db *gorm.DB) error {
 		&models.Teacher{},   // Teacher-specific information (extends User)
 		&models.Course{},    // Course information
 		&models.Enrollment{}, // Student-course enrollment records
+		&models.UserToken{},  // Email verification and invitation tokens
+		&models.RoleChange{}, // Audit trail of role changes
+		&models.RecoveryCode{}, // Two-factor recovery codes
+		&models.LoginAttempt{}, // Failed login counters shared between replicas
+		&models.SecurityEvent{}, // Security event log
+		&models.Session{},       // Issued access tokens
+		&models.SigningKey{},    // JWT signing keys
+		&models.APIKey{},        // Service account API keys
+		&models.AuditLog{},      // Record of every data change
+		&models.JobRun{},        // History of scheduled background jobs
+
+		// Library
+		&model.BookCategory{},
+		&model.Book{},
+		&model.BookCopy{},
+		&model.LibraryCard{},
+		&model.LibraryCardEvent{},
+		&model.BookIssue{},
+		&model.BookRenewal{},
+		&model.BookReservation{},
+		&model.FinePayment{},
+		&model.FineLedgerEntry{},
+		&model.LibraryHoliday{},
+		&model.KioskDevice{},
+
+		// Communications
+		&model.Class{},
+		&model.Communication{},
+		&model.CommunicationAttachment{},
+		&model.CommunicationRead{},
 	)
 
 	if err != nil {
 		return fmt.Errorf("failed to auto-migrate database: %v", err)
 	}
 
+	// Unique indexes used to cover soft-deleted rows too, which kept deleted
+	// emails and course codes from being reused. Their replacements only
+	// cover live rows and were created above.
+	legacyIndexes := []struct {
+		model interface{}
+		name  string
+	}{
+		{&models.User{}, "idx_users_email"},
+		{&models.User{}, "idx_users_external_identity"},
+		{&models.Student{}, "idx_students_user_id"},
+		{&models.Teacher{}, "idx_teachers_user_id"},
+		{&models.Course{}, "idx_courses_code"},
+	}
+	for _, index := range legacyIndexes {
+		if db.Migrator().HasIndex(index.model, index.name) {
+			if err := db.Migrator().DropIndex(index.model, index.name); err != nil {
+				return fmt.Errorf("failed to drop index %s: %v", index.name, err)
+			}
+		}
+	}
+
+	// Books catalogued before copies were tracked get one copy per
+	// TotalCopies, with copies on loan linked to their open loans
+	if err := repository.NewLibraryRepository(db).BackfillCopies(); err != nil {
+		return fmt.Errorf("failed to create book copies: %v", err)
+	}
+	// Unpaid fines charged before the fine ledger was kept are posted to it
+	if err := repository.NewLibraryRepository(db).BackfillFineLedger(); err != nil {
+		return fmt.Errorf("failed to post unpaid fines to the ledger: %v", err)
+	}
+
+	// Book prices are read as decimals, which cannot hold NULL
+	if err := db.Exec("UPDATE books SET price = 0 WHERE price IS NULL").Error; err != nil {
+		return fmt.Errorf("failed to fill in missing book prices: %v", err)
+	}
+
+	if backfillVerified {
+		if err := db.Exec("UPDATE users SET email_verified = true, email_verified_at = NOW()").Error; err != nil {
+			return fmt.Errorf("failed to mark existing users as verified: %v", err)
+		}
+	}
+	if backfillScheduled {
+		if err := db.Exec("UPDATE communications SET is_scheduled = true WHERE is_published = false AND start_date IS NOT NULL").Error; err != nil {
+			return fmt.Errorf("failed to schedule existing communications: %v", err)
+		}
+	}
+
 	// SQL to add foreign key constraints if they don't already exist
 	// Using PL/pgSQL anonymous code block to conditionally add constraints
 	sql := `
@@ -18,6 +18,9 @@ const (
 	AudienceStaff    AudienceType = "staff"
 )
 
+// Communication is a notice, event or news item for a target audience.
+// Authors draft it, then publish it at once or schedule it for StartDate;
+// readers see it until EndDate.
 type Communication struct {
 	Base
 	Title        string           `gorm:"size:255;not null" json:"title"`
@@ -26,11 +29,13 @@ type Communication struct {
 	Audience     AudienceType     `gorm:"type:varchar(20);not null" json:"audience"`
 	StartDate    *time.Time       `gorm:"type:timestamp" json:"start_date,omitempty"`
 	EndDate      *time.Time       `gorm:"type:timestamp" json:"end_date,omitempty"`
+	IsScheduled  bool             `gorm:"default:false" json:"is_scheduled"` // Published by the scheduler once StartDate comes; drafts are not
 	IsPublished  bool             `gorm:"default:false" json:"is_published"`
 	PublishedAt  *time.Time       `gorm:"type:timestamp" json:"published_at,omitempty"`
+	IsImportant  bool             `gorm:"default:false" json:"is_important"` // Listed first in readers' feeds
 	AuthorID     uint             `gorm:"not null" json:"author_id"`
-	TargetClassID *uint           `gorm:"index" json:"target_class_id,omitempty"`
-	TargetUserID  *uint           `gorm:"index" json:"target_user_id,omitempty"`
+	TargetClassID *uint           `gorm:"index" json:"target_class_id,omitempty"` // Only the students of this class
+	TargetUserID  *uint           `gorm:"index" json:"target_user_id,omitempty"` // Only this user, whatever the audience
 
 	// For events
 	Location     string           `gorm:"size:255" json:"location,omitempty"`
@@ -50,3 +55,11 @@ type CommunicationAttachment struct {
 	FileType       string `gorm:"size:100" json:"file_type,omitempty"`
 	FileSize       int64  `gorm:"default:0" json:"file_size"`
 }
+
+// CommunicationRead records that a user opened a communication
+type CommunicationRead struct {
+	Base
+	CommunicationID uint      `gorm:"not null;uniqueIndex:idx_communication_reads_reader" json:"communication_id"`
+	UserID          uint      `gorm:"not null;uniqueIndex:idx_communication_reads_reader;index" json:"user_id"`
+	ReadAt          time.Time `gorm:"not null" json:"read_at"`
+}
@@ -2,12 +2,14 @@ package model
 
 import (
 	"time"
+
+	"github.com/shopspring/decimal"
 )
 
 // BookCategory represents a category for books
 type BookCategory struct {
     Base
-    Name        string `gorm:"size:100;not null;uniqueIndex" json:"name"`
+    Name        string `gorm:"size:100;not null;uniqueIndex:idx_book_categories_name_live,where:deleted_at IS NULL" json:"name"`
     Description string `gorm:"type:text" json:"description,omitempty"`
     IsActive    bool   `gorm:"default:true" json:"is_active"`
 }
@@ -15,14 +17,14 @@ type BookCategory struct {
 // Book represents a book in the library
 type Book struct {
     Base
-    ISBN            string       `gorm:"size:20;uniqueIndex;not null" json:"isbn"`
+    ISBN            string       `gorm:"size:20;uniqueIndex:idx_books_isbn_live,where:deleted_at IS NULL;not null" json:"isbn"`
     Title           string       `gorm:"size:255;not null" json:"title"`
     Author          string       `gorm:"size:255;not null" json:"author"`
     Publisher       string       `gorm:"size:255" json:"publisher,omitempty"`
     PublicationYear int          `gorm:"type:smallint" json:"publication_year"`
     Edition         string       `gorm:"size:50" json:"edition,omitempty"`
     CategoryID      uint         `gorm:"not null" json:"category_id"`
-    Price           float64      `gorm:"type:decimal(10,2)" json:"price"`
+    Price           decimal.Decimal `gorm:"type:decimal(10,2);default:0" json:"price"`
     Pages           int          `gorm:"default:0" json:"pages"`
     Description     string       `gorm:"type:text" json:"description,omitempty"`
     CoverImage      string       `gorm:"size:255" json:"cover_image,omitempty"`
@@ -35,55 +37,227 @@ type Book struct {
     Category *BookCategory `gorm:"foreignKey:CategoryID" json:"category,omitempty"`
 }
 
+// Copy statuses
+const (
+    CopyAvailable = "available"
+    CopyIssued    = "issued"
+    CopyDamaged   = "damaged"
+    CopyLost      = "lost"
+    CopyWithdrawn = "withdrawn"
+    CopyOnHold    = "on_hold" // Waiting on the shelf for the reader it is reserved for
+)
+
+// BookCopy is a physical copy of a book, identified by the barcode on its
+// label
+type BookCopy struct {
+    Base
+    BookID          uint   `gorm:"not null;index" json:"book_id"`
+    AccessionNumber string `gorm:"size:30;not null;uniqueIndex:idx_book_copies_accession_number_live,where:deleted_at IS NULL" json:"accession_number"`
+    Barcode         string `gorm:"size:50;not null;uniqueIndex:idx_book_copies_barcode_live,where:deleted_at IS NULL" json:"barcode"`
+    Condition       string `gorm:"type:varchar(20);default:'good'" json:"condition"` // new, good, fair, poor
+    RackNumber      string `gorm:"size:20" json:"rack_number,omitempty"`
+    Status          string `gorm:"type:varchar(20);default:'available';index" json:"status"` // available, issued, on_hold, damaged, lost, withdrawn
+
+    // Relationships
+    Book *Book `gorm:"foreignKey:BookID" json:"book,omitempty"`
+}
+
+// Reservation statuses
+const (
+    ReservationWaiting   = "waiting"
+    ReservationReady     = "ready" // A copy is held for pickup
+    ReservationFulfilled = "fulfilled"
+    ReservationExpired   = "expired"
+    ReservationCancelled = "cancelled"
+)
+
+// BookReservation is a reader's place in the queue for a title. Readers are
+// served in the order they reserved.
+type BookReservation struct {
+    Base
+    BookID      uint       `gorm:"not null;index" json:"book_id"`
+    UserID      uint       `gorm:"not null;index" json:"user_id"`
+    Status      string     `gorm:"type:varchar(20);default:'waiting';index" json:"status"` // waiting, ready, fulfilled, expired, cancelled
+    CopyID      *uint      `gorm:"index" json:"copy_id,omitempty"` // The copy held while ready
+    ReadyAt     *time.Time `json:"ready_at,omitempty"`
+    ExpiresAt   *time.Time `gorm:"index" json:"expires_at,omitempty"` // End of the pickup window
+    NotifiedAt  *time.Time `json:"notified_at,omitempty"`
+    ClosedAt    *time.Time `json:"closed_at,omitempty"`
+    IssueID     *uint      `json:"issue_id,omitempty"` // The loan that fulfilled it
+    Position    int64      `gorm:"-" json:"position,omitempty"` // Place in the queue while waiting
+
+    // Relationships
+    Book *Book     `gorm:"foreignKey:BookID" json:"book,omitempty"`
+    Copy *BookCopy `gorm:"foreignKey:CopyID" json:"copy,omitempty"`
+    User *User     `gorm:"foreignKey:UserID" json:"user,omitempty"`
+}
+
 // LibraryCard represents a library membership
 type LibraryCard struct {
     Base
-    UserID      uint       `gorm:"not null;uniqueIndex" json:"user_id"`
-    CardNumber  string     `gorm:"size:50;unique;not null" json:"card_number"`
+    UserID      uint       `gorm:"not null;uniqueIndex:idx_library_cards_user_id_live,where:deleted_at IS NULL" json:"user_id"`
+    CardNumber  string     `gorm:"size:50;uniqueIndex:idx_library_cards_card_number_live,where:deleted_at IS NULL;not null" json:"card_number"`
     IssueDate   time.Time  `gorm:"not null" json:"issue_date"`
     ExpiryDate  time.Time  `gorm:"not null" json:"expiry_date"`
     Status      string     `gorm:"type:varchar(20);default:'active'" json:"status"` // active, expired, blocked
     MaxBooks    int        `gorm:"default:3" json:"max_books"`
-    FineAmount  float64    `gorm:"type:decimal(10,2);default:0" json:"fine_amount"`
+    CustomLimit bool       `gorm:"default:false" json:"custom_limit"` // Set when staff gave the card its own MaxBooks; otherwise the role's limit applies
+    FineAmount  decimal.Decimal `gorm:"type:decimal(10,2);default:0" json:"fine_amount"`
+    BlockedReason string   `gorm:"type:text" json:"blocked_reason,omitempty"`
+    BlockedAt   *time.Time `json:"blocked_at,omitempty"`
     
     // Relationships
     User *User `gorm:"foreignKey:UserID" json:"user,omitempty"`
+    Events []LibraryCardEvent `gorm:"foreignKey:CardID" json:"events,omitempty"`
+}
+
+// Library card lifecycle events
+const (
+    CardIssued       = "issued"
+    CardRenewed      = "renewed"
+    CardBlocked      = "blocked"
+    CardUnblocked    = "unblocked"
+    CardReplaced     = "replaced"
+    CardLimitChanged = "limit_changed"
+)
+
+// LibraryCardEvent records a change in the life of a library card. When a
+// card is replaced its old number is kept here, so it is never handed out
+// again and can be told apart from a number that never existed.
+type LibraryCardEvent struct {
+    Base
+    CardID        uint      `gorm:"not null;index" json:"card_id"`
+    Type          string    `gorm:"type:varchar(20);not null" json:"type"`
+    Reason        string    `gorm:"type:text" json:"reason,omitempty"`
+    OldCardNumber string    `gorm:"size:50;index" json:"old_card_number,omitempty"` // Set when the card was replaced
+    ExpiryDate    *time.Time `json:"expiry_date,omitempty"` // The new expiry date of a renewed card
+    MaxBooks      *int      `json:"max_books,omitempty"` // The new limit; nil when reset to the role's
+    RecordedBy    uint      `gorm:"not null" json:"recorded_by"` // Staff ID who made the change
+    OccurredAt    time.Time `gorm:"not null" json:"occurred_at"`
 }
 
 // BookIssue represents a book checkout
 type BookIssue struct {
     Base
     BookID        uint       `gorm:"not null" json:"book_id"`
+    CopyID        *uint      `gorm:"index" json:"copy_id,omitempty"` // Nil only for loans returned before copies were tracked
     CardID        uint       `gorm:"not null" json:"card_id"`
     UserID        uint       `gorm:"not null" json:"user_id"` // For quick access
     IssueDate     time.Time  `gorm:"not null" json:"issue_date"`
     DueDate       time.Time  `gorm:"not null" json:"due_date"`
-    ReturnDate    *time.Time `gorm:"index" json:"return_date,omitempty"`
-    Status        string     `gorm:"type:varchar(20);default:'issued'" json:"status"` // issued, returned, overdue, lost
-    FineAmount    float64    `gorm:"type:decimal(10,2);default:0" json:"fine_amount"`
+    ReturnDate    *time.Time `gorm:"index" json:"return_date,omitempty"` // When the loan was closed, also by declaring the book lost
+    Status        string     `gorm:"type:varchar(20);default:'issued'" json:"status"` // issued, returned, overdue, lost, damaged
+    FineAmount    decimal.Decimal `gorm:"type:decimal(10,2);default:0" json:"fine_amount"`
+    ReplacementCharge decimal.Decimal `gorm:"type:decimal(10,2);default:0" json:"replacement_charge"` // Charged when the book was lost or came back damaged
     FinePaid      bool       `gorm:"default:false" json:"fine_paid"`
-    IssuedBy      uint       `gorm:"not null" json:"issued_by"` // Staff ID who issued the book
+    IssuedBy      *uint      `json:"issued_by,omitempty"` // Staff ID who issued the book; nil when lent by a kiosk
+    IssuedByKiosk *uint      `gorm:"index" json:"issued_by_kiosk,omitempty"` // Kiosk that lent the book
     ReceivedBy    *uint      `gorm:"index" json:"received_by,omitempty"` // Staff ID who received the book
+    ReceivedByKiosk *uint    `gorm:"index" json:"received_by_kiosk,omitempty"` // Kiosk the book was returned at
+    RenewalCount  int        `gorm:"default:0" json:"renewal_count"`
+    DueReminderAt     *time.Time `json:"due_reminder_at,omitempty"` // When the borrower was told the loan is due soon
+    OverdueReminderAt *time.Time `json:"overdue_reminder_at,omitempty"` // When the borrower was last told it is overdue
     
     // Relationships
     Book        *Book        `gorm:"foreignKey:BookID" json:"book,omitempty"`
+    Copy        *BookCopy    `gorm:"foreignKey:CopyID" json:"copy,omitempty"`
     LibraryCard *LibraryCard `gorm:"foreignKey:CardID" json:"library_card,omitempty"`
     User        *User        `gorm:"foreignKey:UserID" json:"user,omitempty"`
     Issuer      *User        `gorm:"foreignKey:IssuedBy" json:"issuer,omitempty"`
     Receiver    *User        `gorm:"foreignKey:ReceivedBy" json:"receiver,omitempty"`
+    Renewals    []BookRenewal `gorm:"foreignKey:IssueID" json:"renewals,omitempty"`
+}
+
+// BookRenewal records one extension of a loan's due date
+type BookRenewal struct {
+    Base
+    IssueID         uint      `gorm:"not null;index" json:"issue_id"`
+    RenewedBy       uint      `gorm:"not null" json:"renewed_by"` // The borrower or the staff member who renewed
+    RenewedAt       time.Time `gorm:"not null" json:"renewed_at"`
+    PreviousDueDate time.Time `gorm:"not null" json:"previous_due_date"`
+    NewDueDate      time.Time `gorm:"not null" json:"new_due_date"`
 }
 
 // FinePayment represents fine payments
 type FinePayment struct {
     Base
     IssueID     uint      `gorm:"not null" json:"issue_id"`
-    Amount      float64   `gorm:"type:decimal(10,2);not null" json:"amount"`
+    Amount      decimal.Decimal `gorm:"type:decimal(10,2);not null" json:"amount"`
     PaymentDate time.Time `gorm:"not null" json:"payment_date"`
     ReceivedBy  uint      `gorm:"not null" json:"received_by"` // Staff ID who received the payment
     PaymentMode string    `gorm:"type:varchar(20);not null" json:"payment_mode"` // cash, card, online
-    ReferenceNo string    `gorm:"size:100" json:"reference_no,omitempty"`
+    ReferenceNo string    `gorm:"size:100" json:"reference_no,omitempty"` // Printed on the receipt; generated when not given
+    Refunded    decimal.Decimal `gorm:"type:decimal(10,2);default:0" json:"refunded"`
     
     // Relationships
     BookIssue *BookIssue `gorm:"foreignKey:IssueID" json:"book_issue,omitempty"`
     Receiver  *User      `gorm:"foreignKey:ReceivedBy" json:"receiver,omitempty"`
 }
+
+// Fine ledger entry types
+const (
+    LedgerCharge   = "charge"
+    LedgerPayment  = "payment"
+    LedgerWaiver   = "waiver"
+    LedgerRefund   = "refund"
+    LedgerReversal = "reversal" // Takes back a replacement charge when a lost book turns up
+)
+
+// FineLedgerEntry is one movement on a library card's fine balance. Charges
+// and refunds add to what the reader owes, payments, waivers and reversals
+// take from it.
+type FineLedgerEntry struct {
+    Base
+    CardID     uint            `gorm:"not null;index" json:"card_id"`
+    UserID     uint            `gorm:"not null;index" json:"user_id"`
+    IssueID    uint            `gorm:"not null;index" json:"issue_id"` // The loan the fine was charged for
+    PaymentID  *uint           `gorm:"index" json:"payment_id,omitempty"` // Set on payments and refunds
+    Type       string          `gorm:"type:varchar(20);not null" json:"type"` // charge, payment, waiver, refund, reversal
+    Amount     decimal.Decimal `gorm:"type:decimal(10,2);not null" json:"amount"` // Signed: negative for payments, waivers and reversals
+    Balance    decimal.Decimal `gorm:"type:decimal(10,2);not null" json:"balance"` // The card's balance after this entry
+    Reason     string          `gorm:"type:text" json:"reason,omitempty"`
+    RecordedBy *uint           `json:"recorded_by,omitempty"` // Staff ID who recorded the entry; nil for fines charged at a kiosk
+    RecordedByKiosk *uint      `json:"recorded_by_kiosk,omitempty"` // Kiosk the book was returned at
+    ApprovedBy *uint           `json:"approved_by,omitempty"` // Staff ID who approved a waiver
+    OccurredAt time.Time       `gorm:"not null;index" json:"occurred_at"`
+}
+
+// Attendant is who handled a checkout or return: a member of staff at the
+// desk, or a self-service kiosk
+type Attendant struct {
+    StaffID *uint
+    KioskID *uint
+}
+
+func StaffAttendant(staffID uint) Attendant {
+    return Attendant{StaffID: &staffID}
+}
+
+func KioskAttendant(kioskID uint) Attendant {
+    return Attendant{KioskID: &kioskID}
+}
+
+// KioskDevice is a self-service station where readers check books out and
+// return them by scanning their library card. It authenticates with a
+// device credential; only the SHA-256 hash of the credential is stored and
+// Prefix identifies it in listings and logs.
+type KioskDevice struct {
+    Base
+    Name           string     `gorm:"size:100;not null" json:"name"`
+    Location       string     `gorm:"size:100" json:"location,omitempty"`
+    Prefix         string     `gorm:"size:20;uniqueIndex;not null" json:"prefix"`
+    CredentialHash string     `gorm:"size:64;not null" json:"-"`
+    LastSeenAt     *time.Time `json:"last_seen_at,omitempty"`
+    LastSeenIP     string     `gorm:"size:64" json:"last_seen_ip,omitempty"`
+    CreatedBy      uint       `gorm:"not null" json:"created_by"` // Staff ID who registered the kiosk
+    RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+}
+
+// LibraryHoliday is a day or a range of days the library is closed, such as
+// a school holiday. Overdue fines are not charged for them.
+type LibraryHoliday struct {
+    Base
+    Name      string    `gorm:"size:100;not null" json:"name"`
+    StartDate time.Time `gorm:"type:date;not null;index" json:"start_date"`
+    EndDate   time.Time `gorm:"type:date;not null;index" json:"end_date"` // Inclusive
+}
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"time"
+
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
@@ -9,22 +11,33 @@ import (
 type UserRole string
 
 const (
-	RoleAdmin    UserRole = "admin"
-	RoleTeacher  UserRole = "teacher"
-	RoleStudent  UserRole = "student"
-	RoleParent   UserRole = "parent"
-	RoleGuardian UserRole = "guardian"
+	RoleAdmin     UserRole = "admin"
+	RoleTeacher   UserRole = "teacher"
+	RoleStudent   UserRole = "student"
+	RoleParent    UserRole = "parent"
+	RoleGuardian  UserRole = "guardian"
+	RoleLibrarian UserRole = "librarian"
 )
 
 type User struct {
 	gorm.Model
 
 	// Authentication
-	Email     string   `gorm:"uniqueIndex;not null"`
-	Password  string   `gorm:"not null"` // Maps to 'password' column in database
+	Email     string   `gorm:"uniqueIndex:idx_users_email_live,where:deleted_at IS NULL;not null"`
+	Password  string   `gorm:"not null" json:"-"` // Maps to 'password' column in database; bcrypt hash, never serialized
 	FirstName string   `gorm:"not null"`
 	LastName  string   `gorm:"not null"`
 	Role      UserRole `gorm:"not null"`
+
+	// Email verification
+	EmailVerified   bool `gorm:"not null;default:false"`
+	EmailVerifiedAt *time.Time
+
+	// Two-factor authentication
+	TwoFactorEnabled bool `gorm:"not null;default:false"`
+
+	// Single sign-on provider; empty for local accounts
+	AuthProvider string `gorm:"not null;default:''"`
 }
 
 // BeforeCreate is a GORM hook that runs before creating a user
@@ -54,6 +67,17 @@ func (u *User) FullName() string {
 	return u.FirstName + " " + u.LastName
 }
 
+// IsFederated reports whether the user signs in through an identity provider
+// (or, for service accounts, with API keys) rather than a password
+func (u *User) IsFederated() bool {
+	return u.AuthProvider != ""
+}
+
+// IsServiceAccount reports whether the user is a machine user
+func (u *User) IsServiceAccount() bool {
+	return u.AuthProvider == "service_account"
+}
+
 // IsAdmin checks if the user has admin role
 func (u *User) IsAdmin() bool {
 	return u.Role == RoleAdmin
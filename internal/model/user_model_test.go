@@ -0,0 +1,20 @@
+package model
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestUserPasswordIsNotSerialized(t *testing.T) {
+	user := &User{Email: "ann@example.com", Password: "$2a$10$hash"}
+	issue := BookIssue{User: user, Issuer: user, Receiver: user}
+
+	data, err := json.Marshal(issue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "$2a$") || strings.Contains(string(data), "Password") {
+		t.Fatalf("password hash in %s", data)
+	}
+}
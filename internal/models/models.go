@@ -1,20 +1,71 @@
 package models
 
 import (
+	"encoding/json"
 	"errors"
+	"time"
 
 	"github.com/E-Timileyin/school-management-system/internal/utils"
 	"gorm.io/gorm"
 )
 
-// User represents a user in the system
+// Roles a user can hold
+const (
+	RoleAdmin   = "admin"
+	RoleTeacher = "teacher"
+	RoleStudent = "student"
+	RoleParent  = "parent"
+	// RoleLibrarian manages the library catalogue
+	RoleLibrarian = "librarian"
+)
+
+// User represents a user in the system.
+//
+// Unique indexes only cover rows that are not soft-deleted, so a deleted
+// record never blocks re-creating the same value; see TrashService.
 type User struct {
 	gorm.Model
-	Email     string `gorm:"uniqueIndex;not null"`
-	Password  string `gorm:"not null"`
+	Email     string `gorm:"uniqueIndex:idx_users_email_live,where:deleted_at IS NULL;not null"`
+	Password  string `gorm:"not null" json:"-"` // bcrypt hash, never serialized
 	FirstName string `gorm:"not null"`
 	LastName  string `gorm:"not null"`
 	Role      string `gorm:"not null"` // admin, teacher, student, parent
+
+	// Email verification
+	EmailVerified   bool `gorm:"not null;default:false"`
+	EmailVerifiedAt *time.Time
+
+	// Two-factor authentication
+	TwoFactorEnabled   bool   `gorm:"not null;default:false"`
+	TwoFactorSecret    string `json:"-"`                           // Base32 TOTP secret, set during enrollment
+	TwoFactorLastStep  int64  `gorm:"not null;default:0" json:"-"` // Last accepted TOTP step, prevents code replay
+	TwoFactorEnabledAt *time.Time
+
+	// Single sign-on. Federated users authenticate at their identity
+	// provider and cannot log in with a password.
+	AuthProvider string  `gorm:"size:50;not null;default:'';uniqueIndex:idx_users_external_identity_live,where:deleted_at IS NULL"`
+	ExternalID   *string `gorm:"size:255;uniqueIndex:idx_users_external_identity_live,where:deleted_at IS NULL"` // Subject claim at the provider
+
+	// ErasedAt is set once the user's personal data has been anonymized
+	ErasedAt *time.Time
+}
+
+// Authentication providers a user can be federated with
+const (
+	AuthProviderOIDC = "oidc"
+	// AuthProviderServiceAccount marks machine users that authenticate with API keys only
+	AuthProviderServiceAccount = "service_account"
+)
+
+// IsFederated reports whether the user signs in through an identity provider
+// (or, for service accounts, with API keys) rather than a password
+func (u *User) IsFederated() bool {
+	return u.AuthProvider != ""
+}
+
+// IsServiceAccount reports whether the user is a machine user
+func (u *User) IsServiceAccount() bool {
+	return u.AuthProvider == AuthProviderServiceAccount
 }
 
 // SetPassword hashes the password and sets it on the user
@@ -38,17 +89,18 @@ func (u *User) CheckPassword(password string) error {
 // Student represents a student in the system
 type Student struct {
 	gorm.Model
-	UserID      uint   `gorm:"uniqueIndex;not null"`
+	UserID      uint   `gorm:"uniqueIndex:idx_students_user_id_live,where:deleted_at IS NULL;not null"`
 	User        User   `gorm:"foreignKey:UserID"`
 	DateOfBirth string `gorm:"type:date"`
 	Address     string
 	Phone       string
+	ClassID     *uint `gorm:"index"` // Class the student is in, for communications to the class
 }
 
 // Teacher represents a teacher in the system
 type Teacher struct {
 	gorm.Model
-	UserID  uint   `gorm:"uniqueIndex;not null"`
+	UserID  uint   `gorm:"uniqueIndex:idx_teachers_user_id_live,where:deleted_at IS NULL;not null"`
 	User    User   `gorm:"foreignKey:UserID"`
 	Subject string `gorm:"not null"`
 	Phone   string
@@ -58,7 +110,7 @@ type Teacher struct {
 type Course struct {
 	gorm.Model
 	Name        string `gorm:"not null"`
-	Code        string `gorm:"uniqueIndex;not null"`
+	Code        string `gorm:"uniqueIndex:idx_courses_code_live,where:deleted_at IS NULL;not null"`
 	Description string
 	TeacherID   uint    `gorm:"not null"` // Reference to Teacher
 	Teacher     Teacher `gorm:"foreignKey:TeacherID"`
@@ -72,6 +124,162 @@ type Enrollment struct {
 	Grade     *string // Nullable grade
 }
 
+// Purposes of tokens sent to users by email
+const (
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposeInvitation        = "invitation"
+)
+
+// UserToken is a single-use token sent to a user by email.
+// Only the SHA-256 hash of the token is stored.
+type UserToken struct {
+	gorm.Model
+	UserID    uint      `gorm:"index;not null"`
+	Purpose   string    `gorm:"size:30;index;not null"`
+	TokenHash string    `gorm:"size:64;uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+}
+
+// RecoveryCode is a one-time code that can replace a TOTP code when the
+// user's authenticator is unavailable. Only the SHA-256 hash is stored.
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint   `gorm:"index;not null"`
+	CodeHash string `gorm:"size:64;uniqueIndex;not null"`
+	UsedAt   *time.Time
+}
+
+// RoleChange records every change of a user's role for auditing
+type RoleChange struct {
+	gorm.Model
+	UserID    uint   `gorm:"index;not null"`
+	OldRole   string `gorm:"not null"`
+	NewRole   string `gorm:"not null"`
+	ChangedBy *uint  `gorm:"index"` // Admin who made the change, nil for system changes
+	Reason    string
+}
+
+// LoginAttempt tracks consecutive failed logins for a key such as an
+// account email or a client IP
+type LoginAttempt struct {
+	Key           string    `gorm:"primaryKey;size:255"`
+	Failures      int       `gorm:"not null;default:0"`
+	LastFailureAt time.Time `gorm:"not null"`
+	LockedUntil   *time.Time
+	UpdatedAt     time.Time
+}
+
+// Security event types
+const (
+	SecurityEventLoginSucceeded  = "login_succeeded"
+	SecurityEventLoginFailed     = "login_failed"
+	SecurityEventLoginBlocked    = "login_blocked"
+	SecurityEventAccountLocked   = "account_locked"
+	SecurityEventAccountUnlocked = "account_unlocked"
+)
+
+// SecurityEvent is an entry in the security event log
+type SecurityEvent struct {
+	gorm.Model
+	Event   string `gorm:"size:50;index;not null"`
+	UserID  *uint  `gorm:"index"`
+	Email   string `gorm:"size:255;index"`
+	IP      string `gorm:"size:64;index"`
+	ActorID *uint  // Admin who triggered the event, e.g. an unlock
+	Details string
+}
+
+// Session is an issued access token tracked server-side so that it can be
+// listed and revoked. TokenID matches the token's jti claim.
+type Session struct {
+	gorm.Model
+	UserID     uint      `gorm:"index;not null"`
+	TokenID    string    `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	Device     string    `gorm:"size:255"`
+	IP         string    `gorm:"size:64"`
+	UserAgent  string    `gorm:"size:512"`
+	LastSeenAt time.Time `gorm:"not null"`
+	ExpiresAt  time.Time `gorm:"index;not null"`
+	RevokedAt  *time.Time
+	Current    bool `gorm:"-"` // Set when listing, marks the session making the request
+}
+
+// SigningKey is an asymmetric key used to sign JWTs. The newest key whose
+// ActivatesAt has passed signs new tokens; older keys stay in the published
+// key set until every token they signed has expired.
+type SigningKey struct {
+	gorm.Model
+	KID         string     `gorm:"column:kid;size:64;uniqueIndex;not null"`
+	Algorithm   string     `gorm:"size:10;not null"`
+	PrivateKey  string     `gorm:"type:text;not null" json:"-"` // PKCS#8 PEM
+	ActivatesAt time.Time  `gorm:"not null"`                    // Published before this, used for signing after
+	ExpiresAt   *time.Time `gorm:"index"`                       // Set once superseded; removed from the key set after
+}
+
+// APIKey authenticates a service account. Only the SHA-256 hash of the key
+// is stored; Prefix identifies the key in listings and logs.
+type APIKey struct {
+	gorm.Model
+	UserID     uint   `gorm:"index;not null"` // The service account
+	Name       string `gorm:"size:100;not null"`
+	Prefix     string `gorm:"size:20;uniqueIndex;not null"`
+	KeyHash    string `gorm:"size:64;not null" json:"-"`
+	Scopes     string `gorm:"size:500;not null"` // Comma separated, e.g. "library:write,courses:read"
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	LastUsedIP string `gorm:"size:64"`
+	CreatedBy  *uint
+	RevokedAt  *time.Time
+}
+
+// Audit actions
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+	// AuditActionErase records that a user's personal data was anonymized
+	AuditActionErase = "erase"
+)
+
+// AuditLog records a single change to a database row. Before and After hold
+// the changed columns only; for creates and deletes they hold the whole row.
+type AuditLog struct {
+	ID         uint            `gorm:"primaryKey"`
+	CreatedAt  time.Time       `gorm:"index"`
+	ActorID    *uint           `gorm:"index"` // Nil for changes made by the system
+	APIKeyID   *uint           // Set when the actor authenticated with an API key
+	KioskID    *uint           // Set when the change was made at a library kiosk
+	Action     string          `gorm:"size:10;not null"`
+	EntityType string          `gorm:"size:100;not null;index:idx_audit_logs_entity"`
+	EntityID   string          `gorm:"size:100;not null;index:idx_audit_logs_entity"`
+	Before     json.RawMessage `gorm:"type:jsonb"`
+	After      json.RawMessage `gorm:"type:jsonb"`
+	IP         string          `gorm:"size:64"`
+	UserAgent  string          `gorm:"size:512"`
+	RequestID  string          `gorm:"size:64;index"`
+}
+
+// Job run outcomes
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// JobRun records one run of a scheduled background job
+type JobRun struct {
+	ID          uint      `gorm:"primaryKey"`
+	Job         string    `gorm:"size:100;not null;index:idx_job_runs_job_started"`
+	StartedAt   time.Time `gorm:"not null;index:idx_job_runs_job_started"`
+	FinishedAt  *time.Time
+	Status      string `gorm:"size:20;not null;index"` // running, succeeded, failed
+	TriggeredBy *uint  // Admin who ran the job by hand; nil for scheduled runs
+	Result      string `gorm:"type:text"` // What the job did, e.g. how many records it changed
+	Error       string `gorm:"type:text"`
+	Instance    string `gorm:"size:255"` // Host and process that ran the job
+}
+
 // Set up table names for all models
 func (User) TableName() string {
 	return "users"
@@ -92,3 +300,43 @@ func (Course) TableName() string {
 func (Enrollment) TableName() string {
 	return "enrollments"
 }
+
+func (UserToken) TableName() string {
+	return "user_tokens"
+}
+
+func (RoleChange) TableName() string {
+	return "role_changes"
+}
+
+func (RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
+
+func (LoginAttempt) TableName() string {
+	return "login_attempts"
+}
+
+func (SecurityEvent) TableName() string {
+	return "security_events"
+}
+
+func (Session) TableName() string {
+	return "sessions"
+}
+
+func (SigningKey) TableName() string {
+	return "signing_keys"
+}
+
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+func (AuditLog) TableName() string {
+	return "audit_logs"
+}
+
+func (JobRun) TableName() string {
+	return "job_runs"
+}
@@ -0,0 +1,233 @@
+// Package oidctest runs a stand-in OpenID Connect identity provider for
+// tests, in the manner of net/http/httptest. It serves discovery, a JWKS,
+// an authorization endpoint that approves every request, and a token
+// endpoint that enforces PKCE.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+const keyID = "oidctest"
+
+// Server is a running stand-in identity provider. Its fields may be changed
+// between logins.
+type Server struct {
+	*httptest.Server
+	ClientID string
+
+	// Subject is the sub claim of ID tokens
+	Subject string
+	// Claims are added to every ID token, e.g. email and groups
+	Claims map[string]any
+	// Nonce, when set, is put in ID tokens in place of the nonce the client
+	// sent, as a replayed token would carry
+	Nonce string
+	// UntrustedKey signs ID tokens with a key that is not in the JWKS
+	UntrustedKey bool
+
+	key       *rsa.PrivateKey
+	untrusted *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]grant
+}
+
+// grant is an issued authorization code
+type grant struct {
+	nonce     string
+	challenge string
+	method    string
+	redirect  string
+}
+
+// NewServer starts an identity provider for the client clientID. Close it
+// when done.
+func NewServer(clientID string) (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	untrusted, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		ClientID:  clientID,
+		Subject:   "subject-1",
+		Claims:    map[string]any{},
+		key:       key,
+		untrusted: untrusted,
+		grants:    map[string]grant{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/jwks", s.jwks)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	s.Server = httptest.NewServer(mux)
+	return s, nil
+}
+
+// Authorize does what the browser does with an authorization URL: it
+// follows it and returns the code and state the provider sends back to the
+// redirect URI
+func (s *Server) Authorize(authURL string) (code, state string, err error) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		return "", "", fmt.Errorf("authorization failed with status %d", resp.StatusCode)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return "", "", err
+	}
+	return callback.Query().Get("code"), callback.Query().Get("state"), nil
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                s.URL,
+		"authorization_endpoint":                s.URL + "/authorize",
+		"token_endpoint":                        s.URL + "/token",
+		"jwks_uri":                              s.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &s.key.PublicKey, KeyID: keyID, Algorithm: string(jose.RS256), Use: "sig"},
+	}})
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != s.ClientID || query.Get("response_type") != "code" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	code := rand.Text()
+	s.mu.Lock()
+	s.grants[code] = grant{
+		nonce:     query.Get("nonce"),
+		challenge: query.Get("code_challenge"),
+		method:    query.Get("code_challenge_method"),
+		redirect:  query.Get("redirect_uri"),
+	}
+	s.mu.Unlock()
+
+	callback := query.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, callback, http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+	clientID, _, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.Form.Get("client_id")
+	}
+
+	// A code can be redeemed once, whether or not the exchange succeeds
+	code := r.Form.Get("code")
+	s.mu.Lock()
+	issued, ok := s.grants[code]
+	delete(s.grants, code)
+	s.mu.Unlock()
+
+	switch {
+	case !ok, clientID != s.ClientID, r.Form.Get("redirect_uri") != issued.redirect:
+		tokenError(w, "invalid_grant")
+		return
+	case issued.method != "S256" || challenge(r.Form.Get("code_verifier")) != issued.challenge:
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	idToken, err := s.idToken(issued.nonce)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": rand.Text(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+// idToken signs an ID token with the configured claims
+func (s *Server) idToken(nonce string) (string, error) {
+	if s.Nonce != "" {
+		nonce = s.Nonce
+	}
+	now := time.Now()
+	claims := map[string]any{}
+	for name, value := range s.Claims {
+		claims[name] = value
+	}
+	claims["iss"] = s.URL
+	claims["sub"] = s.Subject
+	claims["aud"] = s.ClientID
+	claims["nonce"] = nonce
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(time.Hour).Unix()
+
+	key := s.key
+	if s.UntrustedKey {
+		key = s.untrusted
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.RS256,
+		Key:       jose.JSONWebKey{Key: key, KeyID: keyID},
+	}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		return "", err
+	}
+	return jwt.Signed(signer).Claims(claims).Serialize()
+}
+
+// challenge computes the S256 PKCE challenge of a verifier
+func challenge(verifier string) string {
+	if verifier == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,163 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"slices"
+	"testing"
+
+	"golang.org/x/oauth2"
+
+	"github.com/E-Timileyin/school-management-system/internal/config"
+	"github.com/E-Timileyin/school-management-system/internal/oidc/oidctest"
+)
+
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Server) {
+	t.Helper()
+	idp, err := oidctest.NewServer("school")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(idp.Close)
+
+	provider := NewProvider(config.OIDCConfig{
+		IssuerURL:   idp.URL,
+		ClientID:    "school",
+		RedirectURL: "http://school.test/auth/oidc/callback",
+		Scopes:      []string{"openid", "email", "profile"},
+		GroupsClaim: "groups",
+	}, idp.Client())
+	return provider, idp
+}
+
+// authorize starts a login and returns the code the provider hands back
+func authorize(t *testing.T, provider *Provider, idp *oidctest.Server, state, nonce, verifier string) string {
+	t.Helper()
+	authURL, err := provider.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, returnedState, err := idp.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if returnedState != state {
+		t.Fatalf("state %q came back as %q", state, returnedState)
+	}
+	return code
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	provider, idp := newTestProvider(t)
+	verifier := oauth2.GenerateVerifier()
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state-1", "nonce-1", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if parsed.Scheme+"://"+parsed.Host+parsed.Path != idp.URL+"/authorize" {
+		t.Errorf("authorization endpoint %q was not taken from discovery", authURL)
+	}
+	for name, want := range map[string]string{
+		"client_id":             "school",
+		"state":                 "state-1",
+		"nonce":                 "nonce-1",
+		"code_challenge":        oauth2.S256ChallengeFromVerifier(verifier),
+		"code_challenge_method": "S256",
+		"scope":                 "openid email profile",
+	} {
+		if got := query.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestDiscoveryFailure(t *testing.T) {
+	provider, idp := newTestProvider(t)
+	idp.Close()
+
+	if _, err := provider.AuthCodeURL(context.Background(), "state", "nonce", oauth2.GenerateVerifier()); err == nil {
+		t.Fatal("AuthCodeURL succeeded without discovery")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	provider, idp := newTestProvider(t)
+	idp.Subject = "user-42"
+	idp.Claims = map[string]any{
+		"email":          "ann@example.com",
+		"email_verified": "true", // Some IdPs send a string
+		"given_name":     "Ann",
+		"family_name":    "Smith",
+		"groups":         []string{"staff", "it"},
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	code := authorize(t, provider, idp, "state", "nonce", verifier)
+	identity, err := provider.Exchange(context.Background(), code, "nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Subject != "user-42" || identity.Email != "ann@example.com" || !identity.EmailVerified ||
+		identity.GivenName != "Ann" || identity.FamilyName != "Smith" || !slices.Equal(identity.Groups, []string{"staff", "it"}) {
+		t.Fatalf("got identity %+v", identity)
+	}
+}
+
+func TestExchangeSingleGroup(t *testing.T) {
+	provider, idp := newTestProvider(t)
+	idp.Claims = map[string]any{"email": "ann@example.com", "email_verified": true, "groups": "staff"}
+	verifier := oauth2.GenerateVerifier()
+
+	code := authorize(t, provider, idp, "state", "nonce", verifier)
+	identity, err := provider.Exchange(context.Background(), code, "nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !identity.EmailVerified || !slices.Equal(identity.Groups, []string{"staff"}) {
+		t.Fatalf("got identity %+v", identity)
+	}
+}
+
+func TestExchangeRequiresPKCEVerifier(t *testing.T) {
+	provider, idp := newTestProvider(t)
+
+	code := authorize(t, provider, idp, "state", "nonce", oauth2.GenerateVerifier())
+	if _, err := provider.Exchange(context.Background(), code, "nonce", oauth2.GenerateVerifier()); err == nil {
+		t.Fatal("code was redeemed with another verifier")
+	}
+}
+
+func TestExchangeNonceMismatch(t *testing.T) {
+	provider, idp := newTestProvider(t)
+	verifier := oauth2.GenerateVerifier()
+
+	code := authorize(t, provider, idp, "state", "nonce", verifier)
+	if _, err := provider.Exchange(context.Background(), code, "other-nonce", verifier); !errors.Is(err, ErrNonceMismatch) {
+		t.Fatalf("got %v, want ErrNonceMismatch", err)
+	}
+
+	// An ID token replayed from another login carries that login's nonce
+	idp.Nonce = "replayed"
+	code = authorize(t, provider, idp, "state", "nonce", verifier)
+	if _, err := provider.Exchange(context.Background(), code, "nonce", verifier); !errors.Is(err, ErrNonceMismatch) {
+		t.Fatalf("got %v, want ErrNonceMismatch", err)
+	}
+}
+
+func TestExchangeRejectsKeyOutsideJWKS(t *testing.T) {
+	provider, idp := newTestProvider(t)
+	idp.UntrustedKey = true
+	verifier := oauth2.GenerateVerifier()
+
+	code := authorize(t, provider, idp, "state", "nonce", verifier)
+	if _, err := provider.Exchange(context.Background(), code, "nonce", verifier); err == nil {
+		t.Fatal("accepted an ID token signed with a key missing from the JWKS")
+	}
+}
@@ -0,0 +1,75 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/boombuler/barcode/code128"
+	"github.com/go-pdf/fpdf"
+)
+
+// Card is a printable library card
+type Card struct {
+	Title      string
+	Holder     string
+	CardNumber string // Printed as a barcode, which desk and kiosk scanners read
+	// Lines are label and value pairs printed under the holder, e.g. the
+	// expiry date
+	Lines [][2]string
+}
+
+// Cards are the size of a credit card (ID-1), so they fit card holders and
+// can be printed on card stock or cut out of a sheet
+const (
+	cardWidth   = 85.6
+	cardHeight  = 53.98
+	cardMargin  = 4.0
+	cardLabel   = 22.0
+	cardBarcode = 12.0
+)
+
+// LibraryCard renders a card on a single page of its own size
+func LibraryCard(card Card) ([]byte, error) {
+	code, err := code128.Encode(card.CardNumber)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode card number %q: %w", card.CardNumber, err)
+	}
+
+	// The size is taken as is in portrait; landscape would swap it
+	doc := fpdf.NewCustom(&fpdf.InitType{
+		OrientationStr: "P",
+		UnitStr:        "mm",
+		Size:           fpdf.SizeType{Wd: cardWidth, Ht: cardHeight},
+	})
+	doc.SetMargins(cardMargin, cardMargin, cardMargin)
+	doc.SetAutoPageBreak(false, 0)
+	doc.SetTitle(card.Title+" "+card.CardNumber, true)
+	translate := doc.UnicodeTranslatorFromDescriptor("")
+	width := cardWidth - 2*cardMargin
+	doc.AddPage()
+
+	doc.SetFont("Helvetica", "B", 11)
+	doc.CellFormat(width, 6, translate(card.Title), "B", 1, "L", false, 0, "")
+	doc.Ln(1.5)
+
+	doc.SetFont("Helvetica", "B", 10)
+	doc.CellFormat(width, 5, fitText(doc, translate(card.Holder), width), "", 1, "L", false, 0, "")
+	for _, line := range card.Lines {
+		doc.SetFont("Helvetica", "", 7)
+		doc.CellFormat(cardLabel, 3.5, translate(line[0]), "", 0, "L", false, 0, "")
+		doc.SetFont("Helvetica", "B", 7)
+		doc.CellFormat(width-cardLabel, 3.5, fitText(doc, translate(line[1]), width-cardLabel), "", 1, "L", false, 0, "")
+	}
+
+	barTop := cardHeight - cardMargin - cardBarcode - 4
+	drawBars(doc, code, cardMargin, barTop, width, cardBarcode)
+	doc.SetFont("Courier", "", 8)
+	doc.SetXY(cardMargin, barTop+cardBarcode+0.5)
+	doc.CellFormat(width, 3.5, card.CardNumber, "", 0, "C", false, 0, "")
+
+	var buf bytes.Buffer
+	if err := doc.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
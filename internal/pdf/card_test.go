@@ -0,0 +1,78 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// points converts millimetres to the PDF's points
+func points(mm float64) float64 {
+	return mm * 72 / 25.4
+}
+
+func TestLibraryCardFitsPage(t *testing.T) {
+	document, err := LibraryCard(Card{
+		Title:      "School Library",
+		Holder:     "Ann Smith",
+		CardNumber: "LIB-4821093755",
+		Lines:      [][2]string{{"Role", "student"}, {"Expires", "2027-07-31"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	box := regexp.MustCompile(`/MediaBox \[0 0 ([\d.]+) ([\d.]+)\]`).FindSubmatch(document)
+	if box == nil {
+		t.Fatal("the card has no MediaBox")
+	}
+	width, _ := strconv.ParseFloat(string(box[1]), 64)
+	height, _ := strconv.ParseFloat(string(box[2]), 64)
+	if width < height || abs(width-points(cardWidth)) > 0.1 || abs(height-points(cardHeight)) > 0.1 {
+		t.Fatalf("page is %.2f x %.2f pt, want %.2f x %.2f", width, height, points(cardWidth), points(cardHeight))
+	}
+
+	// The barcode is drawn as filled rectangles: x y w h re
+	content := pageContent(t, document)
+	bars := regexp.MustCompile(`([\d.-]+) ([\d.-]+) ([\d.-]+) ([\d.-]+) re`).FindAllSubmatch(content, -1)
+	if len(bars) == 0 {
+		t.Fatal("the card has no barcode")
+	}
+	for _, bar := range bars {
+		var v [4]float64
+		for i := range v {
+			v[i], _ = strconv.ParseFloat(string(bar[i+1]), 64)
+		}
+		x, y, w, h := v[0], v[1], v[2], v[3]
+		// Heights are negative as the page is drawn from the top
+		bottom, top := min(y, y+h), max(y, y+h)
+		if x < 0 || x+w > width || bottom < 0 || top > height {
+			t.Fatalf("bar %s lies outside the %.2f x %.2f page", bar[0], width, height)
+		}
+	}
+}
+
+// pageContent returns the inflated content streams of document
+func pageContent(t *testing.T, document []byte) []byte {
+	t.Helper()
+	var content []byte
+	for _, stream := range regexp.MustCompile(`(?s)/Filter /FlateDecode[^>]*>>\s*stream\r?\n(.*?)endstream`).FindAllSubmatch(document, -1) {
+		reader, err := zlib.NewReader(bytes.NewReader(stream[1]))
+		if err != nil {
+			continue // Fonts and other binary streams
+		}
+		data, _ := io.ReadAll(reader)
+		content = append(content, data...)
+	}
+	return content
+}
+
+func abs(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
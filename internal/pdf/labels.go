@@ -0,0 +1,128 @@
+// Package pdf renders printable documents
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/code128"
+	"github.com/go-pdf/fpdf"
+)
+
+// Label is a barcode label for one item
+type Label struct {
+	Title   string // Printed above the barcode
+	Caption string // Printed below the barcode text, e.g. a shelf mark
+	Barcode string
+}
+
+// Labels are laid out on A4 sheets of 3 x 8 labels of 70 x 37 mm, the
+// common self-adhesive label format
+const (
+	labelColumns = 3
+	labelRows    = 8
+	labelWidth   = 70.0
+	labelHeight  = 37.0
+	sheetTop     = (297.0 - labelRows*labelHeight) / 2
+	labelPadding = 4.0
+	barHeight    = 16.0
+)
+
+// BarcodeLabels renders Code128 labels on A4 label sheets
+func BarcodeLabels(labels []Label) ([]byte, error) {
+	doc := fpdf.New("P", "mm", "A4", "")
+	doc.SetMargins(0, 0, 0)
+	doc.SetAutoPageBreak(false, 0)
+	doc.SetTitle("Labels", true)
+	translate := doc.UnicodeTranslatorFromDescriptor("")
+
+	perSheet := labelColumns * labelRows
+	if len(labels) == 0 {
+		doc.AddPage()
+	}
+	for i, label := range labels {
+		if i%perSheet == 0 {
+			doc.AddPage()
+		}
+		slot := i % perSheet
+		x := float64(slot%labelColumns) * labelWidth
+		y := sheetTop + float64(slot/labelColumns)*labelHeight
+		if err := drawLabel(doc, translate, x, y, label); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := doc.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// drawLabel draws a label with its top left corner at x, y
+func drawLabel(doc *fpdf.Fpdf, translate func(string) string, x, y float64, label Label) error {
+	code, err := code128.Encode(label.Barcode)
+	if err != nil {
+		return fmt.Errorf("cannot encode barcode %q: %w", label.Barcode, err)
+	}
+	width := labelWidth - 2*labelPadding
+
+	doc.SetFont("Helvetica", "B", 8)
+	doc.SetXY(x+labelPadding, y+labelPadding)
+	doc.CellFormat(width, 4, fitText(doc, translate(label.Title), width), "", 0, "C", false, 0, "")
+
+	drawBars(doc, code, x+labelPadding, y+labelPadding+5, width, barHeight)
+
+	doc.SetFont("Courier", "", 9)
+	doc.SetXY(x+labelPadding, y+labelPadding+5+barHeight+0.5)
+	doc.CellFormat(width, 4, label.Barcode, "", 0, "C", false, 0, "")
+
+	if label.Caption != "" {
+		doc.SetFont("Helvetica", "", 7)
+		doc.SetXY(x+labelPadding, y+labelPadding+5+barHeight+4.5)
+		doc.CellFormat(width, 3.5, fitText(doc, translate(label.Caption), width), "", 0, "C", false, 0, "")
+	}
+	return nil
+}
+
+// drawBars draws a barcode as filled rectangles, centered in width. Each
+// run of black modules becomes one rectangle so the bars print crisply at
+// any resolution.
+func drawBars(doc *fpdf.Fpdf, code barcode.Barcode, x, y, width, height float64) {
+	modules := code.Bounds().Dx()
+	// Leave a quiet zone of ten modules on each side
+	module := width / float64(modules+20)
+	left := x + (width-module*float64(modules))/2
+
+	doc.SetFillColor(0, 0, 0)
+	for start := 0; start < modules; {
+		if !isBlack(code, start) {
+			start++
+			continue
+		}
+		end := start
+		for end < modules && isBlack(code, end) {
+			end++
+		}
+		doc.Rect(left+float64(start)*module, y, float64(end-start)*module, height, "F")
+		start = end
+	}
+}
+
+func isBlack(code barcode.Barcode, x int) bool {
+	r, _, _, _ := code.At(x, 0).RGBA()
+	return r == 0
+}
+
+// fitText shortens text with an ellipsis until it fits in width
+func fitText(doc *fpdf.Fpdf, text string, width float64) string {
+	if doc.GetStringWidth(text) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && doc.GetStringWidth(string(runes)+"...") > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
@@ -0,0 +1,65 @@
+package pdf
+
+import (
+	"bytes"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// Receipt is a printed acknowledgement of a payment
+type Receipt struct {
+	Title       string
+	ReferenceNo string
+	// Lines are label and value pairs printed in order, e.g. the payer
+	Lines  [][2]string
+	Amount string
+	Footer string
+}
+
+// Receipts are printed on A5, which fits receipt printers and half sheets
+const (
+	receiptWidth  = 148.0
+	receiptMargin = 15.0
+	receiptLabel  = 45.0
+)
+
+// PaymentReceipt renders a receipt on a single A5 page
+func PaymentReceipt(receipt Receipt) ([]byte, error) {
+	doc := fpdf.New("P", "mm", "A5", "")
+	doc.SetMargins(receiptMargin, receiptMargin, receiptMargin)
+	doc.SetAutoPageBreak(false, 0)
+	doc.SetTitle(receipt.Title+" "+receipt.ReferenceNo, true)
+	translate := doc.UnicodeTranslatorFromDescriptor("")
+	width := receiptWidth - 2*receiptMargin
+	doc.AddPage()
+
+	doc.SetFont("Helvetica", "B", 16)
+	doc.CellFormat(width, 9, translate(receipt.Title), "", 1, "C", false, 0, "")
+	doc.SetFont("Courier", "", 10)
+	doc.CellFormat(width, 6, receipt.ReferenceNo, "B", 1, "C", false, 0, "")
+	doc.Ln(5)
+
+	for _, line := range receipt.Lines {
+		doc.SetFont("Helvetica", "B", 10)
+		doc.CellFormat(receiptLabel, 6, translate(line[0]), "", 0, "L", false, 0, "")
+		doc.SetFont("Helvetica", "", 10)
+		doc.CellFormat(width-receiptLabel, 6, fitText(doc, translate(line[1]), width-receiptLabel), "", 1, "L", false, 0, "")
+	}
+
+	doc.Ln(4)
+	doc.SetFont("Helvetica", "B", 12)
+	doc.CellFormat(receiptLabel, 9, "Amount paid", "TB", 0, "L", false, 0, "")
+	doc.CellFormat(width-receiptLabel, 9, receipt.Amount, "TB", 1, "R", false, 0, "")
+
+	if receipt.Footer != "" {
+		doc.Ln(6)
+		doc.SetFont("Helvetica", "I", 8)
+		doc.MultiCell(width, 4, translate(receipt.Footer), "", "C", false)
+	}
+
+	var buf bytes.Buffer
+	if err := doc.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
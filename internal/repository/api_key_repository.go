@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/E-Timileyin/school-management-system/internal/audit"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"gorm.io/gorm"
+)
+
+type APIKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) *APIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+// WithContext returns a copy of the repository bound to ctx
+func (r *APIKeyRepository) WithContext(ctx context.Context) *APIKeyRepository {
+	return &APIKeyRepository{db: r.db.WithContext(ctx)}
+}
+
+func (r *APIKeyRepository) Create(key *models.APIKey) error {
+	return r.db.Create(key).Error
+}
+
+func (r *APIKeyRepository) FindByID(id uint) (*models.APIKey, error) {
+	var key models.APIKey
+	err := r.db.First(&key, id).Error
+	return &key, err
+}
+
+func (r *APIKeyRepository) FindByPrefix(prefix string) (*models.APIKey, error) {
+	var key models.APIKey
+	err := r.db.Where("prefix = ?", prefix).First(&key).Error
+	return &key, err
+}
+
+// List returns API keys, newest first, optionally only those of one account
+func (r *APIKeyRepository) List(userID uint) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	query := r.db.Order("created_at DESC")
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	err := query.Find(&keys).Error
+	return keys, err
+}
+
+// Touch records the use of a key. Every request is logged already, so the
+// timestamp is not audited.
+func (r *APIKeyRepository) Touch(id uint, ip string, at time.Time) error {
+	return audit.Skip(r.db).Model(&models.APIKey{}).
+		Where("id = ?", id).
+		UpdateColumns(map[string]interface{}{"last_used_at": at, "last_used_ip": ip}).Error
+}
+
+// Revoke revokes a key. It reports false if the key was already revoked.
+func (r *APIKeyRepository) Revoke(id uint) (bool, error) {
+	result := r.db.Model(&models.APIKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// RevokeAllForUser revokes every active key of a service account
+func (r *APIKeyRepository) RevokeAllForUser(userID uint) error {
+	return r.db.Model(&models.APIKey{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
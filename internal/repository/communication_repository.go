@@ -0,0 +1,259 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/E-Timileyin/school-management-system/internal/audit"
+	"github.com/E-Timileyin/school-management-system/internal/model"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CommunicationRepository struct {
+	db *gorm.DB
+}
+
+func NewCommunicationRepository(db *gorm.DB) *CommunicationRepository {
+	return &CommunicationRepository{db: db}
+}
+
+// WithContext returns a copy of the repository bound to ctx
+func (r *CommunicationRepository) WithContext(ctx context.Context) *CommunicationRepository {
+	return &CommunicationRepository{db: r.db.WithContext(ctx)}
+}
+
+// CommunicationFilter narrows the communications listed for authors
+type CommunicationFilter struct {
+	AuthorID uint   // 0 for all authors
+	Status   string // draft, scheduled, published or expired; empty for all
+}
+
+// FeedReader is who a feed is built for
+type FeedReader struct {
+	UserID    uint
+	Audiences []model.AudienceType // The audiences the reader belongs to
+	ClassID   *uint                // The reader's class, if they are a student in one
+}
+
+// CommunicationReader is a user who opened a communication
+type CommunicationReader struct {
+	UserID    uint      `json:"user_id"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	ReadAt    time.Time `json:"read_at"`
+}
+
+// Create saves a communication with its attachments
+func (r *CommunicationRepository) Create(communication *model.Communication) error {
+	return r.db.Omit("Author", "TargetClass").Create(communication).Error
+}
+
+func (r *CommunicationRepository) GetByID(id uint) (*model.Communication, error) {
+	var communication model.Communication
+	err := r.db.Preload("Attachments", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		First(&communication, id).Error
+	return &communication, err
+}
+
+// Update saves a communication. Its attachments are replaced with
+// communication.Attachments unless that is nil.
+func (r *CommunicationRepository) Update(communication *model.Communication) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Save(communication).Error; err != nil {
+			return err
+		}
+		if communication.Attachments == nil {
+			return nil
+		}
+		if err := tx.Where("communication_id = ?", communication.ID).Delete(&model.CommunicationAttachment{}).Error; err != nil {
+			return err
+		}
+		for i := range communication.Attachments {
+			communication.Attachments[i].ID = 0
+			communication.Attachments[i].CommunicationID = communication.ID
+		}
+		if len(communication.Attachments) == 0 {
+			return nil
+		}
+		return tx.Create(&communication.Attachments).Error
+	})
+}
+
+// Delete moves a communication and its attachments to the trash
+func (r *CommunicationRepository) Delete(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("communication_id = ?", id).Delete(&model.CommunicationAttachment{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.Communication{}, id).Error
+	})
+}
+
+// List returns the communications matching filter, newest first
+func (r *CommunicationRepository) List(filter CommunicationFilter, now time.Time) ([]model.Communication, error) {
+	query := r.db.Preload("Attachments", func(db *gorm.DB) *gorm.DB { return db.Order("id") })
+	if filter.AuthorID != 0 {
+		query = query.Where("author_id = ?", filter.AuthorID)
+	}
+	switch filter.Status {
+	case "draft":
+		query = query.Where("is_published = ? AND is_scheduled = ?", false, false)
+	case "scheduled":
+		query = query.Where("is_published = ? AND is_scheduled = ?", false, true)
+	case "published":
+		query = query.Where("is_published = ? AND (end_date IS NULL OR end_date > ?)", true, now)
+	case "expired":
+		query = query.Where("end_date <= ?", now)
+	}
+
+	communications := []model.Communication{}
+	err := query.Order("created_at DESC, id DESC").Find(&communications).Error
+	return communications, err
+}
+
+// Feed returns the published communications a reader may see at now,
+// important ones first and then newest first
+func (r *CommunicationRepository) Feed(reader FeedReader, now time.Time, limit, offset int) ([]model.Communication, int64, error) {
+	query := r.feedQuery(reader, now)
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	communications := []model.Communication{}
+	err := query.Preload("Attachments", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Order("is_important DESC, published_at DESC, id DESC").
+		Limit(limit).Offset(offset).
+		Find(&communications).Error
+	return communications, total, err
+}
+
+// CountUnread counts the communications in a reader's feed at now that
+// they have not read
+func (r *CommunicationRepository) CountUnread(reader FeedReader, now time.Time) (int64, error) {
+	var count int64
+	err := r.feedQuery(reader, now).
+		Where("id NOT IN (?)", r.db.Model(&model.CommunicationRead{}).Select("communication_id").Where("user_id = ?", reader.UserID)).
+		Count(&count).Error
+	return count, err
+}
+
+// feedQuery selects the published communications that reach a reader at
+// now. A communication reaches its target user only; otherwise it reaches
+// its audience, narrowed to the students of its target class if it has one.
+func (r *CommunicationRepository) feedQuery(reader FeedReader, now time.Time) *gorm.DB {
+	query := r.db.Model(&model.Communication{}).
+		Where("is_published = ? AND (start_date IS NULL OR start_date <= ?) AND (end_date IS NULL OR end_date > ?)", true, now, now)
+
+	reaches := r.db.Where("target_user_id = ?", reader.UserID)
+	if len(reader.Audiences) > 0 {
+		audience := r.db.Where("target_user_id IS NULL AND audience IN ?", reader.Audiences)
+		if reader.ClassID != nil {
+			audience = audience.Where("(target_class_id IS NULL OR target_class_id = ?)", *reader.ClassID)
+		} else {
+			audience = audience.Where("target_class_id IS NULL")
+		}
+		reaches = reaches.Or(audience)
+	}
+	return query.Where(reaches)
+}
+
+// ReadTimes returns when userID read each of communicationIDs they have read
+func (r *CommunicationRepository) ReadTimes(userID uint, communicationIDs []uint) (map[uint]time.Time, error) {
+	reads := map[uint]time.Time{}
+	if len(communicationIDs) == 0 {
+		return reads, nil
+	}
+	var rows []model.CommunicationRead
+	if err := r.db.Where("user_id = ? AND communication_id IN ?", userID, communicationIDs).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		reads[row.CommunicationID] = row.ReadAt
+	}
+	return reads, nil
+}
+
+// MarkRead records that userID read a communication. Reading it again
+// keeps the first read time. Receipts are not audited; they are a record
+// of their own.
+func (r *CommunicationRepository) MarkRead(communicationID, userID uint, at time.Time) error {
+	return audit.Skip(r.db).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "communication_id"}, {Name: "user_id"}},
+		DoNothing: true,
+	}).Create(&model.CommunicationRead{CommunicationID: communicationID, UserID: userID, ReadAt: at}).Error
+}
+
+// ListReaders returns the users who read a communication, in the order
+// they read it
+func (r *CommunicationRepository) ListReaders(communicationID uint) ([]CommunicationReader, error) {
+	readers := []CommunicationReader{}
+	err := r.db.Table("communication_reads").
+		Select("users.id AS user_id, users.first_name, users.last_name, users.email, users.role, communication_reads.read_at").
+		Joins("JOIN users ON users.id = communication_reads.user_id").
+		Where("communication_reads.communication_id = ? AND communication_reads.deleted_at IS NULL", communicationID).
+		Order("communication_reads.read_at, users.id").
+		Scan(&readers).Error
+	return readers, err
+}
+
+// ListUnread returns the users a communication reaches who have not read it.
+// roles are the roles of its audience.
+func (r *CommunicationRepository) ListUnread(communication *model.Communication, roles []string) ([]models.User, error) {
+	query := r.db.Model(&models.User{}).
+		Where("auth_provider <> ? AND erased_at IS NULL", models.AuthProviderServiceAccount).
+		Where("id NOT IN (?)", r.db.Model(&model.CommunicationRead{}).Select("user_id").Where("communication_id = ?", communication.ID))
+	switch {
+	case communication.TargetUserID != nil:
+		query = query.Where("id = ?", *communication.TargetUserID)
+	case communication.TargetClassID != nil:
+		query = query.Where("role IN ? AND id IN (?)", roles,
+			r.db.Model(&models.Student{}).Select("user_id").Where("class_id = ?", *communication.TargetClassID))
+	case roles != nil:
+		query = query.Where("role IN ?", roles)
+	}
+
+	var users []models.User
+	err := query.Order("last_name, first_name, id").Find(&users).Error
+	return users, err
+}
+
+// StudentClassID returns the class of the student record of userID, or nil
+func (r *CommunicationRepository) StudentClassID(userID uint) (*uint, error) {
+	var students []models.Student
+	if err := r.db.Where("user_id = ?", userID).Limit(1).Find(&students).Error; err != nil {
+		return nil, err
+	}
+	if len(students) == 0 {
+		return nil, nil
+	}
+	return students[0].ClassID, nil
+}
+
+// ClassExists reports whether a class exists
+func (r *CommunicationRepository) ClassExists(id uint) (bool, error) {
+	var count int64
+	err := r.db.Model(&model.Class{}).Where("id = ?", id).Count(&count).Error
+	return count > 0, err
+}
+
+// UserExists reports whether a user exists and is not in the trash
+func (r *CommunicationRepository) UserExists(id uint) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.User{}).Where("id = ?", id).Count(&count).Error
+	return count > 0, err
+}
+
+// PublishDue publishes the scheduled communications whose start date has
+// come by now and whose end date, if any, has not passed, and returns how
+// many were published
+func (r *CommunicationRepository) PublishDue(now time.Time) (int64, error) {
+	result := r.db.Model(&model.Communication{}).
+		Where("is_published = ? AND is_scheduled = ? AND start_date <= ? AND (end_date IS NULL OR end_date > ?)", false, true, now, now).
+		Updates(map[string]interface{}{"is_published": true, "published_at": now})
+	return result.RowsAffected, result.Error
+}
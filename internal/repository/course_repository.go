@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"context"
+
 	"github.com/E-Timileyin/school-management-system/internal/models"
 	"gorm.io/gorm"
 )
@@ -13,6 +15,11 @@ func NewCourseRepository(db *gorm.DB) *CourseRepository {
 	return &CourseRepository{db: db}
 }
 
+// WithContext returns a copy of the repository bound to ctx
+func (r *CourseRepository) WithContext(ctx context.Context) *CourseRepository {
+	return &CourseRepository{db: r.db.WithContext(ctx)}
+}
+
 func (r *CourseRepository) Create(course *models.Course) error {
 	return r.db.Create(course).Error
 }
@@ -27,8 +34,14 @@ func (r *CourseRepository) Update(course *models.Course) error {
 	return r.db.Save(course).Error
 }
 
+// Delete soft-deletes a course together with its enrollments
 func (r *CourseRepository) Delete(id uint) error {
-	return r.db.Delete(&models.Course{}, id).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.Course{}, id).Error; err != nil {
+			return err
+		}
+		return tx.Where("course_id = ?", id).Delete(&models.Enrollment{}).Error
+	})
 }
 
 func (r *CourseRepository) List() ([]models.Course, error) {
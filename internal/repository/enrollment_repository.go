@@ -2,6 +2,8 @@
 package repository
 
 import (
+	"context"
+
 	"github.com/E-Timileyin/school-management-system/internal/models"
 	"gorm.io/gorm"
 )
@@ -14,6 +16,11 @@ func NewEnrollmentRepository(db *gorm.DB) *EnrollmentRepository {
 	return &EnrollmentRepository{db: db}
 }
 
+// WithContext returns a copy of the repository bound to ctx
+func (r *EnrollmentRepository) WithContext(ctx context.Context) *EnrollmentRepository {
+	return &EnrollmentRepository{db: r.db.WithContext(ctx)}
+}
+
 func (r *EnrollmentRepository) Delete(id uint) error {
 	return r.db.Delete(&models.Enrollment{}, id).Error
 }
@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync"
+	"time"
+
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"gorm.io/gorm"
+)
+
+// JobRunFilter narrows a job history query. Zero values are ignored.
+type JobRunFilter struct {
+	Job    string
+	Status string
+	Limit  int
+	Offset int
+}
+
+type JobRunRepository struct {
+	db    *gorm.DB
+	local *jobLocks
+}
+
+// jobLocks are the jobs running in this process, used where the database
+// has no advisory locks
+type jobLocks struct {
+	mu   sync.Mutex
+	held map[string]bool
+}
+
+func NewJobRunRepository(db *gorm.DB) *JobRunRepository {
+	return &JobRunRepository{db: db, local: &jobLocks{held: map[string]bool{}}}
+}
+
+// WithContext returns a copy of the repository bound to ctx
+func (r *JobRunRepository) WithContext(ctx context.Context) *JobRunRepository {
+	return &JobRunRepository{db: r.db.WithContext(ctx), local: r.local}
+}
+
+// TryLock takes the lock of a job without waiting and returns a function
+// that releases it. On PostgreSQL it is a session advisory lock held on a
+// connection of its own, so only one replica runs the job at a time.
+func (r *JobRunRepository) TryLock(ctx context.Context, job string) (func(), bool, error) {
+	if r.db.Dialector.Name() != "postgres" {
+		return r.local.tryLock(job)
+	}
+
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return nil, false, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	key := "job_runs:" + job
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", key).Scan(&locked); err != nil || !locked {
+		conn.Close()
+		return nil, false, err
+	}
+	return func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", key); err != nil {
+			// Drop the connection rather than return it to the pool locked
+			_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, true, nil
+}
+
+func (l *jobLocks) tryLock(job string) (func(), bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held[job] {
+		return nil, false, nil
+	}
+	l.held[job] = true
+	return func() {
+		l.mu.Lock()
+		delete(l.held, job)
+		l.mu.Unlock()
+	}, true, nil
+}
+
+func (r *JobRunRepository) Create(run *models.JobRun) error {
+	return r.db.Create(run).Error
+}
+
+// Finish records the outcome of a run
+func (r *JobRunRepository) Finish(run *models.JobRun) error {
+	return r.db.Model(run).Select("finished_at", "status", "result", "error").Updates(run).Error
+}
+
+// Last returns the most recent run of a job, or nil if it never ran
+func (r *JobRunRepository) Last(job string) (*models.JobRun, error) {
+	var runs []models.JobRun
+	if err := r.db.Where("job = ?", job).Order("started_at DESC, id DESC").Limit(1).Find(&runs).Error; err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, nil
+	}
+	return &runs[0], nil
+}
+
+// List returns job runs, most recent first
+func (r *JobRunRepository) List(filter JobRunFilter) ([]models.JobRun, error) {
+	query := r.db.Model(&models.JobRun{})
+	if filter.Job != "" {
+		query = query.Where("job = ?", filter.Job)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	offset := filter.Offset
+	if offset < 0 {
+		offset = 0
+	}
+
+	var runs []models.JobRun
+	err := query.Order("started_at DESC, id DESC").Limit(limit).Offset(offset).Find(&runs).Error
+	return runs, err
+}
+
+// DeleteBefore removes the runs of a job that started before cutoff
+func (r *JobRunRepository) DeleteBefore(job string, cutoff time.Time) error {
+	return r.db.Where("job = ? AND started_at < ?", job, cutoff).Delete(&models.JobRun{}).Error
+}
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/E-Timileyin/school-management-system/internal/audit"
+	"github.com/E-Timileyin/school-management-system/internal/model"
+	"gorm.io/gorm"
+)
+
+type KioskRepository struct {
+	db *gorm.DB
+}
+
+func NewKioskRepository(db *gorm.DB) *KioskRepository {
+	return &KioskRepository{db: db}
+}
+
+// WithContext returns a copy of the repository bound to ctx
+func (r *KioskRepository) WithContext(ctx context.Context) *KioskRepository {
+	return &KioskRepository{db: r.db.WithContext(ctx)}
+}
+
+func (r *KioskRepository) Create(kiosk *model.KioskDevice) error {
+	return r.db.Create(kiosk).Error
+}
+
+func (r *KioskRepository) FindByPrefix(prefix string) (*model.KioskDevice, error) {
+	var kiosk model.KioskDevice
+	err := r.db.Where("prefix = ?", prefix).First(&kiosk).Error
+	return &kiosk, err
+}
+
+// List returns the kiosks in the order they were registered
+func (r *KioskRepository) List() ([]model.KioskDevice, error) {
+	var kiosks []model.KioskDevice
+	err := r.db.Order("id").Find(&kiosks).Error
+	return kiosks, err
+}
+
+// Touch records that a kiosk was seen. Kiosk requests are logged already,
+// so the timestamp is not audited.
+func (r *KioskRepository) Touch(id uint, ip string, at time.Time) error {
+	return audit.Skip(r.db).Model(&model.KioskDevice{}).
+		Where("id = ?", id).
+		UpdateColumns(map[string]interface{}{"last_seen_at": at, "last_seen_ip": ip}).Error
+}
+
+// Revoke revokes the credential of a kiosk. It reports false if the kiosk
+// does not exist or was already revoked.
+func (r *KioskRepository) Revoke(id uint) (bool, error) {
+	result := r.db.Model(&model.KioskDevice{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
@@ -1,13 +1,36 @@
 package repository
 
 import (
+	"context"
+	"database/sql/driver"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"github.com/E-Timileyin/school-management-system/internal/model"
 )
 
+var (
+	ErrCopyUnavailable   = errors.New("copy is not available for checkout")
+	ErrAlreadyReturned   = errors.New("book has already been returned")
+	ErrCopyHeld          = errors.New("copy is held for another reader")
+	ErrReservationClosed = errors.New("reservation is no longer active")
+	ErrLoanChanged       = errors.New("loan changed while it was being renewed")
+	ErrNoFineOwed        = errors.New("no fine is outstanding on the loan")
+	ErrFineExceeded      = errors.New("amount exceeds the fine outstanding on the loan")
+	ErrRefundExceeded    = errors.New("amount exceeds what is left of the payment")
+	ErrNotLost           = errors.New("loan is not marked lost")
+	ErrLoanLimit         = errors.New("borrower has reached the loan limit")
+	ErrCopiesOnLoan      = errors.New("not enough copies on the shelf to withdraw")
+)
+
+// activeReservations are the statuses of reservations still in the queue
+var activeReservations = []string{model.ReservationWaiting, model.ReservationReady}
+
 type LibraryRepository struct {
 	db *gorm.DB
 }
@@ -16,9 +39,24 @@ func NewLibraryRepository(db *gorm.DB) *LibraryRepository {
 	return &LibraryRepository{db: db}
 }
 
+// WithContext returns a copy of the repository bound to ctx
+func (r *LibraryRepository) WithContext(ctx context.Context) *LibraryRepository {
+	return &LibraryRepository{db: r.db.WithContext(ctx)}
+}
+
 // Book Methods
+// CreateBook adds a book to the catalogue with TotalCopies copies on the
+// shelf
 func (r *LibraryRepository) CreateBook(book *model.Book) error {
-	return r.db.Create(book).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(book).Error; err != nil {
+			return err
+		}
+		if _, err := createCopies(tx, book.ID, book.TotalCopies, model.CopyAvailable, book.RackNumber); err != nil {
+			return err
+		}
+		return syncCopyCounts(tx, book.ID)
+	})
 }
 
 func (r *LibraryRepository) GetBookByID(id uint) (*model.Book, error) {
@@ -27,17 +65,120 @@ func (r *LibraryRepository) GetBookByID(id uint) (*model.Book, error) {
 	return &book, err
 }
 
-func (r *LibraryRepository) UpdateBook(book *model.Book) error {
-	return r.db.Save(book).Error
+// UpdateBook saves the details of a book and, in the same transaction,
+// adds copies when diff is positive or withdraws copies on the shelf when
+// it is negative. Copy counts follow the copies and are not saved from the
+// book. Nothing is saved if fewer than -diff copies are on the shelf.
+func (r *LibraryRepository) UpdateBook(book *model.Book, diff int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		switch {
+		case diff > 0:
+			if _, err := createCopies(tx, book.ID, diff, model.CopyAvailable, book.RackNumber); err != nil {
+				return err
+			}
+		case diff < 0:
+			if err := withdrawCopies(tx, book.ID, -diff); err != nil {
+				return err
+			}
+		}
+		if diff != 0 {
+			if err := syncCopyCounts(tx, book.ID); err != nil {
+				return err
+			}
+		}
+		return tx.Omit("TotalCopies", "AvailableCopies", "Category").Save(book).Error
+	})
 }
 
+// DeleteBook moves a book and its copies to the trash and cancels the
+// reservations queued for it
 func (r *LibraryRepository) DeleteBook(id uint) error {
-	return r.db.Delete(&model.Book{}, id).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.BookReservation{}).
+			Where("book_id = ? AND status IN ?", id, activeReservations).
+			Updates(map[string]interface{}{"status": model.ReservationCancelled, "closed_at": time.Now()}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("book_id = ?", id).Delete(&model.BookCopy{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.Book{}, id).Error
+	})
 }
 
 // Library Card Methods
-func (r *LibraryRepository) CreateLibraryCard(card *model.LibraryCard) error {
-	return r.db.Create(card).Error
+
+// CreateLibraryCard creates a card together with its first event
+func (r *LibraryRepository) CreateLibraryCard(card *model.LibraryCard, event *model.LibraryCardEvent) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("User", "Events").Create(card).Error; err != nil {
+			return err
+		}
+		event.CardID = card.ID
+		return tx.Create(event).Error
+	})
+}
+
+func (r *LibraryRepository) GetLibraryCard(id uint) (*model.LibraryCard, error) {
+	var card model.LibraryCard
+	err := r.db.First(&card, id).Error
+	return &card, err
+}
+
+// ListCardEvents returns the history of a card, oldest first
+func (r *LibraryRepository) ListCardEvents(cardID uint) ([]model.LibraryCardEvent, error) {
+	var events []model.LibraryCardEvent
+	err := r.db.Where("card_id = ?", cardID).Order("occurred_at, id").Find(&events).Error
+	return events, err
+}
+
+// UpdateLibraryCard saves the given columns of a card and records the
+// event that changed them
+func (r *LibraryRepository) UpdateLibraryCard(card *model.LibraryCard, event *model.LibraryCardEvent, columns ...string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(card).Select(columns).Updates(card).Error; err != nil {
+			return err
+		}
+		event.CardID = card.ID
+		return tx.Create(event).Error
+	})
+}
+
+// ExpireCards sets the status of active cards past their expiry date at
+// now to expired and returns how many changed
+func (r *LibraryRepository) ExpireCards(now time.Time) (int64, error) {
+	result := r.db.Model(&model.LibraryCard{}).
+		Where("status = ? AND expiry_date < ?", "active", now).
+		Update("status", "expired")
+	return result.RowsAffected, result.Error
+}
+
+// CardNumberTaken reports whether any card, including deleted and
+// replaced ones, has or had the number
+func (r *LibraryRepository) CardNumberTaken(number string) (bool, error) {
+	var count int64
+	if err := r.db.Unscoped().Model(&model.LibraryCard{}).Where("card_number = ?", number).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+	return r.CardNumberRetired(number)
+}
+
+// CardNumberRetired reports whether number belonged to a card that was
+// replaced
+func (r *LibraryRepository) CardNumberRetired(number string) (bool, error) {
+	var count int64
+	err := r.db.Unscoped().Model(&model.LibraryCardEvent{}).Where("old_card_number = ?", number).Count(&count).Error
+	return count > 0, err
+}
+
+// GetLibraryCardByNumber finds the card with a scanned card number
+func (r *LibraryRepository) GetLibraryCardByNumber(number string) (*model.LibraryCard, error) {
+	var card model.LibraryCard
+	err := r.db.Where("card_number = ?", number).First(&card).Error
+	return &card, err
 }
 
 func (r *LibraryRepository) GetLibraryCardByUserID(userID uint) (*model.LibraryCard, error) {
@@ -47,124 +188,563 @@ func (r *LibraryRepository) GetLibraryCardByUserID(userID uint) (*model.LibraryC
 }
 
 // Book Issue Methods
-func (r *LibraryRepository) CheckoutBook(issue *model.BookIssue) error {
-	tx := r.db.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
+
+// CheckoutBook lends the copy issue.CopyID. It fails with
+// ErrCopyUnavailable unless the copy is on the shelf, and with ErrCopyHeld
+// when the copy is held for a reservation of another reader, and with
+// ErrLoanLimit when the borrower already has limit books out. The loan
+// fulfils the borrower's reservation of the book, if any; copies held for
+// that reservation other than the one lent go back on the shelf.
+func (r *LibraryRepository) CheckoutBook(issue *model.BookIssue, limit int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// Locking the card makes concurrent checkouts for the borrower wait,
+		// so each counts the loans the others made
+		if _, err := lockCard(tx, issue.CardID); err != nil {
+			return err
+		}
+		var openLoans int64
+		if err := tx.Model(&model.BookIssue{}).Where("user_id = ? AND return_date IS NULL", issue.UserID).
+			Count(&openLoans).Error; err != nil {
+			return err
+		}
+		if openLoans >= int64(limit) {
+			return ErrLoanLimit
 		}
-	}()
 
-	// Check book availability
-	var book model.Book
-	if err := tx.First(&book, issue.BookID).Error; err != nil {
-		tx.Rollback()
-		return err
-	}
+		claimFrom := model.CopyAvailable
+		var holds []model.BookReservation
+		if err := tx.Where("copy_id = ? AND status = ?", *issue.CopyID, model.ReservationReady).
+			Limit(1).Find(&holds).Error; err != nil {
+			return err
+		}
+		if len(holds) > 0 {
+			if holds[0].UserID != issue.UserID {
+				return ErrCopyHeld
+			}
+			claimFrom = model.CopyOnHold
+		}
 
-	if book.AvailableCopies <= 0 {
-		tx.Rollback()
-		return errors.New("no copies available")
-	}
+		// Claiming the copy with a conditional update keeps two desks from
+		// lending the same copy
+		result := tx.Model(&model.BookCopy{}).
+			Where("id = ? AND status = ?", *issue.CopyID, claimFrom).
+			Update("status", model.CopyIssued)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrCopyUnavailable
+		}
 
-	// Update book available copies
-	book.AvailableCopies--
-	if err := tx.Save(&book).Error; err != nil {
-		tx.Rollback()
-		return err
-	}
+		if err := tx.Create(issue).Error; err != nil {
+			return err
+		}
 
-	// Create issue record
-	issue.IssueDate = time.Now()
-	issue.DueDate = time.Now().AddDate(0, 0, 14) // 14 days from now
-	issue.Status = "issued"
+		var heldElsewhere []uint
+		if err := tx.Model(&model.BookReservation{}).
+			Where("book_id = ? AND user_id = ? AND status = ? AND copy_id <> ?",
+				issue.BookID, issue.UserID, model.ReservationReady, *issue.CopyID).
+			Pluck("copy_id", &heldElsewhere).Error; err != nil {
+			return err
+		}
+		if len(heldElsewhere) > 0 {
+			if err := tx.Model(&model.BookCopy{}).
+				Where("id IN ? AND status = ?", heldElsewhere, model.CopyOnHold).
+				Update("status", model.CopyAvailable).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&model.BookReservation{}).
+			Where("book_id = ? AND user_id = ? AND status IN ?", issue.BookID, issue.UserID, activeReservations).
+			Updates(map[string]interface{}{
+				"status":    model.ReservationFulfilled,
+				"closed_at": issue.IssueDate,
+				"issue_id":  issue.ID,
+			}).Error; err != nil {
+			return err
+		}
+		return syncCopyCounts(tx, issue.BookID)
+	})
+}
 
-	if err := tx.Create(issue).Error; err != nil {
-		tx.Rollback()
-		return err
+// ReturnBook closes a loan at now with the overdue fine worked out by the
+// caller. The copy is held for the next reservation of the book, which is
+// returned, or goes back on the shelf when nobody is waiting.
+func (r *LibraryRepository) ReturnBook(issueID uint, receivedBy model.Attendant, fine decimal.Decimal, now time.Time, pickupWindow time.Duration) ([]model.BookReservation, error) {
+	var ready []model.BookReservation
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		issue, err := lockOpenIssue(tx, issueID)
+		if err != nil {
+			return err
+		}
+
+		// Update issue record
+		issue.ReturnDate = &now
+		issue.Status = "returned"
+		issue.ReceivedBy = receivedBy.StaffID
+		issue.ReceivedByKiosk = receivedBy.KioskID
+		issue.FineAmount = fine
+
+		if err := tx.Save(issue).Error; err != nil {
+			return err
+		}
+		if fine.IsPositive() {
+			if err := chargeFine(tx, issue, fine, "Overdue fine", receivedBy, now); err != nil {
+				return err
+			}
+		}
+
+		if issue.CopyID != nil {
+			if err := tx.Model(&model.BookCopy{}).
+				Where("id = ? AND status = ?", *issue.CopyID, model.CopyIssued).
+				Update("status", model.CopyAvailable).Error; err != nil {
+				return err
+			}
+		}
+
+		if ready, err = fillHolds(tx, issue.BookID, now, pickupWindow); err != nil {
+			return err
+		}
+		return syncCopyCounts(tx, issue.BookID)
+	})
+	return ready, err
+}
+
+// WriteOffLoan closes an open loan whose book was lost or came back
+// damaged, with status lost or damaged. The copy is marked the same way and
+// no longer counts towards the book's copies. The overdue fine and the
+// replacement charge worked out by the caller are charged to the card.
+func (r *LibraryRepository) WriteOffLoan(issueID, staffID uint, status string, fine, replacement decimal.Decimal, now time.Time) error {
+	copyStatus := model.CopyLost
+	if status == "damaged" {
+		copyStatus = model.CopyDamaged
 	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		issue, err := lockOpenIssue(tx, issueID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(issue).Updates(map[string]interface{}{
+			"return_date":        now,
+			"status":             status,
+			"received_by":        staffID,
+			"fine_amount":        fine,
+			"replacement_charge": replacement,
+		}).Error; err != nil {
+			return err
+		}
+
+		if fine.IsPositive() {
+			if err := chargeFine(tx, issue, fine, "Overdue fine", model.StaffAttendant(staffID), now); err != nil {
+				return err
+			}
+		}
+		if replacement.IsPositive() {
+			reason := "Replacement of lost book"
+			if status == "damaged" {
+				reason = "Replacement of damaged book"
+			}
+			if err := chargeFine(tx, issue, replacement, reason, model.StaffAttendant(staffID), now); err != nil {
+				return err
+			}
+		}
 
-	return tx.Commit().Error
+		if issue.CopyID != nil {
+			if err := tx.Model(&model.BookCopy{}).
+				Where("id = ? AND status = ?", *issue.CopyID, model.CopyIssued).
+				Update("status", copyStatus).Error; err != nil {
+				return err
+			}
+		}
+		return syncCopyCounts(tx, issue.BookID)
+	})
 }
 
-func (r *LibraryRepository) ReturnBook(issueID, receivedBy uint) error {
-	tx := r.db.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
+// RecoverLostBook closes a loan declared lost as returned at now, when the
+// book turned up. The replacement charge is taken back, which leaves the
+// card in credit if it was paid. The copy is held for the next reservation
+// of the book, which is returned, or goes back on the shelf.
+func (r *LibraryRepository) RecoverLostBook(issueID, receivedBy uint, now time.Time, pickupWindow time.Duration) ([]model.BookReservation, error) {
+	var ready []model.BookReservation
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var issue model.BookIssue
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&issue, issueID).Error; err != nil {
+			return err
+		}
+		if issue.Status != "lost" {
+			return ErrNotLost
 		}
-	}()
 
-	// Get the issue record
+		if issue.ReplacementCharge.IsPositive() {
+			card, err := lockCard(tx, issue.CardID)
+			if err != nil {
+				return err
+			}
+			if err := postLedgerEntry(tx, card, &issue, &model.FineLedgerEntry{
+				IssueID:    issue.ID,
+				Type:       model.LedgerReversal,
+				Amount:     issue.ReplacementCharge.Neg(),
+				Reason:     "Lost book returned",
+				RecordedBy: &receivedBy,
+				OccurredAt: now,
+			}); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&issue).Updates(map[string]interface{}{
+			"return_date":        now,
+			"status":             "returned",
+			"received_by":        receivedBy,
+			"replacement_charge": decimal.Zero,
+		}).Error; err != nil {
+			return err
+		}
+
+		if issue.CopyID != nil {
+			if err := tx.Model(&model.BookCopy{}).
+				Where("id = ? AND status = ?", *issue.CopyID, model.CopyLost).
+				Update("status", model.CopyAvailable).Error; err != nil {
+				return err
+			}
+		}
+
+		var err error
+		if ready, err = fillHolds(tx, issue.BookID, now, pickupWindow); err != nil {
+			return err
+		}
+		return syncCopyCounts(tx, issue.BookID)
+	})
+	return ready, err
+}
+
+// ListOpenLoans returns the loans a user has out with their books and
+// copies, the soonest due first
+func (r *LibraryRepository) ListOpenLoans(userID uint) ([]model.BookIssue, error) {
+	var issues []model.BookIssue
+	err := r.db.Preload("Book").Preload("Copy").
+		Where("user_id = ? AND return_date IS NULL", userID).
+		Order("due_date, id").Find(&issues).Error
+	return issues, err
+}
+
+// lockOpenIssue reads a loan and locks it until the transaction ends, so
+// that it is closed only once. It fails with ErrAlreadyReturned if the loan
+// is closed.
+func lockOpenIssue(tx *gorm.DB, issueID uint) (*model.BookIssue, error) {
 	var issue model.BookIssue
-	if err := tx.Preload("Book").First(&issue, issueID).Error; err != nil {
-		tx.Rollback()
-		return err
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&issue, issueID).Error; err != nil {
+		return nil, err
+	}
+	if issue.ReturnDate != nil {
+		return nil, ErrAlreadyReturned
 	}
+	return &issue, nil
+}
 
-	// Update book available copies
-	var book model.Book
-	if err := tx.First(&book, issue.BookID).Error; err != nil {
-		tx.Rollback()
-		return err
+// GetOpenIssueByCopy finds the loan a copy is out on
+func (r *LibraryRepository) GetOpenIssueByCopy(copyID uint) (*model.BookIssue, error) {
+	var issue model.BookIssue
+	err := r.db.Where("copy_id = ? AND return_date IS NULL", copyID).First(&issue).Error
+	return &issue, err
+}
+
+// RenewIssue moves the due date of an open loan from previousDue to newDue
+// and records the renewal. It fails with ErrLoanChanged if the loan was
+// returned or renewed in the meantime.
+func (r *LibraryRepository) RenewIssue(issue *model.BookIssue, newDue time.Time, renewedBy uint, now time.Time) (*model.BookRenewal, error) {
+	renewal := &model.BookRenewal{
+		IssueID:         issue.ID,
+		RenewedBy:       renewedBy,
+		RenewedAt:       now,
+		PreviousDueDate: issue.DueDate,
+		NewDueDate:      newDue,
 	}
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.BookIssue{}).
+			Where("id = ? AND return_date IS NULL AND renewal_count = ?", issue.ID, issue.RenewalCount).
+			Updates(map[string]interface{}{
+				"due_date":        newDue,
+				"renewal_count":   issue.RenewalCount + 1,
+				"status":          "issued",
+				"due_reminder_at": nil,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrLoanChanged
+		}
+		return tx.Create(renewal).Error
+	})
+	return renewal, err
+}
+
+// ListRenewals returns the renewals of a loan, oldest first
+func (r *LibraryRepository) ListRenewals(issueID uint) ([]model.BookRenewal, error) {
+	var renewals []model.BookRenewal
+	err := r.db.Where("issue_id = ?", issueID).Order("renewed_at, id").Find(&renewals).Error
+	return renewals, err
+}
+
+// CountQueuedReservations counts the readers waiting for a book or with a
+// copy of it on hold
+func (r *LibraryRepository) CountQueuedReservations(bookID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&model.BookReservation{}).
+		Where("book_id = ? AND status IN ?", bookID, activeReservations).
+		Count(&count).Error
+	return count, err
+}
+
+// Fine ledger Methods
+
+// RecordFinePayment takes a payment towards the fine outstanding on a loan.
+// A payment without a reference gets one for its receipt. It fails with
+// ErrNoFineOwed when nothing is outstanding and ErrFineExceeded when the
+// payment is more than what is.
+func (r *LibraryRepository) RecordFinePayment(payment *model.FinePayment) (*model.FineLedgerEntry, error) {
+	var entry *model.FineLedgerEntry
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var issue model.BookIssue
+		if err := tx.First(&issue, payment.IssueID).Error; err != nil {
+			return err
+		}
+		card, err := lockCard(tx, issue.CardID)
+		if err != nil {
+			return err
+		}
+		if err := checkOutstanding(tx, issue.ID, payment.Amount); err != nil {
+			return err
+		}
+
+		if err := tx.Create(payment).Error; err != nil {
+			return err
+		}
+		if payment.ReferenceNo == "" {
+			payment.ReferenceNo = fmt.Sprintf("FP-%s-%06d", payment.PaymentDate.Format("20060102"), payment.ID)
+			if err := tx.Model(payment).Update("reference_no", payment.ReferenceNo).Error; err != nil {
+				return err
+			}
+		}
+
+		entry = &model.FineLedgerEntry{
+			IssueID:    issue.ID,
+			PaymentID:  &payment.ID,
+			Type:       model.LedgerPayment,
+			Amount:     payment.Amount.Neg(),
+			RecordedBy: &payment.ReceivedBy,
+			OccurredAt: payment.PaymentDate,
+		}
+		return postLedgerEntry(tx, card, &issue, entry)
+	})
+	return entry, err
+}
+
+// WaiveFine writes off amount of the fine outstanding on a loan
+func (r *LibraryRepository) WaiveFine(issueID uint, amount decimal.Decimal, reason string, approvedBy uint, now time.Time) (*model.FineLedgerEntry, error) {
+	var entry *model.FineLedgerEntry
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var issue model.BookIssue
+		if err := tx.First(&issue, issueID).Error; err != nil {
+			return err
+		}
+		card, err := lockCard(tx, issue.CardID)
+		if err != nil {
+			return err
+		}
+		if err := checkOutstanding(tx, issue.ID, amount); err != nil {
+			return err
+		}
+
+		entry = &model.FineLedgerEntry{
+			IssueID:    issue.ID,
+			Type:       model.LedgerWaiver,
+			Amount:     amount.Neg(),
+			Reason:     reason,
+			RecordedBy: &approvedBy,
+			ApprovedBy: &approvedBy,
+			OccurredAt: now,
+		}
+		return postLedgerEntry(tx, card, &issue, entry)
+	})
+	return entry, err
+}
+
+// RefundPayment gives back amount of a payment. The refunded amount is owed
+// again until it is waived or paid. It fails with ErrRefundExceeded when
+// more is refunded than is left of the payment.
+func (r *LibraryRepository) RefundPayment(paymentID uint, amount decimal.Decimal, reason string, refundedBy uint, now time.Time) (*model.FineLedgerEntry, error) {
+	var entry *model.FineLedgerEntry
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var payment model.FinePayment
+		if err := tx.First(&payment, paymentID).Error; err != nil {
+			return err
+		}
+		var issue model.BookIssue
+		if err := tx.First(&issue, payment.IssueID).Error; err != nil {
+			return err
+		}
+		card, err := lockCard(tx, issue.CardID)
+		if err != nil {
+			return err
+		}
+		// Read the payment again now that refunds of it wait for the lock
+		if err := tx.First(&payment, paymentID).Error; err != nil {
+			return err
+		}
+		if amount.GreaterThan(payment.Amount.Sub(payment.Refunded)) {
+			return ErrRefundExceeded
+		}
+		if err := tx.Model(&payment).Update("refunded", payment.Refunded.Add(amount)).Error; err != nil {
+			return err
+		}
+
+		entry = &model.FineLedgerEntry{
+			IssueID:    issue.ID,
+			PaymentID:  &payment.ID,
+			Type:       model.LedgerRefund,
+			Amount:     amount,
+			Reason:     reason,
+			RecordedBy: &refundedBy,
+			OccurredAt: now,
+		}
+		return postLedgerEntry(tx, card, &issue, entry)
+	})
+	return entry, err
+}
 
-	book.AvailableCopies++
-	if err := tx.Save(&book).Error; err != nil {
-		tx.Rollback()
+// GetFinePayment returns a payment with the loan it was for, who paid it
+// and who received it
+func (r *LibraryRepository) GetFinePayment(id uint) (*model.FinePayment, error) {
+	var payment model.FinePayment
+	err := r.db.Preload("BookIssue.Book").Preload("BookIssue.User").Preload("Receiver").
+		Preload("BookIssue.LibraryCard", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+		First(&payment, id).Error
+	return &payment, err
+}
+
+// GetPaymentEntry returns the ledger entry a payment was posted as
+func (r *LibraryRepository) GetPaymentEntry(paymentID uint) (*model.FineLedgerEntry, error) {
+	var entry model.FineLedgerEntry
+	err := r.db.Where("payment_id = ? AND type = ?", paymentID, model.LedgerPayment).First(&entry).Error
+	return &entry, err
+}
+
+// ListLedger returns the entries on a card's fine ledger, oldest first
+func (r *LibraryRepository) ListLedger(cardID uint) ([]model.FineLedgerEntry, error) {
+	var entries []model.FineLedgerEntry
+	err := r.db.Where("card_id = ?", cardID).Order("occurred_at, id").Find(&entries).Error
+	return entries, err
+}
+
+// BackfillFineLedger charges the unpaid fines of loans returned before the
+// ledger was kept to their cards
+func (r *LibraryRepository) BackfillFineLedger() error {
+	var issueIDs []uint
+	err := r.db.Model(&model.BookIssue{}).
+		Where("fine_amount > 0 AND fine_paid = ?", false).
+		Where("NOT EXISTS (?)", r.db.Model(&model.FineLedgerEntry{}).Select("1").Where("fine_ledger_entries.issue_id = book_issues.id")).
+		Order("id").Pluck("id", &issueIDs).Error
+	if err != nil {
 		return err
 	}
 
-	// Update issue record
-	now := time.Now()
-	issue.ReturnDate = &now
-	issue.Status = "returned"
-	receivedByUint := receivedBy
-	receivedByUint = receivedBy
-	issue.ReceivedBy = &receivedByUint
-
-	// Calculate fine if any
-	if now.After(issue.DueDate) {
-		daysOverdue := int(now.Sub(issue.DueDate).Hours() / 24)
-		issue.FineAmount = float64(daysOverdue) * 5.0 // $5 per day fine
+	for _, issueID := range issueIDs {
+		err := r.db.Transaction(func(tx *gorm.DB) error {
+			var issue model.BookIssue
+			if err := tx.First(&issue, issueID).Error; err != nil {
+				return err
+			}
+			recordedBy := model.Attendant{StaffID: issue.IssuedBy, KioskID: issue.IssuedByKiosk}
+			if issue.ReceivedBy != nil || issue.ReceivedByKiosk != nil {
+				recordedBy = model.Attendant{StaffID: issue.ReceivedBy, KioskID: issue.ReceivedByKiosk}
+			}
+			occurredAt := issue.UpdatedAt
+			if issue.ReturnDate != nil {
+				occurredAt = *issue.ReturnDate
+			}
+			return chargeFine(tx, &issue, issue.FineAmount, "Overdue fine", recordedBy, occurredAt)
+		})
+		if err != nil {
+			return fmt.Errorf("loan %d: %w", issueID, err)
+		}
 	}
+	return nil
+}
 
-	if err := tx.Save(&issue).Error; err != nil {
-		tx.Rollback()
+// chargeFine posts a fine on a closed loan, such as its overdue fine, to
+// its card
+func chargeFine(tx *gorm.DB, issue *model.BookIssue, fine decimal.Decimal, reason string, recordedBy model.Attendant, now time.Time) error {
+	card, err := lockCard(tx, issue.CardID)
+	if err != nil {
 		return err
 	}
-
-	return tx.Commit().Error
+	return postLedgerEntry(tx, card, issue, &model.FineLedgerEntry{
+		IssueID:         issue.ID,
+		Type:            model.LedgerCharge,
+		Amount:          fine,
+		Reason:          reason,
+		RecordedBy:      recordedBy.StaffID,
+		RecordedByKiosk: recordedBy.KioskID,
+		OccurredAt:      now,
+	})
 }
 
-// Fine Payment Methods
-func (r *LibraryRepository) RecordFinePayment(payment *model.FinePayment) error {
-	tx := r.db.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-	}()
+// lockCard reads a library card and locks it until the transaction ends, so
+// that entries on its ledger are posted one at a time. Cards in the trash
+// still carry their balance.
+func lockCard(tx *gorm.DB, cardID uint) (*model.LibraryCard, error) {
+	var card model.LibraryCard
+	err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).First(&card, cardID).Error
+	return &card, err
+}
 
-	// Update the issue record
-	if err := tx.Model(&model.BookIssue{}).
-		Where("id = ?", payment.IssueID).
-		UpdateColumns(map[string]interface{}{
-			"fine_paid":   true,
-			"fine_amount": 0, // Reset fine amount after payment
-		}).Error; err != nil {
-		tx.Rollback()
+// checkOutstanding fails unless amount can be taken off the fine
+// outstanding on a loan
+func checkOutstanding(tx *gorm.DB, issueID uint, amount decimal.Decimal) error {
+	outstanding, err := fineOutstanding(tx, issueID)
+	if err != nil {
 		return err
 	}
+	if !outstanding.IsPositive() {
+		return ErrNoFineOwed
+	}
+	if amount.GreaterThan(outstanding) {
+		return ErrFineExceeded
+	}
+	return nil
+}
 
-	// Record the payment
-	payment.PaymentDate = time.Now()
-	if err := tx.Create(payment).Error; err != nil {
-		tx.Rollback()
+// fineOutstanding sums the ledger entries of a loan
+func fineOutstanding(tx *gorm.DB, issueID uint) (decimal.Decimal, error) {
+	var outstanding decimal.Decimal
+	err := tx.Model(&model.FineLedgerEntry{}).Select("COALESCE(SUM(amount), 0)").
+		Where("issue_id = ?", issueID).Row().Scan(&outstanding)
+	return outstanding, err
+}
+
+// postLedgerEntry records entry against a locked card, moves the card's
+// balance by its amount and marks the loan's fine paid once nothing is
+// outstanding on it
+func postLedgerEntry(tx *gorm.DB, card *model.LibraryCard, issue *model.BookIssue, entry *model.FineLedgerEntry) error {
+	entry.CardID = card.ID
+	entry.UserID = card.UserID
+	entry.Balance = card.FineAmount.Add(entry.Amount)
+	if err := tx.Create(entry).Error; err != nil {
 		return err
 	}
+	if err := tx.Unscoped().Model(&model.LibraryCard{}).Where("id = ?", card.ID).
+		Update("fine_amount", entry.Balance).Error; err != nil {
+		return err
+	}
+	card.FineAmount = entry.Balance
 
-	return tx.Commit().Error
+	outstanding, err := fineOutstanding(tx, issue.ID)
+	if err != nil {
+		return err
+	}
+	issue.FinePaid = !outstanding.IsPositive()
+	return tx.Model(&model.BookIssue{}).Where("id = ?", issue.ID).Update("fine_paid", issue.FinePaid).Error
 }
 
 // Query Methods
@@ -174,15 +754,48 @@ func (r *LibraryRepository) GetBooksByCategory(categoryID uint) ([]model.Book, e
 	return books, err
 }
 
-func (r *LibraryRepository) GetOverdueBooks() ([]model.BookIssue, error) {
+// GetOverdueBooks returns the loans still out past their due date at now
+func (r *LibraryRepository) GetOverdueBooks(now time.Time) ([]model.BookIssue, error) {
 	var issues []model.BookIssue
-	err := r.db.Where("status = 'issued' AND due_date < ?", time.Now()).
+	err := r.db.Where("return_date IS NULL AND due_date < ?", now).
 		Preload("Book").
 		Preload("User").
+		Order("due_date, id").
 		Find(&issues).Error
 	return issues, err
 }
 
+// GetBooksDueBefore returns the loans not yet overdue at now that are due
+// before until
+func (r *LibraryRepository) GetBooksDueBefore(now, until time.Time) ([]model.BookIssue, error) {
+	var issues []model.BookIssue
+	err := r.db.Where("return_date IS NULL AND due_date >= ? AND due_date < ?", now, until).
+		Preload("Book").
+		Preload("User").
+		Order("due_date, id").
+		Find(&issues).Error
+	return issues, err
+}
+
+// MarkOverdue sets the status of loans past their due date at now to
+// overdue and returns how many changed
+func (r *LibraryRepository) MarkOverdue(now time.Time) (int64, error) {
+	result := r.db.Model(&model.BookIssue{}).
+		Where("return_date IS NULL AND status = ? AND due_date < ?", "issued", now).
+		Update("status", "overdue")
+	return result.RowsAffected, result.Error
+}
+
+// MarkRemindersSent records when borrowers were reminded of loans, either
+// that they are due soon or that they are overdue
+func (r *LibraryRepository) MarkRemindersSent(issueIDs []uint, overdue bool, at time.Time) error {
+	column := "due_reminder_at"
+	if overdue {
+		column = "overdue_reminder_at"
+	}
+	return r.db.Model(&model.BookIssue{}).Where("id IN ?", issueIDs).Update(column, at).Error
+}
+
 func (r *LibraryRepository) GetBorrowingHistory(userID uint) ([]model.BookIssue, error) {
 	var issues []model.BookIssue
 	err := r.db.Where("user_id = ?", userID).
@@ -191,3 +804,892 @@ func (r *LibraryRepository) GetBorrowingHistory(userID uint) ([]model.BookIssue,
 		Find(&issues).Error
 	return issues, err
 }
+
+// BookFilter narrows a catalogue search. Zero values are ignored.
+type BookFilter struct {
+	Query           string // Matches title, author or ISBN
+	CategoryID      *uint
+	Author          string
+	Available       *bool // Only books with (or without) copies on the shelf
+	YearFrom        int
+	YearTo          int
+	IncludeInactive bool
+	Limit           int
+	Offset          int
+}
+
+// CategoryAvailability sums up the copies of the active books in a category
+type CategoryAvailability struct {
+	CategoryID      uint   `json:"category_id"`
+	Name            string `json:"name"`
+	Titles          int64  `json:"titles"`
+	TotalCopies     int64  `json:"total_copies"`
+	AvailableCopies int64  `json:"available_copies"`
+}
+
+// ListBooks searches the catalogue and returns a page of books along with
+// the total number of matches
+func (r *LibraryRepository) ListBooks(filter BookFilter) ([]model.Book, int64, error) {
+	query := r.db.Model(&model.Book{})
+	if filter.Query != "" {
+		pattern := "%" + strings.ToLower(filter.Query) + "%"
+		query = query.Where("LOWER(title) LIKE ? OR LOWER(author) LIKE ? OR LOWER(isbn) LIKE ?", pattern, pattern, pattern)
+	}
+	if filter.CategoryID != nil {
+		query = query.Where("category_id = ?", *filter.CategoryID)
+	}
+	if filter.Author != "" {
+		query = query.Where("LOWER(author) LIKE ?", "%"+strings.ToLower(filter.Author)+"%")
+	}
+	if filter.Available != nil {
+		if *filter.Available {
+			query = query.Where("available_copies > 0")
+		} else {
+			query = query.Where("available_copies <= 0")
+		}
+	}
+	if filter.YearFrom > 0 {
+		query = query.Where("publication_year >= ?", filter.YearFrom)
+	}
+	if filter.YearTo > 0 {
+		query = query.Where("publication_year <= ?", filter.YearTo)
+	}
+	if !filter.IncludeInactive {
+		query = query.Where("is_active = ?", true)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var books []model.Book
+	err := query.Preload("Category").Order("title, id").
+		Limit(filter.Limit).Offset(filter.Offset).Find(&books).Error
+	return books, total, err
+}
+
+// GetBookByISBN finds a live book by ISBN
+func (r *LibraryRepository) GetBookByISBN(isbn string) (*model.Book, error) {
+	var book model.Book
+	err := r.db.Where("isbn = ?", isbn).First(&book).Error
+	return &book, err
+}
+
+// CountOpenIssues counts the copies of a book that are checked out
+func (r *LibraryRepository) CountOpenIssues(bookID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&model.BookIssue{}).
+		Where("book_id = ? AND return_date IS NULL", bookID).
+		Count(&count).Error
+	return count, err
+}
+
+// CountUserOpenIssues counts the copies of a book a user has on loan
+func (r *LibraryRepository) CountUserOpenIssues(bookID, userID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&model.BookIssue{}).
+		Where("book_id = ? AND user_id = ? AND return_date IS NULL", bookID, userID).
+		Count(&count).Error
+	return count, err
+}
+
+// Availability sums up copies of active books per category
+func (r *LibraryRepository) Availability() ([]CategoryAvailability, error) {
+	var rows []CategoryAvailability
+	err := r.db.Model(&model.Book{}).
+		Select("books.category_id, book_categories.name, COUNT(*) AS titles, "+
+			"COALESCE(SUM(books.total_copies), 0) AS total_copies, COALESCE(SUM(books.available_copies), 0) AS available_copies").
+		Joins("JOIN book_categories ON book_categories.id = books.category_id").
+		Where("books.is_active = ?", true).
+		Group("books.category_id, book_categories.name").Order("book_categories.name").
+		Scan(&rows).Error
+	return rows, err
+}
+
+// Category Methods
+func (r *LibraryRepository) ListCategories(includeInactive bool) ([]model.BookCategory, error) {
+	query := r.db.Order("name")
+	if !includeInactive {
+		query = query.Where("is_active = ?", true)
+	}
+	var categories []model.BookCategory
+	err := query.Find(&categories).Error
+	return categories, err
+}
+
+func (r *LibraryRepository) GetCategoryByID(id uint) (*model.BookCategory, error) {
+	var category model.BookCategory
+	err := r.db.First(&category, id).Error
+	return &category, err
+}
+
+func (r *LibraryRepository) GetCategoryByName(name string) (*model.BookCategory, error) {
+	var category model.BookCategory
+	err := r.db.Where("LOWER(name) = ?", strings.ToLower(name)).First(&category).Error
+	return &category, err
+}
+
+func (r *LibraryRepository) CreateCategory(category *model.BookCategory) error {
+	return r.db.Create(category).Error
+}
+
+func (r *LibraryRepository) UpdateCategory(category *model.BookCategory) error {
+	return r.db.Save(category).Error
+}
+
+func (r *LibraryRepository) DeleteCategory(id uint) error {
+	return r.db.Delete(&model.BookCategory{}, id).Error
+}
+
+// CountBooksInCategory counts the live books filed under a category
+func (r *LibraryRepository) CountBooksInCategory(categoryID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&model.Book{}).Where("category_id = ?", categoryID).Count(&count).Error
+	return count, err
+}
+
+// Book Copy Methods
+
+// copyIdentifiers numbers the nth copy of a book. Accession numbers are for
+// people, barcodes for scanners; both are unique per copy.
+func copyIdentifiers(bookID uint, n int64) (accession, barcode string) {
+	return fmt.Sprintf("%06d-%03d", bookID, n), fmt.Sprintf("B%06d%03d", bookID, n)
+}
+
+// AddCopies puts new copies of a book on the shelf
+func (r *LibraryRepository) AddCopies(bookID uint, count int, rackNumber string) ([]model.BookCopy, error) {
+	var copies []model.BookCopy
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		copies, err = createCopies(tx, bookID, count, model.CopyAvailable, rackNumber)
+		if err != nil {
+			return err
+		}
+		return syncCopyCounts(tx, bookID)
+	})
+	return copies, err
+}
+
+// ListCopies returns the copies of a book in accession order
+func (r *LibraryRepository) ListCopies(bookID uint) ([]model.BookCopy, error) {
+	var copies []model.BookCopy
+	err := r.db.Where("book_id = ?", bookID).Order("accession_number").Find(&copies).Error
+	return copies, err
+}
+
+// ListCopiesByID returns copies with their books, in the order of ids
+func (r *LibraryRepository) ListCopiesByID(ids []uint) ([]model.BookCopy, error) {
+	var copies []model.BookCopy
+	if err := r.db.Preload("Book").Where("id IN ?", ids).Find(&copies).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]model.BookCopy, len(copies))
+	for _, bookCopy := range copies {
+		byID[bookCopy.ID] = bookCopy
+	}
+	ordered := make([]model.BookCopy, 0, len(copies))
+	for _, id := range ids {
+		if bookCopy, ok := byID[id]; ok {
+			ordered = append(ordered, bookCopy)
+		}
+	}
+	return ordered, nil
+}
+
+func (r *LibraryRepository) GetCopyByID(id uint) (*model.BookCopy, error) {
+	var bookCopy model.BookCopy
+	err := r.db.Preload("Book").First(&bookCopy, id).Error
+	return &bookCopy, err
+}
+
+func (r *LibraryRepository) GetCopyByBarcode(barcode string) (*model.BookCopy, error) {
+	var bookCopy model.BookCopy
+	err := r.db.Preload("Book").Where("barcode = ?", barcode).First(&bookCopy).Error
+	return &bookCopy, err
+}
+
+// FindAvailableCopy returns a copy of a book that is on the shelf
+func (r *LibraryRepository) FindAvailableCopy(bookID uint) (*model.BookCopy, error) {
+	var bookCopy model.BookCopy
+	err := r.db.Where("book_id = ? AND status = ?", bookID, model.CopyAvailable).Order("id").First(&bookCopy).Error
+	return &bookCopy, err
+}
+
+// UpdateCopy saves a copy and refreshes the copy counts of its book
+func (r *LibraryRepository) UpdateCopy(bookCopy *model.BookCopy) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Book").Save(bookCopy).Error; err != nil {
+			return err
+		}
+		return syncCopyCounts(tx, bookCopy.BookID)
+	})
+}
+
+// BackfillCopies creates copies for books catalogued before copies were
+// tracked, one per TotalCopies. Copies on loan are marked issued and linked
+// to the open loans of the book.
+func (r *LibraryRepository) BackfillCopies() error {
+	var bookIDs []uint
+	err := r.db.Model(&model.Book{}).
+		Where("NOT EXISTS (?)", r.db.Unscoped().Model(&model.BookCopy{}).Select("1").Where("book_copies.book_id = books.id")).
+		Order("id").Pluck("id", &bookIDs).Error
+	if err != nil {
+		return err
+	}
+
+	for _, bookID := range bookIDs {
+		err := r.db.Transaction(func(tx *gorm.DB) error {
+			var book model.Book
+			if err := tx.First(&book, bookID).Error; err != nil {
+				return err
+			}
+			var openIssues []model.BookIssue
+			if err := tx.Where("book_id = ? AND return_date IS NULL", bookID).Order("id").Find(&openIssues).Error; err != nil {
+				return err
+			}
+
+			total := book.TotalCopies
+			if total < len(openIssues) {
+				total = len(openIssues)
+			}
+			issued, err := createCopies(tx, bookID, len(openIssues), model.CopyIssued, book.RackNumber)
+			if err != nil {
+				return err
+			}
+			for i, issue := range openIssues {
+				if err := tx.Model(&model.BookIssue{}).Where("id = ?", issue.ID).Update("copy_id", issued[i].ID).Error; err != nil {
+					return err
+				}
+			}
+			if _, err := createCopies(tx, bookID, total-len(openIssues), model.CopyAvailable, book.RackNumber); err != nil {
+				return err
+			}
+			return syncCopyCounts(tx, bookID)
+		})
+		if err != nil {
+			return fmt.Errorf("book %d: %w", bookID, err)
+		}
+	}
+	return nil
+}
+
+// createCopies adds count copies of a book, numbered after the copies it
+// already has, including deleted ones
+func createCopies(tx *gorm.DB, bookID uint, count int, status, rackNumber string) ([]model.BookCopy, error) {
+	if count <= 0 {
+		return nil, nil
+	}
+	var existing int64
+	if err := tx.Unscoped().Model(&model.BookCopy{}).Where("book_id = ?", bookID).Count(&existing).Error; err != nil {
+		return nil, err
+	}
+
+	copies := make([]model.BookCopy, count)
+	for i := range copies {
+		accession, barcode := copyIdentifiers(bookID, existing+int64(i)+1)
+		copies[i] = model.BookCopy{
+			BookID:          bookID,
+			AccessionNumber: accession,
+			Barcode:         barcode,
+			Condition:       "good",
+			RackNumber:      rackNumber,
+			Status:          status,
+		}
+	}
+	if err := tx.Create(&copies).Error; err != nil {
+		return nil, err
+	}
+	return copies, nil
+}
+
+// withdrawCopies takes count copies that are on the shelf out of
+// circulation, or none if fewer are on the shelf
+func withdrawCopies(tx *gorm.DB, bookID uint, count int) error {
+	var ids []uint
+	if err := tx.Model(&model.BookCopy{}).
+		Where("book_id = ? AND status = ?", bookID, model.CopyAvailable).
+		Order("id DESC").Limit(count).Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) < count {
+		return ErrCopiesOnLoan
+	}
+	result := tx.Model(&model.BookCopy{}).
+		Where("id IN ? AND status = ?", ids, model.CopyAvailable).
+		Update("status", model.CopyWithdrawn)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected < int64(count) {
+		return ErrCopiesOnLoan
+	}
+	return nil
+}
+
+// syncCopyCounts recomputes the copy counts of a book from its copies. Lost,
+// damaged and withdrawn copies no longer count as held. The book is locked
+// first, so that transactions changing copies of the same book count them
+// one after the other and the last to commit sees all the changes.
+func syncCopyCounts(tx *gorm.DB, bookID uint) error {
+	var locked []uint
+	if err := tx.Model(&model.Book{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", bookID).Pluck("id", &locked).Error; err != nil {
+		return err
+	}
+
+	var counts struct {
+		Total     int
+		Available int
+	}
+	err := tx.Model(&model.BookCopy{}).
+		Select("COUNT(*) AS total, COALESCE(SUM(CASE WHEN status = ? THEN 1 ELSE 0 END), 0) AS available", model.CopyAvailable).
+		Where("book_id = ? AND status NOT IN ?", bookID, []string{model.CopyLost, model.CopyDamaged, model.CopyWithdrawn}).
+		Scan(&counts).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&model.Book{}).Where("id = ?", bookID).Updates(map[string]interface{}{
+		"total_copies":     counts.Total,
+		"available_copies": counts.Available,
+	}).Error
+}
+
+func (r *LibraryRepository) GetIssueByID(id uint) (*model.BookIssue, error) {
+	var issue model.BookIssue
+	err := r.db.Preload("Book.Category").Preload("Copy").
+		Preload("Renewals", func(db *gorm.DB) *gorm.DB { return db.Order("renewed_at, id") }).
+		First(&issue, id).Error
+	return &issue, err
+}
+
+// LoanSummary counts a user's loans and unpaid fines
+type LoanSummary struct {
+	OpenLoans    int64
+	OverdueLoans int64
+	UnpaidFines  decimal.Decimal
+}
+
+// GetLoanSummary counts the loans a user has out, how many of them are
+// overdue at now, and the fines the user has not paid
+func (r *LibraryRepository) GetLoanSummary(userID uint, now time.Time) (*LoanSummary, error) {
+	var summary LoanSummary
+	err := r.db.Model(&model.BookIssue{}).
+		Select("COALESCE(SUM(CASE WHEN return_date IS NULL THEN 1 ELSE 0 END), 0) AS open_loans, "+
+			"COALESCE(SUM(CASE WHEN return_date IS NULL AND due_date < ? THEN 1 ELSE 0 END), 0) AS overdue_loans", now).
+		Where("user_id = ?", userID).
+		Scan(&summary).Error
+	if err != nil {
+		return nil, err
+	}
+	err = r.db.Model(&model.FineLedgerEntry{}).Select("COALESCE(SUM(amount), 0)").
+		Where("user_id = ?", userID).Row().Scan(&summary.UnpaidFines)
+	return &summary, err
+}
+
+// Report Methods
+
+// ReportRange limits a report to the records from From up to, but not
+// including, To. Zero times leave that end open.
+type ReportRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// condition is the SQL form of apply for use in expressions. It does not
+// match the NULL row of an outer join that found nothing.
+func (rng ReportRange) condition(column string) (string, []interface{}) {
+	clause := column + " IS NOT NULL"
+	var args []interface{}
+	if !rng.From.IsZero() {
+		clause += " AND " + column + " >= ?"
+		args = append(args, rng.From)
+	}
+	if !rng.To.IsZero() {
+		clause += " AND " + column + " < ?"
+		args = append(args, rng.To)
+	}
+	return clause, args
+}
+
+func (rng ReportRange) apply(query *gorm.DB, column string) *gorm.DB {
+	if !rng.From.IsZero() {
+		query = query.Where(column+" >= ?", rng.From)
+	}
+	if !rng.To.IsZero() {
+		query = query.Where(column+" < ?", rng.To)
+	}
+	return query
+}
+
+// BorrowedTitle counts the loans of a book
+type BorrowedTitle struct {
+	BookID    uint   `json:"book_id"`
+	ISBN      string `json:"isbn"`
+	Title     string `json:"title"`
+	Author    string `json:"author"`
+	Category  string `json:"category"`
+	Loans     int64  `json:"loans"`
+	Borrowers int64  `json:"borrowers"`
+}
+
+// MostBorrowed returns the books lent most often in rng, with the number of
+// distinct borrowers. A categoryID of 0 covers every category.
+func (r *LibraryRepository) MostBorrowed(rng ReportRange, categoryID uint, limit int) ([]BorrowedTitle, error) {
+	query := r.db.Model(&model.BookIssue{}).
+		Select("books.id AS book_id, books.isbn, books.title, books.author, book_categories.name AS category, " +
+			"COUNT(*) AS loans, COUNT(DISTINCT book_issues.user_id) AS borrowers").
+		Joins("JOIN books ON books.id = book_issues.book_id").
+		Joins("LEFT JOIN book_categories ON book_categories.id = books.category_id")
+	query = rng.apply(query, "book_issues.issue_date")
+	if categoryID != 0 {
+		query = query.Where("books.category_id = ?", categoryID)
+	}
+
+	rows := []BorrowedTitle{}
+	err := query.Group("books.id, books.isbn, books.title, books.author, book_categories.name").
+		Order("loans DESC, books.title").Limit(limit).
+		Scan(&rows).Error
+	return rows, err
+}
+
+// LoanEvent is when a loan of a book in a category was issued or returned
+type LoanEvent struct {
+	At         time.Time
+	CategoryID uint
+	Category   string
+}
+
+// ListLoanEvents returns when the loans in rng were issued, or with
+// returned set, when the loans returned in rng came back
+func (r *LibraryRepository) ListLoanEvents(rng ReportRange, returned bool) ([]LoanEvent, error) {
+	column := "book_issues.issue_date"
+	if returned {
+		column = "book_issues.return_date"
+	}
+	query := r.db.Model(&model.BookIssue{}).
+		Select(column + " AS at, books.category_id, book_categories.name AS category").
+		Joins("JOIN books ON books.id = book_issues.book_id").
+		Joins("LEFT JOIN book_categories ON book_categories.id = books.category_id").
+		Where(column + " IS NOT NULL")
+
+	var events []LoanEvent
+	err := rng.apply(query, column).Order(column).Scan(&events).Error
+	return events, err
+}
+
+// CategoryOverdue counts the loans of a category that ran late
+type CategoryOverdue struct {
+	CategoryID   uint    `json:"category_id"`
+	Category     string  `json:"category"`
+	Loans        int64   `json:"loans"`
+	Returned     int64   `json:"returned"`
+	ReturnedLate int64   `json:"returned_late"`
+	Overdue      int64   `json:"overdue"`       // Still out and past due at the time of the report
+	Rate         float64 `gorm:"-" json:"rate"` // Share of the loans returned late or overdue
+}
+
+// OverdueByCategory counts, per category, the loans issued in rng, how
+// many came back late and how many are out past their due date at now
+func (r *LibraryRepository) OverdueByCategory(rng ReportRange, now time.Time) ([]CategoryOverdue, error) {
+	query := r.db.Model(&model.BookIssue{}).
+		Select("books.category_id, book_categories.name AS category, COUNT(*) AS loans, "+
+			"COALESCE(SUM(CASE WHEN book_issues.return_date IS NOT NULL THEN 1 ELSE 0 END), 0) AS returned, "+
+			"COALESCE(SUM(CASE WHEN book_issues.return_date > book_issues.due_date THEN 1 ELSE 0 END), 0) AS returned_late, "+
+			"COALESCE(SUM(CASE WHEN book_issues.return_date IS NULL AND book_issues.due_date < ? THEN 1 ELSE 0 END), 0) AS overdue", now).
+		Joins("JOIN books ON books.id = book_issues.book_id").
+		Joins("LEFT JOIN book_categories ON book_categories.id = books.category_id")
+
+	rows := []CategoryOverdue{}
+	err := rng.apply(query, "book_issues.issue_date").
+		Group("books.category_id, book_categories.name").Order("book_categories.name").
+		Scan(&rows).Error
+	return rows, err
+}
+
+// PaymentModeTotal sums up the fine payments taken in one payment mode
+type PaymentModeTotal struct {
+	PaymentMode string          `json:"payment_mode"`
+	Payments    int64           `json:"payments"`
+	Amount      decimal.Decimal `json:"amount"`
+	Refunded    decimal.Decimal `json:"refunded"`
+	Net         decimal.Decimal `gorm:"-" json:"net"`
+}
+
+// FinesByPaymentMode sums up the fine payments taken in rng per payment
+// mode, with what has been refunded of them since
+func (r *LibraryRepository) FinesByPaymentMode(rng ReportRange) ([]PaymentModeTotal, error) {
+	query := r.db.Model(&model.FinePayment{}).
+		Select("payment_mode, COUNT(*) AS payments, COALESCE(SUM(amount), 0) AS amount, COALESCE(SUM(refunded), 0) AS refunded")
+
+	rows := []PaymentModeTotal{}
+	err := rng.apply(query, "payment_date").Group("payment_mode").Order("payment_mode").Scan(&rows).Error
+	return rows, err
+}
+
+// InactiveCardholder is a holder of an active card who borrowed nothing in
+// the period of a report
+type InactiveCardholder struct {
+	UserID     uint       `json:"user_id"`
+	FirstName  string     `json:"first_name"`
+	LastName   string     `json:"last_name"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	CardNumber string     `json:"card_number"`
+	ExpiryDate time.Time  `json:"expiry_date"`
+	LastLoan   *time.Time `gorm:"-" json:"last_loan"` // Nil when the holder never borrowed
+}
+
+// inactiveCardholderRow is an InactiveCardholder as it is read
+type inactiveCardholderRow struct {
+	InactiveCardholder
+	LastLoanAt aggregateTime
+}
+
+// aggregateTime scans a time computed by an aggregate such as MAX, which
+// PostgreSQL returns as a time and SQLite as text. It is zero for NULL.
+type aggregateTime struct {
+	time.Time
+}
+
+func (t *aggregateTime) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		t.Time = time.Time{}
+	case time.Time:
+		t.Time = v
+	case string:
+		parsed, err := time.Parse("2006-01-02 15:04:05.999999999-07:00", v)
+		if err != nil {
+			return err
+		}
+		t.Time = parsed
+	default:
+		return fmt.Errorf("cannot scan %T into a time", value)
+	}
+	return nil
+}
+
+func (t aggregateTime) Value() (driver.Value, error) {
+	return t.Time, nil
+}
+
+// InactiveCardholders returns the holders of active cards who borrowed
+// nothing in rng, by name
+func (r *LibraryRepository) InactiveCardholders(rng ReportRange) ([]InactiveCardholder, error) {
+	borrowed, args := rng.condition("book_issues.issue_date")
+
+	var found []inactiveCardholderRow
+	err := r.db.Model(&model.LibraryCard{}).
+		Select("library_cards.user_id, users.first_name, users.last_name, users.email, users.role, "+
+			"library_cards.card_number, library_cards.expiry_date, MAX(book_issues.issue_date) AS last_loan_at").
+		Joins("JOIN users ON users.id = library_cards.user_id AND users.deleted_at IS NULL").
+		Joins("LEFT JOIN book_issues ON book_issues.user_id = library_cards.user_id AND book_issues.deleted_at IS NULL").
+		Where("library_cards.status = ?", "active").
+		Group("library_cards.user_id, users.first_name, users.last_name, users.email, users.role, "+
+			"library_cards.card_number, library_cards.expiry_date").
+		Having("COUNT(CASE WHEN "+borrowed+" THEN 1 END) = 0", args...).
+		Order("users.last_name, users.first_name, library_cards.user_id").
+		Scan(&found).Error
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]InactiveCardholder, len(found))
+	for i, row := range found {
+		rows[i] = row.InactiveCardholder
+		if !row.LastLoanAt.IsZero() {
+			lastLoan := row.LastLoanAt.Time
+			rows[i].LastLoan = &lastLoan
+		}
+	}
+	return rows, nil
+}
+
+// UnborrowedBook is an active book nobody borrowed in the period of a report
+type UnborrowedBook struct {
+	BookID      uint      `json:"book_id"`
+	ISBN        string    `json:"isbn"`
+	Title       string    `json:"title"`
+	Author      string    `json:"author"`
+	Category    string    `json:"category"`
+	TotalCopies int       `json:"total_copies"`
+	AddedAt     time.Time `json:"added_at"`
+}
+
+// UnborrowedBooks returns the active books that were not lent in rng, or
+// never with an open range, by title. A categoryID of 0 covers every
+// category.
+func (r *LibraryRepository) UnborrowedBooks(rng ReportRange, categoryID uint) ([]UnborrowedBook, error) {
+	borrowed := rng.apply(r.db.Model(&model.BookIssue{}).Select("1").
+		Where("book_issues.book_id = books.id"), "book_issues.issue_date")
+
+	query := r.db.Model(&model.Book{}).
+		Select("books.id AS book_id, books.isbn, books.title, books.author, book_categories.name AS category, "+
+			"books.total_copies, books.created_at AS added_at").
+		Joins("LEFT JOIN book_categories ON book_categories.id = books.category_id").
+		Where("books.is_active = ?", true).
+		Where("NOT EXISTS (?)", borrowed)
+	if categoryID != 0 {
+		query = query.Where("books.category_id = ?", categoryID)
+	}
+
+	rows := []UnborrowedBook{}
+	err := query.Order("books.title, books.id").Scan(&rows).Error
+	return rows, err
+}
+
+// Holiday Methods
+
+// ListHolidays returns the holidays that overlap from..to, in date order.
+// Zero times leave that end open.
+func (r *LibraryRepository) ListHolidays(from, to time.Time) ([]model.LibraryHoliday, error) {
+	query := r.db.Order("start_date, id")
+	if !from.IsZero() {
+		query = query.Where("end_date >= ?", from)
+	}
+	if !to.IsZero() {
+		query = query.Where("start_date <= ?", to)
+	}
+	var holidays []model.LibraryHoliday
+	err := query.Find(&holidays).Error
+	return holidays, err
+}
+
+func (r *LibraryRepository) CreateHoliday(holiday *model.LibraryHoliday) error {
+	return r.db.Create(holiday).Error
+}
+
+func (r *LibraryRepository) GetHolidayByID(id uint) (*model.LibraryHoliday, error) {
+	var holiday model.LibraryHoliday
+	err := r.db.First(&holiday, id).Error
+	return &holiday, err
+}
+
+func (r *LibraryRepository) DeleteHoliday(id uint) error {
+	return r.db.Delete(&model.LibraryHoliday{}, id).Error
+}
+
+// Reservation Methods
+
+func (r *LibraryRepository) CreateReservation(reservation *model.BookReservation) error {
+	return r.db.Create(reservation).Error
+}
+
+func (r *LibraryRepository) GetReservationByID(id uint) (*model.BookReservation, error) {
+	var reservation model.BookReservation
+	err := r.db.Preload("Book").Preload("Copy").First(&reservation, id).Error
+	return &reservation, err
+}
+
+// FindActiveReservation finds a reader's reservation of a book that is still
+// waiting or ready for pickup
+func (r *LibraryRepository) FindActiveReservation(bookID, userID uint) (*model.BookReservation, error) {
+	var reservation model.BookReservation
+	err := r.db.Preload("Copy").
+		Where("book_id = ? AND user_id = ? AND status IN ?", bookID, userID, activeReservations).
+		First(&reservation).Error
+	return &reservation, err
+}
+
+// ListBookReservations returns the queue for a book, first in line first
+func (r *LibraryRepository) ListBookReservations(bookID uint) ([]model.BookReservation, error) {
+	var reservations []model.BookReservation
+	err := r.db.Preload("Copy").
+		Where("book_id = ? AND status IN ?", bookID, activeReservations).
+		Order("id").Find(&reservations).Error
+	return reservations, err
+}
+
+// ListUserReservations returns a reader's reservations, newest first
+func (r *LibraryRepository) ListUserReservations(userID uint) ([]model.BookReservation, error) {
+	var reservations []model.BookReservation
+	err := r.db.Preload("Book").Preload("Copy").
+		Where("user_id = ?", userID).
+		Order("id DESC").Find(&reservations).Error
+	return reservations, err
+}
+
+// QueuePosition returns the place of a waiting reservation in the queue for
+// its book, starting at 1
+func (r *LibraryRepository) QueuePosition(reservation *model.BookReservation) (int64, error) {
+	var ahead int64
+	err := r.db.Model(&model.BookReservation{}).
+		Where("book_id = ? AND status = ? AND id < ?", reservation.BookID, model.ReservationWaiting, reservation.ID).
+		Count(&ahead).Error
+	return ahead + 1, err
+}
+
+// MarkReservationsNotified records when readers were told about their holds
+func (r *LibraryRepository) MarkReservationsNotified(ids []uint, at time.Time) error {
+	return r.db.Model(&model.BookReservation{}).Where("id IN ?", ids).Update("notified_at", at).Error
+}
+
+// CancelReservation takes a reservation out of the queue. A copy held for it
+// passes to the next reader, whose reservation is returned.
+func (r *LibraryRepository) CancelReservation(id uint, now time.Time, pickupWindow time.Duration) ([]model.BookReservation, error) {
+	var ready []model.BookReservation
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		ready, err = closeReservation(tx, id, model.ReservationCancelled, now, pickupWindow)
+		return err
+	})
+	return ready, err
+}
+
+// ExpireHolds closes the holds whose pickup window ended before now and
+// passes their copies on. It returns the expired reservations and those
+// that became ready.
+func (r *LibraryRepository) ExpireHolds(now time.Time, pickupWindow time.Duration) (expired, ready []model.BookReservation, err error) {
+	var due []model.BookReservation
+	if err := r.db.Where("status = ? AND expires_at < ?", model.ReservationReady, now).
+		Order("id").Find(&due).Error; err != nil {
+		return nil, nil, err
+	}
+
+	for _, reservation := range due {
+		var passedOn []model.BookReservation
+		err := r.db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			passedOn, err = closeReservation(tx, reservation.ID, model.ReservationExpired, now, pickupWindow)
+			return err
+		})
+		if errors.Is(err, ErrReservationClosed) {
+			// Picked up or cancelled in the meantime
+			continue
+		}
+		if err != nil {
+			return expired, ready, fmt.Errorf("reservation %d: %w", reservation.ID, err)
+		}
+		expired = append(expired, reservation)
+		ready = append(ready, passedOn...)
+	}
+	return expired, ready, nil
+}
+
+// FillHolds holds copies on the shelf for the readers waiting for a book
+// and returns the reservations that became ready
+func (r *LibraryRepository) FillHolds(bookID uint, now time.Time, pickupWindow time.Duration) ([]model.BookReservation, error) {
+	var ready []model.BookReservation
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if ready, err = fillHolds(tx, bookID, now, pickupWindow); err != nil {
+			return err
+		}
+		return syncCopyCounts(tx, bookID)
+	})
+	return ready, err
+}
+
+// closeReservation ends an active reservation with status. A copy held for
+// it goes to the next reader in the queue.
+func closeReservation(tx *gorm.DB, id uint, status string, now time.Time, pickupWindow time.Duration) ([]model.BookReservation, error) {
+	var reservation model.BookReservation
+	if err := tx.First(&reservation, id).Error; err != nil {
+		return nil, err
+	}
+	result := tx.Model(&model.BookReservation{}).
+		Where("id = ? AND status IN ?", id, activeReservations).
+		Updates(map[string]interface{}{"status": status, "closed_at": now})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrReservationClosed
+	}
+	if reservation.Status != model.ReservationReady || reservation.CopyID == nil {
+		return nil, nil
+	}
+
+	if err := tx.Model(&model.BookCopy{}).
+		Where("id = ? AND status = ?", *reservation.CopyID, model.CopyOnHold).
+		Update("status", model.CopyAvailable).Error; err != nil {
+		return nil, err
+	}
+	ready, err := fillHolds(tx, reservation.BookID, now, pickupWindow)
+	if err != nil {
+		return nil, err
+	}
+	return ready, syncCopyCounts(tx, reservation.BookID)
+}
+
+// fillHolds pairs the copies of a book on the shelf with the readers waiting
+// for it, in the order they reserved, and returns the reservations that
+// became ready. The caller syncs the copy counts.
+func fillHolds(tx *gorm.DB, bookID uint, now time.Time, pickupWindow time.Duration) ([]model.BookReservation, error) {
+	var waiting []model.BookReservation
+	if err := tx.Where("book_id = ? AND status = ?", bookID, model.ReservationWaiting).
+		Order("id").Find(&waiting).Error; err != nil {
+		return nil, err
+	}
+	if len(waiting) == 0 {
+		return nil, nil
+	}
+
+	var copyIDs []uint
+	if err := tx.Model(&model.BookCopy{}).
+		Where("book_id = ? AND status = ?", bookID, model.CopyAvailable).
+		Order("id").Pluck("id", &copyIDs).Error; err != nil {
+		return nil, err
+	}
+
+	var ready []model.BookReservation
+	for _, reservation := range waiting {
+		copyID, err := claimCopy(tx, &copyIDs, model.CopyOnHold)
+		if err != nil {
+			return nil, err
+		}
+		if copyID == 0 {
+			break
+		}
+
+		expiresAt := now.Add(pickupWindow)
+		result := tx.Model(&model.BookReservation{}).
+			Where("id = ? AND status = ?", reservation.ID, model.ReservationWaiting).
+			Updates(map[string]interface{}{
+				"status":     model.ReservationReady,
+				"copy_id":    copyID,
+				"ready_at":   now,
+				"expires_at": expiresAt,
+			})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 0 {
+			// Cancelled in the meantime; the copy goes to the next reader
+			if err := tx.Model(&model.BookCopy{}).Where("id = ?", copyID).
+				Update("status", model.CopyAvailable).Error; err != nil {
+				return nil, err
+			}
+			copyIDs = append([]uint{copyID}, copyIDs...)
+			continue
+		}
+
+		reservation.Status = model.ReservationReady
+		reservation.CopyID = &copyID
+		reservation.ReadyAt = &now
+		reservation.ExpiresAt = &expiresAt
+		ready = append(ready, reservation)
+	}
+	return ready, nil
+}
+
+// claimCopy moves the first copy in ids that is still on the shelf to
+// status, dropping the copies it tried from ids. It returns 0 when none was
+// left on the shelf.
+func claimCopy(tx *gorm.DB, ids *[]uint, status string) (uint, error) {
+	for len(*ids) > 0 {
+		id := (*ids)[0]
+		*ids = (*ids)[1:]
+		result := tx.Model(&model.BookCopy{}).
+			Where("id = ? AND status = ?", id, model.CopyAvailable).
+			Update("status", status)
+		if result.Error != nil {
+			return 0, result.Error
+		}
+		if result.RowsAffected > 0 {
+			return id, nil
+		}
+	}
+	return 0, nil
+}
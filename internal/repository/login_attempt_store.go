@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LoginAttemptStore keeps failed login counters. RecordFailure must be
+// atomic so that concurrent attempts cannot lose increments.
+type LoginAttemptStore interface {
+	// Get returns the attempt record for key, or nil if there is none
+	Get(key string) (*models.LoginAttempt, error)
+	// RecordFailure increments the counter for key. Counters whose last
+	// failure happened before resetBefore start again from one.
+	RecordFailure(key string, now, resetBefore time.Time) (*models.LoginAttempt, error)
+	// Lock blocks key until the given time
+	Lock(key string, until time.Time) error
+	// Reset clears the counter and any lock for key
+	Reset(key string) error
+}
+
+// MemoryLoginAttemptStore keeps login attempts in process memory.
+// It is only suitable when a single instance of the server runs.
+type MemoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]*models.LoginAttempt
+}
+
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{attempts: make(map[string]*models.LoginAttempt)}
+}
+
+func (s *MemoryLoginAttemptStore) Get(key string) (*models.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok {
+		return nil, nil
+	}
+	copied := *attempt
+	return &copied, nil
+}
+
+func (s *MemoryLoginAttemptStore) RecordFailure(key string, now, resetBefore time.Time) (*models.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune(resetBefore, now)
+
+	attempt, ok := s.attempts[key]
+	if !ok || attempt.LastFailureAt.Before(resetBefore) {
+		attempt = &models.LoginAttempt{Key: key}
+		s.attempts[key] = attempt
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = now
+	attempt.UpdatedAt = now
+
+	copied := *attempt
+	return &copied, nil
+}
+
+func (s *MemoryLoginAttemptStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok {
+		attempt = &models.LoginAttempt{Key: key, LastFailureAt: time.Now()}
+		s.attempts[key] = attempt
+	}
+	attempt.LockedUntil = &until
+	attempt.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *MemoryLoginAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+// prune drops stale, unlocked entries so the map cannot grow without bound.
+// Callers must hold the mutex.
+func (s *MemoryLoginAttemptStore) prune(resetBefore, now time.Time) {
+	if len(s.attempts) < 10000 {
+		return
+	}
+	for key, attempt := range s.attempts {
+		locked := attempt.LockedUntil != nil && attempt.LockedUntil.After(now)
+		if !locked && attempt.LastFailureAt.Before(resetBefore) {
+			delete(s.attempts, key)
+		}
+	}
+}
+
+// LoginAttemptRepository stores login attempts in the database so that all
+// replicas share the same counters
+type LoginAttemptRepository struct {
+	db *gorm.DB
+}
+
+func NewLoginAttemptRepository(db *gorm.DB) *LoginAttemptRepository {
+	return &LoginAttemptRepository{db: db}
+}
+
+func (r *LoginAttemptRepository) Get(key string) (*models.LoginAttempt, error) {
+	var attempt models.LoginAttempt
+	err := r.db.Where("key = ?", key).First(&attempt).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+func (r *LoginAttemptRepository) RecordFailure(key string, now, resetBefore time.Time) (*models.LoginAttempt, error) {
+	attempt := models.LoginAttempt{
+		Key:           key,
+		Failures:      1,
+		LastFailureAt: now,
+		UpdatedAt:     now,
+	}
+
+	// Single upsert statement so concurrent failures are counted correctly
+	err := r.db.Clauses(
+		clause.OnConflict{
+			Columns: []clause.Column{{Name: "key"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"failures": gorm.Expr(
+					"CASE WHEN login_attempts.last_failure_at < ? THEN 1 ELSE login_attempts.failures + 1 END",
+					resetBefore),
+				"last_failure_at": now,
+				"updated_at":      now,
+			}),
+		},
+		clause.Returning{},
+	).Create(&attempt).Error
+	if err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+func (r *LoginAttemptRepository) Lock(key string, until time.Time) error {
+	now := time.Now()
+	attempt := models.LoginAttempt{
+		Key:           key,
+		LastFailureAt: now,
+		LockedUntil:   &until,
+		UpdatedAt:     now,
+	}
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"locked_until", "updated_at"}),
+	}).Create(&attempt).Error
+}
+
+func (r *LoginAttemptRepository) Reset(key string) error {
+	return r.db.Where("key = ?", key).Delete(&models.LoginAttempt{}).Error
+}
@@ -0,0 +1,276 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/E-Timileyin/school-management-system/internal/audit"
+	"github.com/E-Timileyin/school-management-system/internal/model"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+)
+
+// PersonalData is everything stored about one user, including records that
+// are in the trash
+type PersonalData struct {
+	User           models.User
+	Student        *models.Student
+	Teacher        *models.Teacher
+	Enrollments    []models.Enrollment
+	RoleChanges    []models.RoleChange
+	Sessions       []models.Session
+	SecurityEvents []models.SecurityEvent
+	LibraryCard    *model.LibraryCard
+	BookIssues     []model.BookIssue
+	Reservations   []model.BookReservation
+	FinePayments   []model.FinePayment
+	FineLedger     []model.FineLedgerEntry
+	// CommunicationReads are the read receipts of notices the user opened
+	CommunicationReads []model.CommunicationRead
+	// Communications are the notices, news and events the user wrote
+	Communications []model.Communication
+}
+
+// ErasedValue replaces personal values in audit log entries
+const ErasedValue = "[erased]"
+
+// personalColumns are scrubbed from audit log entries of erased users
+var personalColumns = []string{"email", "first_name", "last_name", "external_id", "date_of_birth", "address", "phone"}
+
+// PersonalDataRepository gathers and erases the personal data of a user
+type PersonalDataRepository struct {
+	db *gorm.DB
+}
+
+func NewPersonalDataRepository(db *gorm.DB) *PersonalDataRepository {
+	return &PersonalDataRepository{db: db}
+}
+
+// WithContext returns a copy of the repository bound to ctx
+func (r *PersonalDataRepository) WithContext(ctx context.Context) *PersonalDataRepository {
+	return &PersonalDataRepository{db: r.db.WithContext(ctx)}
+}
+
+// FindUser finds a user whether or not they are in the trash
+func (r *PersonalDataRepository) FindUser(id uint) (*models.User, error) {
+	var user models.User
+	err := r.db.Unscoped().First(&user, id).Error
+	return &user, err
+}
+
+// Collect loads every record linked to a user
+func (r *PersonalDataRepository) Collect(userID uint) (*PersonalData, error) {
+	db := r.db.Unscoped().Session(&gorm.Session{})
+	data := &PersonalData{}
+	if err := db.First(&data.User, userID).Error; err != nil {
+		return nil, err
+	}
+
+	var students []models.Student
+	if err := db.Where("user_id = ?", userID).Order("id DESC").Limit(1).Find(&students).Error; err != nil {
+		return nil, err
+	}
+	if len(students) > 0 {
+		data.Student = &students[0]
+		if err := db.Where("student_id = ?", data.Student.ID).Order("id").Find(&data.Enrollments).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var teachers []models.Teacher
+	if err := db.Where("user_id = ?", userID).Order("id DESC").Limit(1).Find(&teachers).Error; err != nil {
+		return nil, err
+	}
+	if len(teachers) > 0 {
+		data.Teacher = &teachers[0]
+	}
+
+	if err := db.Where("user_id = ?", userID).Order("id").Find(&data.RoleChanges).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Where("user_id = ?", userID).Order("id").Find(&data.Sessions).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Where("user_id = ? OR email = ?", userID, data.User.Email).Order("id").Find(&data.SecurityEvents).Error; err != nil {
+		return nil, err
+	}
+
+	var cards []model.LibraryCard
+	if err := db.Preload("Events").Where("user_id = ?", userID).Order("id DESC").Limit(1).Find(&cards).Error; err != nil {
+		return nil, err
+	}
+	if len(cards) > 0 {
+		data.LibraryCard = &cards[0]
+	}
+	if err := db.Preload("Book").Preload("Renewals").Where("user_id = ?", userID).Order("issue_date").Find(&data.BookIssues).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Preload("Book").Where("user_id = ?", userID).Order("id").Find(&data.Reservations).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Where("issue_id IN (?)", db.Model(&model.BookIssue{}).Select("id").Where("user_id = ?", userID)).
+		Order("payment_date").Find(&data.FinePayments).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Where("user_id = ?", userID).Order("occurred_at, id").Find(&data.FineLedger).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Where("user_id = ?", userID).Order("read_at, id").Find(&data.CommunicationReads).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Preload("Attachments").Where("author_id = ?", userID).Order("id").Find(&data.Communications).Error; err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Erase anonymizes a user's personal data and deletes the records that
+// only exist for them, such as sessions. Enrollments, grades, library
+// circulation and fine payments are kept for the school's aggregate
+// records, linked to the anonymized account. The changes are not audited
+// row by row, since the audit log would keep a copy of what is erased;
+// instead a single erase entry is recorded.
+func (r *PersonalDataRepository) Erase(user *models.User, anonymizedEmail string, now time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		quiet := audit.Skip(tx).Unscoped().Session(&gorm.Session{})
+
+		if err := quiet.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"email":                 anonymizedEmail,
+			"first_name":            "Erased",
+			"last_name":             "User",
+			"password":              "",
+			"email_verified":        false,
+			"email_verified_at":     nil,
+			"two_factor_enabled":    false,
+			"two_factor_secret":     "",
+			"two_factor_enabled_at": nil,
+			"external_id":           nil,
+			"erased_at":             now,
+			"deleted_at":            gorm.Expr("COALESCE(deleted_at, ?)", now),
+		}).Error; err != nil {
+			return err
+		}
+
+		var studentIDs, teacherIDs []uint
+		if err := quiet.Model(&models.Student{}).Where("user_id = ?", user.ID).Pluck("id", &studentIDs).Error; err != nil {
+			return err
+		}
+		if err := quiet.Model(&models.Teacher{}).Where("user_id = ?", user.ID).Pluck("id", &teacherIDs).Error; err != nil {
+			return err
+		}
+		if err := quiet.Model(&models.Student{}).Where("user_id = ?", user.ID).Updates(map[string]interface{}{
+			"date_of_birth": nil,
+			"address":       "",
+			"phone":         "",
+		}).Error; err != nil {
+			return err
+		}
+		if err := quiet.Model(&models.Teacher{}).Where("user_id = ?", user.ID).Update("phone", "").Error; err != nil {
+			return err
+		}
+
+		for _, owned := range []interface{}{&models.Session{}, &models.UserToken{}, &models.RecoveryCode{}} {
+			if err := quiet.Where("user_id = ?", user.ID).Delete(owned).Error; err != nil {
+				return err
+			}
+		}
+		if err := quiet.Model(&models.SecurityEvent{}).
+			Where("user_id = ? OR email = ?", user.ID, user.Email).
+			Updates(map[string]interface{}{"email": anonymizedEmail, "ip": "", "details": ""}).Error; err != nil {
+			return err
+		}
+
+		// Reasons staff gave for blocking or replacing the card may describe
+		// the user
+		if err := quiet.Model(&model.LibraryCard{}).Where("user_id = ?", user.ID).Update("blocked_reason", "").Error; err != nil {
+			return err
+		}
+		if err := quiet.Model(&model.LibraryCardEvent{}).
+			Where("card_id IN (?)", quiet.Model(&model.LibraryCard{}).Select("id").Where("user_id = ?", user.ID)).
+			Update("reason", "").Error; err != nil {
+			return err
+		}
+
+		// Nobody will collect the user's reservations. Waiting ones leave the
+		// queue; copies on hold pass on at the next hold expiry run.
+		if err := quiet.Model(&model.BookReservation{}).
+			Where("user_id = ? AND status = ?", user.ID, model.ReservationWaiting).
+			Updates(map[string]interface{}{"status": model.ReservationCancelled, "closed_at": now}).Error; err != nil {
+			return err
+		}
+		if err := quiet.Model(&model.BookReservation{}).
+			Where("user_id = ? AND status = ?", user.ID, model.ReservationReady).
+			Update("expires_at", now).Error; err != nil {
+			return err
+		}
+
+		if err := scrubAuditLog(quiet, user.ID, studentIDs, teacherIDs); err != nil {
+			return err
+		}
+
+		return audit.Log(tx, models.AuditActionErase, "users", fmt.Sprint(user.ID), nil,
+			map[string]interface{}{"erased_at": now})
+	})
+}
+
+// scrubAuditLog removes personal values from the audit history of a user's
+// records and the client details of changes the user made
+func scrubAuditLog(tx *gorm.DB, userID uint, studentIDs, teacherIDs []uint) error {
+	entities := map[string][]uint{"users": {userID}, "students": studentIDs, "teachers": teacherIDs}
+	for entityType, ids := range entities {
+		if len(ids) == 0 {
+			continue
+		}
+		entityIDs := make([]string, len(ids))
+		for i, id := range ids {
+			entityIDs[i] = fmt.Sprint(id)
+		}
+
+		var entries []models.AuditLog
+		if err := tx.Where("entity_type = ? AND entity_id IN ?", entityType, entityIDs).Find(&entries).Error; err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			before, err := scrubColumns(entry.Before)
+			if err != nil {
+				return err
+			}
+			after, err := scrubColumns(entry.After)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&models.AuditLog{}).Where("id = ?", entry.ID).
+				Updates(map[string]interface{}{"before": before, "after": after}).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Model(&models.AuditLog{}).Where("actor_id = ?", userID).
+		Updates(map[string]interface{}{"ip": "", "user_agent": ""}).Error
+}
+
+// scrubColumns replaces the values of personal columns in an audit diff
+func scrubColumns(data json.RawMessage) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	for _, column := range personalColumns {
+		if _, ok := values[column]; ok {
+			values[column] = ErasedValue
+		}
+	}
+	scrubbed, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(scrubbed), nil
+}
@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"gorm.io/gorm"
+)
+
+type RoleChangeRepository struct {
+	db *gorm.DB
+}
+
+func NewRoleChangeRepository(db *gorm.DB) *RoleChangeRepository {
+	return &RoleChangeRepository{db: db}
+}
+
+// WithContext returns a copy of the repository bound to ctx
+func (r *RoleChangeRepository) WithContext(ctx context.Context) *RoleChangeRepository {
+	return &RoleChangeRepository{db: r.db.WithContext(ctx)}
+}
+
+func (r *RoleChangeRepository) ListByUser(userID uint) ([]models.RoleChange, error) {
+	var changes []models.RoleChange
+	err := r.db.Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&changes).Error
+	return changes, err
+}
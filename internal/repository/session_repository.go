@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/E-Timileyin/school-management-system/internal/audit"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"gorm.io/gorm"
+)
+
+type SessionRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+// WithContext returns a copy of the repository bound to ctx
+func (r *SessionRepository) WithContext(ctx context.Context) *SessionRepository {
+	return &SessionRepository{db: r.db.WithContext(ctx)}
+}
+
+func (r *SessionRepository) Create(session *models.Session) error {
+	return r.db.Create(session).Error
+}
+
+// FindActiveByTokenID returns an unrevoked, unexpired session for a token
+func (r *SessionRepository) FindActiveByTokenID(tokenID string) (*models.Session, error) {
+	var session models.Session
+	err := r.db.Where("token_id = ? AND revoked_at IS NULL AND expires_at > ?", tokenID, time.Now()).
+		First(&session).Error
+	return &session, err
+}
+
+// Touch records activity on a session. This is too frequent to audit.
+func (r *SessionRepository) Touch(id uint, ip string, at time.Time) error {
+	return audit.Skip(r.db).Model(&models.Session{}).
+		Where("id = ?", id).
+		UpdateColumns(map[string]interface{}{"last_seen_at": at, "ip": ip}).Error
+}
+
+// ListActiveByUser returns the user's active sessions, most recently used first
+func (r *SessionRepository) ListActiveByUser(userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// Revoke revokes one session of a user. It reports false if no active
+// session with that ID belongs to the user.
+func (r *SessionRepository) Revoke(userID, sessionID uint) (bool, error) {
+	result := r.db.Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Update("revoked_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// RevokeAllForUser revokes every active session of a user except the one
+// with exceptTokenID, and returns how many were revoked
+func (r *SessionRepository) RevokeAllForUser(userID uint, exceptTokenID string) (int64, error) {
+	query := r.db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID)
+	if exceptTokenID != "" {
+		query = query.Where("token_id <> ?", exceptTokenID)
+	}
+	result := query.Update("revoked_at", time.Now())
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"gorm.io/gorm"
+)
+
+type SigningKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewSigningKeyRepository(db *gorm.DB) *SigningKeyRepository {
+	return &SigningKeyRepository{db: db}
+}
+
+// WithContext returns a copy of the repository bound to ctx
+func (r *SigningKeyRepository) WithContext(ctx context.Context) *SigningKeyRepository {
+	return &SigningKeyRepository{db: r.db.WithContext(ctx)}
+}
+
+// ListUnexpired returns the keys that may still verify tokens, newest first
+func (r *SigningKeyRepository) ListUnexpired(now time.Time) ([]models.SigningKey, error) {
+	var keys []models.SigningKey
+	err := r.db.Where("expires_at IS NULL OR expires_at > ?", now).
+		Order("activates_at DESC").
+		Find(&keys).Error
+	return keys, err
+}
+
+// Rotate adds a key if due reports that one is needed, and schedules the
+// expiry of the keys it supersedes. On PostgreSQL an advisory lock keeps
+// replicas from rotating at the same time.
+func (r *SigningKeyRepository) Rotate(due func(latest *models.SigningKey) bool, key *models.SigningKey, supersededExpiry time.Time) (bool, error) {
+	rotated := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if tx.Dialector.Name() == "postgres" {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('signing_keys'))").Error; err != nil {
+				return err
+			}
+		}
+
+		var latest models.SigningKey
+		err := tx.Order("activates_at DESC").First(&latest).Error
+		switch {
+		case err == gorm.ErrRecordNotFound:
+			if !due(nil) {
+				return nil
+			}
+		case err != nil:
+			return err
+		case !due(&latest):
+			return nil
+		}
+
+		// Pre-published keys that have not signed anything yet are replaced
+		if err := tx.Unscoped().Where("activates_at > ?", key.ActivatesAt).Delete(&models.SigningKey{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.SigningKey{}).
+			Where("expires_at IS NULL").
+			Update("expires_at", supersededExpiry).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(key).Error; err != nil {
+			return err
+		}
+		rotated = true
+		return nil
+	})
+	return rotated, err
+}
+
+// DeleteExpired removes keys that can no longer verify any token
+func (r *SigningKeyRepository) DeleteExpired(now time.Time) error {
+	return r.db.Unscoped().Where("expires_at <= ?", now).Delete(&models.SigningKey{}).Error
+}
@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TrashLink connects a soft-deletable model to a related table through a
+// foreign key column
+type TrashLink struct {
+	Model      interface{}
+	ForeignKey string
+}
+
+// TrashRepository works on soft-deleted rows of any model. The models and
+// column names passed in come from the TrashService registry, never from
+// user input.
+type TrashRepository struct {
+	db *gorm.DB
+}
+
+func NewTrashRepository(db *gorm.DB) *TrashRepository {
+	return &TrashRepository{db: db}
+}
+
+// WithContext returns a copy of the repository bound to ctx
+func (r *TrashRepository) WithContext(ctx context.Context) *TrashRepository {
+	return &TrashRepository{db: r.db.WithContext(ctx)}
+}
+
+// Count returns how many rows of model are in the trash
+func (r *TrashRepository) Count(model interface{}) (int64, error) {
+	var count int64
+	err := r.db.Unscoped().Model(model).Where("deleted_at IS NOT NULL").Count(&count).Error
+	return count, err
+}
+
+// List returns the soft-deleted rows of model, most recently deleted first,
+// as a slice of the model's type
+func (r *TrashRepository) List(model interface{}, limit, offset int) (interface{}, error) {
+	rows := reflect.New(reflect.SliceOf(reflect.TypeOf(model).Elem()))
+	err := r.db.Unscoped().Model(model).Where("deleted_at IS NOT NULL").
+		Order("deleted_at DESC").Limit(limit).Offset(offset).
+		Find(rows.Interface()).Error
+	return rows.Elem().Interface(), err
+}
+
+// DeletedAt returns when a row was soft-deleted, or nil if it is live.
+// It returns gorm.ErrRecordNotFound if the row does not exist at all.
+func (r *TrashRepository) DeletedAt(model interface{}, id uint) (*time.Time, error) {
+	var rows []struct{ DeletedAt *time.Time }
+	err := r.db.Unscoped().Model(model).Select("deleted_at").Where("id = ?", id).Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return rows[0].DeletedAt, nil
+}
+
+// HasLiveDuplicate reports whether a live row other than id has the same
+// values as id in all of columns. NULLs never match, as in a unique index.
+func (r *TrashRepository) HasLiveDuplicate(model interface{}, id uint, columns []string) (bool, error) {
+	query := r.db.Model(model).Where("id <> ?", id)
+	for _, column := range columns {
+		value := r.db.Unscoped().Model(model).Select(column).Where("id = ?", id)
+		query = query.Where("? = (?)", clause.Column{Name: column}, value)
+	}
+	var count int64
+	err := query.Count(&count).Error
+	return count > 0, err
+}
+
+// ParentDeleted reports whether the row referenced by id's link.ForeignKey
+// is in the trash
+func (r *TrashRepository) ParentDeleted(model interface{}, id uint, link TrashLink) (bool, error) {
+	parentID := r.db.Unscoped().Model(model).Select(link.ForeignKey).Where("id = ?", id)
+	var count int64
+	err := r.db.Unscoped().Model(link.Model).
+		Where("id = (?) AND deleted_at IS NOT NULL", parentID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// Restore brings a row back from the trash. Rows of dependents that were
+// deleted together with it, i.e. not before it, are restored as well.
+func (r *TrashRepository) Restore(model interface{}, id uint, dependents []TrashLink) (int64, error) {
+	var restored int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		deletedAt, err := (&TrashRepository{db: tx}).DeletedAt(model, id)
+		if err != nil {
+			return err
+		}
+		if deletedAt == nil {
+			return gorm.ErrRecordNotFound
+		}
+
+		result := tx.Unscoped().Model(model).Where("id = ? AND deleted_at IS NOT NULL", id).Update("deleted_at", nil)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		for _, dependent := range dependents {
+			result := tx.Unscoped().Model(dependent.Model).
+				Where(dependent.ForeignKey+" = ? AND deleted_at >= ?", id, *deletedAt).
+				Update("deleted_at", nil)
+			if result.Error != nil {
+				return result.Error
+			}
+			restored += result.RowsAffected
+		}
+		return nil
+	})
+	return restored, err
+}
+
+// Purge permanently deletes a row in the trash, along with the rows of
+// owned tables that reference it
+func (r *TrashRepository) Purge(model interface{}, id uint, owned []TrashLink) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, link := range owned {
+			if err := tx.Unscoped().Where(link.ForeignKey+" = ?", id).Delete(link.Model).Error; err != nil {
+				return err
+			}
+		}
+		result := tx.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).Delete(model)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// ListExpired returns the IDs of rows of model deleted before cutoff
+func (r *TrashRepository) ListExpired(model interface{}, cutoff time.Time) ([]uint, error) {
+	var ids []uint
+	err := r.db.Unscoped().Model(model).Where("deleted_at < ?", cutoff).Order("id").Pluck("id", &ids).Error
+	return ids, err
+}
@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"context"
+
 	"github.com/E-Timileyin/school-management-system/internal/models"
 	"gorm.io/gorm"
 )
@@ -13,6 +15,11 @@ func NewUserRepository(db *gorm.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
+// WithContext returns a copy of the repository bound to ctx
+func (r *UserRepository) WithContext(ctx context.Context) *UserRepository {
+	return &UserRepository{db: r.db.WithContext(ctx)}
+}
+
 func (r *UserRepository) Create(user *models.User) error {
 	return r.db.Create(user).Error
 }
@@ -29,12 +36,48 @@ func (r *UserRepository) FindByEmail(email string) (*models.User, error) {
 	return &user, err
 }
 
+// FindByExternalID finds the user linked to a subject at an identity provider
+func (r *UserRepository) FindByExternalID(provider, externalID string) (*models.User, error) {
+	var user models.User
+	err := r.db.Where("auth_provider = ? AND external_id = ?", provider, externalID).First(&user).Error
+	return &user, err
+}
+
 func (r *UserRepository) Update(user *models.User) error {
 	return r.db.Save(user).Error
 }
 
+// ChangeRole saves the user's new role together with its audit record
+func (r *UserRepository) ChangeRole(user *models.User, change *models.RoleChange) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(user).Error; err != nil {
+			return err
+		}
+		return tx.Create(change).Error
+	})
+}
+
+// AdvanceTwoFactorStep records the TOTP step of an accepted code. It reports
+// false if the step is not newer than the last accepted one, i.e. a replay.
+func (r *UserRepository) AdvanceTwoFactorStep(id uint, step int64) (bool, error) {
+	result := r.db.Model(&models.User{}).
+		Where("id = ? AND two_factor_last_step < ?", id, step).
+		Update("two_factor_last_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+// Delete soft-deletes a user together with their student or teacher
+// profile, so that restoring the user from the trash can bring both back
 func (r *UserRepository) Delete(id uint) error {
-	return r.db.Delete(&models.User{}, id).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.User{}, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&models.Student{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", id).Delete(&models.Teacher{}).Error
+	})
 }
 
 func (r *UserRepository) List() ([]models.User, error) {
@@ -42,3 +85,10 @@ func (r *UserRepository) List() ([]models.User, error) {
 	err := r.db.Find(&users).Error
 	return users, err
 }
+
+// ListByAuthProvider returns the users signing in through a provider
+func (r *UserRepository) ListByAuthProvider(provider string) ([]models.User, error) {
+	var users []models.User
+	err := r.db.Where("auth_provider = ?", provider).Order("created_at DESC").Find(&users).Error
+	return users, err
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"gorm.io/gorm"
+)
+
+type UserTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewUserTokenRepository(db *gorm.DB) *UserTokenRepository {
+	return &UserTokenRepository{db: db}
+}
+
+// WithContext returns a copy of the repository bound to ctx
+func (r *UserTokenRepository) WithContext(ctx context.Context) *UserTokenRepository {
+	return &UserTokenRepository{db: r.db.WithContext(ctx)}
+}
+
+func (r *UserTokenRepository) Create(token *models.UserToken) error {
+	return r.db.Create(token).Error
+}
+
+// FindValid returns an unused, unexpired token with the given hash and purpose
+func (r *UserTokenRepository) FindValid(tokenHash, purpose string) (*models.UserToken, error) {
+	var token models.UserToken
+	err := r.db.Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?",
+		tokenHash, purpose, time.Now()).
+		First(&token).Error
+	return &token, err
+}
+
+// MarkUsed consumes a token. It reports false if the token was already used,
+// so that two concurrent requests cannot both redeem it.
+func (r *UserTokenRepository) MarkUsed(id uint) (bool, error) {
+	result := r.db.Model(&models.UserToken{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// DeleteUnused removes outstanding tokens of a purpose for a user
+func (r *UserTokenRepository) DeleteUnused(userID uint, purpose string) error {
+	return r.db.Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Delete(&models.UserToken{}).Error
+}
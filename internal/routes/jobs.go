@@ -0,0 +1,43 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/E-Timileyin/school-management-system/internal/scheduler"
+	"github.com/E-Timileyin/school-management-system/internal/service"
+)
+
+// registerJobs adds the background jobs with their default schedules
+func registerJobs(jobs *scheduler.Scheduler, libraryService *service.LibraryService, communicationService *service.CommunicationService) error {
+	for _, job := range []struct {
+		name, schedule string
+		run            scheduler.Func
+	}{
+		{"overdue_loans", "@every 15m", func(ctx context.Context) (string, error) {
+			marked, err := libraryService.WithContext(ctx).MarkOverdueLoans()
+			return fmt.Sprintf("%d loans marked overdue", marked), err
+		}},
+		{"loan_reminders", "@daily 08:00", func(ctx context.Context) (string, error) {
+			dueSoon, overdue, err := libraryService.WithContext(ctx).SendLoanReminders()
+			return fmt.Sprintf("%d due soon and %d overdue reminders sent", dueSoon, overdue), err
+		}},
+		{"library_card_expiry", "@hourly", func(ctx context.Context) (string, error) {
+			expired, err := libraryService.WithContext(ctx).ExpireLibraryCards()
+			return fmt.Sprintf("%d library cards expired", expired), err
+		}},
+		{"hold_expiry", "@every 5m", func(ctx context.Context) (string, error) {
+			expired, err := libraryService.WithContext(ctx).ExpireHolds()
+			return fmt.Sprintf("%d holds expired", expired), err
+		}},
+		{"publish_communications", "@every 1m", func(ctx context.Context) (string, error) {
+			published, err := communicationService.WithContext(ctx).PublishScheduled()
+			return fmt.Sprintf("%d communications published", published), err
+		}},
+	} {
+		if err := jobs.Register(job.name, job.schedule, job.run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
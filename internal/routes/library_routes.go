@@ -3,6 +3,8 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/E-Timileyin/school-management-system/internal/handler"
+	"github.com/E-Timileyin/school-management-system/internal/middlewares"
+	"github.com/E-Timileyin/school-management-system/internal/models"
 )
 
 // setupLibraryRoutes configures all library related routes
@@ -10,30 +12,113 @@ func setupLibraryRoutes(router *gin.RouterGroup, libraryHandler *handler.Library
 	// Library routes group
 	library := router.Group("/library")
 	{
+		// Only librarians and admins may change the catalogue
+		librarian := middlewares.RequireRole(models.RoleLibrarian, models.RoleAdmin)
+
 		// Book routes
 		books := library.Group("/books")
 		{
-			books.POST("", libraryHandler.CreateBook)
+			books.GET("", libraryHandler.ListBooks)
 			books.GET("/:id", libraryHandler.GetBook)
+			books.POST("", librarian, libraryHandler.CreateBook)
+			books.POST("/intake", librarian, libraryHandler.IntakeBook)
+			books.PUT("/:id", librarian, libraryHandler.UpdateBook)
+			books.POST("/:id/deactivate", librarian, libraryHandler.DeactivateBook)
+			books.POST("/:id/activate", librarian, libraryHandler.ActivateBook)
+			books.DELETE("/:id", librarian, libraryHandler.DeleteBook)
+			books.GET("/:id/copies", libraryHandler.ListCopies)
+			books.POST("/:id/copies", librarian, libraryHandler.AddCopies)
+			books.POST("/:id/reservations", libraryHandler.PlaceReservation)
+			books.GET("/:id/reservations", librarian, libraryHandler.ListBookReservations)
+		}
+
+		// Holds queue; readers see and cancel their own reservations
+		reservations := library.Group("/reservations")
+		{
+			reservations.GET("", libraryHandler.ListReservations)
+			reservations.DELETE("/:id", libraryHandler.CancelReservation)
+		}
+
+		// Physical copies, looked up by scanning their barcode
+		copies := library.Group("/copies")
+		{
+			copies.GET("", libraryHandler.FindCopy)
+			copies.PUT("/:id", librarian, libraryHandler.UpdateCopy)
 		}
 
-		// Library card routes
+		// Barcode label sheets for printing
+		library.GET("/labels", librarian, libraryHandler.PrintLabels)
+
+		// Category routes
+		categories := library.Group("/categories")
+		{
+			categories.GET("", libraryHandler.ListCategories)
+			categories.GET("/:id", libraryHandler.GetCategory)
+			categories.POST("", librarian, libraryHandler.CreateCategory)
+			categories.PUT("/:id", librarian, libraryHandler.UpdateCategory)
+			categories.DELETE("/:id", librarian, libraryHandler.DeleteCategory)
+		}
+
+		// Copies on the shelf per category
+		library.GET("/availability", libraryHandler.GetAvailability)
+
+		// Library card routes; readers can see and print their own card
 		cards := library.Group("/cards")
 		{
-			cards.POST("", libraryHandler.IssueLibraryCard)
+			cards.POST("", librarian, libraryHandler.IssueLibraryCard)
+			cards.GET("/:cardId", libraryHandler.GetLibraryCard)
+			cards.GET("/:cardId/print", libraryHandler.PrintLibraryCard)
+			cards.POST("/:cardId/renew", librarian, libraryHandler.RenewLibraryCard)
+			cards.POST("/:cardId/block", librarian, libraryHandler.BlockLibraryCard)
+			cards.POST("/:cardId/unblock", librarian, libraryHandler.UnblockLibraryCard)
+			cards.POST("/:cardId/replace", librarian, libraryHandler.ReplaceLibraryCard)
+			cards.PUT("/:cardId/limit", librarian, libraryHandler.SetCardLimit)
 		}
 
+		// Whether a user may borrow, checked at the desk before scanning
+		library.GET("/borrowers/:userId", librarian, libraryHandler.GetBorrowerStatus)
+
 		// Book circulation routes
 		circulation := library.Group("/circulation")
 		{
-			circulation.POST("/checkout", libraryHandler.CheckoutBook)
-			circulation.PUT("/return", libraryHandler.ReturnBook)
+			circulation.POST("/checkout", librarian, libraryHandler.CheckoutBook)
+			circulation.PUT("/return", librarian, libraryHandler.ReturnBook)
+			circulation.GET("/:issueId", libraryHandler.GetLoan)
+			circulation.POST("/:issueId/renew", libraryHandler.RenewLoan)
+			circulation.POST("/:issueId/lost", librarian, libraryHandler.DeclareLost)
+			circulation.POST("/:issueId/damaged", librarian, libraryHandler.ReturnDamaged)
+			circulation.POST("/:issueId/found", librarian, libraryHandler.RecoverLost)
+			circulation.GET("/:issueId/fine", libraryHandler.GetFine)
+			circulation.POST("/:issueId/fine/payments", librarian, libraryHandler.PayFine)
+			circulation.POST("/:issueId/fine/waivers", librarian, libraryHandler.WaiveFine)
 		}
 
-		// Fine routes
+		// Days the library is closed, which are not charged as overdue
+		holidays := library.Group("/holidays")
+		{
+			holidays.GET("", libraryHandler.ListHolidays)
+			holidays.POST("", librarian, libraryHandler.CreateHoliday)
+			holidays.DELETE("/:id", librarian, libraryHandler.DeleteHoliday)
+		}
+
+		// Fine ledger; readers see their own balance and receipts
 		fines := library.Group("/fines")
 		{
-			fines.POST("/pay", libraryHandler.PayFine)
+			fines.POST("/pay", librarian, libraryHandler.PayFineByIssue)
+			fines.GET("/ledger", libraryHandler.GetFineLedger)
+			fines.GET("/payments/:id/receipt", libraryHandler.PrintReceipt)
+			fines.POST("/payments/:id/refunds", librarian, libraryHandler.RefundPayment)
+		}
+
+		// Circulation reports, as JSON or ?format=csv
+		reports := library.Group("/reports", librarian)
+		{
+			reports.GET("/most-borrowed", libraryHandler.MostBorrowedReport)
+			reports.GET("/circulation", libraryHandler.CirculationReport)
+			reports.GET("/overdue", libraryHandler.OverdueReport)
+			reports.GET("/fines", libraryHandler.FinesReport)
+			reports.GET("/inactive-cardholders", libraryHandler.InactiveCardholdersReport)
+			reports.GET("/never-borrowed", libraryHandler.UnborrowedBooksReport)
 		}
 	}
 }
@@ -1,16 +1,25 @@
 package routes
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 
+	"github.com/E-Timileyin/school-management-system/internal/audit"
+	"github.com/E-Timileyin/school-management-system/internal/bookmeta"
+	"github.com/E-Timileyin/school-management-system/internal/config"
 	"github.com/E-Timileyin/school-management-system/internal/handler"
+	"github.com/E-Timileyin/school-management-system/internal/mailer"
 	"github.com/E-Timileyin/school-management-system/internal/middlewares"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/oidc"
 	"github.com/E-Timileyin/school-management-system/internal/repository"
+	"github.com/E-Timileyin/school-management-system/internal/scheduler"
 	"github.com/E-Timileyin/school-management-system/internal/service"
 )
 
@@ -18,6 +27,12 @@ import (
 func SetupRouter(db *gorm.DB) *gin.Engine {
 	// Initialize Gin router
 	router := gin.Default()
+	router.Use(middlewares.RequestID())
+
+	// Record every change made through GORM in the audit log
+	if err := audit.Register(db); err != nil {
+		log.Fatalf("Failed to register audit callbacks: %v", err)
+	}
 
 	// Initialize repositories
 	userRepo := repository.NewUserRepository(db)
@@ -25,50 +40,151 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 	enrollmentRepo := repository.NewEnrollmentRepository(db)
 	// authRepo is not needed as userRepo handles authentication
 	libraryRepo := repository.NewLibraryRepository(db)
+	communicationRepo := repository.NewCommunicationRepository(db)
+	jobRunRepo := repository.NewJobRunRepository(db)
+	userTokenRepo := repository.NewUserTokenRepository(db)
+	roleChangeRepo := repository.NewRoleChangeRepository(db)
+	recoveryCodeRepo := repository.NewRecoveryCodeRepository(db)
+	securityEventRepo := repository.NewSecurityEventRepository(db)
+	sessionRepo := repository.NewSessionRepository(db)
+	signingKeyRepo := repository.NewSigningKeyRepository(db)
+	apiKeyRepo := repository.NewAPIKeyRepository(db)
+	auditLogRepo := repository.NewAuditLogRepository(db)
+	trashRepo := repository.NewTrashRepository(db)
+	personalDataRepo := repository.NewPersonalDataRepository(db)
+	kioskRepo := repository.NewKioskRepository(db)
+
+	// Initialize mailer
+	mail, err := mailer.New(config.LoadMailConfig())
+	if err != nil {
+		log.Fatalf("Failed to initialize mailer: %v", err)
+	}
+	authConfig := config.LoadAuthConfig()
+	oidcConfig := config.LoadOIDCConfig()
+
+	// Initialize the book metadata lookup used when cataloguing by ISBN
+	libraryConfig := config.LoadLibraryConfig()
+	bookMetadata, err := bookmeta.New(libraryConfig, nil)
+	if err != nil {
+		log.Fatalf("Failed to initialize book metadata provider: %v", err)
+	}
+
+	// Load the token signing keys and keep them rotated
+	keyManager, err := service.NewKeyManager(signingKeyRepo, authConfig, os.Getenv("JWT_SECRET"))
+	if err != nil {
+		log.Fatalf("Failed to initialize signing keys: %v", err)
+	}
+	keyManager.Start(context.Background(), time.Minute)
 
 	// Initialize services
-	userService := service.NewUserService(userRepo)
+	rolePolicy := service.NewRolePolicy(authConfig)
+	userService := service.NewUserService(userRepo, roleChangeRepo, rolePolicy)
+	verificationService := service.NewVerificationService(userRepo, userTokenRepo, mail, authConfig)
+	tokenService := service.NewTokenService(keyManager, authConfig)
+	sessionService := service.NewSessionService(sessionRepo, tokenService)
+	twoFactorService := service.NewTwoFactorService(userRepo, recoveryCodeRepo, authConfig)
+	loginGuard := service.NewLoginGuard(getLoginAttemptStore(db, authConfig), securityEventRepo, authConfig)
+	apiKeyService := service.NewAPIKeyService(userRepo, apiKeyRepo, rolePolicy, authConfig)
+	ssoService := service.NewSSOService(oidc.NewProvider(oidcConfig, nil), userRepo, tokenService, oidcConfig)
 	courseService := service.NewCourseService(courseRepo)
 	enrollmentService := service.NewEnrollmentService(enrollmentRepo)
 	// authService is not needed as userService handles authentication
-	libraryService := service.NewLibraryService(libraryRepo)
+	circulationPolicy := service.NewCirculationPolicy(libraryConfig)
+	finePolicy := service.NewFinePolicy(libraryConfig)
+	libraryService := service.NewLibraryService(libraryRepo, userRepo, bookMetadata, circulationPolicy, finePolicy, mail)
+	kioskService := service.NewKioskService(kioskRepo, libraryService)
+	communicationService := service.NewCommunicationService(communicationRepo)
+	trashService := service.NewTrashService(trashRepo, config.LoadDataConfig())
+	privacyService := service.NewPrivacyService(personalDataRepo, loginGuard)
+
+	// Background jobs; replicas take turns through a lock per job
+	jobScheduler := scheduler.New(jobRunRepo, config.LoadSchedulerConfig())
+	if err := registerJobs(jobScheduler, libraryService, communicationService); err != nil {
+		log.Fatalf("Failed to register background jobs: %v", err)
+	}
+	jobScheduler.Start(context.Background())
 
 	// Initialize handlers
-	userHandler := handler.NewUserHandler(userService)
+	userHandler := handler.NewUserHandler(userService, verificationService, twoFactorService, tokenService, loginGuard, sessionService)
+	twoFactorHandler := handler.NewTwoFactorHandler(twoFactorService)
+	sessionHandler := handler.NewSessionHandler(sessionService)
+	ssoHandler := handler.NewSSOHandler(ssoService, userHandler)
+	keyHandler := handler.NewKeyHandler(keyManager)
+	apiKeyHandler := handler.NewAPIKeyHandler(apiKeyService)
 	courseHandler := handler.NewCourseHandler(courseService, enrollmentService)
 	// authHandler is not needed as userHandler handles authentication
 	libraryHandler := handler.NewLibraryHandler(libraryService)
-	adminHandler := handler.NewAdminHandler(userService, courseService)
-
-	// Get JWT secret
-	jwtSecret := getJWTSecret()
+	communicationHandler := handler.NewCommunicationHandler(communicationService)
+	kioskHandler := handler.NewKioskHandler(kioskService)
+	adminHandler := handler.NewAdminHandler(userService, courseService, verificationService, sessionService)
+	securityHandler := handler.NewSecurityHandler(loginGuard, userService)
+	auditHandler := handler.NewAuditHandler(auditLogRepo)
+	trashHandler := handler.NewTrashHandler(trashService)
+	privacyHandler := handler.NewPrivacyHandler(privacyService)
+	jobHandler := handler.NewJobHandler(jobScheduler, jobRunRepo)
 
 	// ====== Public Routes ======
 	setupHealthCheck(router, db)
 	// Auth routes are handled by userHandler
 	router.POST("/login", userHandler.Login)
+	router.POST("/login/2fa", userHandler.VerifyTwoFactorLogin)
 	router.POST("/register", userHandler.Register)
+	router.GET("/verify-email", userHandler.VerifyEmail)
+	router.POST("/verify-email/resend", userHandler.ResendVerification)
+	router.GET("/invitations/accept", userHandler.GetInvitation)
+	router.POST("/invitations/accept", userHandler.AcceptInvitation)
+	router.GET("/auth/oidc/login", ssoHandler.Login)
+	router.GET("/auth/oidc/callback", ssoHandler.Callback)
+	router.GET("/.well-known/jwks.json", keyHandler.JWKS)
 
 	// ====== Protected API Routes ======
 	api := router.Group("/api")
-	api.Use(middlewares.AuthMiddleware(jwtSecret))
+	api.Use(middlewares.AuthMiddleware(tokenService, sessionService, apiKeyService))
+	api.Use(middlewares.CurrentUser(userService))
+	api.Use(middlewares.AuditActor())
 	{
+		// API keys only reach the areas they hold a scope for
+		users := api.Group("", middlewares.RequireScope(service.ScopeAreaUsers))
+		library := api.Group("", middlewares.RequireScope(service.ScopeAreaLibrary))
+		courses := api.Group("", middlewares.RequireScope(service.ScopeAreaCourses))
+		communications := api.Group("", middlewares.RequireScope(service.ScopeAreaCommunications))
+
 		// User profile routes
-		setupUserRoutes(api, userHandler)
+		setupUserRoutes(users, userHandler, twoFactorHandler, sessionHandler, privacyHandler)
+		users.POST("/logout", sessionHandler.Logout)
 
 		// Library routes
-		setupLibraryRoutes(api, libraryHandler)
+		setupLibraryRoutes(library, libraryHandler)
 
 		// Course routes
-		setupCourseRoutes(api, courseHandler)
+		setupCourseRoutes(courses, courseHandler)
+
+		// Notice board routes
+		setupCommunicationRoutes(communications, communicationHandler)
+	}
+
+	// ====== Kiosk Routes ======
+	// Self-service kiosks authenticate with a device credential and only
+	// reach these routes
+	kiosk := router.Group("/kiosk")
+	kiosk.Use(middlewares.KioskAuth(kioskService))
+	kiosk.Use(middlewares.AuditActor())
+	{
+		kiosk.POST("/checkout", kioskHandler.Checkout)
+		kiosk.POST("/return", kioskHandler.Return)
+		kiosk.POST("/loans", kioskHandler.Loans)
 	}
 
 	// ====== Admin Routes ======
 	admin := router.Group("/admin")
-	admin.Use(middlewares.AuthMiddleware(jwtSecret))
+	admin.Use(middlewares.AuthMiddleware(tokenService, sessionService, apiKeyService))
+	admin.Use(middlewares.CurrentUser(userService))
+	admin.Use(middlewares.AuditActor())
 	admin.Use(middlewares.AdminMiddleware()) // Only users with admin role can access
+	admin.Use(middlewares.RequireTwoFactor(twoFactorService))
+	admin.Use(middlewares.RequireScope(service.ScopeAreaAdmin))
 	{
-		setupAdminRoutes(admin, adminHandler)
+		setupAdminRoutes(admin, adminHandler, securityHandler, sessionHandler, keyHandler, apiKeyHandler, kioskHandler, auditHandler, trashHandler, privacyHandler, jobHandler)
 	}
 
 	return router
@@ -106,17 +222,54 @@ func setupHealthCheck(router *gin.Engine, db *gorm.DB) {
 }
 
 // setupUserRoutes configures user profile related routes
-func setupUserRoutes(router *gin.RouterGroup, userHandler *handler.UserHandler) {
+func setupUserRoutes(
+	router *gin.RouterGroup,
+	userHandler *handler.UserHandler,
+	twoFactorHandler *handler.TwoFactorHandler,
+	sessionHandler *handler.SessionHandler,
+	privacyHandler *handler.PrivacyHandler,
+) {
 	users := router.Group("/users")
 	{
 		users.GET("/me", userHandler.GetProfile)
 		users.PUT("/me", userHandler.UpdateProfile)
 		users.PUT("/password", userHandler.ChangePassword)
+		users.GET("/me/export", privacyHandler.ExportMyData)
+
+		// Two-factor authentication
+		twoFactor := users.Group("/me/2fa")
+		{
+			twoFactor.GET("", twoFactorHandler.GetStatus)
+			twoFactor.POST("/setup", twoFactorHandler.Setup)
+			twoFactor.POST("/confirm", twoFactorHandler.Confirm)
+			twoFactor.POST("/disable", twoFactorHandler.Disable)
+			twoFactor.POST("/recovery-codes", twoFactorHandler.RegenerateRecoveryCodes)
+		}
+
+		// Sessions and devices
+		sessions := users.Group("/me/sessions")
+		{
+			sessions.GET("", sessionHandler.ListMySessions)
+			sessions.DELETE("", sessionHandler.RevokeMyOtherSessions)
+			sessions.DELETE("/:sessionId", sessionHandler.RevokeMySession)
+		}
 	}
 }
 
 // setupAdminRoutes configures admin management routes
-func setupAdminRoutes(router *gin.RouterGroup, adminHandler *handler.AdminHandler) {
+func setupAdminRoutes(
+	router *gin.RouterGroup,
+	adminHandler *handler.AdminHandler,
+	securityHandler *handler.SecurityHandler,
+	sessionHandler *handler.SessionHandler,
+	keyHandler *handler.KeyHandler,
+	apiKeyHandler *handler.APIKeyHandler,
+	kioskHandler *handler.KioskHandler,
+	auditHandler *handler.AuditHandler,
+	trashHandler *handler.TrashHandler,
+	privacyHandler *handler.PrivacyHandler,
+	jobHandler *handler.JobHandler,
+) {
 	// User management
 	users := router.Group("/users")
 	{
@@ -125,6 +278,62 @@ func setupAdminRoutes(router *gin.RouterGroup, adminHandler *handler.AdminHandle
 		users.GET("/:id", adminHandler.GetUserByID)
 		users.PUT("/:id", adminHandler.UpdateUser)
 		users.DELETE("/:id", adminHandler.DeleteUser)
+		users.POST("/:id/invitation", adminHandler.ResendInvitation)
+		users.GET("/:id/role-changes", adminHandler.GetUserRoleHistory)
+		users.GET("/:id/lock", securityHandler.GetLockStatus)
+		users.POST("/:id/unlock", securityHandler.UnlockUser)
+		users.GET("/:id/sessions", sessionHandler.ListUserSessions)
+		users.DELETE("/:id/sessions", sessionHandler.RevokeUserSessions)
+		users.DELETE("/:id/sessions/:sessionId", sessionHandler.RevokeUserSession)
+		users.GET("/:id/export", privacyHandler.ExportUserData)
+		users.POST("/:id/erase", privacyHandler.EraseUser)
+	}
+
+	// Security event log
+	router.GET("/security-events", securityHandler.ListSecurityEvents)
+
+	// Audit log of data changes
+	router.GET("/audit-logs", auditHandler.ListAuditLogs)
+
+	// Scheduled background jobs and their run history
+	jobs := router.Group("/jobs")
+	{
+		jobs.GET("", jobHandler.ListJobs)
+		jobs.GET("/runs", jobHandler.ListRuns)
+		jobs.POST("/:name/run", jobHandler.RunJob)
+	}
+
+	// Soft-deleted records
+	trash := router.Group("/trash")
+	{
+		trash.GET("", trashHandler.ListEntities)
+		trash.POST("/purge", trashHandler.PurgeExpired)
+		trash.GET("/:entity", trashHandler.ListDeleted)
+		trash.POST("/:entity/:id/restore", trashHandler.Restore)
+		trash.DELETE("/:entity/:id", trashHandler.Purge)
+	}
+
+	// Token signing keys
+	router.GET("/signing-keys", keyHandler.ListSigningKeys)
+	router.POST("/signing-keys/rotate", keyHandler.RotateSigningKey)
+
+	// Service accounts and API keys
+	apiKeys := router.Group("/api-keys")
+	{
+		apiKeys.GET("", apiKeyHandler.ListKeys)
+		apiKeys.POST("", apiKeyHandler.CreateKey)
+		apiKeys.DELETE("/:id", apiKeyHandler.RevokeKey)
+		apiKeys.GET("/service-accounts", apiKeyHandler.ListServiceAccounts)
+		apiKeys.POST("/service-accounts", apiKeyHandler.CreateServiceAccount)
+		apiKeys.DELETE("/service-accounts/:id", apiKeyHandler.DeleteServiceAccount)
+	}
+
+	// Self-service library kiosks
+	kiosks := router.Group("/kiosks")
+	{
+		kiosks.GET("", kioskHandler.ListKiosks)
+		kiosks.POST("", kioskHandler.RegisterKiosk)
+		kiosks.DELETE("/:id", kioskHandler.RevokeKiosk)
 	}
 
 	// Course management
@@ -164,12 +373,38 @@ func setupCourseRoutes(router *gin.RouterGroup, courseHandler *handler.CourseHan
 	}
 }
 
-// getJWTSecret retrieves the JWT secret from environment variables
-func getJWTSecret() string {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		// In production, you should fail fast if JWT_SECRET is not set
-		log.Fatal("JWT_SECRET environment variable is not set")
+// setupCommunicationRoutes configures the notice board: every user reads
+// their feed, and staff write communications
+func setupCommunicationRoutes(router *gin.RouterGroup, communicationHandler *handler.CommunicationHandler) {
+	feed := router.Group("/feed")
+	{
+		feed.GET("", communicationHandler.GetFeed)
+		feed.GET("/:id", communicationHandler.ReadCommunication)
+	}
+
+	communications := router.Group("/communications", middlewares.RequireRole(models.RoleAdmin, models.RoleTeacher, models.RoleLibrarian))
+	{
+		communications.GET("", communicationHandler.ListCommunications)
+		communications.POST("", communicationHandler.CreateCommunication)
+		communications.GET("/:id", communicationHandler.GetCommunication)
+		communications.PUT("/:id", communicationHandler.UpdateCommunication)
+		communications.DELETE("/:id", communicationHandler.DeleteCommunication)
+		communications.POST("/:id/publish", communicationHandler.PublishCommunication)
+		communications.POST("/:id/schedule", communicationHandler.ScheduleCommunication)
+		communications.POST("/:id/unpublish", communicationHandler.UnpublishCommunication)
+		communications.GET("/:id/receipts", communicationHandler.GetReadReceipts)
+	}
+}
+
+// getLoginAttemptStore selects where failed logins are tracked
+func getLoginAttemptStore(db *gorm.DB, cfg config.AuthConfig) repository.LoginAttemptStore {
+	switch cfg.LoginAttemptStore {
+	case "database":
+		return repository.NewLoginAttemptRepository(db)
+	case "memory":
+		return repository.NewMemoryLoginAttemptStore()
+	default:
+		log.Fatalf("Unknown LOGIN_ATTEMPT_STORE %q, expected memory or database", cfg.LoginAttemptStore)
+		return nil
 	}
-	return secret
 }
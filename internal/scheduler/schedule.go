@@ -0,0 +1,85 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule tells when a job is next due
+type Schedule interface {
+	// Next returns the first time the job is due after last
+	Next(last time.Time) time.Time
+	String() string
+}
+
+// ParseSchedule parses a schedule spec:
+//
+//	@every 30m      every 30 minutes after the last run
+//	@hourly         at the start of every hour
+//	@daily          at midnight
+//	@daily 07:30    every day at 07:30
+//
+// Times of day are in the server's time zone.
+func ParseSchedule(spec string) (Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty schedule")
+	}
+	switch {
+	case fields[0] == "@every" && len(fields) == 2:
+		interval, err := time.ParseDuration(fields[1])
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid interval in schedule %q", spec)
+		}
+		return every(interval), nil
+	case fields[0] == "@hourly" && len(fields) == 1:
+		return hourly{}, nil
+	case fields[0] == "@daily" && len(fields) == 1:
+		return daily{}, nil
+	case fields[0] == "@daily" && len(fields) == 2:
+		at, err := time.Parse("15:04", fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid time of day in schedule %q, expected HH:MM", spec)
+		}
+		return daily{hour: at.Hour(), minute: at.Minute()}, nil
+	}
+	return nil, fmt.Errorf("unknown schedule %q, expected @every <duration>, @hourly or @daily [HH:MM]", spec)
+}
+
+type every time.Duration
+
+func (e every) Next(last time.Time) time.Time {
+	return last.Add(time.Duration(e))
+}
+
+func (e every) String() string {
+	return "@every " + time.Duration(e).String()
+}
+
+type hourly struct{}
+
+func (hourly) Next(last time.Time) time.Time {
+	return last.Truncate(time.Hour).Add(time.Hour)
+}
+
+func (hourly) String() string {
+	return "@hourly"
+}
+
+type daily struct {
+	hour, minute int
+}
+
+func (d daily) Next(last time.Time) time.Time {
+	last = last.In(time.Local)
+	next := time.Date(last.Year(), last.Month(), last.Day(), d.hour, d.minute, 0, 0, time.Local)
+	if !next.After(last) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func (d daily) String() string {
+	return fmt.Sprintf("@daily %02d:%02d", d.hour, d.minute)
+}
@@ -0,0 +1,223 @@
+// Package scheduler runs background jobs on a schedule. Replicas share the
+// job history in the database and take a lock per job before running it, so
+// each run happens on one of them.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/E-Timileyin/school-management-system/internal/config"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+)
+
+var (
+	ErrUnknownJob = errors.New("unknown job")
+	ErrJobRunning = errors.New("job is already running")
+)
+
+// Func does the work of a job and sums up what it did, e.g. "3 loans
+// marked overdue"
+type Func func(ctx context.Context) (string, error)
+
+type job struct {
+	name     string
+	schedule Schedule // nil when the job only runs by hand
+	run      Func
+}
+
+// JobStatus describes a registered job
+type JobStatus struct {
+	Name     string         `json:"name"`
+	Schedule string         `json:"schedule"` // off when the job only runs by hand
+	LastRun  *models.JobRun `json:"last_run,omitempty"`
+	NextRun  *time.Time     `json:"next_run,omitempty"`
+}
+
+// Scheduler runs registered jobs when they are due
+type Scheduler struct {
+	runs     *repository.JobRunRepository
+	cfg      config.SchedulerConfig
+	instance string
+	started  time.Time
+	jobs     []*job
+}
+
+func New(runs *repository.JobRunRepository, cfg config.SchedulerConfig) *Scheduler {
+	host, _ := os.Hostname()
+	return &Scheduler{
+		runs:     runs,
+		cfg:      cfg,
+		instance: fmt.Sprintf("%s/%d", host, os.Getpid()),
+		started:  time.Now(),
+	}
+}
+
+// Register adds a job with its default schedule spec, see ParseSchedule.
+// SCHEDULER_SCHEDULES can replace the spec, or turn the job off so it only
+// runs by hand.
+func (s *Scheduler) Register(name, spec string, run Func) error {
+	if override, ok := s.cfg.Schedules[name]; ok {
+		spec = override
+	}
+	j := &job{name: name, run: run}
+	if spec != "off" {
+		schedule, err := ParseSchedule(spec)
+		if err != nil {
+			return fmt.Errorf("job %s: %w", name, err)
+		}
+		j.schedule = schedule
+	}
+	s.jobs = append(s.jobs, j)
+	return nil
+}
+
+// Start checks for due jobs every poll interval until ctx is cancelled
+func (s *Scheduler) Start(ctx context.Context) {
+	if !s.cfg.Enabled {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(s.cfg.PollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for _, j := range s.jobs {
+					if j.schedule == nil {
+						continue
+					}
+					if err := s.runIfDue(ctx, j); err != nil {
+						log.Printf("Scheduled job %s: %v", j.name, err)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Jobs lists the registered jobs with their last and next runs
+func (s *Scheduler) Jobs(ctx context.Context) ([]JobStatus, error) {
+	runs := s.runs.WithContext(ctx)
+	statuses := make([]JobStatus, len(s.jobs))
+	for i, j := range s.jobs {
+		last, err := runs.Last(j.name)
+		if err != nil {
+			return nil, err
+		}
+		statuses[i] = JobStatus{Name: j.name, Schedule: "off", LastRun: last}
+		if j.schedule != nil {
+			next := s.nextRun(j, last)
+			statuses[i].Schedule = j.schedule.String()
+			statuses[i].NextRun = &next
+		}
+	}
+	return statuses, nil
+}
+
+// RunNow runs a job at once on behalf of an admin, unless it is running
+// already here or on another replica. A failed run is returned with the
+// error recorded on it.
+func (s *Scheduler) RunNow(ctx context.Context, name string, triggeredBy uint) (*models.JobRun, error) {
+	for _, j := range s.jobs {
+		if j.name != name {
+			continue
+		}
+		release, ok, err := s.runs.WithContext(ctx).TryLock(ctx, j.name)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrJobRunning
+		}
+		defer release()
+		return s.run(ctx, j, &triggeredBy)
+	}
+	return nil, ErrUnknownJob
+}
+
+// runIfDue runs a job if it is due and no other replica is running it. The
+// last run is read under the lock, so a run another replica just finished
+// is seen.
+func (s *Scheduler) runIfDue(ctx context.Context, j *job) error {
+	runs := s.runs.WithContext(ctx)
+	release, ok, err := runs.TryLock(ctx, j.name)
+	if err != nil || !ok {
+		return err
+	}
+	defer release()
+
+	last, err := runs.Last(j.name)
+	if err != nil {
+		return err
+	}
+	if time.Now().Before(s.nextRun(j, last)) {
+		return nil
+	}
+	_, err = s.run(ctx, j, nil)
+	return err
+}
+
+// nextRun is when a job is next due. A job that never ran is scheduled from
+// when the scheduler started.
+func (s *Scheduler) nextRun(j *job, last *models.JobRun) time.Time {
+	if last == nil {
+		return j.schedule.Next(s.started)
+	}
+	return j.schedule.Next(last.StartedAt)
+}
+
+// run runs a job and records the run. It only returns an error when the
+// run cannot be recorded; the job's own error is kept on the run.
+func (s *Scheduler) run(ctx context.Context, j *job, triggeredBy *uint) (*models.JobRun, error) {
+	runs := s.runs.WithContext(ctx)
+	run := &models.JobRun{
+		Job:         j.name,
+		StartedAt:   time.Now(),
+		Status:      models.JobRunning,
+		TriggeredBy: triggeredBy,
+		Instance:    s.instance,
+	}
+	if err := runs.Create(run); err != nil {
+		return nil, err
+	}
+
+	result, err := call(ctx, j.run)
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.Result = result
+	run.Status = models.JobSucceeded
+	if err != nil {
+		run.Status = models.JobFailed
+		run.Error = err.Error()
+		log.Printf("Job %s failed: %v", j.name, err)
+	}
+	if err := runs.Finish(run); err != nil {
+		return run, err
+	}
+
+	if s.cfg.HistoryRetention > 0 {
+		if err := runs.DeleteBefore(j.name, finished.Add(-s.cfg.HistoryRetention)); err != nil {
+			log.Printf("Failed to delete old runs of job %s: %v", j.name, err)
+		}
+	}
+	return run, nil
+}
+
+// call runs fn, turning a panic into an error so one broken job does not
+// stop the scheduler
+func call(ctx context.Context, fn Func) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx)
+}
@@ -0,0 +1,269 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/E-Timileyin/school-management-system/internal/config"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+	"github.com/E-Timileyin/school-management-system/internal/utils"
+)
+
+// apiKeyTouchInterval limits how often LastUsedAt is written for a key
+const apiKeyTouchInterval = time.Minute
+
+// apiKeyPrefix starts every API key so leaked keys are easy to recognise
+const apiKeyPrefix = "sms"
+
+// API key scopes are "<area>:read" or "<area>:write"; write implies read
+const (
+	ScopeAreaUsers   = "users"
+	ScopeAreaCourses = "courses"
+	ScopeAreaLibrary = "library"
+	ScopeAreaAdmin   = "admin"
+
+	ScopeAreaCommunications = "communications"
+)
+
+var scopeAreas = map[string]bool{
+	ScopeAreaUsers:   true,
+	ScopeAreaCourses: true,
+	ScopeAreaLibrary: true,
+	ScopeAreaAdmin:   true,
+
+	ScopeAreaCommunications: true,
+}
+
+var (
+	ErrInvalidAPIKey          = errors.New("invalid, expired or revoked API key")
+	ErrAPIKeyNotFound         = errors.New("API key not found")
+	ErrServiceAccountNotFound = errors.New("service account not found")
+	ErrInvalidScope           = errors.New("invalid scope")
+	ErrInvalidExpiry          = errors.New("invalid expiry")
+	ErrServiceAccountLogin    = errors.New("service accounts cannot log in")
+)
+
+// CreatedAPIKey is returned once when a key is created; Key is never stored
+type CreatedAPIKey struct {
+	Key    string
+	APIKey *models.APIKey
+}
+
+// APIKeyService manages service accounts and the API keys they authenticate with
+type APIKeyService struct {
+	userRepo   *repository.UserRepository
+	apiKeyRepo *repository.APIKeyRepository
+	rolePolicy *RolePolicy
+	cfg        config.AuthConfig
+}
+
+func NewAPIKeyService(
+	userRepo *repository.UserRepository,
+	apiKeyRepo *repository.APIKeyRepository,
+	rolePolicy *RolePolicy,
+	cfg config.AuthConfig,
+) *APIKeyService {
+	return &APIKeyService{
+		userRepo:   userRepo,
+		apiKeyRepo: apiKeyRepo,
+		rolePolicy: rolePolicy,
+		cfg:        cfg,
+	}
+}
+
+// WithContext returns a copy of the service bound to ctx
+func (s *APIKeyService) WithContext(ctx context.Context) *APIKeyService {
+	clone := *s
+	clone.userRepo = s.userRepo.WithContext(ctx)
+	clone.apiKeyRepo = s.apiKeyRepo.WithContext(ctx)
+	return &clone
+}
+
+// CreateServiceAccount creates a machine user acting with role. It has no
+// password and an address that can never receive mail.
+func (s *APIKeyService) CreateServiceAccount(actor *models.User, name, role string) (*models.User, error) {
+	if err := s.rolePolicy.CheckAssignment(actor, role); err != nil {
+		return nil, err
+	}
+
+	suffix, err := utils.GenerateRandomToken(6)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	account := &models.User{
+		Email:           fmt.Sprintf("svc-%s@service-accounts.invalid", strings.ToLower(suffix)),
+		FirstName:       name,
+		LastName:        "Service Account",
+		Role:            role,
+		EmailVerified:   true,
+		EmailVerifiedAt: &now,
+		AuthProvider:    models.AuthProviderServiceAccount,
+	}
+	if err := s.userRepo.Create(account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// ListServiceAccounts returns every service account
+func (s *APIKeyService) ListServiceAccounts() ([]models.User, error) {
+	return s.userRepo.ListByAuthProvider(models.AuthProviderServiceAccount)
+}
+
+// GetServiceAccount returns a service account by user ID
+func (s *APIKeyService) GetServiceAccount(id uint) (*models.User, error) {
+	account, err := s.userRepo.FindByID(id)
+	if err != nil || !account.IsServiceAccount() {
+		return nil, ErrServiceAccountNotFound
+	}
+	return account, nil
+}
+
+// DeleteServiceAccount revokes all keys of a service account and deletes it
+func (s *APIKeyService) DeleteServiceAccount(id uint) error {
+	if _, err := s.GetServiceAccount(id); err != nil {
+		return err
+	}
+	if err := s.apiKeyRepo.RevokeAllForUser(id); err != nil {
+		return err
+	}
+	return s.userRepo.Delete(id)
+}
+
+// CreateKey issues a new key for a service account. A nil expiresAt means
+// the default lifetime; keys never outlive APIKeyMaxTTL.
+func (s *APIKeyService) CreateKey(actor *models.User, accountID uint, name string, scopes []string, expiresAt *time.Time) (*CreatedAPIKey, error) {
+	if _, err := s.GetServiceAccount(accountID); err != nil {
+		return nil, err
+	}
+	normalized, err := normalizeScopes(scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if expiresAt == nil {
+		expiry := now.Add(s.cfg.APIKeyDefaultTTL)
+		expiresAt = &expiry
+	}
+	if !expiresAt.After(now) || expiresAt.After(now.Add(s.cfg.APIKeyMaxTTL)) {
+		return nil, ErrInvalidExpiry
+	}
+
+	prefix, err := utils.GenerateRandomToken(6)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	// The prefix is used to find the key, so it must not contain the separator
+	prefix = apiKeyPrefix + "_" + strings.NewReplacer("_", "x", "-", "y").Replace(prefix)
+	raw := prefix + "_" + secret
+
+	key := &models.APIKey{
+		UserID:    accountID,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   utils.HashToken(raw),
+		Scopes:    strings.Join(normalized, ","),
+		ExpiresAt: expiresAt,
+		CreatedBy: &actor.ID,
+	}
+	if err := s.apiKeyRepo.Create(key); err != nil {
+		return nil, err
+	}
+	return &CreatedAPIKey{Key: raw, APIKey: key}, nil
+}
+
+// ListKeys returns the API keys, optionally those of one service account
+func (s *APIKeyService) ListKeys(accountID uint) ([]models.APIKey, error) {
+	return s.apiKeyRepo.List(accountID)
+}
+
+// RevokeKey revokes an API key
+func (s *APIKeyService) RevokeKey(id uint) error {
+	revoked, err := s.apiKeyRepo.Revoke(id)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// Authenticate checks a raw API key and returns it with its service account
+func (s *APIKeyService) Authenticate(raw, ip string) (*models.APIKey, *models.User, error) {
+	// Keys look like sms_<prefix>_<secret>
+	parts := strings.SplitN(raw, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyPrefix {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	key, err := s.apiKeyRepo.FindByPrefix(parts[0] + "_" + parts[1])
+	if err != nil || subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(utils.HashToken(raw))) != 1 {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && !key.ExpiresAt.After(now)) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	account, err := s.GetServiceAccount(key.UserID)
+	if err != nil {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval || key.LastUsedIP != ip {
+		if err := s.apiKeyRepo.Touch(key.ID, ip, now); err != nil {
+			return nil, nil, err
+		}
+		key.LastUsedAt = &now
+		key.LastUsedIP = ip
+	}
+	return key, account, nil
+}
+
+// ScopeAllows reports whether scopes grant access to area; write access is
+// needed unless the request only reads
+func ScopeAllows(scopes []string, area string, write bool) bool {
+	for _, scope := range scopes {
+		scopeArea, access, _ := strings.Cut(scope, ":")
+		if scopeArea != area {
+			continue
+		}
+		if access == "write" || !write {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeScopes validates and de-duplicates scopes
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, ErrInvalidScope
+	}
+	seen := map[string]bool{}
+	var normalized []string
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		area, access, ok := strings.Cut(scope, ":")
+		if !ok || !scopeAreas[area] || (access != "read" && access != "write") {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			normalized = append(normalized, scope)
+		}
+	}
+	return normalized, nil
+}
@@ -2,76 +2,166 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"gorm.io/gorm"
 
+	"github.com/E-Timileyin/school-management-system/internal/config"
 	"github.com/E-Timileyin/school-management-system/internal/model"
 )
 
 // AuthService handles user authentication and authorization
 type AuthService struct {
-	db *gorm.DB // Database connection
+	db               *gorm.DB          // Database connection
+	cfg              config.AuthConfig // Account settings
+	rolePolicy       *RolePolicy       // Decides which roles may self-register
+	tokenService     *TokenService     // Issues access and challenge tokens
+	twoFactorService *TwoFactorService // Verifies second factors
+	loginGuard       *LoginGuard       // Brute-force protection
+	sessionService   *SessionService   // Tracks issued access tokens
 }
 
 // NewAuthService creates a new instance of AuthService
-func NewAuthService(db *gorm.DB) *AuthService {
-	return &AuthService{db: db}
+func NewAuthService(
+	db *gorm.DB,
+	cfg config.AuthConfig,
+	rolePolicy *RolePolicy,
+	tokenService *TokenService,
+	twoFactorService *TwoFactorService,
+	loginGuard *LoginGuard,
+	sessionService *SessionService,
+) *AuthService {
+	return &AuthService{
+		db:               db,
+		cfg:              cfg,
+		rolePolicy:       rolePolicy,
+		tokenService:     tokenService,
+		twoFactorService: twoFactorService,
+		loginGuard:       loginGuard,
+		sessionService:   sessionService,
+	}
+}
+
+// WithContext returns a copy of the service bound to ctx
+func (s *AuthService) WithContext(ctx context.Context) *AuthService {
+	clone := *s
+	clone.db = s.db.WithContext(ctx)
+	clone.twoFactorService = s.twoFactorService.WithContext(ctx)
+	clone.sessionService = s.sessionService.WithContext(ctx)
+	return &clone
+}
+
+// LoginResult is the outcome of a successful password check.
+// When TwoFactorRequired is set, Token is empty and ChallengeToken must be
+// exchanged together with a TOTP code through VerifyTwoFactor.
+type LoginResult struct {
+	User              *model.User
+	Token             string
+	TwoFactorRequired bool
+	ChallengeToken    string
 }
 
 // Login verifies user credentials and returns a JWT token if successful
-// Returns user details and token if login is successful
-func (s *AuthService) Login(email, password string) (*model.User, string, error) {
+// Returns user details and token if login is successful, or a challenge
+// token if the user has two-factor authentication enabled
+// client identifies the caller for brute-force protection and session tracking
+func (s *AuthService) Login(email, password string, client ClientInfo) (*LoginResult, error) {
+	// Refuse attempts while the account or IP is locked out
+	if err := s.loginGuard.Check(email, client.IP); err != nil {
+		return nil, err
+	}
+
 	// Find user by email
 	var user model.User
 	if err := s.db.Where("email = ?", email).First(&user).Error; err != nil {
-		return nil, "", fmt.Errorf("invalid credentials")
+		s.loginGuard.RecordFailure(email, client.IP, nil, "unknown email")
+		return nil, fmt.Errorf("invalid credentials")
+	}
+
+	// Service accounts and federated accounts have no usable password
+	if user.IsServiceAccount() {
+		return nil, ErrServiceAccountLogin
+	}
+	if user.IsFederated() {
+		return nil, ErrFederatedAccount
 	}
 
 	// Check if password matches
 	if err := user.CheckPassword(password); err != nil {
-		return nil, "", fmt.Errorf("invalid credentials")
+		s.loginGuard.RecordFailure(email, client.IP, &user.ID, "wrong password")
+		return nil, fmt.Errorf("invalid credentials")
 	}
 
-	// Save the user (without LastLogin since it was removed from the model)
-	s.db.Save(&user)
+	// Unverified accounts cannot log in until the emailed link is opened
+	if s.cfg.RequireEmailVerification && !user.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	// Second phase: the password alone only earns a challenge token
+	if user.TwoFactorEnabled {
+		challenge, err := s.tokenService.IssueChallengeToken(user.ID, user.Email)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate token: %v", err)
+		}
+		return &LoginResult{User: &user, TwoFactorRequired: true, ChallengeToken: challenge}, nil
+	}
 
 	// Generate JWT token for authenticated user
-	token, err := s.generateJWT(user.ID, string(user.Role))
+	token, _, err := s.sessionService.Create(user.ID, user.Email, string(user.Role), client)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to generate token: %v", err)
+		return nil, fmt.Errorf("failed to generate token: %v", err)
 	}
+	s.loginGuard.RecordSuccess(user.Email, client.IP, user.ID)
 
-	return &user, token, nil
+	return &LoginResult{User: &user, Token: token}, nil
 }
 
-// generateJWT creates a signed JWT token for authenticated users
-// Token includes user ID, role, and expiration time
-func (s *AuthService) generateJWT(userID uint, role string) (string, error) {
-	// TODO: Move secret key to environment variable
-	jwtSecret := []byte("your-secret-key")
-
-	// Set token claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,                     // User identifier
-		"role":    role,                       // User role (admin, teacher, student)
-		"exp":     time.Now().Add(time.Hour * 24).Unix(), // Token expires in 24 hours
-	})
-
-	// Sign token with secret key
-	tokenString, err := token.SignedString(jwtSecret)
+// VerifyTwoFactor completes a two-phase login with a TOTP or recovery code
+// Returns user details and an access token if the code is valid
+func (s *AuthService) VerifyTwoFactor(challengeToken, code string, client ClientInfo) (*model.User, string, error) {
+	userID, err := s.tokenService.ParseChallengeToken(challengeToken)
 	if err != nil {
-		return "", fmt.Errorf("failed to sign token: %v", err)
+		return nil, "", err
 	}
 
-	return tokenString, nil
+	var user model.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		return nil, "", fmt.Errorf("invalid credentials")
+	}
+
+	// Second factor guesses count towards the same lockout as passwords
+	if err := s.loginGuard.Check(user.Email, client.IP); err != nil {
+		return nil, "", err
+	}
+
+	if err := s.twoFactorService.Verify(userID, code); err != nil {
+		if errors.Is(err, ErrTwoFactorNotEnabled) || errors.Is(err, ErrInvalidTwoFactorCode) {
+			s.loginGuard.RecordFailure(user.Email, client.IP, &user.ID, "wrong two-factor code")
+			return nil, "", ErrInvalidTwoFactorCode
+		}
+		return nil, "", err
+	}
+
+	token, _, err := s.sessionService.Create(user.ID, user.Email, string(user.Role), client)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	s.loginGuard.RecordSuccess(user.Email, client.IP, user.ID)
+
+	return &user, token, nil
 }
 
 // Signup creates a new user account
 // Returns the created user or an error if registration fails
 func (s *AuthService) Signup(email, password, firstName, lastName, role string) (*model.User, error) {
+	// Only roles allowed by the self-registration policy can be requested
+	role, err := s.rolePolicy.SelfRegistrationRole(role)
+	if err != nil {
+		return nil, err
+	}
+
 	// Check if email is already registered
 	var existingUser model.User
 	if err := s.db.Where("email = ?", email).First(&existingUser).Error; err == nil {
@@ -108,10 +198,12 @@ func (s *AuthService) Register(user *model.User) error {
 		return fmt.Errorf("email already registered")
 	}
 
-	// Set default role if not provided
-	if user.Role == "" {
-		user.Role = model.RoleStudent // Default role
+	// Resolve the role through the self-registration policy
+	role, err := s.rolePolicy.SelfRegistrationRole(string(user.Role))
+	if err != nil {
+		return err
 	}
+	user.Role = model.UserRole(role)
 
 	// Save user to database (password should be set using SetPassword before calling Register)
 	if user.Password == "" {
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/E-Timileyin/school-management-system/internal/config"
+	"github.com/E-Timileyin/school-management-system/internal/model"
+)
+
+var ErrLoanRefused = errors.New("loan refused by the circulation policy")
+
+// Reasons the circulation policy refuses a loan. They are stable so clients
+// can show their own message for each.
+const (
+	RefusalNoCard       = "no_library_card"
+	RefusalCardBlocked  = "card_blocked"
+	RefusalCardExpired  = "card_expired"
+	RefusalNotAllowed   = "borrowing_not_allowed"
+	RefusalLoanLimit    = "loan_limit_reached"
+	RefusalUnpaidFines  = "fines_outstanding"
+	RefusalOverdueLoans = "overdue_loans"
+
+	RefusalRenewalLimit = "renewal_limit_reached"
+	RefusalReserved     = "reserved_by_others"
+	RefusalTooOverdue   = "overdue_beyond_grace"
+)
+
+// PolicyError explains why a loan was refused
+type PolicyError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Details carries the numbers behind the refusal, e.g. the loan limit
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+func (e *PolicyError) Error() string {
+	return e.Message
+}
+
+func (e *PolicyError) Unwrap() error {
+	return ErrLoanRefused
+}
+
+// LoanTerms are how many books a borrower may hold and for how long
+type LoanTerms struct {
+	Limit  int           `json:"limit"`
+	Period time.Duration `json:"-"`
+	Days   int           `json:"days"`
+}
+
+// Borrower is what the circulation policy looks at to decide on a loan
+type Borrower struct {
+	Role         string
+	Card         *model.LibraryCard // nil if the user has no card
+	OpenLoans    int64
+	OverdueLoans int64
+	UnpaidFines  decimal.Decimal
+}
+
+// CirculationPolicy decides who may borrow and on what terms
+type CirculationPolicy struct {
+	cfg config.LibraryConfig
+}
+
+func NewCirculationPolicy(cfg config.LibraryConfig) *CirculationPolicy {
+	return &CirculationPolicy{cfg: cfg}
+}
+
+// Terms returns the loan terms of a role and whether the role may borrow
+func (p *CirculationPolicy) Terms(role string) (LoanTerms, bool) {
+	limit, ok := p.cfg.LoanLimits[role]
+	if !ok || limit <= 0 {
+		return LoanTerms{}, false
+	}
+	days, ok := p.cfg.LoanDays[role]
+	if !ok || days <= 0 {
+		days = p.cfg.DefaultLoanDays
+	}
+	return LoanTerms{Limit: limit, Days: days, Period: time.Duration(days) * 24 * time.Hour}, true
+}
+
+// BorrowerTerms returns the loan terms of a borrower: those of their role,
+// with the loan limit of their card if staff gave it one
+func (p *CirculationPolicy) BorrowerTerms(borrower Borrower) (LoanTerms, bool) {
+	terms, ok := p.Terms(borrower.Role)
+	if ok && borrower.Card != nil && borrower.Card.CustomLimit {
+		terms.Limit = borrower.Card.MaxBooks
+	}
+	return terms, ok
+}
+
+// PickupWindow is how long a copy is held for a reader whose reservation
+// came up
+func (p *CirculationPolicy) PickupWindow() time.Duration {
+	return p.cfg.HoldPickupWindow
+}
+
+// DueSoonWindow is how long before the due date borrowers are reminded of
+// a loan
+func (p *CirculationPolicy) DueSoonWindow() time.Duration {
+	return p.cfg.DueSoonWindow
+}
+
+// OverdueReminderInterval is how often borrowers are reminded of an
+// overdue loan
+func (p *CirculationPolicy) OverdueReminderInterval() time.Duration {
+	return p.cfg.OverdueReminderInterval
+}
+
+// CheckLoan returns the terms of a new loan to borrower, or a PolicyError
+// with the first reason the loan must be refused
+func (p *CirculationPolicy) CheckLoan(borrower Borrower, now time.Time) (LoanTerms, error) {
+	terms, err := p.checkBorrower(borrower, now)
+	if err != nil {
+		return LoanTerms{}, err
+	}
+
+	if borrower.UnpaidFines.GreaterThan(p.cfg.MaxUnpaidFines) {
+		return LoanTerms{}, refuse(RefusalUnpaidFines, "unpaid fines exceed the borrowing limit",
+			map[string]interface{}{"unpaid_fines": borrower.UnpaidFines, "max_unpaid_fines": p.cfg.MaxUnpaidFines})
+	}
+	if p.cfg.BlockOnOverdue && borrower.OverdueLoans > 0 {
+		return LoanTerms{}, refuse(RefusalOverdueLoans, "overdue books must be returned first",
+			map[string]interface{}{"overdue_loans": borrower.OverdueLoans})
+	}
+	if borrower.OpenLoans >= int64(terms.Limit) {
+		return LoanTerms{}, refuse(RefusalLoanLimit,
+			fmt.Sprintf("the borrower already has %d of %d books on loan", borrower.OpenLoans, terms.Limit),
+			map[string]interface{}{"open_loans": borrower.OpenLoans, "limit": terms.Limit})
+	}
+	return terms, nil
+}
+
+// CheckRenewal returns the new due date of issue, or a PolicyError with the
+// first reason it cannot be renewed. queued is the number of readers
+// waiting for the title. The loan is extended by the borrower's loan period
+// from its due date, or from now if it is overdue.
+func (p *CirculationPolicy) CheckRenewal(borrower Borrower, issue *model.BookIssue, queued int64, now time.Time) (time.Time, error) {
+	terms, err := p.checkBorrower(borrower, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if issue.RenewalCount >= p.cfg.MaxRenewals {
+		return time.Time{}, refuse(RefusalRenewalLimit, "the loan has been renewed as often as allowed",
+			map[string]interface{}{"renewals": issue.RenewalCount, "max_renewals": p.cfg.MaxRenewals})
+	}
+	if queued > 0 {
+		return time.Time{}, refuse(RefusalReserved, "other readers are waiting for this book",
+			map[string]interface{}{"reservations": queued})
+	}
+	if now.After(issue.DueDate.Add(p.cfg.RenewalGracePeriod)) {
+		return time.Time{}, refuse(RefusalTooOverdue, "the loan is too far overdue to renew, return the book",
+			map[string]interface{}{"due_date": issue.DueDate, "grace_period": p.cfg.RenewalGracePeriod.String()})
+	}
+
+	from := issue.DueDate
+	if now.After(from) {
+		from = now
+	}
+	return from.Add(terms.Period), nil
+}
+
+// checkBorrower refuses borrowers without a usable card or whose role may
+// not borrow, and otherwise returns their loan terms
+func (p *CirculationPolicy) checkBorrower(borrower Borrower, now time.Time) (LoanTerms, error) {
+	card := borrower.Card
+	switch {
+	case card == nil:
+		return LoanTerms{}, refuse(RefusalNoCard, "the borrower has no library card", nil)
+	case card.Status == "blocked":
+		return LoanTerms{}, refuse(RefusalCardBlocked, "the library card is blocked", nil)
+	case card.Status == "expired" || !card.ExpiryDate.After(now):
+		return LoanTerms{}, refuse(RefusalCardExpired, "the library card has expired",
+			map[string]interface{}{"expiry_date": card.ExpiryDate})
+	case card.Status != "active":
+		return LoanTerms{}, refuse(RefusalCardBlocked, fmt.Sprintf("the library card is %s", card.Status), nil)
+	}
+
+	terms, ok := p.BorrowerTerms(borrower)
+	if !ok {
+		return LoanTerms{}, refuse(RefusalNotAllowed,
+			fmt.Sprintf("users with the %s role cannot borrow books", borrower.Role), nil)
+	}
+	return terms, nil
+}
+
+func refuse(code, message string, details map[string]interface{}) *PolicyError {
+	return &PolicyError{Code: code, Message: message, Details: details}
+}
@@ -0,0 +1,426 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/E-Timileyin/school-management-system/internal/model"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+)
+
+var (
+	ErrCommunicationNotFound   = errors.New("communication not found")
+	ErrInvalidCommunication    = errors.New("title, content, a comm_type of notice, event or news and an audience of all, students, teachers, parents or staff are required")
+	ErrInvalidPublishWindow    = errors.New("end_date must be after start_date")
+	ErrInvalidTargetClass      = errors.New("target_class_id must be an existing class, for the students audience")
+	ErrInvalidTargetUser       = errors.New("target_user_id must be an existing user")
+	ErrInvalidAttachment       = errors.New("attachments need a file_name and an http(s) file_url")
+	ErrCommunicationPublished  = errors.New("the communication is already published")
+	ErrCommunicationNotStarted = errors.New("a start_date in the future is required to schedule")
+	ErrCommunicationEnded      = errors.New("the communication has ended")
+	ErrNotCommunicationAuthor  = errors.New("only the author or an admin can manage this communication")
+)
+
+// audienceRoles are the user roles each audience reaches; nil means all
+var audienceRoles = map[model.AudienceType][]string{
+	model.AudienceAll:      nil,
+	model.AudienceStudents: {models.RoleStudent},
+	model.AudienceTeachers: {models.RoleTeacher},
+	model.AudienceParents:  {models.RoleParent},
+	model.AudienceStaff:    {models.RoleAdmin, models.RoleTeacher, models.RoleLibrarian},
+}
+
+var communicationTypes = map[model.CommunicationType]bool{
+	model.CommunicationTypeNotice: true,
+	model.CommunicationTypeEvent:  true,
+	model.CommunicationTypeNews:   true,
+}
+
+// FeedItem is a communication in a reader's feed
+type FeedItem struct {
+	model.Communication
+	ReadAt *time.Time `json:"read_at"` // nil while unread
+}
+
+// Feed is a page of a reader's feed
+type Feed struct {
+	Items  []FeedItem `json:"items"`
+	Total  int64      `json:"total"`
+	Unread int64      `json:"unread"` // Unread items in the whole feed
+}
+
+// ReadReceipts shows who has read a communication and who it reaches that
+// has not yet
+type ReadReceipts struct {
+	CommunicationID uint                             `json:"communication_id"`
+	Read            []repository.CommunicationReader `json:"read"`
+	Unread          []UnreadRecipient                `json:"unread"`
+}
+
+// UnreadRecipient is a user a communication reaches who has not read it
+type UnreadRecipient struct {
+	UserID    uint   `json:"user_id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+}
+
+// CommunicationService runs the notice board: authors draft, schedule and
+// publish communications, and readers get the ones meant for them
+type CommunicationService struct {
+	communicationRepo *repository.CommunicationRepository
+}
+
+func NewCommunicationService(communicationRepo *repository.CommunicationRepository) *CommunicationService {
+	return &CommunicationService{communicationRepo: communicationRepo}
+}
+
+// WithContext returns a copy of the service bound to ctx
+func (s *CommunicationService) WithContext(ctx context.Context) *CommunicationService {
+	clone := *s
+	clone.communicationRepo = s.communicationRepo.WithContext(ctx)
+	return &clone
+}
+
+// CreateCommunication saves a draft by author
+func (s *CommunicationService) CreateCommunication(author *models.User, communication *model.Communication) error {
+	communication.ID = 0
+	communication.AuthorID = author.ID
+	communication.IsPublished = false
+	communication.IsScheduled = false
+	communication.PublishedAt = nil
+	if err := s.validate(communication); err != nil {
+		return err
+	}
+	return s.communicationRepo.Create(communication)
+}
+
+// GetCommunication returns a communication to its author or an admin
+func (s *CommunicationService) GetCommunication(id uint, actor *models.User) (*model.Communication, error) {
+	return s.findOwned(id, actor)
+}
+
+// ListCommunications lists the communications an author wrote, or all of
+// them for admins. status is draft, scheduled, published, expired or empty.
+func (s *CommunicationService) ListCommunications(actor *models.User, status string) ([]model.Communication, error) {
+	filter := repository.CommunicationFilter{Status: status}
+	if actor.Role != models.RoleAdmin {
+		filter.AuthorID = actor.ID
+	}
+	return s.communicationRepo.List(filter, time.Now())
+}
+
+// UpdateCommunication changes the content, audience or publish window of a
+// communication. Published ones can be corrected too; readers who already
+// read them keep their receipt.
+func (s *CommunicationService) UpdateCommunication(id uint, actor *models.User, changes *model.Communication) (*model.Communication, error) {
+	communication, err := s.findOwned(id, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	communication.Title = changes.Title
+	communication.Content = changes.Content
+	communication.CommType = changes.CommType
+	communication.Audience = changes.Audience
+	communication.StartDate = changes.StartDate
+	communication.EndDate = changes.EndDate
+	communication.IsImportant = changes.IsImportant
+	communication.TargetClassID = changes.TargetClassID
+	communication.TargetUserID = changes.TargetUserID
+	communication.Location = changes.Location
+	communication.IsAllDay = changes.IsAllDay
+	communication.Attachments = changes.Attachments
+	if err := s.validate(communication); err != nil {
+		return nil, err
+	}
+	// The scheduler publishes at the start date, so a scheduled
+	// communication cannot lose it; unpublish it to make it a draft
+	if communication.IsScheduled && communication.StartDate == nil {
+		return nil, ErrCommunicationNotStarted
+	}
+	// A scheduled communication moved to a start date that has passed goes
+	// out with the next scheduler run
+	if err := s.communicationRepo.Update(communication); err != nil {
+		return nil, err
+	}
+	return s.communicationRepo.GetByID(communication.ID)
+}
+
+// DeleteCommunication moves a communication to the trash
+func (s *CommunicationService) DeleteCommunication(id uint, actor *models.User) error {
+	if _, err := s.findOwned(id, actor); err != nil {
+		return err
+	}
+	return s.communicationRepo.Delete(id)
+}
+
+// PublishCommunication publishes a draft or scheduled communication now.
+// Its start date becomes now unless it is already in the past.
+func (s *CommunicationService) PublishCommunication(id uint, actor *models.User) (*model.Communication, error) {
+	communication, err := s.findOwned(id, actor)
+	if err != nil {
+		return nil, err
+	}
+	if communication.IsPublished {
+		return nil, ErrCommunicationPublished
+	}
+	now := time.Now()
+	if communication.EndDate != nil && !communication.EndDate.After(now) {
+		return nil, ErrCommunicationEnded
+	}
+
+	if communication.StartDate == nil || communication.StartDate.After(now) {
+		communication.StartDate = &now
+	}
+	communication.IsScheduled = false
+	communication.IsPublished = true
+	communication.PublishedAt = &now
+	communication.Attachments = nil
+	if err := s.communicationRepo.Update(communication); err != nil {
+		return nil, err
+	}
+	return s.communicationRepo.GetByID(communication.ID)
+}
+
+// ScheduleCommunication has the scheduler publish a draft at its start
+// date
+func (s *CommunicationService) ScheduleCommunication(id uint, actor *models.User) (*model.Communication, error) {
+	communication, err := s.findOwned(id, actor)
+	if err != nil {
+		return nil, err
+	}
+	if communication.IsPublished {
+		return nil, ErrCommunicationPublished
+	}
+	if communication.StartDate == nil || !communication.StartDate.After(time.Now()) {
+		return nil, ErrCommunicationNotStarted
+	}
+
+	communication.IsScheduled = true
+	communication.Attachments = nil
+	if err := s.communicationRepo.Update(communication); err != nil {
+		return nil, err
+	}
+	return s.communicationRepo.GetByID(communication.ID)
+}
+
+// UnpublishCommunication takes a communication off readers' feeds, or out
+// of the schedule, and makes it a draft again
+func (s *CommunicationService) UnpublishCommunication(id uint, actor *models.User) (*model.Communication, error) {
+	communication, err := s.findOwned(id, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	communication.IsScheduled = false
+	communication.IsPublished = false
+	communication.PublishedAt = nil
+	communication.Attachments = nil
+	if err := s.communicationRepo.Update(communication); err != nil {
+		return nil, err
+	}
+	return s.communicationRepo.GetByID(communication.ID)
+}
+
+// GetReadReceipts shows who has read a communication and who has not
+func (s *CommunicationService) GetReadReceipts(id uint, actor *models.User) (*ReadReceipts, error) {
+	communication, err := s.findOwned(id, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	read, err := s.communicationRepo.ListReaders(communication.ID)
+	if err != nil {
+		return nil, err
+	}
+	users, err := s.communicationRepo.ListUnread(communication, audienceRoles[communication.Audience])
+	if err != nil {
+		return nil, err
+	}
+	receipts := &ReadReceipts{CommunicationID: communication.ID, Read: read, Unread: make([]UnreadRecipient, len(users))}
+	for i, user := range users {
+		receipts.Unread[i] = UnreadRecipient{UserID: user.ID, FirstName: user.FirstName, LastName: user.LastName, Email: user.Email, Role: user.Role}
+	}
+	return receipts, nil
+}
+
+// Feed returns a page of the communications reader may see now
+func (s *CommunicationService) Feed(reader *models.User, limit, offset int) (*Feed, error) {
+	feedReader, err := s.feedReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	communications, total, err := s.communicationRepo.Feed(feedReader, now, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	unread, err := s.communicationRepo.CountUnread(feedReader, now)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, len(communications))
+	for i := range communications {
+		ids[i] = communications[i].ID
+	}
+	reads, err := s.communicationRepo.ReadTimes(reader.ID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	feed := &Feed{Items: make([]FeedItem, len(communications)), Total: total, Unread: unread}
+	for i := range communications {
+		feed.Items[i] = FeedItem{Communication: communications[i]}
+		if readAt, ok := reads[communications[i].ID]; ok {
+			feed.Items[i].ReadAt = &readAt
+		}
+	}
+	return feed, nil
+}
+
+// ReadCommunication returns a communication from reader's feed and records
+// that they read it
+func (s *CommunicationService) ReadCommunication(id uint, reader *models.User) (*FeedItem, error) {
+	communication, err := s.communicationRepo.GetByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCommunicationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	feedReader, err := s.feedReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if !reaches(communication, feedReader, now) {
+		return nil, ErrCommunicationNotFound
+	}
+
+	if err := s.communicationRepo.MarkRead(communication.ID, reader.ID, now); err != nil {
+		return nil, err
+	}
+	reads, err := s.communicationRepo.ReadTimes(reader.ID, []uint{communication.ID})
+	if err != nil {
+		return nil, err
+	}
+	readAt := reads[communication.ID]
+	return &FeedItem{Communication: *communication, ReadAt: &readAt}, nil
+}
+
+// PublishScheduled publishes the communications scheduled to start by now
+// and returns how many were published
+func (s *CommunicationService) PublishScheduled() (int64, error) {
+	return s.communicationRepo.PublishDue(time.Now())
+}
+
+// feedReader works out which communications reach a user
+func (s *CommunicationService) feedReader(user *models.User) (repository.FeedReader, error) {
+	reader := repository.FeedReader{UserID: user.ID}
+	for audience, roles := range audienceRoles {
+		if roles == nil || slices.Contains(roles, user.Role) {
+			reader.Audiences = append(reader.Audiences, audience)
+		}
+	}
+	if user.Role == models.RoleStudent {
+		classID, err := s.communicationRepo.StudentClassID(user.ID)
+		if err != nil {
+			return reader, err
+		}
+		reader.ClassID = classID
+	}
+	return reader, nil
+}
+
+// reaches is the check of CommunicationRepository.Feed for one
+// communication
+func reaches(communication *model.Communication, reader repository.FeedReader, now time.Time) bool {
+	switch {
+	case !communication.IsPublished,
+		communication.StartDate != nil && communication.StartDate.After(now),
+		communication.EndDate != nil && !communication.EndDate.After(now):
+		return false
+	case communication.TargetUserID != nil:
+		return *communication.TargetUserID == reader.UserID
+	case communication.TargetClassID != nil && (reader.ClassID == nil || *reader.ClassID != *communication.TargetClassID):
+		return false
+	}
+	for _, audience := range reader.Audiences {
+		if audience == communication.Audience {
+			return true
+		}
+	}
+	return false
+}
+
+// findOwned loads a communication that actor, its author or an admin, may
+// manage
+func (s *CommunicationService) findOwned(id uint, actor *models.User) (*model.Communication, error) {
+	communication, err := s.communicationRepo.GetByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCommunicationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if communication.AuthorID != actor.ID && actor.Role != models.RoleAdmin {
+		return nil, ErrNotCommunicationAuthor
+	}
+	return communication, nil
+}
+
+func (s *CommunicationService) validate(communication *model.Communication) error {
+	communication.Title = strings.TrimSpace(communication.Title)
+	communication.Content = strings.TrimSpace(communication.Content)
+	communication.Location = strings.TrimSpace(communication.Location)
+	if _, ok := audienceRoles[communication.Audience]; !ok ||
+		communication.Title == "" || communication.Content == "" || !communicationTypes[communication.CommType] {
+		return ErrInvalidCommunication
+	}
+	if communication.StartDate != nil && communication.EndDate != nil && !communication.EndDate.After(*communication.StartDate) {
+		return ErrInvalidPublishWindow
+	}
+
+	if communication.TargetClassID != nil {
+		if communication.Audience != model.AudienceStudents {
+			return ErrInvalidTargetClass
+		}
+		exists, err := s.communicationRepo.ClassExists(*communication.TargetClassID)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrInvalidTargetClass
+		}
+	}
+
+	if communication.TargetUserID != nil {
+		exists, err := s.communicationRepo.UserExists(*communication.TargetUserID)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrInvalidTargetUser
+		}
+	}
+
+	for i := range communication.Attachments {
+		attachment := &communication.Attachments[i]
+		attachment.FileName = strings.TrimSpace(attachment.FileName)
+		attachment.FileURL = strings.TrimSpace(attachment.FileURL)
+		if attachment.FileName == "" || attachment.FileSize < 0 ||
+			!(strings.HasPrefix(attachment.FileURL, "https://") || strings.HasPrefix(attachment.FileURL, "http://")) {
+			return ErrInvalidAttachment
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/E-Timileyin/school-management-system/internal/model"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+)
+
+func newTestCommunicationService(t *testing.T) (*CommunicationService, *gorm.DB, *models.User) {
+	t.Helper()
+	db := newTestDB(t)
+	author := &models.User{Email: "teacher@example.com", Password: "x", FirstName: "Ann", LastName: "Smith", Role: models.RoleTeacher}
+	if err := db.Create(author).Error; err != nil {
+		t.Fatal(err)
+	}
+	return NewCommunicationService(repository.NewCommunicationRepository(db)), db, author
+}
+
+func newNotice() *model.Communication {
+	return &model.Communication{Title: "Trip", Content: "To the zoo", CommType: model.CommunicationTypeNotice, Audience: model.AudienceAll}
+}
+
+func TestScheduledCommunicationKeepsStartDate(t *testing.T) {
+	service, _, author := newTestCommunicationService(t)
+	communication := newNotice()
+	start := time.Now().Add(time.Hour)
+	communication.StartDate = &start
+	if err := service.CreateCommunication(author, communication); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.ScheduleCommunication(communication.ID, author); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.UpdateCommunication(communication.ID, author, newNotice()); !errors.Is(err, ErrCommunicationNotStarted) {
+		t.Fatalf("got %v, want ErrCommunicationNotStarted", err)
+	}
+
+	// As a draft it needs no start date
+	if _, err := service.UnpublishCommunication(communication.ID, author); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := service.UpdateCommunication(communication.ID, author, newNotice())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.IsScheduled || updated.StartDate != nil {
+		t.Fatalf("got %+v", updated)
+	}
+}
+
+func TestCommunicationTargetUserMustExist(t *testing.T) {
+	service, db, author := newTestCommunicationService(t)
+	parent := &models.User{Email: "parent@example.com", Password: "x", FirstName: "Bo", LastName: "Smith", Role: models.RoleParent}
+	if err := db.Create(parent).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	communication := newNotice()
+	missing := parent.ID + 100
+	communication.TargetUserID = &missing
+	if err := service.CreateCommunication(author, communication); !errors.Is(err, ErrInvalidTargetUser) {
+		t.Fatalf("got %v, want ErrInvalidTargetUser", err)
+	}
+
+	communication = newNotice()
+	communication.TargetUserID = &parent.ID
+	if err := service.CreateCommunication(author, communication); err != nil {
+		t.Fatal(err)
+	}
+
+	// Users in the trash are not reached either
+	if err := db.Delete(parent).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.UpdateCommunication(communication.ID, author, communication); !errors.Is(err, ErrInvalidTargetUser) {
+		t.Fatalf("got %v, want ErrInvalidTargetUser", err)
+	}
+}
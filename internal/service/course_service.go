@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+
 	"github.com/E-Timileyin/school-management-system/internal/models"
 	"github.com/E-Timileyin/school-management-system/internal/repository"
 )
@@ -13,6 +15,13 @@ func NewCourseService(courseRepo *repository.CourseRepository) *CourseService {
 	return &CourseService{courseRepo: courseRepo}
 }
 
+// WithContext returns a copy of the service bound to ctx
+func (s *CourseService) WithContext(ctx context.Context) *CourseService {
+	clone := *s
+	clone.courseRepo = s.courseRepo.WithContext(ctx)
+	return &clone
+}
+
 func (s *CourseService) CreateCourse(course *models.Course) error {
 	return s.courseRepo.Create(course)
 }
@@ -1,6 +1,10 @@
 package service
 
-import "github.com/E-Timileyin/school-management-system/internal/repository"
+import (
+	"context"
+
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+)
 
 type EnrollmentService struct {
 	enrollmentRepo *repository.EnrollmentRepository
@@ -10,6 +14,13 @@ func NewEnrollmentService(enrollmentRepo *repository.EnrollmentRepository) *Enro
 	return &EnrollmentService{enrollmentRepo: enrollmentRepo}
 }
 
+// WithContext returns a copy of the service bound to ctx
+func (s *EnrollmentService) WithContext(ctx context.Context) *EnrollmentService {
+	clone := *s
+	clone.enrollmentRepo = s.enrollmentRepo.WithContext(ctx)
+	return &clone
+}
+
 func (s *EnrollmentService) DeleteEnrollment(id uint) error {
 	return s.enrollmentRepo.Delete(id)
 }
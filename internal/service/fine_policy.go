@@ -0,0 +1,113 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/E-Timileyin/school-management-system/internal/config"
+	"github.com/E-Timileyin/school-management-system/internal/model"
+)
+
+// DateLayout formats calendar days, such as holidays
+const DateLayout = "2006-01-02"
+
+// FineQuote is an overdue fine with how it was worked out
+type FineQuote struct {
+	Amount decimal.Decimal `json:"amount"`
+	// Accruing is set while the loan is open and the fine still grows
+	Accruing  bool            `json:"accruing"`
+	DailyRate decimal.Decimal `json:"daily_rate"`
+	// DaysOverdue counts calendar days past the due date; ChargeableDays
+	// leaves out weekends, holidays and grace days
+	DaysOverdue    int  `json:"days_overdue"`
+	ChargeableDays int  `json:"chargeable_days"`
+	Capped         bool `json:"capped"`
+	// LostCharge is what the borrower pays if the book is lost or comes back
+	// damaged
+	LostCharge decimal.Decimal `json:"lost_charge"`
+}
+
+// FinePolicy works out overdue fines and lost book charges
+type FinePolicy struct {
+	cfg config.LibraryConfig
+}
+
+func NewFinePolicy(cfg config.LibraryConfig) *FinePolicy {
+	return &FinePolicy{cfg: cfg}
+}
+
+// DailyRate returns the rate charged per overdue day for a book in category
+// borrowed by a user with role. A category rate takes precedence over a
+// role rate, which takes precedence over the default.
+func (p *FinePolicy) DailyRate(category, role string) decimal.Decimal {
+	if rate, ok := p.cfg.FineCategoryRates[strings.ToLower(category)]; ok {
+		return rate
+	}
+	if rate, ok := p.cfg.FineRoleRates[role]; ok {
+		return rate
+	}
+	return p.cfg.FineDailyRate
+}
+
+// Overdue works out the fine for a loan due at due and returned, or still
+// out, at until. Days are calendar days in the server's time zone; a loan
+// is overdue from the day after its due date. holidays are the closures
+// that overlap the overdue days.
+func (p *FinePolicy) Overdue(due, until time.Time, rate decimal.Decimal, holidays []model.LibraryHoliday) FineQuote {
+	quote := FineQuote{Amount: decimal.Zero, DailyRate: rate}
+
+	closed := closedDates(holidays)
+	day := startOfDay(due).AddDate(0, 0, 1)
+	last := startOfDay(until)
+	for ; !day.After(last); day = day.AddDate(0, 0, 1) {
+		quote.DaysOverdue++
+		if p.cfg.FineSkipWeekends && (day.Weekday() == time.Saturday || day.Weekday() == time.Sunday) {
+			continue
+		}
+		if closed[day.Format(DateLayout)] {
+			continue
+		}
+		quote.ChargeableDays++
+	}
+
+	quote.ChargeableDays -= p.cfg.FineGraceDays
+	if quote.ChargeableDays < 0 {
+		quote.ChargeableDays = 0
+	}
+	quote.Amount = rate.Mul(decimal.NewFromInt(int64(quote.ChargeableDays))).Round(2)
+	if p.cfg.FineMaxPerItem.IsPositive() && quote.Amount.GreaterThan(p.cfg.FineMaxPerItem) {
+		quote.Amount = p.cfg.FineMaxPerItem
+		quote.Capped = true
+	}
+	return quote
+}
+
+// LostCharge is what a borrower pays for losing or damaging a book: its
+// price, or the default price when it has none, plus the lost book fee
+func (p *FinePolicy) LostCharge(book *model.Book) decimal.Decimal {
+	price := book.Price.Round(2)
+	if !price.IsPositive() {
+		price = p.cfg.LostBookDefaultPrice
+	}
+	return price.Add(p.cfg.LostBookFee)
+}
+
+// closedDates lists the days covered by holidays. Holiday dates are stored
+// without a time zone and read back as midnight UTC.
+func closedDates(holidays []model.LibraryHoliday) map[string]bool {
+	closed := map[string]bool{}
+	for _, holiday := range holidays {
+		end := holiday.EndDate.UTC()
+		for day := holiday.StartDate.UTC(); !day.After(end); day = day.AddDate(0, 0, 1) {
+			closed[day.Format(DateLayout)] = true
+		}
+	}
+	return closed
+}
+
+func startOfDay(t time.Time) time.Time {
+	t = t.In(time.Local)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/E-Timileyin/school-management-system/internal/config"
+	"github.com/E-Timileyin/school-management-system/internal/model"
+)
+
+// march returns noon on a day in March 2026 in the server's time zone.
+// March 2 is a Monday.
+func march(d int) time.Time {
+	return time.Date(2026, time.March, d, 12, 0, 0, 0, time.Local)
+}
+
+// closure is a library holiday as it is read back from the database
+func closure(from, to int) model.LibraryHoliday {
+	return model.LibraryHoliday{
+		StartDate: time.Date(2026, time.March, from, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2026, time.March, to, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestOverdue(t *testing.T) {
+	rate := decimal.RequireFromString("0.5")
+	tests := []struct {
+		name     string
+		cfg      config.LibraryConfig
+		due      time.Time
+		until    time.Time
+		holidays []model.LibraryHoliday
+		days     int
+		charged  int
+		amount   string
+		capped   bool
+	}{
+		{name: "not yet due", due: march(6), until: march(4), amount: "0"},
+		{name: "returned on the due date", due: march(6), until: march(6), amount: "0"},
+		{name: "every day counts", due: march(6), until: march(10), days: 4, charged: 4, amount: "2"},
+		{name: "grace days", cfg: config.LibraryConfig{FineGraceDays: 2}, due: march(2), until: march(5), days: 3, charged: 1, amount: "0.5"},
+		{name: "within the grace days", cfg: config.LibraryConfig{FineGraceDays: 2}, due: march(2), until: march(4), days: 2, amount: "0"},
+		{
+			name: "weekends skipped", cfg: config.LibraryConfig{FineSkipWeekends: true},
+			due: march(6), until: march(10), days: 4, charged: 2, amount: "1",
+		},
+		{
+			name: "holidays skipped", due: march(2), until: march(9), holidays: []model.LibraryHoliday{closure(4, 5), closure(20, 21)},
+			days: 7, charged: 5, amount: "2.5",
+		},
+		{
+			name: "grace days come after weekends and holidays", cfg: config.LibraryConfig{FineSkipWeekends: true, FineGraceDays: 1},
+			due: march(6), until: march(11), holidays: []model.LibraryHoliday{closure(9, 9)}, days: 5, charged: 1, amount: "0.5",
+		},
+		{
+			name: "capped per loan", cfg: config.LibraryConfig{FineMaxPerItem: decimal.RequireFromString("3")},
+			due: march(2), until: march(20), days: 18, charged: 18, amount: "3", capped: true,
+		},
+		{
+			name: "at the cap", cfg: config.LibraryConfig{FineMaxPerItem: decimal.RequireFromString("3")},
+			due: march(2), until: march(8), days: 6, charged: 6, amount: "3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quote := NewFinePolicy(tt.cfg).Overdue(tt.due, tt.until, rate, tt.holidays)
+			if quote.DaysOverdue != tt.days || quote.ChargeableDays != tt.charged ||
+				!quote.Amount.Equal(decimal.RequireFromString(tt.amount)) || quote.Capped != tt.capped {
+				t.Fatalf("got %d days, %d chargeable, amount %s, capped %v; want %d, %d, %s, %v",
+					quote.DaysOverdue, quote.ChargeableDays, quote.Amount, quote.Capped, tt.days, tt.charged, tt.amount, tt.capped)
+			}
+		})
+	}
+}
+
+func TestOverdueDayBoundary(t *testing.T) {
+	policy := NewFinePolicy(config.LibraryConfig{})
+	rate := decimal.NewFromInt(1)
+	due := time.Date(2026, time.March, 2, 23, 59, 0, 0, time.Local)
+
+	tests := []struct {
+		until time.Time
+		days  int
+	}{
+		{time.Date(2026, time.March, 2, 23, 59, 59, 0, time.Local), 0},
+		{time.Date(2026, time.March, 3, 0, 0, 0, 0, time.Local), 1},
+		{time.Date(2026, time.March, 3, 23, 59, 59, 0, time.Local), 1},
+		{time.Date(2026, time.March, 4, 0, 0, 1, 0, time.Local), 2},
+		// Times in another zone are counted in the server's
+		{time.Date(2026, time.March, 3, 0, 30, 0, 0, time.Local).UTC(), 1},
+	}
+	for _, tt := range tests {
+		quote := policy.Overdue(due, tt.until, rate, nil)
+		if quote.DaysOverdue != tt.days || !quote.Amount.Equal(decimal.NewFromInt(int64(tt.days))) {
+			t.Errorf("returned at %s: got %d days and %s, want %d", tt.until, quote.DaysOverdue, quote.Amount, tt.days)
+		}
+	}
+}
+
+func TestLostCharge(t *testing.T) {
+	policy := NewFinePolicy(config.LibraryConfig{
+		LostBookFee:          decimal.RequireFromString("2.5"),
+		LostBookDefaultPrice: decimal.RequireFromString("20"),
+	})
+
+	if got := policy.LostCharge(&model.Book{Price: decimal.RequireFromString("12.99")}); !got.Equal(decimal.RequireFromString("15.49")) {
+		t.Errorf("priced book: got %s, want 15.49", got)
+	}
+	if got := policy.LostCharge(&model.Book{}); !got.Equal(decimal.RequireFromString("22.5")) {
+		t.Errorf("book without a price: got %s, want 22.5", got)
+	}
+}
@@ -0,0 +1,297 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/E-Timileyin/school-management-system/internal/config"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+	"github.com/E-Timileyin/school-management-system/internal/utils"
+)
+
+var (
+	ErrUnknownSigningKey   = errors.New("unknown signing key")
+	ErrNoSigningKey        = errors.New("no active signing key")
+	ErrRotationUnsupported = errors.New("key rotation requires an asymmetric signing algorithm")
+)
+
+// keyReloadThrottle limits how often an unknown kid triggers a reload, so
+// tokens with made-up kids cannot hammer the database
+const keyReloadThrottle = 5 * time.Second
+
+// loadedKey is a signing key record with its parsed key material
+type loadedKey struct {
+	record models.SigningKey
+	key    *utils.JWTKey
+}
+
+// KeyManager holds the keys used to sign and verify JWTs. With an asymmetric
+// algorithm keys live in the database so every replica shares them, and are
+// rotated on a schedule. With HS256 the single JWT_SECRET is used.
+type KeyManager struct {
+	repo *repository.SigningKeyRepository
+	cfg  config.AuthConfig
+	hmac *utils.JWTKey
+
+	mu       sync.RWMutex
+	keys     []loadedKey // Newest first
+	loadedAt time.Time
+}
+
+// NewKeyManager loads the key set, creating the first key if there is none.
+// secret is only used when the configured algorithm is HS256.
+func NewKeyManager(repo *repository.SigningKeyRepository, cfg config.AuthConfig, secret string) (*KeyManager, error) {
+	m := &KeyManager{repo: repo, cfg: cfg}
+
+	switch cfg.JWTSigningAlgorithm {
+	case utils.JWTAlgorithmHS256:
+		if secret == "" {
+			return nil, errors.New("JWT_SECRET is required for HS256 signing")
+		}
+		m.hmac = utils.NewHMACKey("", secret)
+		return m, nil
+	case utils.JWTAlgorithmRS256, utils.JWTAlgorithmEdDSA:
+	default:
+		return nil, fmt.Errorf("unsupported JWT_SIGNING_ALG %q", cfg.JWTSigningAlgorithm)
+	}
+
+	if _, err := m.RotateIfDue(); err != nil {
+		return nil, err
+	}
+	if err := m.reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Start refreshes the key set every interval, picking up keys created by
+// other replicas, and performs scheduled rotations until ctx is cancelled
+func (m *KeyManager) Start(ctx context.Context, interval time.Duration) {
+	if m.hmac != nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := m.RotateIfDue(); err != nil {
+					log.Printf("Signing key rotation failed: %v", err)
+				}
+				if err := m.repo.DeleteExpired(time.Now()); err != nil {
+					log.Printf("Failed to delete expired signing keys: %v", err)
+				}
+				if err := m.reload(); err != nil {
+					log.Printf("Failed to reload signing keys: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// SigningKey returns the key new tokens are signed with
+func (m *KeyManager) SigningKey() (*utils.JWTKey, error) {
+	if m.hmac != nil {
+		return m.hmac, nil
+	}
+
+	now := time.Now()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, k := range m.keys {
+		if !k.record.ActivatesAt.After(now) {
+			return k.key, nil
+		}
+	}
+	return nil, ErrNoSigningKey
+}
+
+// LookupKey returns the verification key for a kid. Keys unknown to this
+// replica are looked up in the database once in a while.
+func (m *KeyManager) LookupKey(kid string) (*utils.JWTKey, error) {
+	if m.hmac != nil {
+		if kid != "" {
+			return nil, ErrUnknownSigningKey
+		}
+		return m.hmac, nil
+	}
+
+	if key := m.find(kid); key != nil {
+		return key, nil
+	}
+
+	m.mu.RLock()
+	stale := time.Since(m.loadedAt) > keyReloadThrottle
+	m.mu.RUnlock()
+	if stale {
+		if err := m.reload(); err != nil {
+			return nil, err
+		}
+		if key := m.find(kid); key != nil {
+			return key, nil
+		}
+	}
+	return nil, ErrUnknownSigningKey
+}
+
+func (m *KeyManager) find(kid string) *utils.JWTKey {
+	now := time.Now()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, k := range m.keys {
+		if k.record.KID == kid && (k.record.ExpiresAt == nil || k.record.ExpiresAt.After(now)) {
+			return k.key
+		}
+	}
+	return nil
+}
+
+// AllowedAlgorithms lists the algorithms tokens may be signed with. HS256 is
+// only accepted when it is the configured algorithm.
+func (m *KeyManager) AllowedAlgorithms() []string {
+	if m.hmac != nil {
+		return []string{utils.JWTAlgorithmHS256}
+	}
+	// Keys of a previously configured algorithm stay valid until they expire
+	return []string{utils.JWTAlgorithmRS256, utils.JWTAlgorithmEdDSA}
+}
+
+// PublicKeys returns the key set to publish at /.well-known/jwks.json,
+// including keys that are not yet used for signing
+func (m *KeyManager) PublicKeys() []utils.JWK {
+	keys := []utils.JWK{}
+	if m.hmac != nil {
+		return keys
+	}
+
+	now := time.Now()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, k := range m.keys {
+		if k.record.ExpiresAt != nil && !k.record.ExpiresAt.After(now) {
+			continue
+		}
+		if jwk, ok := k.key.PublicJWK(); ok {
+			keys = append(keys, jwk)
+		}
+	}
+	return keys
+}
+
+// ListKeys returns the metadata of the current key set, newest first
+func (m *KeyManager) ListKeys() []models.SigningKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	records := make([]models.SigningKey, 0, len(m.keys))
+	for _, k := range m.keys {
+		records = append(records, k.record)
+	}
+	return records
+}
+
+// RotateIfDue creates the next key once the current one has been in use for
+// the rotation interval. The new key is published JWTKeyPrepublish before it
+// starts signing so that verifiers caching the key set can pick it up.
+func (m *KeyManager) RotateIfDue() (bool, error) {
+	if m.hmac != nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	due := func(latest *models.SigningKey) bool {
+		return latest == nil || !latest.ActivatesAt.Add(m.cfg.JWTKeyRotationInterval).After(now.Add(m.cfg.JWTKeyPrepublish))
+	}
+	activatesAt := now.Add(m.cfg.JWTKeyPrepublish)
+
+	// Check before generating a key pair; the repository checks again under
+	// a lock in case another replica rotated in the meantime
+	keys, err := m.repo.ListUnexpired(now)
+	if err != nil {
+		return false, err
+	}
+	if len(keys) == 0 {
+		// The very first key has to sign right away
+		activatesAt = now
+	} else if !due(&keys[0]) {
+		return false, nil
+	}
+	_, rotated, err := m.rotate(m.repo, due, activatesAt)
+	return rotated, err
+}
+
+// Rotate creates a new key that starts signing immediately, e.g. when an
+// admin suspects a key was exposed. Tokens signed with older keys stay valid
+// until they expire. ctx attributes the rotation in the audit log.
+func (m *KeyManager) Rotate(ctx context.Context) (*models.SigningKey, error) {
+	if m.hmac != nil {
+		return nil, ErrRotationUnsupported
+	}
+	key, _, err := m.rotate(m.repo.WithContext(ctx), func(*models.SigningKey) bool { return true }, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err := m.reload(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (m *KeyManager) rotate(repo *repository.SigningKeyRepository, due func(*models.SigningKey) bool, activatesAt time.Time) (*models.SigningKey, bool, error) {
+	privatePEM, err := utils.GenerateJWTKey(m.cfg.JWTSigningAlgorithm)
+	if err != nil {
+		return nil, false, err
+	}
+	kid, err := utils.GenerateRandomToken(12)
+	if err != nil {
+		return nil, false, err
+	}
+
+	key := &models.SigningKey{
+		KID:         kid,
+		Algorithm:   m.cfg.JWTSigningAlgorithm,
+		PrivateKey:  privatePEM,
+		ActivatesAt: activatesAt,
+	}
+	// Superseded keys keep signing until the new key activates, and their
+	// tokens must verify for a full token lifetime after that
+	rotated, err := repo.Rotate(due, key, activatesAt.Add(m.cfg.TokenTTL))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to rotate signing key: %w", err)
+	}
+	if rotated {
+		log.Printf("Created signing key %s (%s), active from %s", kid, key.Algorithm, activatesAt.Format(time.RFC3339))
+	}
+	return key, rotated, nil
+}
+
+// reload replaces the cached key set with the keys in the database
+func (m *KeyManager) reload() error {
+	records, err := m.repo.ListUnexpired(time.Now())
+	if err != nil {
+		return err
+	}
+
+	keys := make([]loadedKey, 0, len(records))
+	for _, record := range records {
+		key, err := utils.ParseJWTKey(record.KID, record.Algorithm, record.PrivateKey)
+		if err != nil {
+			log.Printf("Skipping unusable signing key %s: %v", record.KID, err)
+			continue
+		}
+		keys = append(keys, loadedKey{record: record, key: key})
+	}
+
+	m.mu.Lock()
+	m.keys = keys
+	m.loadedAt = time.Now()
+	m.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/E-Timileyin/school-management-system/internal/model"
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+	"github.com/E-Timileyin/school-management-system/internal/utils"
+)
+
+// kioskTouchInterval limits how often LastSeenAt is written for a kiosk
+const kioskTouchInterval = time.Minute
+
+// kioskCredentialPrefix starts every kiosk credential, which look like
+// kiosk_<prefix>_<secret>
+const kioskCredentialPrefix = "kiosk"
+
+var (
+	ErrInvalidKioskCredential = errors.New("invalid or revoked kiosk credential")
+	ErrKioskNotFound          = errors.New("kiosk not found")
+	ErrInvalidKiosk           = errors.New("kiosk name is required")
+	ErrBarcodeRequired        = errors.New("scan the barcode of the book")
+)
+
+// RegisteredKiosk is returned once when a kiosk is registered; the
+// credential is never stored
+type RegisteredKiosk struct {
+	Credential string             `json:"credential"`
+	Kiosk      *model.KioskDevice `json:"kiosk"`
+}
+
+// KioskLoan is a loan as shown on a kiosk screen
+type KioskLoan struct {
+	IssueID    uint            `json:"issue_id"`
+	Title      string          `json:"title"`
+	Author     string          `json:"author"`
+	Barcode    string          `json:"barcode,omitempty"`
+	IssueDate  time.Time       `json:"issue_date"`
+	DueDate    time.Time       `json:"due_date"`
+	ReturnDate *time.Time      `json:"return_date,omitempty"`
+	Overdue    bool            `json:"overdue"`
+	FineAmount decimal.Decimal `json:"fine_amount"`
+}
+
+// KioskLoans is what readers see after scanning their card at a kiosk. It
+// leaves out anything more personal than their first name.
+type KioskLoans struct {
+	FirstName   string          `json:"first_name"`
+	Loans       []KioskLoan     `json:"loans"`
+	UnpaidFines decimal.Decimal `json:"unpaid_fines"`
+	CanBorrow   bool            `json:"can_borrow"`
+	Refusal     *PolicyError    `json:"refusal,omitempty"`
+}
+
+// KioskService registers self-service kiosks and serves the checkouts,
+// returns and loan lookups made at them
+type KioskService struct {
+	repo    *repository.KioskRepository
+	library *LibraryService
+}
+
+func NewKioskService(repo *repository.KioskRepository, library *LibraryService) *KioskService {
+	return &KioskService{repo: repo, library: library}
+}
+
+// WithContext returns a copy of the service bound to ctx
+func (s *KioskService) WithContext(ctx context.Context) *KioskService {
+	return &KioskService{repo: s.repo.WithContext(ctx), library: s.library.WithContext(ctx)}
+}
+
+// RegisterKiosk creates a kiosk and its device credential
+func (s *KioskService) RegisterKiosk(name, location string, createdBy uint) (*RegisteredKiosk, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ErrInvalidKiosk
+	}
+
+	prefix, err := utils.GenerateRandomToken(6)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	// The prefix is used to find the kiosk, so it must not contain the separator
+	prefix = kioskCredentialPrefix + "_" + strings.NewReplacer("_", "x", "-", "y").Replace(prefix)
+	credential := prefix + "_" + secret
+
+	kiosk := &model.KioskDevice{
+		Name:           name,
+		Location:       strings.TrimSpace(location),
+		Prefix:         prefix,
+		CredentialHash: utils.HashToken(credential),
+		CreatedBy:      createdBy,
+	}
+	if err := s.repo.Create(kiosk); err != nil {
+		return nil, err
+	}
+	return &RegisteredKiosk{Credential: credential, Kiosk: kiosk}, nil
+}
+
+func (s *KioskService) ListKiosks() ([]model.KioskDevice, error) {
+	return s.repo.List()
+}
+
+// RevokeKiosk revokes the credential of a kiosk, which stops working at once
+func (s *KioskService) RevokeKiosk(id uint) error {
+	revoked, err := s.repo.Revoke(id)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrKioskNotFound
+	}
+	return nil
+}
+
+// Authenticate checks a kiosk credential and returns its kiosk
+func (s *KioskService) Authenticate(credential, ip string) (*model.KioskDevice, error) {
+	parts := strings.SplitN(credential, "_", 3)
+	if len(parts) != 3 || parts[0] != kioskCredentialPrefix {
+		return nil, ErrInvalidKioskCredential
+	}
+
+	kiosk, err := s.repo.FindByPrefix(parts[0] + "_" + parts[1])
+	if err != nil || subtle.ConstantTimeCompare([]byte(kiosk.CredentialHash), []byte(utils.HashToken(credential))) != 1 {
+		return nil, ErrInvalidKioskCredential
+	}
+	if kiosk.RevokedAt != nil {
+		return nil, ErrInvalidKioskCredential
+	}
+
+	now := time.Now()
+	if kiosk.LastSeenAt == nil || now.Sub(*kiosk.LastSeenAt) >= kioskTouchInterval || kiosk.LastSeenIP != ip {
+		if err := s.repo.Touch(kiosk.ID, ip, now); err != nil {
+			return nil, err
+		}
+		kiosk.LastSeenAt = &now
+		kiosk.LastSeenIP = ip
+	}
+	return kiosk, nil
+}
+
+// Checkout lends the scanned copy to the holder of the scanned card. The
+// circulation policy applies as at the desk.
+func (s *KioskService) Checkout(kioskID uint, cardNumber, barcode string) (*KioskLoan, error) {
+	barcode = strings.TrimSpace(barcode)
+	if barcode == "" {
+		return nil, ErrBarcodeRequired
+	}
+	card, err := s.library.GetCardByNumber(cardNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	issue, err := s.library.CheckoutBook(barcode, 0, card.UserID, model.KioskAttendant(kioskID))
+	if err != nil {
+		return nil, err
+	}
+	if issue, err = s.library.repo.GetIssueByID(issue.ID); err != nil {
+		return nil, err
+	}
+	loan := kioskLoan(issue, time.Now())
+	return &loan, nil
+}
+
+// Return takes back a scanned copy and charges any overdue fine
+func (s *KioskService) Return(kioskID uint, barcode string) (*KioskLoan, error) {
+	barcode = strings.TrimSpace(barcode)
+	if barcode == "" {
+		return nil, ErrBarcodeRequired
+	}
+	issue, err := s.library.ReturnCopy(barcode, model.KioskAttendant(kioskID))
+	if err != nil {
+		return nil, err
+	}
+	loan := kioskLoan(issue, time.Now())
+	return &loan, nil
+}
+
+// Loans shows the holder of a scanned card their loans, their unpaid fines
+// and whether they may borrow
+func (s *KioskService) Loans(cardNumber string) (*KioskLoans, error) {
+	card, err := s.library.GetCardByNumber(cardNumber)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.library.findUser(card.UserID)
+	if err != nil {
+		return nil, err
+	}
+	status, err := s.library.GetBorrowerStatus(card.UserID)
+	if err != nil {
+		return nil, err
+	}
+	issues, err := s.library.repo.ListOpenLoans(card.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	loans := &KioskLoans{
+		FirstName:   user.FirstName,
+		Loans:       make([]KioskLoan, len(issues)),
+		UnpaidFines: status.UnpaidFines,
+		CanBorrow:   status.CanBorrow,
+		Refusal:     status.Refusal,
+	}
+	for i := range issues {
+		loans.Loans[i] = kioskLoan(&issues[i], now)
+	}
+	return loans, nil
+}
+
+func kioskLoan(issue *model.BookIssue, now time.Time) KioskLoan {
+	loan := KioskLoan{
+		IssueID:    issue.ID,
+		IssueDate:  issue.IssueDate,
+		DueDate:    issue.DueDate,
+		ReturnDate: issue.ReturnDate,
+		Overdue:    issue.ReturnDate == nil && issue.DueDate.Before(now),
+		FineAmount: issue.FineAmount,
+	}
+	if issue.Book != nil {
+		loan.Title = issue.Book.Title
+		loan.Author = issue.Book.Author
+	}
+	if issue.Copy != nil {
+		loan.Barcode = issue.Copy.Barcode
+	}
+	return loan
+}
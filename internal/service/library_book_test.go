@@ -0,0 +1,58 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/E-Timileyin/school-management-system/internal/config"
+	"github.com/E-Timileyin/school-management-system/internal/model"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+)
+
+// copies returns the total and available copies recorded on the book
+func (lt *libraryTest) copies(t *testing.T) (total, available int) {
+	t.Helper()
+	var book model.Book
+	if err := lt.db.First(&book, lt.book.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return book.TotalCopies, book.AvailableCopies
+}
+
+func TestUpdateBookKeepsCopiesOnLoan(t *testing.T) {
+	lt := newLibraryTest(t, func(cfg *config.LibraryConfig) {
+		cfg.LoanLimits = map[string]int{models.RoleStudent: 5}
+	})
+	for i := 0; i < 4; i++ {
+		lt.checkout(t)
+	}
+
+	// One copy is on the shelf, so two cannot be withdrawn, and the rest
+	// of the change is not saved either
+	book := *lt.book
+	book.Title = "Dune Messiah"
+	book.TotalCopies = 3
+	if err := lt.service.UpdateBookDetails(&book); !errors.Is(err, ErrCopiesOnLoan) {
+		t.Fatalf("got %v, want ErrCopiesOnLoan", err)
+	}
+	if total, available := lt.copies(t); total != 5 || available != 1 {
+		t.Fatalf("got %d copies, %d available; want 5, 1", total, available)
+	}
+	var saved model.Book
+	if err := lt.db.First(&saved, lt.book.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if saved.Title != "Dune" {
+		t.Fatalf("refused update saved the title %q", saved.Title)
+	}
+
+	book = *lt.book
+	book.Title = "Dune Messiah"
+	book.TotalCopies = 4
+	if err := lt.service.UpdateBookDetails(&book); err != nil {
+		t.Fatal(err)
+	}
+	if book.Title != "Dune Messiah" || book.TotalCopies != 4 || book.AvailableCopies != 0 {
+		t.Fatalf("got %q with %d copies, %d available", book.Title, book.TotalCopies, book.AvailableCopies)
+	}
+}
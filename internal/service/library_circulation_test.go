@@ -0,0 +1,103 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"github.com/E-Timileyin/school-management-system/internal/config"
+	"github.com/E-Timileyin/school-management-system/internal/model"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+)
+
+// libraryTest is a library with a librarian, a student with a card, and a
+// book of five copies priced 12.99
+type libraryTest struct {
+	service *LibraryService
+	db      *gorm.DB
+	reader  *models.User
+	staff   *models.User
+	book    *model.Book
+}
+
+func newLibraryTest(t *testing.T, configure func(*config.LibraryConfig)) *libraryTest {
+	t.Helper()
+	cfg := config.LibraryConfig{
+		LoanLimits:     map[string]int{models.RoleStudent: 3},
+		LoanDays:       map[string]int{models.RoleStudent: 14},
+		MaxUnpaidFines: decimal.NewFromInt(100),
+		FineDailyRate:  decimal.NewFromInt(1),
+	}
+	if configure != nil {
+		configure(&cfg)
+	}
+
+	db := newTestDB(t)
+	service := NewLibraryService(
+		repository.NewLibraryRepository(db),
+		repository.NewUserRepository(db),
+		nil,
+		NewCirculationPolicy(cfg),
+		NewFinePolicy(cfg),
+		nil,
+	)
+
+	lt := &libraryTest{service: service, db: db}
+	lt.staff = &models.User{Email: "librarian@example.com", Password: "x", FirstName: "Lee", LastName: "Brown", Role: models.RoleLibrarian}
+	lt.reader = &models.User{Email: "student@example.com", Password: "x", FirstName: "Ann", LastName: "Smith", Role: models.RoleStudent}
+	for _, user := range []*models.User{lt.staff, lt.reader} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := service.IssueLibraryCard(lt.reader.ID, 1, lt.staff.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	category := &model.BookCategory{Name: "Fiction", IsActive: true}
+	if err := db.Create(category).Error; err != nil {
+		t.Fatal(err)
+	}
+	lt.book = &model.Book{ISBN: "9780441172719", Title: "Dune", Author: "Frank Herbert", CategoryID: category.ID,
+		TotalCopies: 5, Price: decimal.RequireFromString("12.99")}
+	if err := service.AddNewBook(lt.book); err != nil {
+		t.Fatal(err)
+	}
+	return lt
+}
+
+// checkout lends the reader a copy of the book
+func (lt *libraryTest) checkout(t *testing.T) *model.BookIssue {
+	t.Helper()
+	issue, err := lt.service.CheckoutBook("", lt.book.ID, lt.reader.ID, model.StaffAttendant(lt.staff.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return issue
+}
+
+func TestCheckoutLimitCheckedWithTheLoan(t *testing.T) {
+	lt := newLibraryTest(t, nil)
+	issue := lt.checkout(t)
+
+	// Concurrent checkouts both pass the policy before either is saved;
+	// the limit is checked again with the card locked
+	var bookCopy model.BookCopy
+	if err := lt.db.Where("book_id = ? AND status = ?", lt.book.ID, model.CopyAvailable).First(&bookCopy).Error; err != nil {
+		t.Fatal(err)
+	}
+	next := &model.BookIssue{BookID: lt.book.ID, CopyID: &bookCopy.ID, CardID: issue.CardID, UserID: lt.reader.ID,
+		Status: "issued", IssueDate: issue.IssueDate, DueDate: issue.DueDate}
+	if err := repository.NewLibraryRepository(lt.db).CheckoutBook(next, 1); !errors.Is(err, repository.ErrLoanLimit) {
+		t.Fatalf("got %v, want ErrLoanLimit", err)
+	}
+	if err := lt.db.First(&bookCopy, bookCopy.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if bookCopy.Status != model.CopyAvailable {
+		t.Fatalf("refused checkout left the copy %s", bookCopy.Status)
+	}
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+
+	"github.com/E-Timileyin/school-management-system/internal/bookmeta"
+	"github.com/E-Timileyin/school-management-system/internal/config"
+	"github.com/E-Timileyin/school-management-system/internal/model"
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+)
+
+// failingProvider stands in for a metadata service that cannot be reached
+type failingProvider struct{}
+
+func (failingProvider) Lookup(context.Context, string) (*bookmeta.Metadata, error) {
+	return nil, errors.New("connection refused")
+}
+
+func newTestLibraryService(t *testing.T, metadata bookmeta.Provider) (*LibraryService, *gorm.DB, uint) {
+	t.Helper()
+	db := newTestDB(t)
+	category := &model.BookCategory{Name: "Fiction", IsActive: true}
+	if err := db.Create(category).Error; err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.LibraryConfig{}
+	service := NewLibraryService(
+		repository.NewLibraryRepository(db),
+		repository.NewUserRepository(db),
+		metadata,
+		NewCirculationPolicy(cfg),
+		NewFinePolicy(cfg),
+		nil,
+	)
+	return service, db, category.ID
+}
+
+var duneFixture = map[string]bookmeta.Metadata{
+	"9780441172719": {Title: "Dune", Authors: []string{"Frank Herbert"}, Publisher: "Ace Books", PublicationYear: 1990, Pages: 535},
+}
+
+func TestIntakeCreatesBookFromMetadata(t *testing.T) {
+	service, db, categoryID := newTestLibraryService(t, bookmeta.NewFixtureProvider(duneFixture))
+
+	book, created, err := service.IntakeBook(context.Background(), BookIntake{ISBN: "0-441-17271-7", CategoryID: categoryID, Copies: 2, RackNumber: "A1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created || book.ISBN != "9780441172719" || book.Title != "Dune" || book.Author != "Frank Herbert" ||
+		book.Publisher != "Ace Books" || book.PublicationYear != 1990 || book.TotalCopies != 2 || book.AvailableCopies != 2 {
+		t.Fatalf("got created=%v, book %+v", created, book)
+	}
+	var copies int64
+	db.Model(&model.BookCopy{}).Where("book_id = ?", book.ID).Count(&copies)
+	if copies != 2 {
+		t.Fatalf("got %d copies, want 2", copies)
+	}
+}
+
+func TestIntakeAddsCopiesToExistingBook(t *testing.T) {
+	service, db, categoryID := newTestLibraryService(t, bookmeta.NewFixtureProvider(duneFixture))
+
+	first, _, err := service.IntakeBook(context.Background(), BookIntake{ISBN: "9780441172719", CategoryID: categoryID, Copies: 2, RackNumber: "A1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same book scanned by its ISBN-10, without a rack number
+	book, created, err := service.IntakeBook(context.Background(), BookIntake{ISBN: "0441172717", CategoryID: categoryID, Copies: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created || book.ID != first.ID || book.TotalCopies != 5 || book.AvailableCopies != 5 {
+		t.Fatalf("got created=%v, book %+v", created, book)
+	}
+
+	var books int64
+	db.Model(&model.Book{}).Count(&books)
+	if books != 1 {
+		t.Fatalf("got %d books, want 1", books)
+	}
+	var copies []model.BookCopy
+	db.Where("book_id = ?", book.ID).Find(&copies)
+	if len(copies) != 5 {
+		t.Fatalf("got %d copies, want 5", len(copies))
+	}
+	for _, bookCopy := range copies {
+		if bookCopy.RackNumber != "A1" {
+			t.Fatalf("copy %s is on rack %q, want the book's rack A1", bookCopy.Barcode, bookCopy.RackNumber)
+		}
+	}
+}
+
+func TestIntakeWhenLookupFails(t *testing.T) {
+	service, _, categoryID := newTestLibraryService(t, failingProvider{})
+
+	_, _, err := service.IntakeBook(context.Background(), BookIntake{ISBN: "9780441172719", CategoryID: categoryID})
+	if !errors.Is(err, ErrMetadataUnavailable) {
+		t.Fatalf("got %v, want ErrMetadataUnavailable", err)
+	}
+
+	// Details sent with the scan are enough to catalogue the book
+	book, created, err := service.IntakeBook(context.Background(), BookIntake{
+		ISBN: "9780441172719", CategoryID: categoryID, Title: "Dune", Author: "Frank Herbert",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created || book.Title != "Dune" || book.Author != "Frank Herbert" || book.TotalCopies != 1 {
+		t.Fatalf("got created=%v, book %+v", created, book)
+	}
+}
+
+func TestIntakeUnknownISBN(t *testing.T) {
+	service, _, categoryID := newTestLibraryService(t, bookmeta.NewFixtureProvider(nil))
+
+	_, _, err := service.IntakeBook(context.Background(), BookIntake{ISBN: "9780306406157", CategoryID: categoryID})
+	if !errors.Is(err, ErrMetadataNotFound) {
+		t.Fatalf("got %v, want ErrMetadataNotFound", err)
+	}
+
+	// Given details take precedence over looked up ones
+	service.metadata = bookmeta.NewFixtureProvider(duneFixture)
+	book, _, err := service.IntakeBook(context.Background(), BookIntake{ISBN: "9780441172719", CategoryID: categoryID, Title: "Dune (school edition)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Title != "Dune (school edition)" || book.Author != "Frank Herbert" {
+		t.Fatalf("got book %+v", book)
+	}
+}
+
+func TestIntakeRejectsInvalidScans(t *testing.T) {
+	service, _, categoryID := newTestLibraryService(t, bookmeta.NewFixtureProvider(duneFixture))
+
+	if _, _, err := service.IntakeBook(context.Background(), BookIntake{ISBN: "9780441172718", CategoryID: categoryID}); !errors.Is(err, ErrInvalidISBN) {
+		t.Fatalf("got %v, want ErrInvalidISBN", err)
+	}
+	if _, _, err := service.IntakeBook(context.Background(), BookIntake{ISBN: "9780441172719", CategoryID: categoryID, Copies: -1}); !errors.Is(err, ErrInvalidCopies) {
+		t.Fatalf("got %v, want ErrInvalidCopies", err)
+	}
+}
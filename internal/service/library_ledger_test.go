@@ -0,0 +1,175 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/E-Timileyin/school-management-system/internal/model"
+)
+
+// returnLate lends the reader a copy and takes it back days after it was
+// due, charging a fine of one a day
+func (lt *libraryTest) returnLate(t *testing.T, days int) *model.BookIssue {
+	t.Helper()
+	issue := lt.checkout(t)
+	if err := lt.db.Model(issue).Update("due_date", time.Now().AddDate(0, 0, -days)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := lt.service.ReturnBook(issue.ID, model.StaffAttendant(lt.staff.ID)); err != nil {
+		t.Fatal(err)
+	}
+	return issue
+}
+
+// pay records a cash payment against a loan
+func (lt *libraryTest) pay(issueID uint, amount string) (*model.FineLedgerEntry, error) {
+	return lt.service.RecordFinePayment(&model.FinePayment{
+		IssueID: issueID, Amount: decimal.RequireFromString(amount), ReceivedBy: lt.staff.ID, PaymentMode: "cash",
+	})
+}
+
+// balance returns the fine the reader owes
+func (lt *libraryTest) balance(t *testing.T) decimal.Decimal {
+	t.Helper()
+	ledger, err := lt.service.GetFineLedger(lt.reader.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ledger.Balance
+}
+
+func wantBalance(t *testing.T, got decimal.Decimal, want string) {
+	t.Helper()
+	if !got.Equal(decimal.RequireFromString(want)) {
+		t.Fatalf("balance %s, want %s", got, want)
+	}
+}
+
+func TestFinePayments(t *testing.T) {
+	lt := newLibraryTest(t, nil)
+	issue := lt.returnLate(t, 5)
+	wantBalance(t, lt.balance(t), "5")
+
+	entry, err := lt.pay(issue.ID, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Type != model.LedgerPayment || !entry.Amount.Equal(decimal.NewFromInt(-2)) {
+		t.Fatalf("got a %s of %s, want a payment of -2", entry.Type, entry.Amount)
+	}
+	wantBalance(t, entry.Balance, "3")
+
+	// More than is outstanding is refused and changes nothing
+	if _, err := lt.pay(issue.ID, "3.01"); !errors.Is(err, ErrFineExceeded) {
+		t.Fatalf("overpayment: got %v, want ErrFineExceeded", err)
+	}
+	wantBalance(t, lt.balance(t), "3")
+
+	entry, err = lt.pay(issue.ID, "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantBalance(t, entry.Balance, "0")
+	if _, err := lt.pay(issue.ID, "1"); !errors.Is(err, ErrNoFineOwed) {
+		t.Fatalf("paid fine: got %v, want ErrNoFineOwed", err)
+	}
+}
+
+func TestFineWaiver(t *testing.T) {
+	lt := newLibraryTest(t, nil)
+	issue := lt.returnLate(t, 5)
+
+	if _, err := lt.service.WaiveFine(issue.ID, decimal.NewFromInt(1), " ", lt.staff.ID); !errors.Is(err, ErrInvalidAdjustment) {
+		t.Fatalf("waiver without a reason: got %v, want ErrInvalidAdjustment", err)
+	}
+	entry, err := lt.service.WaiveFine(issue.ID, decimal.NewFromInt(2), "first offence", lt.staff.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Type != model.LedgerWaiver || entry.ApprovedBy == nil || *entry.ApprovedBy != lt.staff.ID {
+		t.Fatalf("got %+v, want a waiver approved by the librarian", entry)
+	}
+	wantBalance(t, entry.Balance, "3")
+
+	if _, err := lt.service.WaiveFine(issue.ID, decimal.NewFromInt(4), "first offence", lt.staff.ID); !errors.Is(err, ErrFineExceeded) {
+		t.Fatalf("waiving more than is owed: got %v, want ErrFineExceeded", err)
+	}
+	wantBalance(t, lt.balance(t), "3")
+}
+
+func TestFineRefund(t *testing.T) {
+	lt := newLibraryTest(t, nil)
+	issue := lt.returnLate(t, 5)
+	payment, err := lt.pay(issue.ID, "5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := lt.service.RefundPayment(*payment.PaymentID, decimal.RequireFromString("5.01"), "paid twice", lt.staff.ID); !errors.Is(err, ErrRefundExceeded) {
+		t.Fatalf("refunding more than was paid: got %v, want ErrRefundExceeded", err)
+	}
+	entry, err := lt.service.RefundPayment(*payment.PaymentID, decimal.NewFromInt(3), "paid twice", lt.staff.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// What is refunded is owed again
+	if entry.Type != model.LedgerRefund || !entry.Amount.Equal(decimal.NewFromInt(3)) {
+		t.Fatalf("got a %s of %s, want a refund of 3", entry.Type, entry.Amount)
+	}
+	wantBalance(t, entry.Balance, "3")
+
+	// Only what is left of the payment can be refunded
+	if _, err := lt.service.RefundPayment(*payment.PaymentID, decimal.NewFromInt(3), "paid twice", lt.staff.ID); !errors.Is(err, ErrRefundExceeded) {
+		t.Fatalf("refunding a payment twice: got %v, want ErrRefundExceeded", err)
+	}
+	wantBalance(t, lt.balance(t), "3")
+}
+
+func TestFineLedgerRunningBalance(t *testing.T) {
+	lt := newLibraryTest(t, nil)
+	first := lt.returnLate(t, 5)
+	second := lt.returnLate(t, 2)
+
+	payment, err := lt.pay(first.ID, "4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lt.service.WaiveFine(second.ID, decimal.NewFromInt(2), "book was damaged on the shelf", lt.staff.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lt.service.RefundPayment(*payment.PaymentID, decimal.RequireFromString("1.50"), "overcharged", lt.staff.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	ledger, err := lt.service.GetFineLedger(lt.reader.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		kind    string
+		amount  string
+		balance string
+	}{
+		{model.LedgerCharge, "5", "5"},
+		{model.LedgerCharge, "2", "7"},
+		{model.LedgerPayment, "-4", "3"},
+		{model.LedgerWaiver, "-2", "1"},
+		{model.LedgerRefund, "1.5", "2.5"},
+	}
+	if len(ledger.Entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(ledger.Entries), len(want))
+	}
+	balance := decimal.Zero
+	for i, entry := range ledger.Entries {
+		balance = balance.Add(entry.Amount)
+		if entry.Type != want[i].kind || !entry.Amount.Equal(decimal.RequireFromString(want[i].amount)) ||
+			!entry.Balance.Equal(decimal.RequireFromString(want[i].balance)) || !entry.Balance.Equal(balance) {
+			t.Errorf("entry %d: got %s %s, balance %s; want %s %s, balance %s",
+				i, entry.Type, entry.Amount, entry.Balance, want[i].kind, want[i].amount, want[i].balance)
+		}
+	}
+	wantBalance(t, ledger.Balance, "2.5")
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/E-Timileyin/school-management-system/internal/config"
+	"github.com/E-Timileyin/school-management-system/internal/model"
+)
+
+// newLostBookTest charges 2.50 on top of the price of a lost book
+func newLostBookTest(t *testing.T) *libraryTest {
+	return newLibraryTest(t, func(cfg *config.LibraryConfig) {
+		cfg.LostBookFee = decimal.RequireFromString("2.5")
+	})
+}
+
+func (lt *libraryTest) wantCopies(t *testing.T, total, available int) {
+	t.Helper()
+	if gotTotal, gotAvailable := lt.copies(t); gotTotal != total || gotAvailable != available {
+		t.Fatalf("got %d copies, %d available; want %d, %d", gotTotal, gotAvailable, total, available)
+	}
+}
+
+func (lt *libraryTest) wantCopyStatus(t *testing.T, issue *model.BookIssue, status string) {
+	t.Helper()
+	var bookCopy model.BookCopy
+	if err := lt.db.First(&bookCopy, *issue.CopyID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if bookCopy.Status != status {
+		t.Fatalf("copy is %s, want %s", bookCopy.Status, status)
+	}
+}
+
+func TestDeclareLost(t *testing.T) {
+	lt := newLostBookTest(t)
+	lt.checkout(t)
+	issue := lt.checkout(t)
+	lt.wantCopies(t, 5, 3)
+
+	lost, err := lt.service.DeclareLost(issue.ID, lt.staff.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lost.Status != "lost" || lost.ReturnDate == nil || !lost.ReplacementCharge.Equal(decimal.RequireFromString("15.49")) {
+		t.Fatalf("got a %s loan charged %s, want a closed lost loan charged 15.49", lost.Status, lost.ReplacementCharge)
+	}
+	// The lost copy is no longer held; the other loan is still out
+	lt.wantCopies(t, 4, 3)
+	lt.wantCopyStatus(t, issue, model.CopyLost)
+	wantBalance(t, lt.balance(t), "15.49")
+
+	if _, err := lt.service.DeclareLost(issue.ID, lt.staff.ID); !errors.Is(err, ErrAlreadyReturned) {
+		t.Fatalf("declaring it lost again: got %v, want ErrAlreadyReturned", err)
+	}
+	wantBalance(t, lt.balance(t), "15.49")
+}
+
+func TestReturnDamaged(t *testing.T) {
+	lt := newLostBookTest(t)
+	issue := lt.checkout(t)
+	if err := lt.db.Model(issue).Update("due_date", time.Now().AddDate(0, 0, -3)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	damaged, err := lt.service.ReturnDamaged(issue.ID, lt.staff.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if damaged.Status != "damaged" || !damaged.FineAmount.Equal(decimal.NewFromInt(3)) ||
+		!damaged.ReplacementCharge.Equal(decimal.RequireFromString("15.49")) {
+		t.Fatalf("got a %s loan with fine %s and charge %s, want damaged, 3 and 15.49",
+			damaged.Status, damaged.FineAmount, damaged.ReplacementCharge)
+	}
+	lt.wantCopies(t, 4, 4)
+	lt.wantCopyStatus(t, issue, model.CopyDamaged)
+	wantBalance(t, lt.balance(t), "18.49")
+
+	// Only lost books can turn up again
+	if _, err := lt.service.RecoverLost(issue.ID, lt.staff.ID); !errors.Is(err, ErrLoanNotLost) {
+		t.Fatalf("recovering a damaged book: got %v, want ErrLoanNotLost", err)
+	}
+}
+
+func TestRecoverLost(t *testing.T) {
+	lt := newLostBookTest(t)
+	issue := lt.checkout(t)
+	if _, err := lt.service.DeclareLost(issue.ID, lt.staff.ID); err != nil {
+		t.Fatal(err)
+	}
+	lt.wantCopies(t, 4, 4)
+
+	recovered, err := lt.service.RecoverLost(issue.ID, lt.staff.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recovered.Status != "returned" || !recovered.ReplacementCharge.IsZero() {
+		t.Fatalf("got a %s loan charged %s, want returned and nothing charged", recovered.Status, recovered.ReplacementCharge)
+	}
+	lt.wantCopies(t, 5, 5)
+	lt.wantCopyStatus(t, issue, model.CopyAvailable)
+
+	ledger, err := lt.service.GetFineLedger(lt.reader.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ledger.Entries) != 2 {
+		t.Fatalf("got %d ledger entries, want the charge and its reversal", len(ledger.Entries))
+	}
+	reversal := ledger.Entries[1]
+	if reversal.Type != model.LedgerReversal || !reversal.Amount.Equal(decimal.RequireFromString("-15.49")) {
+		t.Fatalf("got a %s of %s, want a reversal of -15.49", reversal.Type, reversal.Amount)
+	}
+	wantBalance(t, ledger.Balance, "0")
+
+	if _, err := lt.service.RecoverLost(issue.ID, lt.staff.ID); !errors.Is(err, ErrLoanNotLost) {
+		t.Fatalf("recovering it twice: got %v, want ErrLoanNotLost", err)
+	}
+}
+
+func TestRecoverPaidLostBook(t *testing.T) {
+	lt := newLostBookTest(t)
+	issue := lt.checkout(t)
+	if _, err := lt.service.DeclareLost(issue.ID, lt.staff.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lt.pay(issue.ID, "15.49"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A paid replacement charge is taken back as credit on the card
+	if _, err := lt.service.RecoverLost(issue.ID, lt.staff.ID); err != nil {
+		t.Fatal(err)
+	}
+	wantBalance(t, lt.balance(t), "-15.49")
+	lt.wantCopies(t, 5, 5)
+}
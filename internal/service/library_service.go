@@ -1,24 +1,182 @@
 package service
 
 import (
+	"context"
+	"crypto/rand"
 	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"github.com/E-Timileyin/school-management-system/internal/bookmeta"
+	"github.com/E-Timileyin/school-management-system/internal/mailer"
 	"github.com/E-Timileyin/school-management-system/internal/model"
+	"github.com/E-Timileyin/school-management-system/internal/models"
 	"github.com/E-Timileyin/school-management-system/internal/repository"
+	"github.com/E-Timileyin/school-management-system/internal/utils"
+)
+
+var (
+	ErrBookNotFound      = errors.New("book not found")
+	ErrBookInactive      = errors.New("book has been withdrawn from circulation")
+	ErrBookOnLoan        = errors.New("book has copies on loan")
+	ErrCopiesOnLoan      = errors.New("total copies cannot be fewer than the copies on loan")
+	ErrDuplicateISBN     = errors.New("a book with this ISBN already exists")
+	ErrCategoryNotFound  = errors.New("category not found")
+	ErrCategoryInUse     = errors.New("category still has books")
+	ErrDuplicateCategory = errors.New("a category with this name already exists")
+	ErrInvalidBook       = errors.New("isbn, title, author and category_id are required and copies must not be negative")
+	ErrInvalidCategory   = errors.New("category name is required")
+
+	ErrInvalidISBN         = errors.New("invalid ISBN, expected a valid ISBN-10 or ISBN-13")
+	ErrInvalidCopies       = errors.New("copies must be at least 1")
+	ErrMetadataNotFound    = errors.New("no details found for this ISBN, send title and author")
+	ErrMetadataUnavailable = errors.New("book details lookup failed, try again or send title and author")
+
+	ErrCopyNotFound     = errors.New("copy not found")
+	ErrCopyNotAvailable = errors.New("copy is not available for checkout")
+	ErrNoCopyAvailable  = errors.New("no copies of this book are available")
+	ErrCopyNotOnLoan    = errors.New("copy is not on loan")
+	ErrCopyOnLoan       = errors.New("copy is on loan, return it first")
+	ErrAlreadyReturned  = errors.New("book has already been returned")
+	ErrDuplicateBarcode = errors.New("another copy already has this barcode")
+	ErrInvalidCopy      = errors.New("invalid copy condition or status")
+	ErrCheckoutTarget   = errors.New("send either barcode or bookId")
+	ErrCopyOnHold       = errors.New("copy is held for a reader who reserved it")
+	ErrIssueNotFound    = errors.New("loan not found")
+	ErrLoanChanged      = errors.New("the loan changed while it was being renewed, try again")
+	ErrLoanNotLost      = errors.New("the loan is not marked lost")
+
+	ErrCardNotFound     = errors.New("library card not found")
+	ErrCardExists       = errors.New("the user already has a library card, renew or replace it instead")
+	ErrCardReplaced     = errors.New("this library card was replaced and is no longer valid")
+	ErrCardBlocked      = errors.New("the library card is already blocked")
+	ErrCardNotBlocked   = errors.New("the library card is not blocked")
+	ErrInvalidCardTerm  = errors.New("a card is valid for 1 to 10 years")
+	ErrInvalidCardLimit = errors.New("max_books must be between 0 and 50, or null for the role's limit")
+	ErrBlockReason      = errors.New("a reason for blocking the card is required")
+
+	ErrNoLibraryCard     = errors.New("the user has no library card")
+	ErrPaymentNotFound   = errors.New("payment not found")
+	ErrInvalidPayment    = errors.New("amount must be positive in whole cents and payment_mode one of cash, card, online")
+	ErrInvalidAdjustment = errors.New("a positive amount in whole cents and a reason are required")
+	ErrNoFineOwed        = errors.New("no fine is outstanding on this loan")
+	ErrFineExceeded      = errors.New("amount exceeds the fine outstanding on this loan")
+	ErrRefundExceeded    = errors.New("amount exceeds what is left of the payment to refund")
+
+	ErrInvalidReportRange = errors.New("the report must end after it starts")
+
+	ErrHolidayNotFound = errors.New("holiday not found")
+	ErrInvalidHoliday  = errors.New("name, start_date and end_date (YYYY-MM-DD) are required, and a holiday may not end before it starts or last over a year")
+
+	ErrReservationNotFound = errors.New("reservation not found")
+	ErrReservationClosed   = errors.New("reservation is no longer active")
+	ErrAlreadyReserved     = errors.New("the reader already has a reservation for this book")
+	ErrAlreadyBorrowed     = errors.New("the reader already has this book on loan")
+	ErrCopiesOnShelf       = errors.New("copies of this book are on the shelf, borrow one instead")
 )
 
+// copyConditions are the conditions a copy can be recorded in
+var copyConditions = map[string]bool{"new": true, "good": true, "fair": true, "poor": true}
+
+// manualCopyStatuses can be set by librarians; issued is only set by
+// checkouts
+var manualCopyStatuses = map[string]bool{
+	model.CopyAvailable: true,
+	model.CopyDamaged:   true,
+	model.CopyLost:      true,
+	model.CopyWithdrawn: true,
+}
+
+// maxCatalogPage caps the number of books returned per page
+const maxCatalogPage = 200
+
 type LibraryService struct {
-	repo *repository.LibraryRepository
+	repo     *repository.LibraryRepository
+	userRepo *repository.UserRepository
+	metadata bookmeta.Provider
+	policy   *CirculationPolicy
+	fines    *FinePolicy
+	mailer   mailer.Mailer
+}
+
+func NewLibraryService(
+	repo *repository.LibraryRepository,
+	userRepo *repository.UserRepository,
+	metadata bookmeta.Provider,
+	policy *CirculationPolicy,
+	fines *FinePolicy,
+	mailer mailer.Mailer,
+) *LibraryService {
+	return &LibraryService{
+		repo:     repo,
+		userRepo: userRepo,
+		metadata: metadata,
+		policy:   policy,
+		fines:    fines,
+		mailer:   mailer,
+	}
+}
+
+// BorrowerStatus tells whether a user may borrow and why not
+type BorrowerStatus struct {
+	UserID       uint               `json:"user_id"`
+	Role         string             `json:"role"`
+	Card         *model.LibraryCard `json:"card"`
+	Terms        *LoanTerms         `json:"terms,omitempty"`
+	OpenLoans    int64              `json:"open_loans"`
+	OverdueLoans int64              `json:"overdue_loans"`
+	UnpaidFines  decimal.Decimal    `json:"unpaid_fines"`
+	CanBorrow    bool               `json:"can_borrow"`
+	Refusal      *PolicyError       `json:"refusal,omitempty"`
+}
+
+// BookIntake is a scanned book being added to the catalogue. Fields that
+// are set take precedence over the looked up metadata.
+type BookIntake struct {
+	ISBN       string
+	CategoryID uint
+	Copies     int
+	RackNumber string
+	Price      decimal.Decimal
+	Title      string
+	Author     string
 }
 
-func NewLibraryService(repo *repository.LibraryRepository) *LibraryService {
-	return &LibraryService{repo: repo}
+// WithContext returns a copy of the service bound to ctx
+func (s *LibraryService) WithContext(ctx context.Context) *LibraryService {
+	clone := *s
+	clone.repo = s.repo.WithContext(ctx)
+	clone.userRepo = s.userRepo.WithContext(ctx)
+	return &clone
 }
 
 // Book Management
 func (s *LibraryService) GetBookByID(id uint) (*model.Book, error) {
-	return s.repo.GetBookByID(id)
+	book, err := s.repo.GetBookByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrBookNotFound
+	}
+	return book, err
+}
+
+// SearchBooks returns a page of the catalogue matching filter, along with
+// the total number of matches
+func (s *LibraryService) SearchBooks(filter repository.BookFilter) ([]model.Book, int64, error) {
+	if filter.Limit <= 0 || filter.Limit > maxCatalogPage {
+		filter.Limit = 50
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+	return s.repo.ListBooks(filter)
 }
 
 func (s *LibraryService) AddNewBook(book *model.Book) error {
@@ -29,98 +187,1651 @@ func (s *LibraryService) AddNewBook(book *model.Book) error {
 	book.AvailableCopies = book.TotalCopies
 	book.IsActive = true
 
+	if err := s.validateBook(book); err != nil {
+		return err
+	}
+
 	return s.repo.CreateBook(book)
 }
 
+// IntakeBook adds scanned copies to the catalogue. A book already in the
+// catalogue gets the copies added; otherwise its details are looked up by
+// ISBN and a new book is created. It reports whether the book was created.
+func (s *LibraryService) IntakeBook(ctx context.Context, intake BookIntake) (*model.Book, bool, error) {
+	isbn, err := utils.NormalizeISBN(intake.ISBN)
+	if err != nil {
+		return nil, false, ErrInvalidISBN
+	}
+	if intake.Copies == 0 {
+		intake.Copies = 1
+	}
+	if intake.Copies < 0 {
+		return nil, false, ErrInvalidCopies
+	}
+
+	book, err := s.addCopiesByISBN(isbn, intake.Copies, intake.RackNumber)
+	if err == nil {
+		return book, false, nil
+	}
+	if !errors.Is(err, ErrBookNotFound) {
+		return nil, false, err
+	}
+
+	book = &model.Book{
+		ISBN:        isbn,
+		CategoryID:  intake.CategoryID,
+		TotalCopies: intake.Copies,
+		RackNumber:  intake.RackNumber,
+		Price:       intake.Price,
+		Title:       intake.Title,
+		Author:      intake.Author,
+	}
+	meta, err := s.metadata.Lookup(ctx, isbn)
+	switch {
+	case err == nil:
+		applyMetadata(book, meta)
+	case book.Title == "" || book.Author == "":
+		if errors.Is(err, bookmeta.ErrNotFound) {
+			return nil, false, ErrMetadataNotFound
+		}
+		log.Printf("Book metadata lookup for %s failed: %v", isbn, err)
+		return nil, false, ErrMetadataUnavailable
+	}
+
+	if err := s.AddNewBook(book); err != nil {
+		// Another scan of the same ISBN may have created the book meanwhile
+		if added, addErr := s.addCopiesByISBN(isbn, intake.Copies, intake.RackNumber); addErr == nil {
+			return added, false, nil
+		}
+		return nil, false, err
+	}
+	return book, true, nil
+}
+
+// addCopiesByISBN adds copies to the live book with isbn, returning
+// ErrBookNotFound if there is none
+func (s *LibraryService) addCopiesByISBN(isbn string, copies int, rackNumber string) (*model.Book, error) {
+	existing, err := s.repo.GetBookByISBN(isbn)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrBookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rackNumber == "" {
+		rackNumber = existing.RackNumber
+	}
+	if _, err := s.repo.AddCopies(existing.ID, copies, rackNumber); err != nil {
+		return nil, err
+	}
+	return s.GetBookByID(existing.ID)
+}
+
+// applyMetadata fills in the details of book that were not given
+func applyMetadata(book *model.Book, meta *bookmeta.Metadata) {
+	if book.Title == "" {
+		book.Title = meta.Title
+	}
+	if book.Author == "" {
+		book.Author = strings.Join(meta.Authors, ", ")
+	}
+	book.Publisher = meta.Publisher
+	book.PublicationYear = meta.PublicationYear
+	book.Pages = meta.Pages
+	book.CoverImage = meta.CoverURL
+}
+
+// UpdateBookDetails saves changes to a book. Raising TotalCopies adds new
+// copies; lowering it withdraws copies that are on the shelf. Available
+// copies follow the copies and cannot be set directly.
 func (s *LibraryService) UpdateBookDetails(book *model.Book) error {
-	// Prevent updating available copies directly
-	existingBook, err := s.repo.GetBookByID(book.ID)
+	if err := s.validateBook(book); err != nil {
+		return err
+	}
+	current, err := s.GetBookByID(book.ID)
 	if err != nil {
 		return err
 	}
 
-	// Calculate new available copies based on total copies change
-	if book.TotalCopies != existingBook.TotalCopies {
-		diff := book.TotalCopies - existingBook.TotalCopies
-		book.AvailableCopies = existingBook.AvailableCopies + diff
-		if book.AvailableCopies < 0 {
-			book.AvailableCopies = 0
-		}
+	err = s.repo.UpdateBook(book, book.TotalCopies-current.TotalCopies)
+	if errors.Is(err, repository.ErrCopiesOnLoan) {
+		return ErrCopiesOnLoan
+	}
+	if err != nil {
+		return err
 	}
+	updated, err := s.GetBookByID(book.ID)
+	if err != nil {
+		return err
+	}
+	*book = *updated
+	return nil
+}
+
+// SetBookActive withdraws a book from circulation or returns it. Inactive
+// books stay in the catalogue for staff but cannot be checked out.
+func (s *LibraryService) SetBookActive(id uint, active bool) (*model.Book, error) {
+	book, err := s.GetBookByID(id)
+	if err != nil {
+		return nil, err
+	}
+	book.IsActive = active
+	if err := s.UpdateBookDetails(book); err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+// DeleteBook moves a book to the trash. Books with copies on loan must be
+// returned first.
+func (s *LibraryService) DeleteBook(id uint) error {
+	if _, err := s.GetBookByID(id); err != nil {
+		return err
+	}
+	onLoan, err := s.repo.CountOpenIssues(id)
+	if err != nil {
+		return err
+	}
+	if onLoan > 0 {
+		return ErrBookOnLoan
+	}
+	return s.repo.DeleteBook(id)
+}
+
+// Availability sums up the copies of active books per category
+func (s *LibraryService) Availability() ([]repository.CategoryAvailability, error) {
+	return s.repo.Availability()
+}
+
+// validateBook checks the required fields of a book, that its category
+// exists and that its ISBN is not taken by another book
+func (s *LibraryService) validateBook(book *model.Book) error {
+	isbn, err := utils.NormalizeISBN(book.ISBN)
+	if err != nil {
+		return ErrInvalidISBN
+	}
+	book.ISBN = isbn
+	book.Title = strings.TrimSpace(book.Title)
+	book.Author = strings.TrimSpace(book.Author)
+	if book.ISBN == "" || book.Title == "" || book.Author == "" || book.CategoryID == 0 || book.TotalCopies < 0 {
+		return ErrInvalidBook
+	}
+
+	if _, err := s.GetCategoryByID(book.CategoryID); err != nil {
+		return err
+	}
+
+	existing, err := s.repo.GetBookByISBN(book.ISBN)
+	if err == nil && existing.ID != book.ID {
+		return ErrDuplicateISBN
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Category Management
+func (s *LibraryService) ListCategories(includeInactive bool) ([]model.BookCategory, error) {
+	return s.repo.ListCategories(includeInactive)
+}
+
+func (s *LibraryService) GetCategoryByID(id uint) (*model.BookCategory, error) {
+	category, err := s.repo.GetCategoryByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCategoryNotFound
+	}
+	return category, err
+}
+
+func (s *LibraryService) CreateCategory(category *model.BookCategory) error {
+	category.IsActive = true
+	if err := s.validateCategory(category); err != nil {
+		return err
+	}
+	return s.repo.CreateCategory(category)
+}
+
+func (s *LibraryService) UpdateCategory(category *model.BookCategory) error {
+	if err := s.validateCategory(category); err != nil {
+		return err
+	}
+	return s.repo.UpdateCategory(category)
+}
+
+// DeleteCategory moves an empty category to the trash
+func (s *LibraryService) DeleteCategory(id uint) error {
+	if _, err := s.GetCategoryByID(id); err != nil {
+		return err
+	}
+	count, err := s.repo.CountBooksInCategory(id)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrCategoryInUse
+	}
+	return s.repo.DeleteCategory(id)
+}
 
-	return s.repo.UpdateBook(book)
+// validateCategory checks that a category is named and that no other
+// category has the same name, ignoring case
+func (s *LibraryService) validateCategory(category *model.BookCategory) error {
+	category.Name = strings.TrimSpace(category.Name)
+	if category.Name == "" {
+		return ErrInvalidCategory
+	}
+	existing, err := s.repo.GetCategoryByName(category.Name)
+	if err == nil && existing.ID != category.ID {
+		return ErrDuplicateCategory
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
 }
 
 // Library Card Management
-func (s *LibraryService) IssueLibraryCard(userID uint, validForYears int) (*model.LibraryCard, error) {
-	// Check if user already has an active card
-	existingCard, err := s.repo.GetLibraryCardByUserID(userID)
-	if err == nil && existingCard.Status == "active" {
-		return nil, errors.New("user already has an active library card")
+
+// maxCardYears and maxCardBooks bound the validity and loan limit staff
+// can give a card
+const (
+	maxCardYears = 10
+	maxCardBooks = 50
+)
+
+// IssueLibraryCard gives a user their library card. A user has one card;
+// an expired, blocked or lost card is renewed, unblocked or replaced rather
+// than issued again.
+func (s *LibraryService) IssueLibraryCard(userID uint, validForYears int, issuedBy uint) (*model.LibraryCard, error) {
+	if validForYears < 1 || validForYears > maxCardYears {
+		return nil, ErrInvalidCardTerm
+	}
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
 	}
+	_, err = s.repo.GetLibraryCardByUserID(userID)
+	if err == nil {
+		return nil, ErrCardExists
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// The card shows the loan limit of the user's role at the time of issue;
+	// checkouts apply the current policy unless staff change the limit
+	terms, _ := s.policy.Terms(user.Role)
 
+	cardNumber, err := s.newCardNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
 	card := &model.LibraryCard{
 		UserID:     userID,
-		CardNumber: generateLibraryCardNumber(), // Implement this function
-		IssueDate:  time.Now(),
-		ExpiryDate: time.Now().AddDate(validForYears, 0, 0),
+		CardNumber: cardNumber,
+		IssueDate:  now,
+		ExpiryDate: now.AddDate(validForYears, 0, 0),
 		Status:     "active",
-		MaxBooks:   5, // Default value
+		MaxBooks:   terms.Limit,
 	}
+	event := &model.LibraryCardEvent{Type: model.CardIssued, ExpiryDate: &card.ExpiryDate, RecordedBy: issuedBy, OccurredAt: now}
 
-	if err := s.repo.CreateLibraryCard(card); err != nil {
+	if err := s.repo.CreateLibraryCard(card, event); err != nil {
 		return nil, err
 	}
+	return card, nil
+}
 
+// GetLibraryCard returns a card and its history. Readers may only see their
+// own card.
+func (s *LibraryService) GetLibraryCard(cardID, userID uint, staff bool) (*model.LibraryCard, error) {
+	card, err := s.findCard(cardID)
+	if err != nil {
+		return nil, err
+	}
+	if !staff && card.UserID != userID {
+		return nil, ErrCardNotFound
+	}
+	if card.Events, err = s.repo.ListCardEvents(card.ID); err != nil {
+		return nil, err
+	}
 	return card, nil
 }
 
-// Book Circulation
-func (s *LibraryService) CheckoutBook(bookID, userID, staffID uint) error {
-	// Get user's library card
-	card, err := s.repo.GetLibraryCardByUserID(userID)
+// GetCardHolder returns a card and the user it belongs to, for printing the
+// card. Readers may only print their own card.
+func (s *LibraryService) GetCardHolder(cardID, userID uint, staff bool) (*model.LibraryCard, *models.User, error) {
+	card, err := s.findCard(cardID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !staff && card.UserID != userID {
+		return nil, nil, ErrCardNotFound
+	}
+	holder, err := s.findUser(card.UserID)
 	if err != nil {
-		return errors.New("no active library card found")
+		return nil, nil, err
 	}
+	return card, holder, nil
+}
 
-	// Check if user has reached max books limit
-	// (Implementation depends on your repository methods)
+// GetCardByNumber finds the card with a scanned card number, telling a
+// replaced card apart from one that never existed
+func (s *LibraryService) GetCardByNumber(cardNumber string) (*model.LibraryCard, error) {
+	cardNumber = strings.TrimSpace(cardNumber)
+	if cardNumber == "" {
+		return nil, ErrCardNotFound
+	}
+	card, err := s.repo.GetLibraryCardByNumber(cardNumber)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return card, err
+	}
+	retired, err := s.repo.CardNumberRetired(cardNumber)
+	if err != nil {
+		return nil, err
+	}
+	if retired {
+		return nil, ErrCardReplaced
+	}
+	return nil, ErrCardNotFound
+}
 
-	// Create book issue record
-	issue := &model.BookIssue{
-		BookID:    bookID,
-		CardID:    card.ID,
-		UserID:    userID,
-		IssuedBy:  staffID,
-		Status:    "issued",
-		IssueDate: time.Now(),
-		DueDate:   time.Now().AddDate(0, 0, 14), // 14 days from now
+// RenewLibraryCard extends a card by years, from its expiry date or from
+// today if it has expired. An expired card becomes active again; a blocked
+// one stays blocked.
+func (s *LibraryService) RenewLibraryCard(cardID uint, years int, staffID uint) (*model.LibraryCard, error) {
+	if years < 1 || years > maxCardYears {
+		return nil, ErrInvalidCardTerm
+	}
+	card, err := s.findCard(cardID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	from := card.ExpiryDate
+	if from.Before(now) {
+		from = now
+	}
+	card.ExpiryDate = from.AddDate(years, 0, 0)
+	if card.Status == "expired" {
+		card.Status = "active"
+	}
+	event := &model.LibraryCardEvent{Type: model.CardRenewed, ExpiryDate: &card.ExpiryDate, RecordedBy: staffID, OccurredAt: now}
+
+	if err := s.repo.UpdateLibraryCard(card, event, "expiry_date", "status"); err != nil {
+		return nil, err
+	}
+	return card, nil
+}
+
+// BlockLibraryCard stops a card from being used to borrow until it is
+// unblocked
+func (s *LibraryService) BlockLibraryCard(cardID uint, reason string, staffID uint) (*model.LibraryCard, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrBlockReason
+	}
+	card, err := s.findCard(cardID)
+	if err != nil {
+		return nil, err
 	}
+	if card.Status == "blocked" {
+		return nil, ErrCardBlocked
+	}
+
+	now := time.Now()
+	card.Status = "blocked"
+	card.BlockedReason = reason
+	card.BlockedAt = &now
+	event := &model.LibraryCardEvent{Type: model.CardBlocked, Reason: reason, RecordedBy: staffID, OccurredAt: now}
 
-	return s.repo.CheckoutBook(issue)
+	if err := s.repo.UpdateLibraryCard(card, event, "status", "blocked_reason", "blocked_at"); err != nil {
+		return nil, err
+	}
+	return card, nil
 }
 
-func (s *LibraryService) ReturnBook(issueID, receivedBy uint) error {
-	return s.repo.ReturnBook(issueID, receivedBy)
+// UnblockLibraryCard lifts a block. The card is active again, or expired if
+// it ran out while blocked.
+func (s *LibraryService) UnblockLibraryCard(cardID uint, reason string, staffID uint) (*model.LibraryCard, error) {
+	card, err := s.findCard(cardID)
+	if err != nil {
+		return nil, err
+	}
+	if card.Status != "blocked" {
+		return nil, ErrCardNotBlocked
+	}
+
+	now := time.Now()
+	card.Status = "active"
+	if card.ExpiryDate.Before(now) {
+		card.Status = "expired"
+	}
+	card.BlockedReason = ""
+	card.BlockedAt = nil
+	event := &model.LibraryCardEvent{Type: model.CardUnblocked, Reason: strings.TrimSpace(reason), RecordedBy: staffID, OccurredAt: now}
+
+	if err := s.repo.UpdateLibraryCard(card, event, "status", "blocked_reason", "blocked_at"); err != nil {
+		return nil, err
+	}
+	return card, nil
 }
 
-// Fine Management
-func (s *LibraryService) CalculateFine(issueID uint) (float64, error) {
-	// Get the issue record
-	// Calculate fine based on due date and current date
-	// Return the fine amount
-	return 0, nil // Implement this
+// ReplaceLibraryCard gives a card a new number, e.g. when it was lost. The
+// old number stops working at once. Loans, fines and history stay with the
+// card, so nothing has to be carried over.
+func (s *LibraryService) ReplaceLibraryCard(cardID uint, reason string, staffID uint) (*model.LibraryCard, error) {
+	card, err := s.findCard(cardID)
+	if err != nil {
+		return nil, err
+	}
+	cardNumber, err := s.newCardNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	event := &model.LibraryCardEvent{
+		Type:          model.CardReplaced,
+		Reason:        strings.TrimSpace(reason),
+		OldCardNumber: card.CardNumber,
+		RecordedBy:    staffID,
+		OccurredAt:    now,
+	}
+	card.CardNumber = cardNumber
+
+	if err := s.repo.UpdateLibraryCard(card, event, "card_number"); err != nil {
+		return nil, err
+	}
+	return card, nil
+}
+
+// SetCardLimit gives a card its own loan limit, which applies instead of
+// the limit of the holder's role. A nil maxBooks goes back to the role's
+// limit.
+func (s *LibraryService) SetCardLimit(cardID uint, maxBooks *int, staffID uint) (*model.LibraryCard, error) {
+	if maxBooks != nil && (*maxBooks < 0 || *maxBooks > maxCardBooks) {
+		return nil, ErrInvalidCardLimit
+	}
+	card, err := s.findCard(cardID)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxBooks != nil {
+		card.MaxBooks = *maxBooks
+		card.CustomLimit = true
+	} else {
+		user, err := s.findUser(card.UserID)
+		if err != nil {
+			return nil, err
+		}
+		terms, _ := s.policy.Terms(user.Role)
+		card.MaxBooks = terms.Limit
+		card.CustomLimit = false
+	}
+	event := &model.LibraryCardEvent{Type: model.CardLimitChanged, MaxBooks: maxBooks, RecordedBy: staffID, OccurredAt: time.Now()}
+
+	if err := s.repo.UpdateLibraryCard(card, event, "max_books", "custom_limit"); err != nil {
+		return nil, err
+	}
+	return card, nil
+}
+
+func (s *LibraryService) findCard(id uint) (*model.LibraryCard, error) {
+	card, err := s.repo.GetLibraryCard(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCardNotFound
+	}
+	return card, err
+}
+
+// Copy Management
+func (s *LibraryService) ListCopies(bookID uint) ([]model.BookCopy, error) {
+	if _, err := s.GetBookByID(bookID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListCopies(bookID)
+}
+
+// AddCopies puts count new copies of a book on the shelf
+func (s *LibraryService) AddCopies(bookID uint, count int, rackNumber string) ([]model.BookCopy, error) {
+	book, err := s.GetBookByID(bookID)
+	if err != nil {
+		return nil, err
+	}
+	if count <= 0 {
+		return nil, ErrInvalidCopies
+	}
+	if rackNumber == "" {
+		rackNumber = book.RackNumber
+	}
+	copies, err := s.repo.AddCopies(bookID, count, rackNumber)
+	if err != nil {
+		return nil, err
+	}
+	s.fillHolds(bookID)
+	return copies, nil
 }
 
-func (s *LibraryService) RecordFinePayment(payment *model.FinePayment) error {
-	// Validate payment amount
-	// Record the payment
-	return s.repo.RecordFinePayment(payment)
+func (s *LibraryService) GetCopyByID(id uint) (*model.BookCopy, error) {
+	bookCopy, err := s.repo.GetCopyByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCopyNotFound
+	}
+	return bookCopy, err
+}
+
+func (s *LibraryService) GetCopyByBarcode(barcode string) (*model.BookCopy, error) {
+	bookCopy, err := s.repo.GetCopyByBarcode(strings.TrimSpace(barcode))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCopyNotFound
+	}
+	return bookCopy, err
+}
+
+// UpdateCopy saves changes to a copy. previousStatus is the status before
+// the changes; copies on loan change status only through returns.
+func (s *LibraryService) UpdateCopy(bookCopy *model.BookCopy, previousStatus string) error {
+	bookCopy.Barcode = strings.TrimSpace(bookCopy.Barcode)
+	if bookCopy.Barcode == "" || !copyConditions[bookCopy.Condition] {
+		return ErrInvalidCopy
+	}
+	if bookCopy.Status != previousStatus {
+		if previousStatus == model.CopyIssued {
+			return ErrCopyOnLoan
+		}
+		if previousStatus == model.CopyOnHold {
+			return ErrCopyOnHold
+		}
+		if !manualCopyStatuses[bookCopy.Status] {
+			return ErrInvalidCopy
+		}
+	}
+
+	existing, err := s.repo.GetCopyByBarcode(bookCopy.Barcode)
+	if err == nil && existing.ID != bookCopy.ID {
+		return ErrDuplicateBarcode
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if err := s.repo.UpdateCopy(bookCopy); err != nil {
+		return err
+	}
+	if bookCopy.Status == model.CopyAvailable && previousStatus != model.CopyAvailable {
+		s.fillHolds(bookCopy.BookID)
+	}
+	return nil
 }
 
-// Helper function to generate library card number
-func generateLibraryCardNumber() string {
-	// Implement your card number generation logic
-	// Example: LIB-{timestamp}-{random}
-	return "LIB-12345678"
+// LabelCopies returns the copies to print labels for, with their books:
+// the listed copies, or all copies of a book
+func (s *LibraryService) LabelCopies(bookID uint, copyIDs []uint) ([]model.BookCopy, error) {
+	if len(copyIDs) > 0 {
+		return s.repo.ListCopiesByID(copyIDs)
+	}
+	book, err := s.GetBookByID(bookID)
+	if err != nil {
+		return nil, err
+	}
+	copies, err := s.repo.ListCopies(bookID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range copies {
+		copies[i].Book = book
+	}
+	return copies, nil
+}
+
+// Book Circulation
+
+// CheckoutBook lends a copy to a user. The copy is the one scanned by
+// barcode or, given only a book, the copy held for the user's reservation
+// or else any copy on the shelf.
+func (s *LibraryService) CheckoutBook(barcode string, bookID, userID uint, issuedBy model.Attendant) (*model.BookIssue, error) {
+	if (barcode == "") == (bookID == 0) {
+		return nil, ErrCheckoutTarget
+	}
+
+	// Holds past their pickup window must not keep a copy from the desk
+	if _, err := s.ExpireHolds(); err != nil {
+		return nil, err
+	}
+
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var bookCopy *model.BookCopy
+	if barcode != "" {
+		if bookCopy, err = s.GetCopyByBarcode(barcode); err != nil {
+			return nil, err
+		}
+		bookID = bookCopy.BookID
+	}
+
+	book, err := s.GetBookByID(bookID)
+	if err != nil {
+		return nil, err
+	}
+	if !book.IsActive {
+		return nil, ErrBookInactive
+	}
+
+	if bookCopy == nil {
+		if reservation, err := s.repo.FindActiveReservation(bookID, userID); err == nil && reservation.Copy != nil {
+			bookCopy = reservation.Copy
+		} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+	if bookCopy == nil {
+		bookCopy, err = s.repo.FindAvailableCopy(bookID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNoCopyAvailable
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	borrower, err := s.borrower(user, now)
+	if err != nil {
+		return nil, err
+	}
+	terms, err := s.policy.CheckLoan(borrower, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create book issue record
+	issue := &model.BookIssue{
+		BookID:        bookID,
+		CopyID:        &bookCopy.ID,
+		CardID:        borrower.Card.ID,
+		UserID:        userID,
+		IssuedBy:      issuedBy.StaffID,
+		IssuedByKiosk: issuedBy.KioskID,
+		Status:        "issued",
+		IssueDate:     now,
+		DueDate:       now.Add(terms.Period),
+	}
+
+	// The limit is checked again as the loan is made, in case another
+	// checkout for the borrower got in first
+	if err := s.repo.CheckoutBook(issue, terms.Limit); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrCopyUnavailable):
+			return nil, ErrCopyNotAvailable
+		case errors.Is(err, repository.ErrCopyHeld):
+			return nil, ErrCopyOnHold
+		case errors.Is(err, repository.ErrLoanLimit):
+			return nil, refuse(RefusalLoanLimit,
+				fmt.Sprintf("the borrower already has %d of %d books on loan", terms.Limit, terms.Limit),
+				map[string]interface{}{"open_loans": terms.Limit, "limit": terms.Limit})
+		}
+		return nil, err
+	}
+	// A copy the borrower had on hold besides the one lent is free again
+	s.fillHolds(bookID)
+	return issue, nil
+}
+
+// ReturnBook closes a loan and charges the overdue fine. The copy is held
+// for the next reader waiting for the book, who is told to pick it up.
+func (s *LibraryService) ReturnBook(issueID uint, receivedBy model.Attendant) error {
+	issue, err := s.repo.GetIssueByID(issueID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrIssueNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if issue.ReturnDate != nil {
+		return ErrAlreadyReturned
+	}
+
+	now := time.Now()
+	fine, err := s.quoteFine(issue, now)
+	if err != nil {
+		return err
+	}
+	ready, err := s.repo.ReturnBook(issueID, receivedBy, fine.Amount, now, s.policy.PickupWindow())
+	if errors.Is(err, repository.ErrAlreadyReturned) {
+		return ErrAlreadyReturned
+	}
+	if err != nil {
+		return err
+	}
+	s.notifyReady(ready)
+	return nil
+}
+
+// ReturnCopy closes the loan of a scanned copy and returns the loan
+func (s *LibraryService) ReturnCopy(barcode string, receivedBy model.Attendant) (*model.BookIssue, error) {
+	bookCopy, err := s.GetCopyByBarcode(barcode)
+	if err != nil {
+		return nil, err
+	}
+	issue, err := s.repo.GetOpenIssueByCopy(bookCopy.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCopyNotOnLoan
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.ReturnBook(issue.ID, receivedBy); err != nil {
+		return nil, err
+	}
+	return s.repo.GetIssueByID(issue.ID)
+}
+
+// DeclareLost closes a loan whose book the borrower lost. The borrower is
+// charged the overdue fine so far and the replacement charge of the book.
+func (s *LibraryService) DeclareLost(issueID, staffID uint) (*model.BookIssue, error) {
+	return s.writeOffLoan(issueID, staffID, "lost")
+}
+
+// ReturnDamaged closes a loan whose book came back too damaged to lend
+// again. Like a lost book, the borrower pays the overdue fine and the
+// replacement charge.
+func (s *LibraryService) ReturnDamaged(issueID, staffID uint) (*model.BookIssue, error) {
+	return s.writeOffLoan(issueID, staffID, "damaged")
+}
+
+func (s *LibraryService) writeOffLoan(issueID, staffID uint, status string) (*model.BookIssue, error) {
+	issue, err := s.repo.GetIssueByID(issueID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrIssueNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if issue.ReturnDate != nil {
+		return nil, ErrAlreadyReturned
+	}
+
+	now := time.Now()
+	quote, err := s.quoteFine(issue, now)
+	if err != nil {
+		return nil, err
+	}
+	err = s.repo.WriteOffLoan(issueID, staffID, status, quote.Amount, quote.LostCharge, now)
+	if errors.Is(err, repository.ErrAlreadyReturned) {
+		return nil, ErrAlreadyReturned
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetIssueByID(issueID)
+}
+
+// RecoverLost takes back a loan declared lost when the book turns up. The
+// replacement charge is reversed; the overdue fine charged when it was
+// declared lost stands.
+func (s *LibraryService) RecoverLost(issueID, staffID uint) (*model.BookIssue, error) {
+	ready, err := s.repo.RecoverLostBook(issueID, staffID, time.Now(), s.policy.PickupWindow())
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, ErrIssueNotFound
+	case errors.Is(err, repository.ErrNotLost):
+		return nil, ErrLoanNotLost
+	case err != nil:
+		return nil, err
+	}
+	s.notifyReady(ready)
+	return s.repo.GetIssueByID(issueID)
+}
+
+// GetLoan returns a loan with its renewal history. Borrowers may only see
+// their own loans; staff may see any.
+func (s *LibraryService) GetLoan(issueID, userID uint, staff bool) (*model.BookIssue, error) {
+	issue, err := s.repo.GetIssueByID(issueID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !staff && issue.UserID != userID) {
+		return nil, ErrIssueNotFound
+	}
+	return issue, err
+}
+
+// RenewLoan extends the due date of a loan on behalf of userID, who must be
+// the borrower unless staff is set. The circulation policy decides whether
+// and until when.
+func (s *LibraryService) RenewLoan(issueID, userID uint, staff bool) (*model.BookIssue, error) {
+	issue, err := s.GetLoan(issueID, userID, staff)
+	if err != nil {
+		return nil, err
+	}
+	if issue.ReturnDate != nil {
+		return nil, ErrAlreadyReturned
+	}
+
+	user, err := s.findUser(issue.UserID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	borrower, err := s.borrower(user, now)
+	if err != nil {
+		return nil, err
+	}
+	queued, err := s.repo.CountQueuedReservations(issue.BookID)
+	if err != nil {
+		return nil, err
+	}
+	newDue, err := s.policy.CheckRenewal(borrower, issue, queued, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.repo.RenewIssue(issue, newDue, userID, now); err != nil {
+		if errors.Is(err, repository.ErrLoanChanged) {
+			return nil, ErrLoanChanged
+		}
+		return nil, err
+	}
+	return s.repo.GetIssueByID(issueID)
+}
+
+// Reservations
+
+// PlaceReservation queues a reader for a book with no copies on the shelf.
+// Readers who could not borrow the book when it comes up are refused, except
+// for being at their loan limit, which a return can resolve in time.
+func (s *LibraryService) PlaceReservation(bookID, userID uint) (*model.BookReservation, error) {
+	book, err := s.GetBookByID(bookID)
+	if err != nil {
+		return nil, err
+	}
+	if !book.IsActive {
+		return nil, ErrBookInactive
+	}
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	borrower, err := s.borrower(user, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.policy.CheckLoan(borrower, time.Now()); err != nil {
+		var refusal *PolicyError
+		if !errors.As(err, &refusal) || refusal.Code != RefusalLoanLimit {
+			return nil, err
+		}
+	}
+
+	if _, err := s.repo.FindActiveReservation(bookID, userID); err == nil {
+		return nil, ErrAlreadyReserved
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	onLoan, err := s.repo.CountUserOpenIssues(bookID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if onLoan > 0 {
+		return nil, ErrAlreadyBorrowed
+	}
+	if book.AvailableCopies > 0 {
+		return nil, ErrCopiesOnShelf
+	}
+
+	reservation := &model.BookReservation{
+		BookID: bookID,
+		UserID: userID,
+		Status: model.ReservationWaiting,
+	}
+	if err := s.repo.CreateReservation(reservation); err != nil {
+		return nil, err
+	}
+	// A copy may have come back between the check and the reservation
+	s.fillHolds(bookID)
+	return s.GetReservation(reservation.ID)
+}
+
+// GetReservation returns a reservation with its place in the queue
+func (s *LibraryService) GetReservation(id uint) (*model.BookReservation, error) {
+	reservation, err := s.repo.GetReservationByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrReservationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.setPosition(reservation); err != nil {
+		return nil, err
+	}
+	return reservation, nil
+}
+
+// ListReservations returns a reader's reservations, newest first
+func (s *LibraryService) ListReservations(userID uint) ([]model.BookReservation, error) {
+	reservations, err := s.repo.ListUserReservations(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range reservations {
+		if err := s.setPosition(&reservations[i]); err != nil {
+			return nil, err
+		}
+	}
+	return reservations, nil
+}
+
+// ListBookReservations returns the queue for a book in the order readers
+// will be served
+func (s *LibraryService) ListBookReservations(bookID uint) ([]model.BookReservation, error) {
+	if _, err := s.GetBookByID(bookID); err != nil {
+		return nil, err
+	}
+	reservations, err := s.repo.ListBookReservations(bookID)
+	if err != nil {
+		return nil, err
+	}
+	var position int64
+	for i := range reservations {
+		if reservations[i].Status == model.ReservationWaiting {
+			position++
+			reservations[i].Position = position
+		}
+	}
+	return reservations, nil
+}
+
+// CancelReservation takes a reservation out of the queue. Readers may only
+// cancel their own; staff may cancel any.
+func (s *LibraryService) CancelReservation(id, userID uint, staff bool) (*model.BookReservation, error) {
+	reservation, err := s.repo.GetReservationByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !staff && reservation.UserID != userID) {
+		return nil, ErrReservationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ready, err := s.repo.CancelReservation(id, time.Now(), s.policy.PickupWindow())
+	if errors.Is(err, repository.ErrReservationClosed) {
+		return nil, ErrReservationClosed
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.notifyReady(ready)
+	return s.GetReservation(id)
+}
+
+// ExpireHolds ends the holds whose pickup window has passed, offers the
+// copies to the next readers in line and returns how many holds ended
+func (s *LibraryService) ExpireHolds() (int, error) {
+	expired, ready, err := s.repo.ExpireHolds(time.Now(), s.policy.PickupWindow())
+	// Readers are told about what did change even if a later hold failed
+	s.notifyExpired(expired)
+	s.notifyReady(ready)
+	return len(expired), err
+}
+
+func (s *LibraryService) setPosition(reservation *model.BookReservation) error {
+	if reservation.Status != model.ReservationWaiting {
+		return nil
+	}
+	position, err := s.repo.QueuePosition(reservation)
+	reservation.Position = position
+	return err
+}
+
+// fillHolds holds copies on the shelf for readers waiting for the book. The
+// change that freed the copies has been saved already, so a failure here is
+// only logged; the next return or expiry run fills the holds.
+func (s *LibraryService) fillHolds(bookID uint) {
+	ready, err := s.repo.FillHolds(bookID, time.Now(), s.policy.PickupWindow())
+	if err != nil {
+		log.Printf("Failed to hold copies of book %d for reservations: %v", bookID, err)
+		return
+	}
+	s.notifyReady(ready)
+}
+
+// notifyReady tells readers that a copy is waiting for them. Delivery
+// failures are logged; the hold stands either way.
+func (s *LibraryService) notifyReady(reservations []model.BookReservation) {
+	var notified []uint
+	for _, reservation := range reservations {
+		err := s.notifyReader(reservation, "Your reserved copy of %s is ready for pickup",
+			"A copy of \"%s\" you reserved is waiting for you at the library desk.\n\n"+
+				"Please pick it up by %s; after that it goes to the next reader in line.\n")
+		if err != nil {
+			log.Printf("Failed to notify reader of reservation %d: %v", reservation.ID, err)
+			continue
+		}
+		notified = append(notified, reservation.ID)
+	}
+	if len(notified) > 0 {
+		if err := s.repo.MarkReservationsNotified(notified, time.Now()); err != nil {
+			log.Printf("Failed to record reservation notifications: %v", err)
+		}
+	}
+}
+
+// notifyExpired tells readers that they missed their pickup window
+func (s *LibraryService) notifyExpired(reservations []model.BookReservation) {
+	for _, reservation := range reservations {
+		err := s.notifyReader(reservation, "Your hold on %s has expired",
+			"The copy of \"%s\" held for you was not picked up by %s and has gone to the next reader in line.\n\n"+
+				"You can reserve the book again from the catalogue.\n")
+		if err != nil {
+			log.Printf("Failed to notify reader of expired reservation %d: %v", reservation.ID, err)
+		}
+	}
+}
+
+// notifyReader emails the reader of a reservation. subject is formatted
+// with the book title, body with the title and the end of the pickup window.
+func (s *LibraryService) notifyReader(reservation model.BookReservation, subject, body string) error {
+	user, err := s.userRepo.FindByID(reservation.UserID)
+	if err != nil {
+		return err
+	}
+	book, err := s.repo.GetBookByID(reservation.BookID)
+	if err != nil {
+		return err
+	}
+	var pickupBy string
+	if reservation.ExpiresAt != nil {
+		pickupBy = reservation.ExpiresAt.Format("Mon 2 Jan 2006 15:04")
+	}
+	return s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: fmt.Sprintf(subject, book.Title),
+		Body:    fmt.Sprintf("Hello %s,\n\n", user.FirstName) + fmt.Sprintf(body, book.Title, pickupBy),
+	})
+}
+
+// GetBorrowerStatus reports whether a user may borrow right now, with the
+// terms they get or the reason they are refused
+func (s *LibraryService) GetBorrowerStatus(userID uint) (*BorrowerStatus, error) {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	borrower, err := s.borrower(user, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	status := &BorrowerStatus{
+		UserID:       user.ID,
+		Role:         user.Role,
+		Card:         borrower.Card,
+		OpenLoans:    borrower.OpenLoans,
+		OverdueLoans: borrower.OverdueLoans,
+		UnpaidFines:  borrower.UnpaidFines,
+	}
+	if terms, ok := s.policy.BorrowerTerms(borrower); ok {
+		status.Terms = &terms
+	}
+	if _, err := s.policy.CheckLoan(borrower, time.Now()); err != nil {
+		var refusal *PolicyError
+		if !errors.As(err, &refusal) {
+			return nil, err
+		}
+		status.Refusal = refusal
+	} else {
+		status.CanBorrow = true
+	}
+	return status, nil
+}
+
+// borrower gathers what the circulation policy needs to know about a user
+func (s *LibraryService) borrower(user *models.User, now time.Time) (Borrower, error) {
+	borrower := Borrower{Role: user.Role}
+
+	card, err := s.repo.GetLibraryCardByUserID(user.ID)
+	switch {
+	case err == nil:
+		borrower.Card = card
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return borrower, err
+	}
+
+	summary, err := s.repo.GetLoanSummary(user.ID, now)
+	if err != nil {
+		return borrower, err
+	}
+	borrower.OpenLoans = summary.OpenLoans
+	borrower.OverdueLoans = summary.OverdueLoans
+	borrower.UnpaidFines = summary.UnpaidFines
+	return borrower, nil
+}
+
+func (s *LibraryService) findUser(id uint) (*models.User, error) {
+	user, err := s.userRepo.FindByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
+}
+
+// Scheduled circulation jobs
+
+// MarkOverdueLoans sets the status of loans past their due date to overdue
+// and returns how many changed
+func (s *LibraryService) MarkOverdueLoans() (int64, error) {
+	return s.repo.MarkOverdue(time.Now())
+}
+
+// ExpireLibraryCards sets the status of cards past their expiry date to
+// expired and returns how many changed
+func (s *LibraryService) ExpireLibraryCards() (int64, error) {
+	return s.repo.ExpireCards(time.Now())
+}
+
+// SendLoanReminders emails borrowers whose loans fall due within the due
+// soon window, once per due date, and borrowers with overdue loans, once
+// per overdue reminder interval. It returns how many of each were sent;
+// failed deliveries are logged and tried again on the next run.
+func (s *LibraryService) SendLoanReminders() (dueSoon, overdue int, err error) {
+	now := time.Now()
+
+	due, err := s.repo.GetBooksDueBefore(now, now.Add(s.policy.DueSoonWindow()))
+	if err != nil {
+		return 0, 0, err
+	}
+	var reminded []uint
+	for _, issue := range due {
+		if issue.DueReminderAt != nil {
+			continue
+		}
+		err := s.remindBorrower(issue, "%s is due back soon",
+			"\"%s\" is due back at the library on %s.\n\n"+
+				"You can renew the loan unless other readers are waiting for the book.\n")
+		if err != nil {
+			log.Printf("Failed to remind borrower of loan %d: %v", issue.ID, err)
+			continue
+		}
+		reminded = append(reminded, issue.ID)
+	}
+	if len(reminded) > 0 {
+		if err := s.repo.MarkRemindersSent(reminded, false, now); err != nil {
+			return len(reminded), 0, err
+		}
+	}
+	dueSoon = len(reminded)
+
+	late, err := s.repo.GetOverdueBooks(now)
+	if err != nil {
+		return dueSoon, 0, err
+	}
+	reminded = nil
+	since := now.Add(-s.policy.OverdueReminderInterval())
+	for _, issue := range late {
+		if issue.OverdueReminderAt != nil && issue.OverdueReminderAt.After(since) {
+			continue
+		}
+		err := s.remindBorrower(issue, "%s is overdue",
+			"\"%s\" was due back at the library on %s.\n\n"+
+				"Please return it as soon as you can; a fine is charged for every day it is late.\n")
+		if err != nil {
+			log.Printf("Failed to remind borrower of overdue loan %d: %v", issue.ID, err)
+			continue
+		}
+		reminded = append(reminded, issue.ID)
+	}
+	if len(reminded) > 0 {
+		if err := s.repo.MarkRemindersSent(reminded, true, now); err != nil {
+			return dueSoon, len(reminded), err
+		}
+	}
+	return dueSoon, len(reminded), nil
+}
+
+// remindBorrower emails the borrower of a loan. subject is formatted with
+// the book title, body with the title and the due date. Loans of deleted
+// accounts are skipped without an error.
+func (s *LibraryService) remindBorrower(issue model.BookIssue, subject, body string) error {
+	if issue.User == nil || issue.Book == nil {
+		return nil
+	}
+	due := issue.DueDate.In(time.Local).Format("Mon 2 Jan 2006")
+	return s.mailer.Send(mailer.Message{
+		To:      issue.User.Email,
+		Subject: fmt.Sprintf(subject, issue.Book.Title),
+		Body:    fmt.Sprintf("Hello %s,\n\n", issue.User.FirstName) + fmt.Sprintf(body, issue.Book.Title, due),
+	})
+}
+
+// Fine Management
+
+// CalculateFine returns the fine of a loan: what has accrued so far while it
+// is open, or what was charged when it was returned
+func (s *LibraryService) CalculateFine(issueID uint) (decimal.Decimal, error) {
+	issue, err := s.repo.GetIssueByID(issueID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return decimal.Zero, ErrIssueNotFound
+	}
+	if err != nil {
+		return decimal.Zero, err
+	}
+	quote, err := s.fineOf(issue)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return quote.Amount, nil
+}
+
+// GetFine explains the fine of a loan and what losing the book would cost.
+// Borrowers may only see their own loans; staff may see any.
+func (s *LibraryService) GetFine(issueID, userID uint, staff bool) (*FineQuote, error) {
+	issue, err := s.GetLoan(issueID, userID, staff)
+	if err != nil {
+		return nil, err
+	}
+	quote, err := s.fineOf(issue)
+	if err != nil {
+		return nil, err
+	}
+	return &quote, nil
+}
+
+// fineOf works out the fine of an open loan as of now. For a returned loan
+// it explains the fine as of the return, with the amount that was charged.
+func (s *LibraryService) fineOf(issue *model.BookIssue) (FineQuote, error) {
+	if issue.ReturnDate == nil {
+		quote, err := s.quoteFine(issue, time.Now())
+		quote.Accruing = true
+		return quote, err
+	}
+	quote, err := s.quoteFine(issue, *issue.ReturnDate)
+	quote.Amount = issue.FineAmount
+	return quote, err
+}
+
+// quoteFine works out the overdue fine of a loan returned at until
+func (s *LibraryService) quoteFine(issue *model.BookIssue, until time.Time) (FineQuote, error) {
+	var category string
+	if issue.Book != nil && issue.Book.Category != nil {
+		category = issue.Book.Category.Name
+	}
+	// Erased borrowers have no role any more and pay the default rate
+	var role string
+	user, err := s.userRepo.FindByID(issue.UserID)
+	switch {
+	case err == nil:
+		role = user.Role
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return FineQuote{}, err
+	}
+
+	holidays, err := s.repo.ListHolidays(issue.DueDate, until)
+	if err != nil {
+		return FineQuote{}, err
+	}
+	quote := s.fines.Overdue(issue.DueDate, until, s.fines.DailyRate(category, role), holidays)
+	if issue.Book != nil {
+		quote.LostCharge = s.fines.LostCharge(issue.Book)
+	}
+	return quote, nil
+}
+
+// Holiday calendar
+
+// ListHolidays returns the library closures that overlap from..to
+func (s *LibraryService) ListHolidays(from, to time.Time) ([]model.LibraryHoliday, error) {
+	return s.repo.ListHolidays(from, to)
+}
+
+// AddHoliday closes the library on the days from holiday.StartDate to
+// holiday.EndDate, so that no fines are charged for them
+func (s *LibraryService) AddHoliday(holiday *model.LibraryHoliday) error {
+	holiday.Name = strings.TrimSpace(holiday.Name)
+	if holiday.Name == "" || holiday.StartDate.IsZero() || holiday.EndDate.Before(holiday.StartDate) ||
+		holiday.EndDate.After(holiday.StartDate.AddDate(1, 0, 0)) {
+		return ErrInvalidHoliday
+	}
+	return s.repo.CreateHoliday(holiday)
+}
+
+func (s *LibraryService) DeleteHoliday(id uint) error {
+	if _, err := s.repo.GetHolidayByID(id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrHolidayNotFound
+		}
+		return err
+	}
+	return s.repo.DeleteHoliday(id)
+}
+
+// Fine ledger
+
+// FineLedger is a library card's fine balance with the entries behind it
+type FineLedger struct {
+	Card    *model.LibraryCard      `json:"card"`
+	Balance decimal.Decimal         `json:"balance"`
+	Entries []model.FineLedgerEntry `json:"entries"`
+}
+
+// FineReceipt is a payment with the card balance it left
+type FineReceipt struct {
+	Payment *model.FinePayment
+	Balance decimal.Decimal
+}
+
+// GetFineLedger returns the fine ledger of a user's library card
+func (s *LibraryService) GetFineLedger(userID uint) (*FineLedger, error) {
+	card, err := s.repo.GetLibraryCardByUserID(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNoLibraryCard
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.repo.ListLedger(card.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &FineLedger{Card: card, Balance: card.FineAmount, Entries: entries}, nil
+}
+
+// RecordFinePayment takes a payment of part or all of the fine outstanding
+// on a loan and returns its ledger entry
+func (s *LibraryService) RecordFinePayment(payment *model.FinePayment) (*model.FineLedgerEntry, error) {
+	if !validFineAmount(payment.Amount) || !paymentModes[payment.PaymentMode] {
+		return nil, ErrInvalidPayment
+	}
+	payment.ReferenceNo = strings.TrimSpace(payment.ReferenceNo)
+	payment.PaymentDate = time.Now()
+	payment.Refunded = decimal.Zero
+
+	entry, err := s.repo.RecordFinePayment(payment)
+	return entry, fineLedgerError(err, ErrIssueNotFound)
+}
+
+// WaiveFine writes off part or all of the fine outstanding on a loan. The
+// librarian approving it gives the reason.
+func (s *LibraryService) WaiveFine(issueID uint, amount decimal.Decimal, reason string, approvedBy uint) (*model.FineLedgerEntry, error) {
+	reason = strings.TrimSpace(reason)
+	if !validFineAmount(amount) || reason == "" {
+		return nil, ErrInvalidAdjustment
+	}
+	entry, err := s.repo.WaiveFine(issueID, amount, reason, approvedBy, time.Now())
+	return entry, fineLedgerError(err, ErrIssueNotFound)
+}
+
+// RefundPayment gives back part or all of a payment. What is refunded is
+// owed again; waive it as well when the fine itself is cancelled.
+func (s *LibraryService) RefundPayment(paymentID uint, amount decimal.Decimal, reason string, refundedBy uint) (*model.FineLedgerEntry, error) {
+	reason = strings.TrimSpace(reason)
+	if !validFineAmount(amount) || reason == "" {
+		return nil, ErrInvalidAdjustment
+	}
+	entry, err := s.repo.RefundPayment(paymentID, amount, reason, refundedBy, time.Now())
+	return entry, fineLedgerError(err, ErrPaymentNotFound)
+}
+
+// GetFineReceipt returns what goes on the receipt of a payment. Borrowers
+// may only see receipts for their own loans; staff may see any.
+func (s *LibraryService) GetFineReceipt(paymentID, userID uint, staff bool) (*FineReceipt, error) {
+	payment, err := s.repo.GetFinePayment(paymentID)
+	if errors.Is(err, gorm.ErrRecordNotFound) ||
+		(err == nil && !staff && (payment.BookIssue == nil || payment.BookIssue.UserID != userID)) {
+		return nil, ErrPaymentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	entry, err := s.repo.GetPaymentEntry(payment.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FineReceipt{Payment: payment, Balance: entry.Balance}, nil
+}
+
+// paymentModes are the ways a fine can be paid
+var paymentModes = map[string]bool{"cash": true, "card": true, "online": true}
+
+// validFineAmount accepts positive amounts in whole cents
+func validFineAmount(amount decimal.Decimal) bool {
+	return amount.IsPositive() && amount.Equal(amount.Round(2))
+}
+
+// fineLedgerError maps ledger errors from the repository. notFound is
+// returned when the loan or payment posted against does not exist.
+func fineLedgerError(err error, notFound error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return notFound
+	case errors.Is(err, repository.ErrNoFineOwed):
+		return ErrNoFineOwed
+	case errors.Is(err, repository.ErrFineExceeded):
+		return ErrFineExceeded
+	case errors.Is(err, repository.ErrRefundExceeded):
+		return ErrRefundExceeded
+	}
+	return err
+}
+
+// Reports
+
+const (
+	defaultReportLimit = 20
+	maxReportLimit     = 100
+	// inactivePeriod is how far back the inactive cardholders report looks
+	// when no start date is given
+	inactivePeriod = 180 * 24 * time.Hour
+)
+
+// CirculationMonth counts the loans of a category issued and returned in a
+// month
+type CirculationMonth struct {
+	Month      string `json:"month"` // YYYY-MM
+	CategoryID uint   `json:"category_id"`
+	Category   string `json:"category"`
+	Issued     int64  `json:"issued"`
+	Returned   int64  `json:"returned"`
+}
+
+// OverdueReport is the overdue rate of each category and of the whole
+// library
+type OverdueReport struct {
+	Categories []repository.CategoryOverdue `json:"categories"`
+	Total      repository.CategoryOverdue   `json:"total"`
+}
+
+// FineCollectionReport is what was collected in fines per payment mode and
+// in all
+type FineCollectionReport struct {
+	PaymentModes []repository.PaymentModeTotal `json:"payment_modes"`
+	Total        repository.PaymentModeTotal   `json:"total"`
+}
+
+// MostBorrowed returns the titles lent most often in rng. limit defaults to
+// 20 and is capped at 100.
+func (s *LibraryService) MostBorrowed(rng repository.ReportRange, categoryID uint, limit int) ([]repository.BorrowedTitle, error) {
+	if err := validReportRange(rng); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultReportLimit
+	}
+	if limit > maxReportLimit {
+		limit = maxReportLimit
+	}
+	return s.repo.MostBorrowed(rng, categoryID, limit)
+}
+
+// CirculationByMonth counts the loans issued and returned in rng per
+// category and calendar month, oldest month first
+func (s *LibraryService) CirculationByMonth(rng repository.ReportRange) ([]CirculationMonth, error) {
+	if err := validReportRange(rng); err != nil {
+		return nil, err
+	}
+	issued, err := s.repo.ListLoanEvents(rng, false)
+	if err != nil {
+		return nil, err
+	}
+	returned, err := s.repo.ListLoanEvents(rng, true)
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct {
+		month    string
+		category uint
+	}
+	months := map[key]*CirculationMonth{}
+	count := func(events []repository.LoanEvent, returns bool) {
+		for _, event := range events {
+			k := key{event.At.In(time.Local).Format("2006-01"), event.CategoryID}
+			month, ok := months[k]
+			if !ok {
+				month = &CirculationMonth{Month: k.month, CategoryID: event.CategoryID, Category: event.Category}
+				months[k] = month
+			}
+			if returns {
+				month.Returned++
+			} else {
+				month.Issued++
+			}
+		}
+	}
+	count(issued, false)
+	count(returned, true)
+
+	rows := make([]CirculationMonth, 0, len(months))
+	for _, month := range months {
+		rows = append(rows, *month)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Month != rows[j].Month {
+			return rows[i].Month < rows[j].Month
+		}
+		return rows[i].Category < rows[j].Category
+	})
+	return rows, nil
+}
+
+// OverdueRates works out the share of the loans issued in rng that came
+// back late or are overdue now, per category and in all
+func (s *LibraryService) OverdueRates(rng repository.ReportRange) (*OverdueReport, error) {
+	if err := validReportRange(rng); err != nil {
+		return nil, err
+	}
+	categories, err := s.repo.OverdueByCategory(rng, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	report := &OverdueReport{Categories: categories, Total: repository.CategoryOverdue{Category: "Total"}}
+	for i := range report.Categories {
+		category := &report.Categories[i]
+		category.Rate = overdueRate(category)
+		report.Total.Loans += category.Loans
+		report.Total.Returned += category.Returned
+		report.Total.ReturnedLate += category.ReturnedLate
+		report.Total.Overdue += category.Overdue
+	}
+	report.Total.Rate = overdueRate(&report.Total)
+	return report, nil
+}
+
+func overdueRate(row *repository.CategoryOverdue) float64 {
+	if row.Loans == 0 {
+		return 0
+	}
+	rate := float64(row.ReturnedLate+row.Overdue) / float64(row.Loans)
+	return math.Round(rate*10000) / 10000
+}
+
+// FinesCollected sums up the fine payments taken in rng per payment mode.
+// Net is what is left after refunds.
+func (s *LibraryService) FinesCollected(rng repository.ReportRange) (*FineCollectionReport, error) {
+	if err := validReportRange(rng); err != nil {
+		return nil, err
+	}
+	modes, err := s.repo.FinesByPaymentMode(rng)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &FineCollectionReport{PaymentModes: modes, Total: repository.PaymentModeTotal{PaymentMode: "total"}}
+	for i := range report.PaymentModes {
+		mode := &report.PaymentModes[i]
+		mode.Net = mode.Amount.Sub(mode.Refunded)
+		report.Total.Payments += mode.Payments
+		report.Total.Amount = report.Total.Amount.Add(mode.Amount)
+		report.Total.Refunded = report.Total.Refunded.Add(mode.Refunded)
+	}
+	report.Total.Net = report.Total.Amount.Sub(report.Total.Refunded)
+	return report, nil
+}
+
+// InactiveCardholders returns the holders of active cards who borrowed
+// nothing in rng. Without a start date it looks back 180 days.
+func (s *LibraryService) InactiveCardholders(rng repository.ReportRange) ([]repository.InactiveCardholder, error) {
+	if rng.From.IsZero() {
+		end := rng.To
+		if end.IsZero() {
+			end = time.Now()
+		}
+		rng.From = end.Add(-inactivePeriod)
+	}
+	if err := validReportRange(rng); err != nil {
+		return nil, err
+	}
+	return s.repo.InactiveCardholders(rng)
+}
+
+// UnborrowedBooks returns the active books nobody borrowed in rng. With an
+// open range, those never borrowed at all.
+func (s *LibraryService) UnborrowedBooks(rng repository.ReportRange, categoryID uint) ([]repository.UnborrowedBook, error) {
+	if err := validReportRange(rng); err != nil {
+		return nil, err
+	}
+	return s.repo.UnborrowedBooks(rng, categoryID)
+}
+
+func validReportRange(rng repository.ReportRange) error {
+	if !rng.From.IsZero() && !rng.To.IsZero() && !rng.To.After(rng.From) {
+		return ErrInvalidReportRange
+	}
+	return nil
+}
+
+// newCardNumber generates a card number that no card has had, including
+// deleted ones, so an old card can never be mistaken for a new one
+func (s *LibraryService) newCardNumber() (string, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		number, err := generateLibraryCardNumber()
+		if err != nil {
+			return "", err
+		}
+		taken, err := s.repo.CardNumberTaken(number)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return number, nil
+		}
+	}
+	return "", errors.New("failed to generate an unused library card number")
+}
+
+// generateLibraryCardNumber returns a random card number of ten digits,
+// e.g. LIB-4821093755, which fits on the barcode of the card
+func generateLibraryCardNumber() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(10_000_000_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("LIB-%010d", n), nil
 }
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/E-Timileyin/school-management-system/internal/config"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+)
+
+var ErrLoginThrottled = errors.New("too many failed login attempts")
+
+// ThrottledError reports how long a client has to wait before trying again
+type ThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool // The account or IP is locked rather than just slowed down
+}
+
+func (e *ThrottledError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("%s, try again in %s", ErrLoginThrottled, e.RetryAfter.Round(time.Second))
+	}
+	return fmt.Sprintf("%s, wait %s before retrying", ErrLoginThrottled, e.RetryAfter.Round(time.Second))
+}
+
+func (e *ThrottledError) Unwrap() error {
+	return ErrLoginThrottled
+}
+
+// LoginGuard protects logins against brute force by tracking failures per
+// account and per client IP, slowing down repeated failures exponentially
+// and locking out after too many. Every decision is written to the
+// security event log.
+type LoginGuard struct {
+	store  repository.LoginAttemptStore
+	events *repository.SecurityEventRepository
+	cfg    config.AuthConfig
+}
+
+func NewLoginGuard(
+	store repository.LoginAttemptStore,
+	events *repository.SecurityEventRepository,
+	cfg config.AuthConfig,
+) *LoginGuard {
+	return &LoginGuard{store: store, events: events, cfg: cfg}
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns a ThrottledError if the account or the IP may not attempt a
+// login right now
+func (g *LoginGuard) Check(email, ip string) error {
+	now := time.Now()
+	for _, key := range []string{accountKey(email), ipKey(ip)} {
+		attempt, err := g.store.Get(key)
+		if err != nil {
+			return err
+		}
+		if attempt == nil || attempt.LockedUntil == nil || !attempt.LockedUntil.After(now) {
+			continue
+		}
+
+		limit := g.cfg.LoginMaxFailures
+		if strings.HasPrefix(key, "ip:") {
+			limit = g.cfg.LoginIPMaxFailures
+		}
+		g.record(models.SecurityEventLoginBlocked, nil, email, ip, nil, "blocked by "+key)
+		return &ThrottledError{
+			RetryAfter: attempt.LockedUntil.Sub(now),
+			Locked:     attempt.Failures >= limit,
+		}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed login (wrong password, unknown email or
+// wrong second factor) against both the account and the IP
+func (g *LoginGuard) RecordFailure(email, ip string, userID *uint, reason string) {
+	now := time.Now()
+	resetBefore := now.Add(-g.cfg.LoginFailureWindow)
+
+	g.record(models.SecurityEventLoginFailed, userID, email, ip, nil, reason)
+
+	if attempt, err := g.store.RecordFailure(accountKey(email), now, resetBefore); err != nil {
+		log.Printf("Failed to record login failure for account: %v", err)
+	} else {
+		g.applyDelay(attempt, 1, g.cfg.LoginMaxFailures, now, func() {
+			g.record(models.SecurityEventAccountLocked, userID, email, ip, nil,
+				fmt.Sprintf("%d consecutive failed logins", attempt.Failures))
+		})
+	}
+
+	if attempt, err := g.store.RecordFailure(ipKey(ip), now, resetBefore); err != nil {
+		log.Printf("Failed to record login failure for IP: %v", err)
+	} else {
+		// Many users can share an IP (e.g. a school network), so an IP is only
+		// slowed down once it fails more often than a single account may
+		g.applyDelay(attempt, g.cfg.LoginMaxFailures, g.cfg.LoginIPMaxFailures, now, func() {
+			g.record(models.SecurityEventLoginBlocked, nil, "", ip, nil,
+				fmt.Sprintf("IP blocked after %d failed logins", attempt.Failures))
+		})
+	}
+}
+
+// applyDelay locks the key for the lockout duration once the limit is
+// reached, and otherwise backs off exponentially once more than
+// backoffAfter failures have been counted
+func (g *LoginGuard) applyDelay(attempt *models.LoginAttempt, backoffAfter, limit int, now time.Time, onLock func()) {
+	var delay time.Duration
+	switch {
+	case attempt.Failures >= limit:
+		delay = g.cfg.LoginLockoutDuration
+		if attempt.Failures == limit {
+			onLock()
+		}
+	case attempt.Failures > backoffAfter:
+		delay = g.cfg.LoginBackoffBase << (attempt.Failures - backoffAfter - 1)
+		if delay > g.cfg.LoginBackoffMax || delay <= 0 {
+			delay = g.cfg.LoginBackoffMax
+		}
+	default:
+		return
+	}
+
+	if err := g.store.Lock(attempt.Key, now.Add(delay)); err != nil {
+		log.Printf("Failed to lock %s: %v", attempt.Key, err)
+	}
+}
+
+// RecordSuccess clears the account's failure counter after a complete login.
+// The IP counter is left alone so one valid account cannot be used to reset
+// an attacker's budget.
+func (g *LoginGuard) RecordSuccess(email, ip string, userID uint) {
+	if err := g.store.Reset(accountKey(email)); err != nil {
+		log.Printf("Failed to reset login failures: %v", err)
+	}
+	g.record(models.SecurityEventLoginSucceeded, &userID, email, ip, nil, "")
+}
+
+// Unlock lifts a lockout on an account on behalf of an admin
+func (g *LoginGuard) Unlock(user *models.User, actorID uint, ip string) error {
+	if err := g.store.Reset(accountKey(user.Email)); err != nil {
+		return err
+	}
+	g.record(models.SecurityEventAccountUnlocked, &user.ID, user.Email, ip, &actorID, "unlocked by admin")
+	return nil
+}
+
+// Forget drops the failed login counter of an account without recording an
+// event, for when the account's personal data is erased
+func (g *LoginGuard) Forget(email string) error {
+	return g.store.Reset(accountKey(email))
+}
+
+// LockStatus returns the failure counter for an account, or nil if it has none
+func (g *LoginGuard) LockStatus(email string) (*models.LoginAttempt, error) {
+	return g.store.Get(accountKey(email))
+}
+
+// ListEvents queries the security event log
+func (g *LoginGuard) ListEvents(filter repository.SecurityEventFilter) ([]models.SecurityEvent, error) {
+	return g.events.List(filter)
+}
+
+func (g *LoginGuard) record(event string, userID *uint, email, ip string, actorID *uint, details string) {
+	entry := &models.SecurityEvent{
+		Event:   event,
+		UserID:  userID,
+		Email:   strings.ToLower(strings.TrimSpace(email)),
+		IP:      ip,
+		ActorID: actorID,
+		Details: details,
+	}
+	if err := g.events.Create(entry); err != nil {
+		log.Printf("Failed to write security event %s: %v", event, err)
+	}
+}
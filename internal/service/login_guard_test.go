@@ -0,0 +1,177 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/E-Timileyin/school-management-system/internal/config"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+)
+
+func newTestLoginGuard(t *testing.T) (*LoginGuard, *repository.SecurityEventRepository) {
+	events := repository.NewSecurityEventRepository(newTestDB(t))
+	guard := NewLoginGuard(repository.NewMemoryLoginAttemptStore(), events, config.AuthConfig{
+		LoginMaxFailures:     3,
+		LoginIPMaxFailures:   6,
+		LoginLockoutDuration: 15 * time.Minute,
+		LoginBackoffBase:     time.Second,
+		LoginBackoffMax:      4 * time.Second,
+		LoginFailureWindow:   time.Hour,
+	})
+	return guard, events
+}
+
+// throttled returns the ThrottledError of Check, or nil if the login may go ahead
+func throttled(t *testing.T, guard *LoginGuard, email, ip string) *ThrottledError {
+	t.Helper()
+	err := guard.Check(email, ip)
+	if err == nil {
+		return nil
+	}
+	var throttledErr *ThrottledError
+	if !errors.As(err, &throttledErr) {
+		t.Fatalf("Check(%q, %q) = %v, want a ThrottledError", email, ip, err)
+	}
+	return throttledErr
+}
+
+func countEvents(t *testing.T, events *repository.SecurityEventRepository, event string) int {
+	t.Helper()
+	list, err := events.List(repository.SecurityEventFilter{Event: event})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(list)
+}
+
+func TestLoginGuardAccountBackoffAndLockout(t *testing.T) {
+	guard, events := newTestLoginGuard(t)
+	const email, ip = "ann@example.com", "10.0.0.1"
+
+	guard.RecordFailure(email, ip, nil, "wrong password")
+	if err := throttled(t, guard, email, ip); err != nil {
+		t.Fatalf("first failure throttled the account: %v", err)
+	}
+
+	guard.RecordFailure(email, ip, nil, "wrong password")
+	err := throttled(t, guard, email, ip)
+	if err == nil || err.Locked || err.RetryAfter > time.Second {
+		t.Fatalf("second failure: got %+v, want a backoff of at most 1s", err)
+	}
+
+	guard.RecordFailure(email, ip, nil, "wrong password")
+	err = throttled(t, guard, email, ip)
+	if err == nil || !err.Locked || err.RetryAfter < 14*time.Minute {
+		t.Fatalf("failure at the limit: got %+v, want a 15m lockout", err)
+	}
+	// The account is matched case-insensitively
+	if throttled(t, guard, " ANN@example.com", "10.0.0.2") == nil {
+		t.Fatal("lockout did not apply to the same address in another case")
+	}
+
+	guard.RecordFailure(email, ip, nil, "wrong password")
+	if got := countEvents(t, events, models.SecurityEventAccountLocked); got != 1 {
+		t.Fatalf("got %d account_locked events, want 1", got)
+	}
+}
+
+func TestLoginGuardBackoffIsCapped(t *testing.T) {
+	guard, _ := newTestLoginGuard(t)
+	guard.cfg.LoginMaxFailures = 10
+	guard.cfg.LoginIPMaxFailures = 100
+	const email, ip = "ann@example.com", "10.0.0.1"
+
+	// Failures 2 to 6 wait 1s, 2s, 4s and then the 4s maximum
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second, 4 * time.Second}
+	guard.RecordFailure(email, ip, nil, "wrong password")
+	for i, max := range want {
+		guard.RecordFailure(email, ip, nil, "wrong password")
+		err := throttled(t, guard, email, ip)
+		if err == nil || err.Locked || err.RetryAfter > max || err.RetryAfter <= max-time.Second {
+			t.Fatalf("failure %d: got %+v, want a backoff of %s", i+2, err, max)
+		}
+	}
+}
+
+func TestLoginGuardIPThresholds(t *testing.T) {
+	guard, events := newTestLoginGuard(t)
+	const ip = "10.0.0.1"
+	emails := []string{"a@example.com", "b@example.com", "c@example.com", "d@example.com", "e@example.com", "f@example.com"}
+
+	// A shared IP is not slowed down until it fails more often than one
+	// account may
+	for _, email := range emails[:3] {
+		guard.RecordFailure(email, ip, nil, "unknown email")
+	}
+	if err := throttled(t, guard, "z@example.com", ip); err != nil {
+		t.Fatalf("IP throttled at the per-account limit: %+v", err)
+	}
+
+	guard.RecordFailure(emails[3], ip, nil, "unknown email")
+	err := throttled(t, guard, "z@example.com", ip)
+	if err == nil || err.Locked || err.RetryAfter > time.Second {
+		t.Fatalf("IP past the per-account limit: got %+v, want a 1s backoff", err)
+	}
+
+	guard.RecordFailure(emails[4], ip, nil, "unknown email")
+	guard.RecordFailure(emails[5], ip, nil, "unknown email")
+	err = throttled(t, guard, "z@example.com", ip)
+	if err == nil || !err.Locked {
+		t.Fatalf("IP at its limit: got %+v, want it blocked", err)
+	}
+	if throttled(t, guard, "z@example.com", "10.0.0.2") != nil {
+		t.Fatal("another IP was blocked")
+	}
+	if got := countEvents(t, events, models.SecurityEventAccountLocked); got != 0 {
+		t.Fatalf("single failures per account locked %d accounts", got)
+	}
+}
+
+func TestLoginGuardUnlock(t *testing.T) {
+	guard, events := newTestLoginGuard(t)
+	user := &models.User{Email: "ann@example.com"}
+	user.ID = 7
+	const ip = "10.0.0.1"
+
+	for i := 0; i < 3; i++ {
+		guard.RecordFailure(user.Email, ip, &user.ID, "wrong password")
+	}
+	if err := throttled(t, guard, user.Email, "10.0.0.2"); err == nil || !err.Locked {
+		t.Fatalf("got %+v, want the account locked", err)
+	}
+
+	if err := guard.Unlock(user, 1, "10.0.0.9"); err != nil {
+		t.Fatal(err)
+	}
+	if err := throttled(t, guard, user.Email, "10.0.0.2"); err != nil {
+		t.Fatalf("account still throttled after unlock: %+v", err)
+	}
+	attempt, err := guard.LockStatus(user.Email)
+	if err != nil || attempt != nil {
+		t.Fatalf("LockStatus after unlock = %+v, %v; want no counter", attempt, err)
+	}
+	if got := countEvents(t, events, models.SecurityEventAccountUnlocked); got != 1 {
+		t.Fatalf("got %d account_unlocked events, want 1", got)
+	}
+
+	// The counter starts again from zero
+	guard.RecordFailure(user.Email, "10.0.0.2", &user.ID, "wrong password")
+	if err := throttled(t, guard, user.Email, "10.0.0.2"); err != nil {
+		t.Fatalf("first failure after unlock throttled the account: %+v", err)
+	}
+}
+
+func TestLoginGuardSuccessKeepsIPCounter(t *testing.T) {
+	guard, _ := newTestLoginGuard(t)
+	const ip = "10.0.0.1"
+
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com", "d@example.com"} {
+		guard.RecordFailure(email, ip, nil, "unknown email")
+	}
+	guard.RecordSuccess("e@example.com", ip, 1)
+	if err := throttled(t, guard, "e@example.com", ip); err == nil {
+		t.Fatal("a successful login reset the IP backoff")
+	}
+}
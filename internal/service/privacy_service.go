@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+)
+
+var (
+	ErrUserNotFound        = errors.New("user not found")
+	ErrErasureConfirmation = errors.New("confirm_email does not match the user's email")
+	ErrAlreadyErased       = errors.New("personal data of this user has already been erased")
+	ErrEraseSelf           = errors.New("you cannot erase your own account")
+	ErrEraseServiceAccount = errors.New("service accounts hold no personal data, delete them instead")
+)
+
+// erasedEmailDomain is used for the placeholder email of erased users. The
+// .invalid TLD can never receive mail.
+const erasedEmailDomain = "erased.invalid"
+
+// PersonalDataExport is everything the system stores about a user, grouped
+// in named sections
+type PersonalDataExport struct {
+	GeneratedAt time.Time              `json:"generated_at"`
+	UserID      uint                   `json:"user_id"`
+	Sections    map[string]interface{} `json:"sections"`
+}
+
+// PrivacyService exports and erases the personal data of users on request
+type PrivacyService struct {
+	personalDataRepo *repository.PersonalDataRepository
+	loginGuard       *LoginGuard
+}
+
+func NewPrivacyService(personalDataRepo *repository.PersonalDataRepository, loginGuard *LoginGuard) *PrivacyService {
+	return &PrivacyService{personalDataRepo: personalDataRepo, loginGuard: loginGuard}
+}
+
+// WithContext returns a copy of the service whose changes are attributed to
+// the actor in ctx
+func (s *PrivacyService) WithContext(ctx context.Context) *PrivacyService {
+	clone := *s
+	clone.personalDataRepo = s.personalDataRepo.WithContext(ctx)
+	return &clone
+}
+
+// Export assembles the personal data of a user, including users in the trash
+func (s *PrivacyService) Export(userID uint) (*PersonalDataExport, error) {
+	data, err := s.personalDataRepo.Collect(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The password hash is a credential, not data about the user
+	data.User.Password = ""
+
+	sections := map[string]interface{}{
+		"profile":             data.User,
+		"role_changes":        data.RoleChanges,
+		"sessions":            data.Sessions,
+		"security_events":     data.SecurityEvents,
+		"book_issues":         data.BookIssues,
+		"reservations":        data.Reservations,
+		"fine_payments":       data.FinePayments,
+		"fine_ledger":         data.FineLedger,
+		"communication_reads": data.CommunicationReads,
+		"communications":      data.Communications,
+	}
+	if data.Student != nil {
+		sections["student"] = data.Student
+		sections["enrollments"] = data.Enrollments
+	}
+	if data.Teacher != nil {
+		sections["teacher"] = data.Teacher
+	}
+	if data.LibraryCard != nil {
+		sections["library_card"] = data.LibraryCard
+	}
+
+	return &PersonalDataExport{
+		GeneratedAt: time.Now().UTC(),
+		UserID:      userID,
+		Sections:    sections,
+	}, nil
+}
+
+// Erase anonymizes a user's personal data and removes the account. Academic
+// and financial records stay, linked to the anonymized account, so totals
+// and history remain correct. confirmEmail must repeat the user's email to
+// guard against erasing the wrong account; erasure cannot be undone.
+func (s *PrivacyService) Erase(actor *models.User, userID uint, confirmEmail string) error {
+	user, err := s.personalDataRepo.FindUser(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case user.ErasedAt != nil:
+		return ErrAlreadyErased
+	case user.ID == actor.ID:
+		return ErrEraseSelf
+	case user.IsServiceAccount():
+		return ErrEraseServiceAccount
+	case !strings.EqualFold(strings.TrimSpace(confirmEmail), user.Email):
+		return ErrErasureConfirmation
+	}
+
+	anonymizedEmail := fmt.Sprintf("erased-%d@%s", user.ID, erasedEmailDomain)
+	if err := s.personalDataRepo.Erase(user, anonymizedEmail, time.Now()); err != nil {
+		return err
+	}
+	return s.loginGuard.Forget(user.Email)
+}
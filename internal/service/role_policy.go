@@ -0,0 +1,104 @@
+package service
+
+import (
+	"errors"
+	"log"
+
+	"github.com/E-Timileyin/school-management-system/internal/config"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+)
+
+var (
+	ErrInvalidRole              = errors.New("invalid role")
+	ErrSelfRegistrationDisabled = errors.New("self-registration is disabled")
+	ErrRoleNotAllowed           = errors.New("you are not allowed to assign this role")
+	ErrOwnRoleChange            = errors.New("you cannot change your own role")
+)
+
+// validRoles lists every role known to the system
+var validRoles = map[string]bool{
+	models.RoleAdmin:     true,
+	models.RoleTeacher:   true,
+	models.RoleStudent:   true,
+	models.RoleParent:    true,
+	models.RoleLibrarian: true,
+}
+
+// adminOnlyRoles can only ever be assigned by an admin, whatever the
+// self-registration configuration says
+var adminOnlyRoles = map[string]bool{
+	models.RoleAdmin:     true,
+	models.RoleTeacher:   true,
+	models.RoleParent:    true,
+	models.RoleLibrarian: true,
+}
+
+// RolePolicy decides who may assign which role
+type RolePolicy struct {
+	selfRegistrationEnabled bool
+	selfRegistrationRoles   []string
+}
+
+// NewRolePolicy builds the policy from configuration. Roles that are not
+// valid or are reserved for admins are dropped from the self-registration list.
+func NewRolePolicy(cfg config.AuthConfig) *RolePolicy {
+	policy := &RolePolicy{selfRegistrationEnabled: cfg.SelfRegistrationEnabled}
+	for _, role := range cfg.SelfRegistrationRoles {
+		if !validRoles[role] || adminOnlyRoles[role] {
+			log.Printf("Warning: role %q cannot be used for self-registration, ignoring it", role)
+			continue
+		}
+		policy.selfRegistrationRoles = append(policy.selfRegistrationRoles, role)
+	}
+	if len(policy.selfRegistrationRoles) == 0 {
+		policy.selfRegistrationEnabled = false
+	}
+	return policy
+}
+
+// IsValidRole reports whether the role exists
+func (p *RolePolicy) IsValidRole(role string) bool {
+	return validRoles[role]
+}
+
+// SelfRegistrationRole resolves the role for a self-registration request.
+// An empty role selects the default self-registration role.
+func (p *RolePolicy) SelfRegistrationRole(requested string) (string, error) {
+	if !p.selfRegistrationEnabled {
+		return "", ErrSelfRegistrationDisabled
+	}
+	if requested == "" {
+		return p.selfRegistrationRoles[0], nil
+	}
+	if !validRoles[requested] {
+		return "", ErrInvalidRole
+	}
+	for _, role := range p.selfRegistrationRoles {
+		if role == requested {
+			return role, nil
+		}
+	}
+	return "", ErrRoleNotAllowed
+}
+
+// CheckAssignment verifies that actor may give role to another user
+func (p *RolePolicy) CheckAssignment(actor *models.User, role string) error {
+	if !validRoles[role] {
+		return ErrInvalidRole
+	}
+	if actor == nil || actor.Role != models.RoleAdmin {
+		return ErrRoleNotAllowed
+	}
+	return nil
+}
+
+// CheckChange verifies that actor may change target's role to role
+func (p *RolePolicy) CheckChange(actor, target *models.User, role string) error {
+	if err := p.CheckAssignment(actor, role); err != nil {
+		return err
+	}
+	if actor.ID == target.ID {
+		return ErrOwnRoleChange
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+	"github.com/E-Timileyin/school-management-system/internal/utils"
+)
+
+// sessionTouchInterval limits how often LastSeenAt is written for a session
+const sessionTouchInterval = time.Minute
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionInvalid  = errors.New("session revoked or expired")
+)
+
+// ClientInfo describes the client a request came from
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// SessionService tracks issued access tokens so users and admins can see and
+// revoke them
+type SessionService struct {
+	sessionRepo  *repository.SessionRepository
+	tokenService *TokenService
+}
+
+func NewSessionService(sessionRepo *repository.SessionRepository, tokenService *TokenService) *SessionService {
+	return &SessionService{
+		sessionRepo:  sessionRepo,
+		tokenService: tokenService,
+	}
+}
+
+// WithContext returns a copy of the service bound to ctx
+func (s *SessionService) WithContext(ctx context.Context) *SessionService {
+	clone := *s
+	clone.sessionRepo = s.sessionRepo.WithContext(ctx)
+	return &clone
+}
+
+// Create starts a session for a fully authenticated user and returns the
+// access token bound to it
+func (s *SessionService) Create(userID uint, email, role string, client ClientInfo) (string, *models.Session, error) {
+	tokenID, err := utils.GenerateRandomToken(24)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		UserID:     userID,
+		TokenID:    tokenID,
+		Device:     utils.DescribeDevice(client.UserAgent),
+		IP:         client.IP,
+		UserAgent:  client.UserAgent,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(s.tokenService.AccessTokenTTL()),
+	}
+	if err := s.sessionRepo.Create(session); err != nil {
+		return "", nil, err
+	}
+
+	token, err := s.tokenService.IssueAccessToken(userID, email, role, tokenID)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, session, nil
+}
+
+// Touch checks that the session of a token is still active and records
+// the activity
+func (s *SessionService) Touch(tokenID, ip string) (*models.Session, error) {
+	if tokenID == "" {
+		return nil, ErrSessionInvalid
+	}
+
+	session, err := s.sessionRepo.FindActiveByTokenID(tokenID)
+	if err != nil {
+		return nil, ErrSessionInvalid
+	}
+
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) >= sessionTouchInterval || session.IP != ip {
+		if err := s.sessionRepo.Touch(session.ID, ip, now); err != nil {
+			return nil, err
+		}
+		session.LastSeenAt = now
+		session.IP = ip
+	}
+	return session, nil
+}
+
+// ListForUser returns a user's active sessions, flagging the one using currentTokenID
+func (s *SessionService) ListForUser(userID uint, currentTokenID string) ([]models.Session, error) {
+	sessions, err := s.sessionRepo.ListActiveByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = currentTokenID != "" && sessions[i].TokenID == currentTokenID
+	}
+	return sessions, nil
+}
+
+// Revoke ends one session of a user
+func (s *SessionService) Revoke(userID, sessionID uint) error {
+	revoked, err := s.sessionRepo.Revoke(userID, sessionID)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeAll ends all sessions of a user except the one using exceptTokenID
+// (pass "" to end every session) and returns how many were ended
+func (s *SessionService) RevokeAll(userID uint, exceptTokenID string) (int64, error) {
+	return s.sessionRepo.RevokeAllForUser(userID, exceptTokenID)
+}
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+
+	"github.com/E-Timileyin/school-management-system/internal/config"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/oidc"
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+	"github.com/E-Timileyin/school-management-system/internal/utils"
+)
+
+var (
+	ErrSSODisabled         = errors.New("single sign-on is not enabled")
+	ErrSSOFailed           = errors.New("single sign-on failed")
+	ErrSSOEmailUnverified  = errors.New("the identity provider did not confirm your email address")
+	ErrSSONoAccount        = errors.New("no account exists for this identity")
+	ErrSSONoRole           = errors.New("none of your groups is allowed to sign in")
+	ErrSSOIdentityMismatch = errors.New("this account is linked to a different identity")
+	ErrFederatedAccount    = errors.New("this account signs in with single sign-on")
+)
+
+// rolePriority orders roles from most to least privileged, used when a
+// user's groups map to several roles
+var rolePriority = []string{models.RoleAdmin, models.RoleTeacher, models.RoleLibrarian, models.RoleParent, models.RoleStudent}
+
+// SSOFlow is a started single sign-on login
+type SSOFlow struct {
+	// RedirectURL is the IdP login page the browser is sent to
+	RedirectURL string
+	// FlowToken must be kept by the browser (as a cookie) until the callback
+	FlowToken string
+}
+
+// SSOService logs users in through an OpenID Connect identity provider and
+// maps the identity onto a local user
+type SSOService struct {
+	provider     *oidc.Provider
+	userRepo     *repository.UserRepository
+	tokenService *TokenService
+	cfg          config.OIDCConfig
+	roleMapping  map[string]string
+}
+
+// NewSSOService creates the service. Mappings to unknown roles are dropped.
+func NewSSOService(
+	provider *oidc.Provider,
+	userRepo *repository.UserRepository,
+	tokenService *TokenService,
+	cfg config.OIDCConfig,
+) *SSOService {
+	mapping := map[string]string{}
+	for group, role := range cfg.RoleMapping {
+		if !validRoles[role] {
+			log.Printf("Warning: OIDC group %q maps to unknown role %q, ignoring it", group, role)
+			continue
+		}
+		mapping[group] = role
+	}
+	if cfg.DefaultRole != "" && !validRoles[cfg.DefaultRole] {
+		log.Printf("Warning: OIDC default role %q is unknown, ignoring it", cfg.DefaultRole)
+		cfg.DefaultRole = ""
+	}
+	return &SSOService{
+		provider:     provider,
+		userRepo:     userRepo,
+		tokenService: tokenService,
+		cfg:          cfg,
+		roleMapping:  mapping,
+	}
+}
+
+// WithContext returns a copy of the service bound to ctx
+func (s *SSOService) WithContext(ctx context.Context) *SSOService {
+	clone := *s
+	clone.userRepo = s.userRepo.WithContext(ctx)
+	return &clone
+}
+
+// Enabled reports whether single sign-on is configured
+func (s *SSOService) Enabled() bool {
+	return s.cfg.Enabled
+}
+
+// FlowTTL returns how long a started login stays valid
+func (s *SSOService) FlowTTL() time.Duration {
+	return s.cfg.FlowTTL
+}
+
+// Begin starts a login with fresh state, nonce and PKCE verifier
+func (s *SSOService) Begin(ctx context.Context) (*SSOFlow, error) {
+	if !s.cfg.Enabled {
+		return nil, ErrSSODisabled
+	}
+
+	state, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	redirectURL, err := s.provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return nil, err
+	}
+	flowToken, err := s.tokenService.IssueSSOFlowToken(state, nonce, verifier, s.cfg.FlowTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &SSOFlow{RedirectURL: redirectURL, FlowToken: flowToken}, nil
+}
+
+// Complete handles the IdP callback: it checks the returned state against the
+// flow token, redeems the code and returns the matching local user
+func (s *SSOService) Complete(ctx context.Context, flowToken, state, code string) (*models.User, error) {
+	if !s.cfg.Enabled {
+		return nil, ErrSSODisabled
+	}
+
+	nonce, verifier, err := s.tokenService.ParseSSOFlowToken(flowToken, state)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := s.provider.Exchange(ctx, code, nonce, verifier)
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		return nil, ErrSSOFailed
+	}
+	// Accounts are matched by email, which is only safe if the IdP vouches for it
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, ErrSSOEmailUnverified
+	}
+
+	return s.resolveUser(identity)
+}
+
+// resolveUser finds the user linked to the identity, links an existing
+// account with the same email, or creates a new one
+func (s *SSOService) resolveUser(identity *oidc.Identity) (*models.User, error) {
+	role := s.mapRole(identity.Groups)
+
+	user, err := s.userRepo.FindByExternalID(models.AuthProviderOIDC, identity.Subject)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		user, err = s.userRepo.FindByEmail(identity.Email)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return s.createUser(identity, role)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if user.ExternalID != nil {
+			return nil, ErrSSOIdentityMismatch
+		}
+		if err := s.link(user, identity); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if s.cfg.SyncRoles && role != "" && role != user.Role {
+		change := &models.RoleChange{
+			UserID:  user.ID,
+			OldRole: user.Role,
+			NewRole: role,
+			Reason:  "synced from identity provider groups",
+		}
+		user.Role = role
+		if err := s.userRepo.ChangeRole(user, change); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// link federates an existing local account. Its password stops working.
+func (s *SSOService) link(user *models.User, identity *oidc.Identity) error {
+	subject := identity.Subject
+	user.AuthProvider = models.AuthProviderOIDC
+	user.ExternalID = &subject
+	user.Password = ""
+	if !user.EmailVerified {
+		now := time.Now()
+		user.EmailVerified = true
+		user.EmailVerifiedAt = &now
+	}
+	return s.userRepo.Update(user)
+}
+
+func (s *SSOService) createUser(identity *oidc.Identity, role string) (*models.User, error) {
+	if !s.cfg.AutoCreateUsers {
+		return nil, ErrSSONoAccount
+	}
+	if role == "" {
+		return nil, ErrSSONoRole
+	}
+
+	subject := identity.Subject
+	now := time.Now()
+	user := &models.User{
+		Email:           identity.Email,
+		FirstName:       identity.GivenName,
+		LastName:        identity.FamilyName,
+		Role:            role,
+		EmailVerified:   true,
+		EmailVerifiedAt: &now,
+		AuthProvider:    models.AuthProviderOIDC,
+		ExternalID:      &subject,
+	}
+	// Names are required locally but optional in ID tokens
+	if user.FirstName == "" {
+		user.FirstName, _, _ = strings.Cut(user.Email, "@")
+	}
+	if user.LastName == "" {
+		user.LastName = "-"
+	}
+
+	if err := s.userRepo.Create(user); err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	return user, nil
+}
+
+// mapRole returns the most privileged role any of the groups maps to,
+// falling back to the default role
+func (s *SSOService) mapRole(groups []string) string {
+	matched := map[string]bool{}
+	for _, group := range groups {
+		if role, ok := s.roleMapping[group]; ok {
+			matched[role] = true
+		}
+	}
+	for _, role := range rolePriority {
+		if matched[role] {
+			return role
+		}
+	}
+	return s.cfg.DefaultRole
+}
+
+// SecureCookies reports whether the flow cookie must only be sent over HTTPS
+func (s *SSOService) SecureCookies() bool {
+	return strings.HasPrefix(s.cfg.RedirectURL, "https://")
+}
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/E-Timileyin/school-management-system/internal/config"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/oidc"
+	"github.com/E-Timileyin/school-management-system/internal/oidc/oidctest"
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+	"github.com/E-Timileyin/school-management-system/internal/utils"
+)
+
+type ssoTest struct {
+	service *SSOService
+	idp     *oidctest.Server
+	db      *gorm.DB
+}
+
+func newSSOTest(t *testing.T, configure func(*config.OIDCConfig)) *ssoTest {
+	t.Helper()
+	idp, err := oidctest.NewServer("school")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(idp.Close)
+	idp.Claims = map[string]any{
+		"email":          "ann@example.com",
+		"email_verified": true,
+		"given_name":     "Ann",
+		"family_name":    "Smith",
+	}
+
+	cfg := config.OIDCConfig{
+		Enabled:         true,
+		IssuerURL:       idp.URL,
+		ClientID:        "school",
+		RedirectURL:     "http://school.test/auth/oidc/callback",
+		Scopes:          []string{"openid", "email", "profile"},
+		GroupsClaim:     "groups",
+		RoleMapping:     map[string]string{"pupils": models.RoleStudent, "staff": models.RoleTeacher, "it": models.RoleAdmin},
+		AutoCreateUsers: true,
+		SyncRoles:       true,
+		FlowTTL:         time.Minute,
+	}
+	if configure != nil {
+		configure(&cfg)
+	}
+
+	keys, err := NewKeyManager(nil, config.AuthConfig{JWTSigningAlgorithm: utils.JWTAlgorithmHS256}, "test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := newTestDB(t)
+	service := NewSSOService(
+		oidc.NewProvider(cfg, idp.Client()),
+		repository.NewUserRepository(db),
+		NewTokenService(keys, config.AuthConfig{}),
+		cfg,
+	)
+	return &ssoTest{service: service, idp: idp, db: db}
+}
+
+// login runs the browser's side of a login and completes it with the state
+// returned by the provider, or with state if it is not empty
+func (s *ssoTest) login(t *testing.T, state string) (*models.User, error) {
+	t.Helper()
+	flow, err := s.service.Begin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, returnedState, err := s.idp.Authorize(flow.RedirectURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state == "" {
+		state = returnedState
+	}
+	return s.service.Complete(context.Background(), flow.FlowToken, state, code)
+}
+
+func TestSSOCreatesUser(t *testing.T) {
+	sso := newSSOTest(t, nil)
+	sso.idp.Claims["groups"] = []string{"staff"}
+
+	user, err := sso.login(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "ann@example.com" || user.FirstName != "Ann" || user.LastName != "Smith" || user.Role != models.RoleTeacher ||
+		!user.EmailVerified || !user.IsFederated() || user.ExternalID == nil || *user.ExternalID != sso.idp.Subject {
+		t.Fatalf("got user %+v", user)
+	}
+
+	again, err := sso.login(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != user.ID {
+		t.Fatalf("second login resolved to user %d, want %d", again.ID, user.ID)
+	}
+}
+
+func TestSSORoleMapping(t *testing.T) {
+	tests := []struct {
+		name        string
+		groups      any
+		defaultRole string
+		want        string
+		wantErr     error
+	}{
+		{name: "single group", groups: []string{"pupils"}, want: models.RoleStudent},
+		{name: "most privileged wins", groups: []string{"pupils", "it", "staff"}, want: models.RoleAdmin},
+		{name: "groups claim as a string", groups: "staff", want: models.RoleTeacher},
+		{name: "unmapped groups use the default", groups: []string{"visitors"}, defaultRole: models.RoleParent, want: models.RoleParent},
+		{name: "unmapped groups without a default", groups: []string{"visitors"}, wantErr: ErrSSONoRole},
+		{name: "no groups", wantErr: ErrSSONoRole},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sso := newSSOTest(t, func(cfg *config.OIDCConfig) { cfg.DefaultRole = tt.defaultRole })
+			if tt.groups != nil {
+				sso.idp.Claims["groups"] = tt.groups
+			}
+
+			user, err := sso.login(t, "")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Role != tt.want {
+				t.Fatalf("got role %q, want %q", user.Role, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSOSyncsRoles(t *testing.T) {
+	sso := newSSOTest(t, nil)
+	sso.idp.Claims["groups"] = []string{"staff"}
+	user, err := sso.login(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sso.idp.Claims["groups"] = []string{"staff", "it"}
+	user, err = sso.login(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != models.RoleAdmin {
+		t.Fatalf("got role %q, want admin", user.Role)
+	}
+	var change models.RoleChange
+	if err := sso.db.Where("user_id = ?", user.ID).First(&change).Error; err != nil {
+		t.Fatal(err)
+	}
+	if change.OldRole != models.RoleTeacher || change.NewRole != models.RoleAdmin {
+		t.Fatalf("got role change %+v", change)
+	}
+}
+
+func TestSSOLinksExistingAccountByEmail(t *testing.T) {
+	sso := newSSOTest(t, func(cfg *config.OIDCConfig) { cfg.AutoCreateUsers = false })
+	sso.idp.Claims["groups"] = []string{"pupils"}
+	existing := &models.User{Email: "ann@example.com", FirstName: "Ann", LastName: "Smith", Role: models.RoleStudent}
+	if err := existing.SetPassword("password123"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sso.db.Create(existing).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := sso.login(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != existing.ID || !user.IsFederated() || *user.ExternalID != sso.idp.Subject || !user.EmailVerified {
+		t.Fatalf("got user %+v", user)
+	}
+	var stored models.User
+	if err := sso.db.First(&stored, existing.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Password != "" || stored.CheckPassword("password123") == nil {
+		t.Fatal("the linked account can still log in with its password")
+	}
+
+	// Another identity with the same email cannot take the account over
+	sso.idp.Subject = "someone-else"
+	if _, err := sso.login(t, ""); !errors.Is(err, ErrSSOIdentityMismatch) {
+		t.Fatalf("got %v, want ErrSSOIdentityMismatch", err)
+	}
+}
+
+func TestSSOUnknownEmailWithoutAutoCreate(t *testing.T) {
+	sso := newSSOTest(t, func(cfg *config.OIDCConfig) { cfg.AutoCreateUsers = false })
+	sso.idp.Claims["groups"] = []string{"staff"}
+
+	if _, err := sso.login(t, ""); !errors.Is(err, ErrSSONoAccount) {
+		t.Fatalf("got %v, want ErrSSONoAccount", err)
+	}
+}
+
+func TestSSORejectsUnverifiedEmail(t *testing.T) {
+	sso := newSSOTest(t, nil)
+	sso.idp.Claims["groups"] = []string{"staff"}
+	existing := &models.User{Email: "ann@example.com", FirstName: "Ann", LastName: "Smith", Role: models.RoleAdmin, Password: "x"}
+	if err := sso.db.Create(existing).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, verified := range []any{false, "false", nil} {
+		sso.idp.Claims["email_verified"] = verified
+		if _, err := sso.login(t, ""); !errors.Is(err, ErrSSOEmailUnverified) {
+			t.Fatalf("email_verified %v: got %v, want ErrSSOEmailUnverified", verified, err)
+		}
+	}
+	var stored models.User
+	if err := sso.db.First(&stored, existing.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.IsFederated() {
+		t.Fatal("an unverified email was linked to an existing account")
+	}
+}
+
+func TestSSOStateMismatch(t *testing.T) {
+	sso := newSSOTest(t, nil)
+	sso.idp.Claims["groups"] = []string{"staff"}
+
+	if _, err := sso.login(t, "forged-state"); !errors.Is(err, ErrInvalidSSOFlow) {
+		t.Fatalf("got %v, want ErrInvalidSSOFlow", err)
+	}
+}
+
+func TestSSONonceMismatch(t *testing.T) {
+	sso := newSSOTest(t, nil)
+	sso.idp.Claims["groups"] = []string{"staff"}
+	sso.idp.Nonce = "replayed"
+
+	if _, err := sso.login(t, ""); !errors.Is(err, ErrSSOFailed) {
+		t.Fatalf("got %v, want ErrSSOFailed", err)
+	}
+	var count int64
+	sso.db.Model(&models.User{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("a login with a replayed ID token created %d users", count)
+	}
+}
+
+func TestSSODisabled(t *testing.T) {
+	sso := newSSOTest(t, func(cfg *config.OIDCConfig) { cfg.Enabled = false })
+
+	if _, err := sso.service.Begin(context.Background()); !errors.Is(err, ErrSSODisabled) {
+		t.Fatalf("got %v, want ErrSSODisabled", err)
+	}
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"github.com/E-Timileyin/school-management-system/internal/config"
+	"github.com/E-Timileyin/school-management-system/internal/utils"
+)
+
+var (
+	ErrInvalidChallenge = errors.New("invalid or expired login challenge")
+	ErrInvalidSSOFlow   = errors.New("invalid or expired single sign-on request")
+)
+
+// TokenService issues the JWTs handed out after authentication
+type TokenService struct {
+	keys *KeyManager
+	cfg  config.AuthConfig
+}
+
+func NewTokenService(keys *KeyManager, cfg config.AuthConfig) *TokenService {
+	return &TokenService{keys: keys, cfg: cfg}
+}
+
+// sign signs claims with the current signing key
+func (s *TokenService) sign(claims utils.JWTClaims, ttl time.Duration) (string, error) {
+	key, err := s.keys.SigningKey()
+	if err != nil {
+		return "", err
+	}
+	return utils.GenerateToken(claims, key, ttl)
+}
+
+// parse verifies a token against the key set
+func (s *TokenService) parse(token string) (*utils.JWTClaims, error) {
+	return utils.ValidateToken(token, s.keys.LookupKey, s.keys.AllowedAlgorithms())
+}
+
+// ParseAccessToken verifies an access token. Restricted tokens such as 2FA
+// challenges are rejected.
+func (s *TokenService) ParseAccessToken(token string) (*utils.JWTClaims, error) {
+	claims, err := s.parse(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, errors.New("token is not an access token")
+	}
+	return claims, nil
+}
+
+// IssueAccessToken creates a token granting API access to the user.
+// tokenID becomes the jti claim and links the token to its session.
+func (s *TokenService) IssueAccessToken(userID uint, email, role, tokenID string) (string, error) {
+	claims := utils.JWTClaims{
+		UserID: userID,
+		Email:  email,
+		Role:   role,
+	}
+	claims.ID = tokenID
+	return s.sign(claims, s.cfg.TokenTTL)
+}
+
+// AccessTokenTTL returns how long access tokens are valid
+func (s *TokenService) AccessTokenTTL() time.Duration {
+	return s.cfg.TokenTTL
+}
+
+// IssueChallengeToken creates a short-lived token proving that the user
+// passed the password check and now has to provide a second factor
+func (s *TokenService) IssueChallengeToken(userID uint, email string) (string, error) {
+	return s.sign(utils.JWTClaims{
+		UserID:  userID,
+		Email:   email,
+		Purpose: utils.TokenPurposeTwoFactorChallenge,
+	}, s.cfg.TwoFactorChallengeTTL)
+}
+
+// ParseChallengeToken validates a challenge token and returns the user ID
+func (s *TokenService) ParseChallengeToken(token string) (uint, error) {
+	claims, err := s.parse(token)
+	if err != nil || claims.Purpose != utils.TokenPurposeTwoFactorChallenge {
+		return 0, ErrInvalidChallenge
+	}
+	return claims.UserID, nil
+}
+
+// IssueSSOFlowToken packs the state, nonce and PKCE verifier of a single
+// sign-on login into a signed token stored in a cookie until the callback
+func (s *TokenService) IssueSSOFlowToken(state, nonce, verifier string, ttl time.Duration) (string, error) {
+	claims := utils.JWTClaims{
+		Purpose:      utils.TokenPurposeSSOFlow,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+	}
+	claims.ID = state
+	return s.sign(claims, ttl)
+}
+
+// ParseSSOFlowToken validates a flow token and checks that it belongs to state
+func (s *TokenService) ParseSSOFlowToken(token, state string) (nonce, verifier string, err error) {
+	claims, err := s.parse(token)
+	if err != nil || claims.Purpose != utils.TokenPurposeSSOFlow || state == "" ||
+		subtle.ConstantTimeCompare([]byte(claims.ID), []byte(state)) != 1 {
+		return "", "", ErrInvalidSSOFlow
+	}
+	return claims.Nonce, claims.CodeVerifier, nil
+}
@@ -0,0 +1,283 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/E-Timileyin/school-management-system/internal/config"
+	"github.com/E-Timileyin/school-management-system/internal/model"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+)
+
+var (
+	ErrUnknownTrashEntity   = errors.New("unknown entity type")
+	ErrNotInTrash           = errors.New("record is not in the trash")
+	ErrRestoreConflict      = errors.New("a live record with the same unique value exists")
+	ErrRestoreParentDeleted = errors.New("a record this one belongs to is deleted, restore it first")
+	ErrInvalidRetention     = errors.New("retention must not be negative")
+)
+
+// maxTrashPage caps the number of records returned per page
+const maxTrashPage = 500
+
+// trashEntity describes how records of one table are restored and purged
+type trashEntity struct {
+	model func() interface{}
+	// unique lists column sets that must be unique among live rows; a record
+	// cannot be restored while a live record holds the same values
+	unique [][]string
+	// parents must be live before the record can be restored
+	parents []repository.TrashLink
+	// dependents are soft-deleted along with the record and can be restored
+	// with it
+	dependents []repository.TrashLink
+	// owned rows are hard-deleted when the record is purged
+	owned []repository.TrashLink
+}
+
+// trashEntities lists the entities that can be managed in the trash, keyed
+// by table name. It returns fresh model values on every call.
+func trashEntities() map[string]trashEntity {
+	return map[string]trashEntity{
+		"users": {
+			model:  func() interface{} { return &models.User{} },
+			unique: [][]string{{"email"}, {"auth_provider", "external_id"}},
+			dependents: []repository.TrashLink{
+				{Model: &models.Student{}, ForeignKey: "user_id"},
+				{Model: &models.Teacher{}, ForeignKey: "user_id"},
+			},
+			owned: []repository.TrashLink{
+				{Model: &models.Student{}, ForeignKey: "user_id"},
+				{Model: &models.Teacher{}, ForeignKey: "user_id"},
+				{Model: &models.Session{}, ForeignKey: "user_id"},
+				{Model: &models.UserToken{}, ForeignKey: "user_id"},
+				{Model: &models.RecoveryCode{}, ForeignKey: "user_id"},
+				{Model: &models.APIKey{}, ForeignKey: "user_id"},
+			},
+		},
+		"students": {
+			model:   func() interface{} { return &models.Student{} },
+			unique:  [][]string{{"user_id"}},
+			parents: []repository.TrashLink{{Model: &models.User{}, ForeignKey: "user_id"}},
+			owned:   []repository.TrashLink{{Model: &models.Enrollment{}, ForeignKey: "student_id"}},
+		},
+		"teachers": {
+			model:   func() interface{} { return &models.Teacher{} },
+			unique:  [][]string{{"user_id"}},
+			parents: []repository.TrashLink{{Model: &models.User{}, ForeignKey: "user_id"}},
+		},
+		"courses": {
+			model:      func() interface{} { return &models.Course{} },
+			unique:     [][]string{{"code"}},
+			dependents: []repository.TrashLink{{Model: &models.Enrollment{}, ForeignKey: "course_id"}},
+			owned:      []repository.TrashLink{{Model: &models.Enrollment{}, ForeignKey: "course_id"}},
+		},
+		"enrollments": {
+			model: func() interface{} { return &models.Enrollment{} },
+			parents: []repository.TrashLink{
+				{Model: &models.Student{}, ForeignKey: "student_id"},
+				{Model: &models.Course{}, ForeignKey: "course_id"},
+			},
+		},
+		"book_categories": {
+			model:  func() interface{} { return &model.BookCategory{} },
+			unique: [][]string{{"name"}},
+		},
+		"books": {
+			model:      func() interface{} { return &model.Book{} },
+			unique:     [][]string{{"isbn"}},
+			parents:    []repository.TrashLink{{Model: &model.BookCategory{}, ForeignKey: "category_id"}},
+			dependents: []repository.TrashLink{{Model: &model.BookCopy{}, ForeignKey: "book_id"}},
+			owned:      []repository.TrashLink{{Model: &model.BookCopy{}, ForeignKey: "book_id"}},
+		},
+		"book_copies": {
+			model:   func() interface{} { return &model.BookCopy{} },
+			unique:  [][]string{{"accession_number"}, {"barcode"}},
+			parents: []repository.TrashLink{{Model: &model.Book{}, ForeignKey: "book_id"}},
+		},
+		"library_holidays": {
+			model: func() interface{} { return &model.LibraryHoliday{} },
+		},
+		"library_cards": {
+			model:   func() interface{} { return &model.LibraryCard{} },
+			unique:  [][]string{{"user_id"}, {"card_number"}},
+			parents: []repository.TrashLink{{Model: &models.User{}, ForeignKey: "user_id"}},
+		},
+	}
+}
+
+// PurgeResult reports what a purge removed. Records still referenced by
+// other data, e.g. a book with circulation history, are skipped.
+type PurgeResult struct {
+	Purged  map[string]int    `json:"purged"`
+	Skipped map[string][]uint `json:"skipped,omitempty"`
+}
+
+// TrashService lets admins browse soft-deleted records, restore them and
+// purge them for good
+type TrashService struct {
+	trashRepo *repository.TrashRepository
+	cfg       config.DataConfig
+}
+
+func NewTrashService(trashRepo *repository.TrashRepository, cfg config.DataConfig) *TrashService {
+	return &TrashService{trashRepo: trashRepo, cfg: cfg}
+}
+
+// WithContext returns a copy of the service whose changes are attributed to
+// the actor in ctx
+func (s *TrashService) WithContext(ctx context.Context) *TrashService {
+	clone := *s
+	clone.trashRepo = s.trashRepo.WithContext(ctx)
+	return &clone
+}
+
+// Retention is how long records stay in the trash by default
+func (s *TrashService) Retention() time.Duration {
+	return s.cfg.TrashRetention
+}
+
+// Entities lists the entity types that can be managed with the number of
+// records of each in the trash
+func (s *TrashService) Entities() (map[string]int64, error) {
+	counts := map[string]int64{}
+	for name, entity := range trashEntities() {
+		count, err := s.trashRepo.Count(entity.model())
+		if err != nil {
+			return nil, err
+		}
+		counts[name] = count
+	}
+	return counts, nil
+}
+
+// List returns a page of the deleted records of an entity type, most
+// recently deleted first
+func (s *TrashService) List(entityType string, limit, offset int) (interface{}, error) {
+	entity, ok := trashEntities()[entityType]
+	if !ok {
+		return nil, ErrUnknownTrashEntity
+	}
+	if limit <= 0 || limit > maxTrashPage {
+		limit = 100
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return s.trashRepo.List(entity.model(), limit, offset)
+}
+
+// Restore brings a record back from the trash. With withDependents, records
+// that were deleted along with it, such as a user's student profile, are
+// restored too. It returns the number of dependents restored.
+func (s *TrashService) Restore(entityType string, id uint, withDependents bool) (int64, error) {
+	entity, ok := trashEntities()[entityType]
+	if !ok {
+		return 0, ErrUnknownTrashEntity
+	}
+	if err := s.checkInTrash(entity, id); err != nil {
+		return 0, err
+	}
+
+	for _, parent := range entity.parents {
+		deleted, err := s.trashRepo.ParentDeleted(entity.model(), id, parent)
+		if err != nil {
+			return 0, err
+		}
+		if deleted {
+			return 0, ErrRestoreParentDeleted
+		}
+	}
+	for _, columns := range entity.unique {
+		conflict, err := s.trashRepo.HasLiveDuplicate(entity.model(), id, columns)
+		if err != nil {
+			return 0, err
+		}
+		if conflict {
+			return 0, ErrRestoreConflict
+		}
+	}
+
+	var dependents []repository.TrashLink
+	if withDependents {
+		dependents = entity.dependents
+	}
+	restored, err := s.trashRepo.Restore(entity.model(), id, dependents)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, ErrNotInTrash
+	}
+	return restored, err
+}
+
+// Purge permanently deletes a single record in the trash
+func (s *TrashService) Purge(entityType string, id uint) error {
+	entity, ok := trashEntities()[entityType]
+	if !ok {
+		return ErrUnknownTrashEntity
+	}
+	if err := s.checkInTrash(entity, id); err != nil {
+		return err
+	}
+	err := s.trashRepo.Purge(entity.model(), id, entity.owned)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotInTrash
+	}
+	return err
+}
+
+// PurgeExpired permanently deletes records that have been in the trash for
+// longer than retention. An empty entityType purges every entity type.
+func (s *TrashService) PurgeExpired(entityType string, retention time.Duration) (*PurgeResult, error) {
+	if retention < 0 {
+		return nil, ErrInvalidRetention
+	}
+	entities := trashEntities()
+	names := make([]string, 0, len(entities))
+	if entityType != "" {
+		if _, ok := entities[entityType]; !ok {
+			return nil, ErrUnknownTrashEntity
+		}
+		names = append(names, entityType)
+	} else {
+		for name := range entities {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	cutoff := time.Now().Add(-retention)
+	result := &PurgeResult{Purged: map[string]int{}, Skipped: map[string][]uint{}}
+	for _, name := range names {
+		entity := entities[name]
+		ids, err := s.trashRepo.ListExpired(entity.model(), cutoff)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			// Each record is purged on its own so one that is still
+			// referenced does not hold back the rest
+			if err := s.trashRepo.Purge(entity.model(), id, entity.owned); err != nil {
+				if !errors.Is(err, gorm.ErrRecordNotFound) {
+					result.Skipped[name] = append(result.Skipped[name], id)
+				}
+				continue
+			}
+			result.Purged[name]++
+		}
+	}
+	return result, nil
+}
+
+// checkInTrash returns ErrNotInTrash unless the record exists and is deleted
+func (s *TrashService) checkInTrash(entity trashEntity, id uint) error {
+	deletedAt, err := s.trashRepo.DeletedAt(entity.model(), id)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && deletedAt == nil) {
+		return ErrNotInTrash
+	}
+	return err
+}
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/E-Timileyin/school-management-system/internal/config"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+	"github.com/E-Timileyin/school-management-system/internal/utils"
+)
+
+const recoveryCodeCount = 10
+
+var (
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorNotStarted     = errors.New("two-factor enrollment has not been started")
+	ErrInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+	ErrInvalidPassword         = errors.New("invalid password")
+)
+
+// TwoFactorEnrollment is returned when a user starts setting up 2FA
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+	QRCode string `json:"qr_code"` // PNG data URI
+}
+
+// TwoFactorService manages TOTP enrollment, verification and recovery codes
+type TwoFactorService struct {
+	userRepo     *repository.UserRepository
+	recoveryRepo *repository.RecoveryCodeRepository
+	cfg          config.AuthConfig
+}
+
+func NewTwoFactorService(
+	userRepo *repository.UserRepository,
+	recoveryRepo *repository.RecoveryCodeRepository,
+	cfg config.AuthConfig,
+) *TwoFactorService {
+	return &TwoFactorService{
+		userRepo:     userRepo,
+		recoveryRepo: recoveryRepo,
+		cfg:          cfg,
+	}
+}
+
+// WithContext returns a copy of the service bound to ctx
+func (s *TwoFactorService) WithContext(ctx context.Context) *TwoFactorService {
+	clone := *s
+	clone.userRepo = s.userRepo.WithContext(ctx)
+	clone.recoveryRepo = s.recoveryRepo.WithContext(ctx)
+	return &clone
+}
+
+// IsRequired reports whether the user's role must use 2FA. Service accounts
+// authenticate with API keys and are exempt.
+func (s *TwoFactorService) IsRequired(user *models.User) bool {
+	if user.IsServiceAccount() {
+		return false
+	}
+	for _, role := range s.cfg.TwoFactorRequiredRoles {
+		if role == user.Role {
+			return true
+		}
+	}
+	return false
+}
+
+// BeginEnrollment generates a new secret for the user. 2FA is only switched
+// on once a code generated from it is confirmed.
+func (s *TwoFactorService) BeginEnrollment(user *models.User) (*TwoFactorEnrollment, error) {
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	user.TwoFactorSecret = secret
+	user.TwoFactorLastStep = 0
+	if err := s.userRepo.Update(user); err != nil {
+		return nil, err
+	}
+
+	uri := utils.TOTPProvisioningURI(s.cfg.TwoFactorIssuer, user.Email, secret)
+	qrCode, err := utils.QRCodeDataURI(uri, 256)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorEnrollment{Secret: secret, URI: uri, QRCode: qrCode}, nil
+}
+
+// ConfirmEnrollment enables 2FA once the user proves their authenticator
+// works, and returns a fresh set of recovery codes
+func (s *TwoFactorService) ConfirmEnrollment(user *models.User, code string) ([]string, error) {
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if user.TwoFactorSecret == "" {
+		return nil, ErrTwoFactorNotStarted
+	}
+
+	step, ok := utils.ValidateTOTP(user.TwoFactorSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	now := time.Now()
+	user.TwoFactorEnabled = true
+	user.TwoFactorEnabledAt = &now
+	user.TwoFactorLastStep = step
+	if err := s.userRepo.Update(user); err != nil {
+		return nil, err
+	}
+
+	return s.generateRecoveryCodes(user.ID)
+}
+
+// Disable turns 2FA off after checking the password and a current code.
+// Federated users have no password, so only the code is checked for them.
+func (s *TwoFactorService) Disable(user *models.User, password, code string) error {
+	if !user.TwoFactorEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+	if !user.IsFederated() && user.CheckPassword(password) != nil {
+		return ErrInvalidPassword
+	}
+	if err := s.Verify(user.ID, code); err != nil {
+		return err
+	}
+
+	// Reload the user as Verify may have advanced the replay counter
+	fresh, err := s.userRepo.FindByID(user.ID)
+	if err != nil {
+		return err
+	}
+	fresh.TwoFactorEnabled = false
+	fresh.TwoFactorSecret = ""
+	fresh.TwoFactorLastStep = 0
+	fresh.TwoFactorEnabledAt = nil
+	if err := s.userRepo.Update(fresh); err != nil {
+		return err
+	}
+	*user = *fresh
+
+	return s.recoveryRepo.DeleteAll(user.ID)
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a current code
+func (s *TwoFactorService) RegenerateRecoveryCodes(user *models.User, code string) ([]string, error) {
+	if !user.TwoFactorEnabled {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	if err := s.Verify(user.ID, code); err != nil {
+		return nil, err
+	}
+	return s.generateRecoveryCodes(user.ID)
+}
+
+// RemainingRecoveryCodes returns the number of unused recovery codes
+func (s *TwoFactorService) RemainingRecoveryCodes(userID uint) (int64, error) {
+	return s.recoveryRepo.CountUnused(userID)
+}
+
+// Verify checks a TOTP code or a one-time recovery code for the user.
+// Each TOTP code and each recovery code can only be used once.
+func (s *TwoFactorService) Verify(userID uint, code string) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return ErrInvalidTwoFactorCode
+	}
+	if !user.TwoFactorEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+	if step, ok := utils.ValidateTOTP(user.TwoFactorSecret, code, time.Now()); ok {
+		fresh, err := s.userRepo.AdvanceTwoFactorStep(user.ID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	used, err := s.recoveryRepo.Use(user.ID, utils.HashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// generateRecoveryCodes creates and stores a new set of recovery codes,
+// returning them in plain text so they can be shown to the user once
+func (s *TwoFactorService) generateRecoveryCodes(userID uint) ([]string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	plain := make([]string, 0, recoveryCodeCount)
+	records := make([]models.RecoveryCode, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(encoding.EncodeToString(b)) // 8 characters
+		code := raw[:4] + "-" + raw[4:]
+
+		plain = append(plain, code)
+		records = append(records, models.RecoveryCode{
+			UserID:   userID,
+			CodeHash: utils.HashToken(normalizeRecoveryCode(code)),
+		})
+	}
+
+	if err := s.recoveryRepo.Replace(userID, records); err != nil {
+		return nil, err
+	}
+	return plain, nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes typed by the user
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
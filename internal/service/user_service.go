@@ -1,20 +1,41 @@
 package service
 
 import (
+	"context"
+
 	"github.com/E-Timileyin/school-management-system/internal/models"
 	"github.com/E-Timileyin/school-management-system/internal/repository"
 )
 
 type UserService struct {
-	userRepo *repository.UserRepository
+	userRepo       *repository.UserRepository
+	roleChangeRepo *repository.RoleChangeRepository
+	rolePolicy     *RolePolicy
 }
 
 func (s *UserService) CreateUser(user *models.User) error {
 	return s.userRepo.Create(user)
 }
 
-func NewUserService(userRepo *repository.UserRepository) *UserService {
-	return &UserService{userRepo: userRepo}
+func NewUserService(
+	userRepo *repository.UserRepository,
+	roleChangeRepo *repository.RoleChangeRepository,
+	rolePolicy *RolePolicy,
+) *UserService {
+	return &UserService{
+		userRepo:       userRepo,
+		roleChangeRepo: roleChangeRepo,
+		rolePolicy:     rolePolicy,
+	}
+}
+
+// WithContext returns a copy of the service whose database access carries
+// ctx, so that changes are attributed to the request's actor
+func (s *UserService) WithContext(ctx context.Context) *UserService {
+	clone := *s
+	clone.userRepo = s.userRepo.WithContext(ctx)
+	clone.roleChangeRepo = s.roleChangeRepo.WithContext(ctx)
+	return &clone
 }
 
 func (s *UserService) GetUserByID(id uint) (*models.User, error) {
@@ -36,3 +57,39 @@ func (s *UserService) DeleteUser(id uint) error {
 func (s *UserService) ListUsers() ([]models.User, error) {
 	return s.userRepo.List()
 }
+
+// SelfRegistrationRole resolves the role a self-registering user receives
+func (s *UserService) SelfRegistrationRole(requested string) (string, error) {
+	return s.rolePolicy.SelfRegistrationRole(requested)
+}
+
+// CheckRoleAssignment verifies that actor may create a user with role
+func (s *UserService) CheckRoleAssignment(actor *models.User, role string) error {
+	return s.rolePolicy.CheckAssignment(actor, role)
+}
+
+// ChangeRole changes a user's role if the policy allows it and records the
+// change in the audit trail. Any other pending changes on user are saved too.
+func (s *UserService) ChangeRole(actor, user *models.User, role, reason string) error {
+	if user.Role == role {
+		return s.userRepo.Update(user)
+	}
+	if err := s.rolePolicy.CheckChange(actor, user, role); err != nil {
+		return err
+	}
+
+	change := &models.RoleChange{
+		UserID:    user.ID,
+		OldRole:   user.Role,
+		NewRole:   role,
+		ChangedBy: &actor.ID,
+		Reason:    reason,
+	}
+	user.Role = role
+	return s.userRepo.ChangeRole(user, change)
+}
+
+// GetRoleHistory returns the role changes of a user, newest first
+func (s *UserService) GetRoleHistory(userID uint) ([]models.RoleChange, error) {
+	return s.roleChangeRepo.ListByUser(userID)
+}
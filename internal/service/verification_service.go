@@ -0,0 +1,284 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/E-Timileyin/school-management-system/internal/config"
+	"github.com/E-Timileyin/school-management-system/internal/mailer"
+	"github.com/E-Timileyin/school-management-system/internal/models"
+	"github.com/E-Timileyin/school-management-system/internal/repository"
+	"github.com/E-Timileyin/school-management-system/internal/utils"
+)
+
+var (
+	ErrInvalidToken         = errors.New("invalid or expired token")
+	ErrEmailNotVerified     = errors.New("email address not verified")
+	ErrEmailAlreadyVerified = errors.New("email address already verified")
+	ErrEmailDelivery        = errors.New("email could not be delivered")
+	ErrEmailTaken           = errors.New("email address already in use")
+)
+
+// VerificationService handles email verification and account invitations
+type VerificationService struct {
+	userRepo  *repository.UserRepository
+	tokenRepo *repository.UserTokenRepository
+	mailer    mailer.Mailer
+	cfg       config.AuthConfig
+}
+
+func NewVerificationService(
+	userRepo *repository.UserRepository,
+	tokenRepo *repository.UserTokenRepository,
+	mailer mailer.Mailer,
+	cfg config.AuthConfig,
+) *VerificationService {
+	return &VerificationService{
+		userRepo:  userRepo,
+		tokenRepo: tokenRepo,
+		mailer:    mailer,
+		cfg:       cfg,
+	}
+}
+
+// WithContext returns a copy of the service bound to ctx
+func (s *VerificationService) WithContext(ctx context.Context) *VerificationService {
+	clone := *s
+	clone.userRepo = s.userRepo.WithContext(ctx)
+	clone.tokenRepo = s.tokenRepo.WithContext(ctx)
+	return &clone
+}
+
+// CheckVerified returns ErrEmailNotVerified when the user may not log in yet
+func (s *VerificationService) CheckVerified(user *models.User) error {
+	if s.cfg.RequireEmailVerification && !user.EmailVerified {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+// SendVerificationEmail issues a new verification link for the user,
+// invalidating any link sent earlier
+func (s *VerificationService) SendVerificationEmail(user *models.User) error {
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+
+	token, err := s.issueToken(user.ID, models.TokenPurposeEmailVerification, s.cfg.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := s.link("/verify-email", token)
+	return s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nPlease verify your email address by opening the link below:\n\n%s\n\nThe link expires in %s.\n",
+			user.FirstName, link, s.cfg.EmailVerificationTTL),
+	})
+}
+
+// ResendVerification sends a new verification link to an unverified account.
+// Unknown or already verified addresses are ignored so the endpoint cannot be
+// used to discover which emails are registered.
+func (s *VerificationService) ResendVerification(email string) error {
+	user, err := s.userRepo.FindByEmail(email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if user.EmailVerified {
+		return nil
+	}
+	return s.SendVerificationEmail(user)
+}
+
+// ChangeEmail saves the user with a new email address. The new address has to
+// be verified like a new account's, so the user is marked unverified and a
+// link is sent to it; a delivery failure is returned after the change is
+// saved.
+func (s *VerificationService) ChangeEmail(user *models.User, email string) error {
+	if email == user.Email {
+		return s.userRepo.Update(user)
+	}
+	existing, err := s.userRepo.FindByEmail(email)
+	if err == nil && existing.ID != user.ID {
+		return ErrEmailTaken
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	user.Email = email
+	user.EmailVerified = false
+	user.EmailVerifiedAt = nil
+	if err := s.userRepo.Update(user); err != nil {
+		return err
+	}
+	if err := s.SendVerificationEmail(user); err != nil {
+		return fmt.Errorf("%w: %v", ErrEmailDelivery, err)
+	}
+	return nil
+}
+
+// VerifyEmail redeems a verification token and activates the account
+func (s *VerificationService) VerifyEmail(rawToken string) (*models.User, error) {
+	token, err := s.redeemToken(rawToken, models.TokenPurposeEmailVerification)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.FindByID(token.UserID)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	markVerified(user)
+	if err := s.userRepo.Update(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// InviteUser creates an account without a usable password and emails the
+// user a link to choose one. The account is verified when the invitation is
+// accepted.
+func (s *VerificationService) InviteUser(user *models.User) error {
+	placeholder, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return err
+	}
+	if err := user.SetPassword(placeholder); err != nil {
+		return err
+	}
+	user.EmailVerified = false
+
+	if err := s.userRepo.Create(user); err != nil {
+		return err
+	}
+	if err := s.SendInvitation(user); err != nil {
+		return fmt.Errorf("%w: %v", ErrEmailDelivery, err)
+	}
+	return nil
+}
+
+// SendInvitation (re)sends the invitation link for an existing account
+func (s *VerificationService) SendInvitation(user *models.User) error {
+	token, err := s.issueToken(user.ID, models.TokenPurposeInvitation, s.cfg.InvitationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := s.link("/invitations/accept", token)
+	return s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "You have been invited to the School Management System",
+		Body: fmt.Sprintf("Hello %s,\n\nAn account has been created for you. Choose your password using the link below:\n\n%s\n\nThe link expires in %s.\n",
+			user.FirstName, link, s.cfg.InvitationTTL),
+	})
+}
+
+// LookupInvitation returns the user an invitation token belongs to without redeeming it
+func (s *VerificationService) LookupInvitation(rawToken string) (*models.User, error) {
+	token, err := s.tokenRepo.FindValid(utils.HashToken(rawToken), models.TokenPurposeInvitation)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	user, err := s.userRepo.FindByID(token.UserID)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	return user, nil
+}
+
+// AcceptInvitation redeems an invitation token, sets the chosen password and
+// marks the email address as verified
+func (s *VerificationService) AcceptInvitation(rawToken, password string) (*models.User, error) {
+	token, err := s.tokenRepo.FindValid(utils.HashToken(rawToken), models.TokenPurposeInvitation)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	user, err := s.userRepo.FindByID(token.UserID)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err := user.SetPassword(password); err != nil {
+		return nil, err
+	}
+
+	used, err := s.tokenRepo.MarkUsed(token.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !used {
+		return nil, ErrInvalidToken
+	}
+
+	markVerified(user)
+	if err := s.userRepo.Update(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// issueToken creates a new token for the user, replacing outstanding ones
+func (s *VerificationService) issueToken(userID uint, purpose string, ttl time.Duration) (string, error) {
+	raw, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.tokenRepo.DeleteUnused(userID, purpose); err != nil {
+		return "", err
+	}
+
+	token := &models.UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: utils.HashToken(raw),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := s.tokenRepo.Create(token); err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// redeemToken looks up a token and marks it as used
+func (s *VerificationService) redeemToken(rawToken, purpose string) (*models.UserToken, error) {
+	token, err := s.tokenRepo.FindValid(utils.HashToken(rawToken), purpose)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	used, err := s.tokenRepo.MarkUsed(token.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !used {
+		return nil, ErrInvalidToken
+	}
+	return token, nil
+}
+
+func (s *VerificationService) link(path, token string) string {
+	return strings.TrimRight(s.cfg.BaseURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+func markVerified(user *models.User) {
+	if user.EmailVerified {
+		return
+	}
+	now := time.Now()
+	user.EmailVerified = true
+	user.EmailVerifiedAt = &now
+}
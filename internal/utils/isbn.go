@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalidISBN = errors.New("invalid ISBN")
+
+// NormalizeISBN validates an ISBN-10 or ISBN-13, ignoring hyphens and
+// spaces, and returns it as a 13 digit ISBN
+func NormalizeISBN(raw string) (string, error) {
+	var digits []byte
+	for i := 0; i < len(raw); i++ {
+		switch ch := raw[i]; {
+		case ch >= '0' && ch <= '9':
+			digits = append(digits, ch)
+		case ch == 'x' || ch == 'X':
+			digits = append(digits, 'X')
+		case ch == '-' || ch == ' ':
+		default:
+			return "", ErrInvalidISBN
+		}
+	}
+
+	switch len(digits) {
+	case 10:
+		if !validISBN10(digits) {
+			return "", ErrInvalidISBN
+		}
+		isbn13 := append([]byte("978"), digits[:9]...)
+		return string(append(isbn13, isbn13CheckDigit(isbn13))), nil
+	case 13:
+		if strings.IndexByte(string(digits), 'X') >= 0 {
+			return "", ErrInvalidISBN
+		}
+		if !strings.HasPrefix(string(digits), "978") && !strings.HasPrefix(string(digits), "979") {
+			return "", ErrInvalidISBN
+		}
+		if isbn13CheckDigit(digits[:12]) != digits[12] {
+			return "", ErrInvalidISBN
+		}
+		return string(digits), nil
+	default:
+		return "", ErrInvalidISBN
+	}
+}
+
+// validISBN10 checks the mod 11 checksum of an ISBN-10. Only the check
+// digit may be X, standing for 10.
+func validISBN10(digits []byte) bool {
+	sum := 0
+	for i, ch := range digits {
+		value := int(ch - '0')
+		if ch == 'X' {
+			if i != 9 {
+				return false
+			}
+			value = 10
+		}
+		sum += (10 - i) * value
+	}
+	return sum%11 == 0
+}
+
+// isbn13CheckDigit computes the check digit of the first 12 digits of an
+// ISBN-13
+func isbn13CheckDigit(digits []byte) byte {
+	sum := 0
+	for i, ch := range digits[:12] {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(ch-'0')
+	}
+	return byte('0' + (10-sum%10)%10)
+}
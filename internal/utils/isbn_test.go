@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{name: "ISBN-13", raw: "9780441172719", want: "9780441172719"},
+		{name: "ISBN-13 with hyphens", raw: "978-0-441-17271-9", want: "9780441172719"},
+		{name: "ISBN-13 with spaces", raw: "978 0 441 17271 9", want: "9780441172719"},
+		{name: "979 prefix", raw: "979-10-90636-07-1", want: "9791090636071"},
+		{name: "ISBN-10 converted to 13", raw: "0441172717", want: "9780441172719"},
+		{name: "ISBN-10 with hyphens", raw: "0-441-17271-7", want: "9780441172719"},
+		{name: "ISBN-10 with X check digit", raw: "0-8044-2957-X", want: "9780804429573"},
+		{name: "ISBN-10 with lower case x", raw: "080442957x", want: "9780804429573"},
+		{name: "ISBN-10 whose 13 check digit is 0", raw: "0306406152", want: "9780306406157"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeISBN(tt.raw)
+			if err != nil {
+				t.Fatalf("NormalizeISBN(%q) failed: %v", tt.raw, err)
+			}
+			if got != tt.want {
+				t.Fatalf("NormalizeISBN(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeISBNRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{name: "empty", raw: ""},
+		{name: "ISBN-13 with a wrong check digit", raw: "9780441172718"},
+		{name: "ISBN-10 with a wrong check digit", raw: "0441172718"},
+		{name: "X inside an ISBN-10", raw: "04411X2717"},
+		{name: "X in an ISBN-13", raw: "978044117271X"},
+		{name: "13 digits without a Bookland prefix", raw: "9770441172719"},
+		{name: "too short", raw: "044117271"},
+		{name: "too long", raw: "97804411727190"},
+		{name: "letters", raw: "978O441172719"},
+		{name: "other separators", raw: "978.0.441.17271.9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := NormalizeISBN(tt.raw); !errors.Is(err, ErrInvalidISBN) {
+				t.Fatalf("NormalizeISBN(%q) = %q, %v; want ErrInvalidISBN", tt.raw, got, err)
+			}
+		})
+	}
+}
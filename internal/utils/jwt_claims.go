@@ -2,9 +2,21 @@ package utils
 
 import "github.com/golang-jwt/jwt/v5"
 
+// Token purposes. Access tokens have no purpose set.
+const (
+	TokenPurposeTwoFactorChallenge = "2fa_challenge"
+	TokenPurposeSSOFlow            = "sso_flow"
+)
+
 // JWTClaims represents the claims to be included in the JWT token
 type JWTClaims struct {
-	UserID uint   `json:"user_id"`
-	Email  string `json:"email"`
+	UserID  uint   `json:"user_id"`
+	Email   string `json:"email"`
+	Role    string `json:"role,omitempty"`
+	Purpose string `json:"purpose,omitempty"` // Set on restricted tokens such as 2FA challenges
+
+	// Single sign-on flow state, kept client side between redirect and callback
+	Nonce        string `json:"nonce,omitempty"`
+	CodeVerifier string `json:"code_verifier,omitempty"`
 	jwt.RegisteredClaims
 }
@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported JWT signing algorithms
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmRS256 = "RS256"
+	JWTAlgorithmEdDSA = "EdDSA"
+)
+
+// JWTKey is a key used to sign and verify tokens
+type JWTKey struct {
+	ID        string // Sent as the kid header
+	Method    jwt.SigningMethod
+	SignKey   any // Private key, or the secret for HMAC
+	VerifyKey any // Public key, or the secret for HMAC
+}
+
+// NewHMACKey wraps a shared secret as an HS256 key
+func NewHMACKey(id, secret string) *JWTKey {
+	return &JWTKey{ID: id, Method: jwt.SigningMethodHS256, SignKey: []byte(secret), VerifyKey: []byte(secret)}
+}
+
+// GenerateJWTKey creates a new asymmetric key pair and returns the private
+// key as PKCS#8 PEM
+func GenerateJWTKey(algorithm string) (string, error) {
+	var private crypto.Signer
+	var err error
+	switch algorithm {
+	case JWTAlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case JWTAlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return "", fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// ParseJWTKey loads a PKCS#8 PEM private key created by GenerateJWTKey
+func ParseJWTKey(id, algorithm, privatePEM string) (*JWTKey, error) {
+	block, _ := pem.Decode([]byte(privatePEM))
+	if block == nil {
+		return nil, errors.New("invalid PEM private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		if algorithm != JWTAlgorithmRS256 {
+			break
+		}
+		return &JWTKey{ID: id, Method: jwt.SigningMethodRS256, SignKey: private, VerifyKey: &private.PublicKey}, nil
+	case ed25519.PrivateKey:
+		if algorithm != JWTAlgorithmEdDSA {
+			break
+		}
+		return &JWTKey{ID: id, Method: jwt.SigningMethodEdDSA, SignKey: private, VerifyKey: private.Public()}, nil
+	}
+	return nil, fmt.Errorf("key %s does not match algorithm %q", id, algorithm)
+}
+
+// JWK is the public part of a signing key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// PublicJWK returns the public key as a JWK. Symmetric keys cannot be
+// published and report false.
+func (k *JWTKey) PublicJWK() (JWK, bool) {
+	jwk := JWK{KeyID: k.ID, Use: "sig", Algorithm: k.Method.Alg()}
+	switch public := k.VerifyKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	default:
+		return JWK{}, false
+	}
+	return jwk, true
+}
@@ -3,32 +3,30 @@ package utils
 import (
 	"time"
 
-	"github.com/E-Timileyin/school-management-system/internal/model"
-
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// GenerateToken creates a new JWT token for the given user
-func GenerateToken(user model.User, jwtSecret string, expirationTime time.Duration) (string, error) {
-	expiration := time.Now().Add(expirationTime)
-
-	// Create the JWT claims
-	claims := &JWTClaims{
-		UserID: user.ID,
-		Email:  user.Email,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expiration),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-			Issuer:    "school-management-system",
-		},
-	}
+// TokenIssuer is the iss claim of every token issued by the system
+const TokenIssuer = "school-management-system"
+
+// GenerateToken signs the given claims with key as a JWT valid for expirationTime
+func GenerateToken(claims JWTClaims, key *JWTKey, expirationTime time.Duration) (string, error) {
+	now := time.Now()
 
-	// Create the token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	// Fill in the registered claims, keeping the token ID (jti) if set
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(expirationTime))
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.NotBefore = jwt.NewNumericDate(now)
+	claims.Issuer = TokenIssuer
+
+	// Create the token, naming the key so verifiers can pick it from the key set
+	token := jwt.NewWithClaims(key.Method, &claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
 
-	// Sign the token with the secret key
-	tokenString, err := token.SignedString([]byte(jwtSecret))
+	// Sign the token with the private key
+	tokenString, err := token.SignedString(key.SignKey)
 	if err != nil {
 		return "", err
 	}
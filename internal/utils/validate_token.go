@@ -1,14 +1,34 @@
 package utils
 
 import (
+	"errors"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func ValidateToken(tokenString string, jwtSecret string) (*JWTClaims, error) {
-	// token validation here
+// JWTKeyLookup returns the verification key for a kid header
+type JWTKeyLookup func(kid string) (*JWTKey, error)
+
+// ValidateToken verifies a token against the key named by its kid header.
+// Only the algorithms listed in allowedAlgorithms are accepted, and a token
+// must also use the algorithm of its key, so an RSA public key can never be
+// used as an HMAC secret.
+func ValidateToken(tokenString string, lookup JWTKeyLookup, allowedAlgorithms []string) (*JWTClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtSecret), nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		key, err := lookup(kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, errors.New("token algorithm does not match its key")
+		}
+		return key.VerifyKey, nil
+	},
+		jwt.WithValidMethods(allowedAlgorithms),
+		jwt.WithIssuer(TokenIssuer),
+		jwt.WithExpirationRequired(),
+	)
 	if err != nil {
 		return nil, err
 	}